@@ -1,24 +1,50 @@
 // Command healthcheck is a tiny client used as the container HEALTHCHECK. The
 // final image is built FROM scratch, which has no shell or curl/wget, so this
-// compiled helper performs the probe instead. It exits 0 when /healthz returns
-// 200 and non-zero otherwise.
+// compiled helper performs the probe instead. It exits 0 when the endpoint,
+// /healthz by default, returns 200 and non-zero otherwise.
+//
+// Flags select the endpoint (/livez, /readyz, or /healthz), probe another
+// base URL than the server's own port, e.g. through a TLS-terminating proxy,
+// and, for /readyz, set how stale the polled data may be before the probe
+// fails, overriding the server's READY_STALE_AFTER.
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 )
 
 func main() {
-	port := os.Getenv("LISTENER_PORT")
-	if port == "" {
-		port = "8080"
+	endpoint := flag.String("endpoint", "/healthz", "path to probe: /livez, /readyz, or /healthz")
+	base := flag.String("url", "", "base URL of the server, e.g. https://visualizer.example.com behind a TLS-terminating proxy (default: http://127.0.0.1:$LISTENER_PORT)")
+	staleAfter := flag.Duration("stale-after", 0, "with /readyz, how old the last successful poll may be (default: the server's READY_STALE_AFTER)")
+	flag.Parse()
+
+	if *base == "" {
+		port := os.Getenv("LISTENER_PORT")
+		if port == "" {
+			port = "8080"
+		}
+		*base = "http://127.0.0.1:" + port
+	}
+	u, err := url.Parse(*base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fmt.Fprintf(os.Stderr, "healthcheck: -url %q must be an http or https URL\n", *base)
+		os.Exit(2)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + *endpoint
+	if *staleAfter > 0 {
+		u.RawQuery = url.Values{"staleAfter": {staleAfter.String()}}.Encode()
 	}
 
 	client := &http.Client{Timeout: 4 * time.Second}
-	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%s/healthz", port))
+	resp, err := client.Get(u.String())
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "healthcheck:", err)
 		os.Exit(1)
@@ -26,7 +52,9 @@ func main() {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "healthcheck: unexpected status %d\n", resp.StatusCode)
+		// The body explains the failure, e.g. which /readyz checks failed.
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		fmt.Fprintf(os.Stderr, "healthcheck: unexpected status %d: %s\n", resp.StatusCode, strings.TrimSpace(string(body)))
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/docker"
+	"github.com/jtgasper3/swarm-visualizer/internal/oauth"
+)
+
+// checkConfig implements the check-config subcommand, for validating a
+// deployment's environment before rolling it out. It loads the configuration
+// as the server would, checks every sensitiveDataPaths and allowedDataPaths
+// entry against the published data structure, optionally checks that the
+// identity provider can be discovered, and prints the effective configuration
+// with secrets masked. It returns the process exit code: 0 if no problems were
+// found.
+func checkConfig(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	checkOIDC := flags.Bool("oidc", false, "also fetch the OIDC discovery document and signing keys")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+
+	var problems []error
+	for _, p := range cfg.SensitiveDataPaths {
+		if err := docker.ValidateDataPath(p); err != nil {
+			problems = append(problems, fmt.Errorf("sensitiveDataPaths entry %q: %v", p, err))
+		}
+	}
+	for _, p := range cfg.CompiledAllowedDataPaths {
+		if err := docker.ValidateDataPath(p.String()); err != nil {
+			problems = append(problems, fmt.Errorf("allowedDataPaths entry %q: %v", p, err))
+		}
+	}
+	if *checkOIDC && cfg.AuthEnabled {
+		if err := oauth.CheckDiscovery(cfg); err != nil {
+			problems = append(problems, fmt.Errorf("oidc: %v", err))
+		}
+	}
+
+	out, err := yaml.Marshal(cfg.Masked())
+	if err != nil {
+		fmt.Fprintf(stderr, "Printing configuration: %v\n", err)
+		return 1
+	}
+	_, _ = stdout.Write(out)
+
+	if len(problems) > 0 {
+		fmt.Fprintf(stderr, "Invalid configuration:\n%v\n", errors.Join(problems...))
+		return 1
+	}
+	fmt.Fprintln(stderr, "Configuration OK")
+	return 0
+}
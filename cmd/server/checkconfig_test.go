@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	idp := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(idp.Close)
+
+	oidcEnv := map[string]string{
+		"ENABLE_AUTHN":        "true",
+		"OIDC_CLIENT_ID":      "client",
+		"OIDC_CLIENT_SECRET":  "hunter2",
+		"OIDC_REDIRECT_URL":   "https://viz.example.com/callback",
+		"OIDC_WELL_KNOWN_URL": idp.URL + "/.well-known/openid-configuration",
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		wantCode   int
+		wantStdout []string
+		wantStderr []string
+	}{
+		{
+			name:       "valid configuration",
+			env:        map[string]string{"SENSITIVE_DATA_PATHS": "services.*.Spec.Labels,tasks.*.Spec.ContainerSpec.Env,**.'/^Secret/',nodes.*.Spec.Labels.'com.example.*'"},
+			wantCode:   0,
+			wantStdout: []string{"listenerPort: \"8080\"", "services.*.Spec.Labels"},
+			wantStderr: []string{"Configuration OK"},
+		},
+		{
+			name:       "misspelled sensitive data path",
+			env:        map[string]string{"SENSITIVE_DATA_PATHS": "services.*.Spec.Lables"},
+			wantCode:   1,
+			wantStderr: []string{`"services.*.Spec.Lables"`, `"Lables" not found`},
+		},
+		{
+			name:       "invalid setting",
+			env:        map[string]string{"LISTENER_PORT": "http"},
+			wantCode:   1,
+			wantStderr: []string{"listenerPort"},
+		},
+		{
+			name:       "secret is masked",
+			env:        oidcEnv,
+			wantCode:   0,
+			wantStdout: []string{"clientSecret: '********'"},
+		},
+		{
+			name:       "unreachable identity provider",
+			args:       []string{"-oidc"},
+			env:        oidcEnv,
+			wantCode:   1,
+			wantStderr: []string{"oidc:", "unexpected status 404"},
+		},
+		{
+			name:     "unknown flag",
+			args:     []string{"-bogus"},
+			wantCode: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			var stdout, stderr bytes.Buffer
+			code := checkConfig(tc.args, &stdout, &stderr)
+
+			if code != tc.wantCode {
+				t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, tc.wantCode, stderr.String())
+			}
+			if strings.Contains(stdout.String(), "hunter2") {
+				t.Errorf("stdout contains the client secret:\n%s", stdout.String())
+			}
+			for _, want := range tc.wantStdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("stdout does not contain %q:\n%s", want, stdout.String())
+				}
+			}
+			for _, want := range tc.wantStderr {
+				if !strings.Contains(stderr.String(), want) {
+					t.Errorf("stderr does not contain %q:\n%s", want, stderr.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+)
+
+// livezHandler reports that the process is up and serving requests. It never
+// fails, so an orchestrator restarts the container only when it hangs.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok"))
+}
+
+// poller is the part of the hub readiness is judged by.
+type poller interface {
+	Ready() bool
+	LastPoll(group string) time.Time
+}
+
+// readiness is the /readyz report.
+type readiness struct {
+	// Status is "ok" when every check passes and "unavailable" otherwise.
+	Status string `json:"status"`
+	// StaleAfter is the threshold the polls were judged by.
+	StaleAfter string  `json:"staleAfter"`
+	Checks     []check `json:"checks"`
+}
+
+// check is the result of one readiness check.
+type check struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Message explains a failure.
+	Message string `json:"message,omitempty"`
+	// LastSuccess and AgeSeconds are when a poll last succeeded and how long
+	// ago that was, for the poll checks.
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	AgeSeconds  *float64   `json:"ageSeconds,omitempty"`
+}
+
+// readyzHandler reports whether the server is serving fresh data: a snapshot
+// has been published, the task and structural polls have succeeded within
+// READY_STALE_AFTER, and, when authentication is enabled, the identity
+// provider's signing keys are loaded. authStatus is nil when it is disabled.
+// A staleAfter query parameter overrides the threshold, so a probe can be
+// more or less tolerant than the server. It returns 200 when every check
+// passes and 503 otherwise, with a JSON body explaining each.
+func readyzHandler(cfgs *config.Holder, hub poller, authStatus func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		staleAfter := cfgs.Load().ReadyStaleAfter
+		if v := r.URL.Query().Get("staleAfter"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				http.Error(w, fmt.Sprintf("staleAfter %q must be a positive duration such as 1m", v), http.StatusBadRequest)
+				return
+			}
+			staleAfter = d
+		}
+
+		now := time.Now()
+		report := readiness{Status: "ok", StaleAfter: staleAfter.String()}
+		published := check{Name: "published", OK: hub.Ready()}
+		if !published.OK {
+			published.Message = "no snapshot has been published yet"
+		}
+		report.Checks = append(report.Checks,
+			published,
+			pollCheck(hub, "tasks", staleAfter, now),
+			pollCheck(hub, "structural", staleAfter, now),
+		)
+		if authStatus != nil {
+			oidc := check{Name: "oidc", OK: true}
+			if err := authStatus(); err != nil {
+				oidc.OK, oidc.Message = false, err.Error()
+			}
+			report.Checks = append(report.Checks, oidc)
+		}
+
+		status := http.StatusOK
+		for _, c := range report.Checks {
+			if !c.OK {
+				report.Status, status = "unavailable", http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			slog.Error("Writing the readiness report failed", logging.Err(err))
+		}
+	}
+}
+
+// pollCheck checks that the poll group last succeeded within staleAfter of
+// now.
+func pollCheck(hub poller, group string, staleAfter time.Duration, now time.Time) check {
+	c := check{Name: group + "Poll"}
+	last := hub.LastPoll(group)
+	if last.IsZero() {
+		c.Message = "the " + group + " poll has not succeeded yet"
+		return c
+	}
+	age := now.Sub(last)
+	seconds := age.Seconds()
+	c.LastSuccess, c.AgeSeconds = &last, &seconds
+	c.OK = age <= staleAfter
+	if !c.OK {
+		c.Message = fmt.Sprintf("the %s poll last succeeded %s ago", group, age.Round(time.Second))
+	}
+	return c
+}
@@ -2,54 +2,142 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/jtgasper3/swarm-visualizer/internal/admin"
 	"github.com/jtgasper3/swarm-visualizer/internal/config"
 	"github.com/jtgasper3/swarm-visualizer/internal/docker"
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
 	"github.com/jtgasper3/swarm-visualizer/internal/oauth"
+	"github.com/jtgasper3/swarm-visualizer/internal/ratelimit"
+	"github.com/jtgasper3/swarm-visualizer/internal/tracing"
 )
 
 func main() {
-	cfg := config.LoadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(checkConfig(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	logging.Configure(os.Stderr, cfg)
+	for _, p := range cfg.CompiledSensitiveDataPaths {
+		if err := docker.ValidateDataPath(p.String()); err != nil {
+			slog.Warn("Sensitive data path will not match", "path", p.String(), logging.Err(err))
+		}
+	}
+	for _, p := range cfg.CompiledAllowedDataPaths {
+		if err := docker.ValidateDataPath(p.String()); err != nil {
+			slog.Warn("Allowed data path will not match", "path", p.String(), logging.Err(err))
+		}
+	}
+
+	// Tracing is configured by the standard OTEL_* environment variables
+	// rather than the config file, and set up first so the Docker client and
+	// handlers created below report to it.
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		fatal("Tracing setup failed", err)
+	}
+	if tracing.Enabled() {
+		slog.Info("OpenTelemetry tracing enabled")
+	}
+
+	// cfgs holds the running configuration; its reloadable settings can be
+	// swapped on SIGHUP or when the config file changes.
+	cfgs := config.NewHolder(cfg)
+	cfgs.Subscribe(func(cfg *config.Config) { logging.Configure(os.Stderr, cfg) })
+	go cfgs.WatchFile()
 
 	contextRoot := cfg.ContextRoot
-	log.Printf("Server root context is %s", contextRoot)
 
 	mux := http.NewServeMux()
 
 	fs := http.FileServer(http.Dir("./static"))
 	mux.Handle(contextRoot, http.StripPrefix(contextRoot, fs))
 
+	// One limiter backs every rate-limited endpoint group.
+	limiter := ratelimit.New(cfg.RateLimits, cfg.TrustedProxies)
+	go limiter.Cleanup()
+
 	// Register auth first so its token validator can be handed to the WebSocket
 	// handler. auth is nil when authentication is disabled.
-	auth := oauth.RegisterOAuthHandlers(mux, cfg)
+	auth := oauth.RegisterOAuthHandlers(mux, cfg, limiter)
 	var validate docker.TokenValidator
 	if auth != nil {
 		validate = auth.ValidateToken
 	}
 
-	hub := docker.RegisterDockerHandlers(mux, cfg, validate)
+	// Cancelled on shutdown to stop polling and abandon webhook retries.
+	inspectCtx, stopInspecting := context.WithCancel(context.Background())
+	defer stopInspecting()
+	hub := docker.RegisterDockerHandlers(inspectCtx, mux, cfgs, validate, limiter)
 
-	// Unauthenticated readiness endpoint at a fixed path (independent of
-	// CONTEXT_ROOT) for orchestrator health checks.
+	// Unauthenticated health endpoints at fixed paths (independent of
+	// CONTEXT_ROOT) for orchestrator health checks. /healthz only waits for
+	// the first snapshot; /readyz also fails when the data goes stale or
+	// login is unavailable.
+	mux.HandleFunc("/livez", livezHandler)
 	mux.Handle("/healthz", healthzHandler(hub.Ready))
+	var authStatus func() error
+	if auth != nil {
+		authStatus = auth.Status
+	}
+	mux.Handle("/readyz", readyzHandler(cfgs, hub, authStatus))
+	// Authentication status is reported separately so an identity provider
+	// outage degrades login without failing the container health check.
+	if auth != nil {
+		mux.Handle("/healthz/auth", authHealthzHandler(auth.Status))
+	}
+
+	adminMux := registerOperational(mux, cfgs, hub)
 
 	server := &http.Server{
 		Addr:              ":" + cfg.ListenerPort,
-		Handler:           mux,
+		Handler:           tracing.Handler(mux),
 		ReadHeaderTimeout: 10 * time.Second,
 		WriteTimeout:      90 * time.Second,
 	}
 
 	go func() {
-		log.Printf("Server started on :%s", cfg.ListenerPort)
+		slog.Info("Server started", "port", cfg.ListenerPort, "context_root", contextRoot)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal("ListenAndServe: ", err)
+			fatal("Server failed", err)
+		}
+	}()
+
+	var adminServer *http.Server
+	if adminMux != nil {
+		adminServer = &http.Server{
+			Addr:              cfg.AdminListen,
+			Handler:           tracing.Handler(adminMux),
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      90 * time.Second,
+		}
+		go func() {
+			slog.Info("Admin server started", "address", cfg.AdminListen)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("Admin server failed", err)
+			}
+		}()
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("SIGHUP received, reloading configuration")
+			if err := cfgs.Reload(); err != nil {
+				slog.Error("Config reload failed, keeping the running configuration", logging.Err(err))
+			}
 		}
 	}()
 
@@ -57,13 +145,49 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
 	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
 	defer cancel()
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			slog.Warn("Admin server forced to shut down", logging.Err(err))
+		}
+	}
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown: ", err)
+		fatal("Server forced to shut down", err)
+	}
+	stopInspecting()
+	if err := hub.Close(); err != nil {
+		slog.Warn("Closing the history failed", logging.Err(err))
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("Flushing traces failed", logging.Err(err))
 	}
-	log.Println("Server stopped")
+	slog.Info("Server stopped")
+}
+
+// registerOperational registers the operational endpoints. With an admin
+// listener configured, they go on a mux of their own for it, which is
+// returned; otherwise it returns nil. The swarm state metrics name every
+// stack, service, and node, so on the public mux /metrics takes the admin
+// token, as the other admin endpoints do, and is not served without one.
+func registerOperational(mux *http.ServeMux, cfgs *config.Holder, clients admin.Clients) *http.ServeMux {
+	if cfgs.Load().AdminListen == "" {
+		mux.Handle("/metrics", docker.RequireAdmin(cfgs, metrics.Handler()))
+		return nil
+	}
+	adminMux := http.NewServeMux()
+	// Profiling, runtime stats, the configuration, and the client list are
+	// never served on the public port.
+	admin.Register(adminMux, cfgs, clients)
+	adminMux.Handle("/metrics", metrics.Handler())
+	return adminMux
+}
+
+// fatal logs msg with err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
 }
 
 // healthzHandler reports readiness for orchestrator health checks. It returns
@@ -79,3 +203,17 @@ func healthzHandler(ready func() bool) http.HandlerFunc {
 		http.Error(w, "not ready", http.StatusServiceUnavailable)
 	}
 }
+
+// authHealthzHandler reports whether authentication is available. It returns
+// 200 when status returns nil (the identity provider has been discovered and
+// its signing keys loaded) and 503 with the reason otherwise.
+func authHealthzHandler(status func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := status(); err != nil {
+			http.Error(w, "auth unavailable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("ok"))
+	}
+}
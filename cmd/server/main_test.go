@@ -1,9 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
 )
 
 func TestHealthzHandler(t *testing.T) {
@@ -31,3 +38,176 @@ func TestHealthzHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthHealthzHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "available returns 200", want: http.StatusOK},
+		{name: "degraded returns 503", err: errors.New("idp down"), want: http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := authHealthzHandler(func() error { return tc.err })
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz/auth", nil))
+
+			if rr.Code != tc.want {
+				t.Fatalf("status = %d, want %d", rr.Code, tc.want)
+			}
+		})
+	}
+}
+
+// fakePoller is a hub that has published when ready and last polled each
+// group at the given times.
+type fakePoller struct {
+	ready  bool
+	polled map[string]time.Time
+}
+
+func (f fakePoller) Ready() bool                     { return f.ready }
+func (f fakePoller) LastPoll(group string) time.Time { return f.polled[group] }
+
+func TestReadyzHandler(t *testing.T) {
+	now := time.Now()
+	fresh := fakePoller{ready: true, polled: map[string]time.Time{"tasks": now, "structural": now.Add(-5 * time.Second)}}
+	staleTasks := fakePoller{ready: true, polled: map[string]time.Time{"tasks": now.Add(-2 * time.Minute), "structural": now}}
+
+	tests := []struct {
+		name       string
+		hub        poller
+		authStatus func() error
+		query      string
+		want       int
+		// failing are the checks expected to fail.
+		failing []string
+	}{
+		{name: "fresh", hub: fresh, want: http.StatusOK},
+		{name: "never published", hub: fakePoller{}, want: http.StatusServiceUnavailable, failing: []string{"published", "tasksPoll", "structuralPoll"}},
+		{name: "stale tasks", hub: staleTasks, want: http.StatusServiceUnavailable, failing: []string{"tasksPoll"}},
+		{name: "stale tasks within a probe's threshold", hub: staleTasks, query: "?staleAfter=5m", want: http.StatusOK},
+		{name: "fresh beyond a probe's threshold", hub: fresh, query: "?staleAfter=1s", want: http.StatusServiceUnavailable, failing: []string{"structuralPoll"}},
+		{name: "oidc keys unavailable", hub: fresh, authStatus: func() error { return errors.New("jwks fetch failed") }, want: http.StatusServiceUnavailable, failing: []string{"oidc"}},
+		{name: "oidc keys loaded", hub: fresh, authStatus: func() error { return nil }, want: http.StatusOK},
+		{name: "bad threshold", hub: fresh, query: "?staleAfter=soon", want: http.StatusBadRequest},
+	}
+
+	cfgs := config.NewHolder(&config.Config{ReadyStaleAfter: time.Minute})
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			readyzHandler(cfgs, tc.hub, tc.authStatus).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz"+tc.query, nil))
+			if rr.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tc.want, rr.Body)
+			}
+			if tc.want == http.StatusBadRequest {
+				return
+			}
+
+			var report readiness
+			if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			var failing []string
+			for _, c := range report.Checks {
+				if !c.OK {
+					failing = append(failing, c.Name)
+					if c.Message == "" {
+						t.Errorf("failed check %q has no message", c.Name)
+					}
+				}
+			}
+			if !slices.Equal(failing, tc.failing) {
+				t.Errorf("failing checks = %v, want %v", failing, tc.failing)
+			}
+			if wantStatus := map[bool]string{true: "ok", false: "unavailable"}[tc.want == http.StatusOK]; report.Status != wantStatus {
+				t.Errorf("status = %q, want %q", report.Status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestLivezHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	livezHandler(rr, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "ok" {
+		t.Fatalf("livez = %d %q, want 200 ok", rr.Code, rr.Body)
+	}
+}
+
+func TestRegisterOperational_Metrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.Config
+		bearer      string
+		wantPublic  int
+		wantAdmin   int
+		wantNoAdmin bool
+	}{
+		{name: "not served without an admin token", wantPublic: http.StatusNotFound, wantNoAdmin: true},
+		{name: "admin token required", cfg: config.Config{AdminToken: "s3cret"}, wantPublic: http.StatusUnauthorized, wantNoAdmin: true},
+		{name: "admin token given", cfg: config.Config{AdminToken: "s3cret"}, bearer: "s3cret", wantPublic: http.StatusOK, wantNoAdmin: true},
+		{name: "admin listener", cfg: config.Config{AdminListen: "127.0.0.1:9090"}, wantPublic: http.StatusNotFound, wantAdmin: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			adminMux := registerOperational(mux, config.NewHolder(&tc.cfg), nil)
+			get := func(h http.Handler) int {
+				req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+				if tc.bearer != "" {
+					req.Header.Set("Authorization", "Bearer "+tc.bearer)
+				}
+				rr := httptest.NewRecorder()
+				h.ServeHTTP(rr, req)
+				return rr.Code
+			}
+
+			if got := get(mux); got != tc.wantPublic {
+				t.Errorf("public /metrics = %d, want %d", got, tc.wantPublic)
+			}
+			if tc.wantNoAdmin {
+				if adminMux != nil {
+					t.Error("admin mux returned without an admin listener")
+				}
+				return
+			}
+			if adminMux == nil {
+				t.Fatal("no admin mux for the admin listener")
+			}
+			if got := get(adminMux); got != tc.wantAdmin {
+				t.Errorf("admin /metrics = %d, want %d", got, tc.wantAdmin)
+			}
+		})
+	}
+}
+
+// TestRegisterOperational_MetricsWithAuthentication verifies that turning on
+// authentication does not expose the metrics, whose labels name the swarm's
+// stacks, services, and nodes, on the public port: OIDC sessions do not
+// grant access to them, only the admin token does.
+func TestRegisterOperational_MetricsWithAuthentication(t *testing.T) {
+	for _, cfg := range []config.Config{
+		{AuthEnabled: true},
+		{AuthEnabled: true, AdminToken: "s3cret"},
+	} {
+		mux := http.NewServeMux()
+		registerOperational(mux, config.NewHolder(&cfg), nil)
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "any"})
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound && rr.Code != http.StatusUnauthorized {
+			t.Errorf("adminToken set = %t: /metrics = %d, want 404 or 401", cfg.AdminToken != "", rr.Code)
+		}
+		if strings.Contains(rr.Body.String(), "swarm_visualizer_") {
+			t.Errorf("adminToken set = %t: /metrics served metrics: %s", cfg.AdminToken != "", rr.Body)
+		}
+	}
+}
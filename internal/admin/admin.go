@@ -0,0 +1,116 @@
+// Package admin serves the operational endpoints of the admin listener:
+// profiling, runtime statistics, the running configuration, and the connected
+// WebSocket clients. They are unauthenticated, so they are only served on the
+// separate listener set by ADMIN_LISTEN, never on the public port.
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/docker"
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+)
+
+// Clients is the part of the hub the client endpoints use.
+type Clients interface {
+	Clients() []docker.ClientInfo
+	Disconnect(id uint64) bool
+}
+
+// started is when the process started, for the reported uptime.
+var started = time.Now()
+
+// Register serves the endpoints on mux, the admin listener's:
+//
+//   - /debug/pprof/ serves the net/http/pprof profiles.
+//   - /debug/runtime reports Go runtime statistics.
+//   - /debug/config reports the running configuration with secrets masked.
+//   - /debug/clients lists the connected WebSocket clients, and
+//     DELETE /debug/clients/{id} disconnects one.
+func Register(mux *http.ServeMux, cfgs *config.Holder, clients Clients) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("GET /debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, readRuntimeStats())
+	})
+	mux.HandleFunc("GET /debug/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, cfgs.Load().Masked())
+	})
+	mux.HandleFunc("GET /debug/clients", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, clients.Clients())
+	})
+	mux.HandleFunc("DELETE /debug/clients/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil || !clients.Disconnect(id) {
+			http.Error(w, "No such client", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// runtimeStats is the /debug/runtime report.
+type runtimeStats struct {
+	GoVersion  string `json:"goVersion"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	NumCPU     int    `json:"numCPU"`
+	Goroutines int    `json:"goroutines"`
+	// Uptime is how long the process has run, in seconds.
+	Uptime float64 `json:"uptimeSeconds"`
+
+	HeapAllocBytes   uint64  `json:"heapAllocBytes"`
+	HeapInuseBytes   uint64  `json:"heapInuseBytes"`
+	HeapObjects      uint64  `json:"heapObjects"`
+	TotalAllocBytes  uint64  `json:"totalAllocBytes"`
+	SysBytes         uint64  `json:"sysBytes"`
+	NumGC            uint32  `json:"numGC"`
+	GCPauseTotalSecs float64 `json:"gcPauseTotalSeconds"`
+	// LastGC is when the last garbage collection finished, or zero if none
+	// has.
+	LastGC time.Time `json:"lastGC"`
+}
+
+func readRuntimeStats() runtimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := runtimeStats{
+		GoVersion:        runtime.Version(),
+		GOMAXPROCS:       runtime.GOMAXPROCS(0),
+		NumCPU:           runtime.NumCPU(),
+		Goroutines:       runtime.NumGoroutine(),
+		Uptime:           time.Since(started).Seconds(),
+		HeapAllocBytes:   m.HeapAlloc,
+		HeapInuseBytes:   m.HeapInuse,
+		HeapObjects:      m.HeapObjects,
+		TotalAllocBytes:  m.TotalAlloc,
+		SysBytes:         m.Sys,
+		NumGC:            m.NumGC,
+		GCPauseTotalSecs: time.Duration(m.PauseTotalNs).Seconds(),
+	}
+	if m.LastGC > 0 {
+		stats.LastGC = time.Unix(0, int64(m.LastGC))
+	}
+	return stats
+}
+
+// writeJSON writes v as indented JSON, not to be cached.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Error("Writing an admin response failed", logging.Err(err))
+	}
+}
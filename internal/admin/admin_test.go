@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/docker"
+)
+
+// fakeClients is a fixed set of connected clients.
+type fakeClients struct {
+	clients      []docker.ClientInfo
+	disconnected []uint64
+}
+
+func (f *fakeClients) Clients() []docker.ClientInfo { return f.clients }
+
+func (f *fakeClients) Disconnect(id uint64) bool {
+	for i, c := range f.clients {
+		if c.ID == id {
+			f.clients = append(f.clients[:i], f.clients[i+1:]...)
+			f.disconnected = append(f.disconnected, id)
+			return true
+		}
+	}
+	return false
+}
+
+// serve serves a request through a mux the endpoints are registered on.
+func serve(cfg *config.Config, clients Clients, method, path string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	Register(mux, config.NewHolder(cfg), clients)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func TestClients(t *testing.T) {
+	clients := &fakeClients{clients: []docker.ClientInfo{
+		{ID: 1, ClientIP: "10.0.0.1", User: "alice", FramesSent: 3},
+		{ID: 2, ClientIP: "10.0.0.2"},
+	}}
+
+	rec := serve(&config.Config{}, clients, http.MethodGet, "/debug/clients")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /debug/clients = %d, want 200", rec.Code)
+	}
+	var got []docker.ClientInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].User != "alice" || got[0].FramesSent != 3 {
+		t.Errorf("clients = %+v, want both clients", got)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/debug/clients/1", http.StatusNoContent},
+		{"/debug/clients/1", http.StatusNotFound},
+		{"/debug/clients/99", http.StatusNotFound},
+		{"/debug/clients/abc", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := serve(&config.Config{}, clients, http.MethodDelete, tt.path); rec.Code != tt.want {
+			t.Errorf("DELETE %s = %d, want %d", tt.path, rec.Code, tt.want)
+		}
+	}
+	if len(clients.disconnected) != 1 || clients.disconnected[0] != 1 {
+		t.Errorf("disconnected = %v, want [1]", clients.disconnected)
+	}
+}
+
+func TestConfig_MasksSecrets(t *testing.T) {
+	cfg := &config.Config{ClusterName: "prod", AdminToken: "s3cret"}
+	rec := serve(cfg, &fakeClients{}, http.MethodGet, "/debug/config")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /debug/config = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "s3cret") {
+		t.Errorf("config exposes the admin token: %s", body)
+	}
+	if !strings.Contains(body, `"prod"`) {
+		t.Errorf("config lacks the cluster name: %s", body)
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	rec := serve(&config.Config{}, &fakeClients{}, http.MethodGet, "/debug/runtime")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /debug/runtime = %d, want 200", rec.Code)
+	}
+	var stats runtimeStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Goroutines < 1 || stats.GOMAXPROCS < 1 || stats.GoVersion == "" || stats.HeapAllocBytes == 0 {
+		t.Errorf("runtime stats = %+v, want them filled in", stats)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", rec.Header().Get("Cache-Control"))
+	}
+}
+
+func TestPprof(t *testing.T) {
+	rec := serve(&config.Config{}, &fakeClients{}, http.MethodGet, "/debug/pprof/")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "goroutine") {
+		t.Errorf("GET /debug/pprof/ = %d, want the profile index", rec.Code)
+	}
+}
@@ -1,27 +1,142 @@
 package config
 
 import (
-	"log"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
 	"net"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/jtgasper3/swarm-visualizer/internal"
 )
 
 type Config struct {
-	ClusterName        string
-	ContextRoot        string
-	ListenerPort       string
+	ClusterName  string
+	ContextRoot  string
+	ListenerPort string
+	// AdminListen is the address, host:port, of the admin listener serving
+	// operational endpoints such as /metrics. When empty there is none, and
+	// they are served on the main listener.
+	AdminListen        string
 	AuthEnabled        bool
 	OAuthConfig        OAuthConfig
 	TrustedProxies     []*net.IPNet
 	SensitiveDataPaths []string
-	HideAllConfigs     bool
-	HideAllEnvs        bool
-	HideAllMounts      bool
-	HideAllSecrets     bool
-	HideLabels         []string
-	MaxWSConnections   int
+	// CompiledSensitiveDataPaths holds SensitiveDataPaths compiled, in the
+	// same order, so their patterns are parsed once rather than per publish.
+	CompiledSensitiveDataPaths []*internal.Path
+	// AllowedDataPaths, when set, switches publishing to allow-list mode:
+	// only the values these paths select are published. Entries may name a
+	// preset such as "minimal".
+	AllowedDataPaths []string
+	// CompiledAllowedDataPaths holds AllowedDataPaths with presets expanded,
+	// compiled.
+	CompiledAllowedDataPaths []*internal.Path
+	HideAllConfigs           bool
+	HideAllEnvs              bool
+	HideAllMounts            bool
+	HideAllSecrets           bool
+	HideLabels               []string
+	// HiddenStacks and HiddenServices are globs matched against stack
+	// namespaces and service names; HiddenNodeLabels are label selectors
+	// ("key" or "key=glob") matched against node labels. Matching stacks,
+	// services, and nodes are left out of the published data, with their
+	// tasks.
+	HiddenStacks     []string
+	HiddenServices   []string
+	HiddenNodeLabels []string
+	// CompiledHiddenStacks, CompiledHiddenServices, and
+	// CompiledHiddenNodeLabels hold the above compiled.
+	CompiledHiddenStacks     internal.Globs
+	CompiledHiddenServices   internal.Globs
+	CompiledHiddenNodeLabels []internal.LabelSelector
+	// SanitizeHashSalt keys the hash sanitization action. When empty, a
+	// random salt is used for the life of the process.
+	SanitizeHashSalt string
+	// SecretDetector, when not nil, redacts env values and labels that look
+	// like secrets. It is set when secret detection is enabled and matches
+	// keys against SecretKeyPatterns.
+	SecretDetector    *internal.SecretDetector
+	SecretKeyPatterns []string
+	MaxWSConnections  int
+	// MaxWSConnectionsPerIP caps concurrent WebSocket connections from a
+	// single client IP. 0 means unlimited.
+	MaxWSConnectionsPerIP int
+	// SwarmMetricsLabels are the dimensions, of "stack", "service", and
+	// "node", the swarm state metrics are broken down by. Empty aggregates
+	// them over the cluster.
+	SwarmMetricsLabels []string
+	// SwarmMetricsFailedWindow is how long a failed task is counted by the
+	// recently failed tasks metric.
+	SwarmMetricsFailedWindow time.Duration
+	// ReadyStaleAfter is how old the last successful task or structural poll
+	// may be before the server is reported not ready.
+	ReadyStaleAfter time.Duration
+	// TimelineSize is how many of the latest changes to the swarm are kept
+	// for the activity feed.
+	TimelineSize int
+	// HistoryPath is the file the history store keeps past snapshots in.
+	// When empty, no history is kept.
+	HistoryPath string
+	// HistoryRetention and HistoryMaxBytes bound the history kept by age
+	// and by size.
+	HistoryRetention time.Duration
+	HistoryMaxBytes  int64
+	// HistoryKeyframeInterval is how often a whole snapshot is stored; those
+	// in between are stored as deltas from the one before.
+	HistoryKeyframeInterval time.Duration
+	// LogLevel is the minimum level logged, and LogFormat how records are
+	// written: "text" or "json".
+	LogLevel  slog.Level
+	LogFormat string
+	// AdminToken is the bearer token that grants access to the admin
+	// endpoints. When empty, they are disabled.
+	AdminToken string
+	// Webhooks are notified of swarm events.
+	Webhooks []Webhook
+	// RateLimits holds the per-client-IP rate limit policy for each endpoint
+	// group (see RateLimitGroups).
+	RateLimits map[string]RateLimit
+}
+
+// RateLimit is a token-bucket policy: on average Requests per Period, with
+// bursts of up to Burst. Zero Requests disables limiting for the group.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// Rate limit groups, each covering a set of endpoints.
+const (
+	RateLimitLogin    = "login"
+	RateLimitCallback = "callback"
+	RateLimitLogout   = "logout"
+	RateLimitWS       = "ws"
+	RateLimitAPI      = "api"
+)
+
+// RateLimitGroups lists the valid rate limit group names.
+var RateLimitGroups = []string{RateLimitLogin, RateLimitCallback, RateLimitLogout, RateLimitWS, RateLimitAPI}
+
+// defaultRateLimits applies to groups not given a policy in the config file
+// or RATE_LIMITS.
+var defaultRateLimits = map[string]RateLimit{
+	RateLimitLogin:    {Requests: 5, Period: time.Minute, Burst: 5},
+	RateLimitCallback: {Requests: 5, Period: time.Minute, Burst: 5},
+	RateLimitLogout:   {Requests: 10, Period: time.Minute, Burst: 10},
+	RateLimitWS:       {Requests: 30, Period: time.Minute, Burst: 10},
+	RateLimitAPI:      {Requests: 60, Period: time.Minute, Burst: 30},
 }
 
 type OAuthConfig struct {
@@ -32,7 +147,6 @@ type OAuthConfig struct {
 	AuthURL          string
 	TokenURL         string
 	OIDCWellKnownURL string
-	Issuer           string
 	UsernameClaim    string
 	SessionMaxAge    int
 }
@@ -42,113 +156,592 @@ const (
 	defaultListenerPort     = "8080"
 	defaultSessionMaxAge    = 3600
 	defaultMaxWSConnections = 256
+	defaultTimelineSize     = 500
+	defaultUsernameClaim    = "preferred_username"
+
+	defaultSwarmMetricsFailedWindow = "15m"
+	defaultReadyStaleAfter          = "1m"
+
+	defaultHistoryRetention        = "168h"
+	defaultHistoryMaxSize          = "1GB"
+	defaultHistoryKeyframeInterval = "10m"
+
+	defaultLogLevel  = "info"
+	defaultLogFormat = "text"
 )
 
-func LoadConfig() *Config {
-	authEnabled := os.Getenv("ENABLE_AUTHN") == "true"
+// logFormatValues are the accepted LOG_FORMAT values.
+var logFormatValues = []string{"text", "json"}
+
+// defaultSwarmMetricsLabels breaks the swarm state metrics down by every
+// dimension.
+var defaultSwarmMetricsLabels = []string{"stack", "service", "node"}
+
+// swarmMetricsLabelValues are the accepted SWARM_METRICS_LABELS entries.
+// "none" stands alone, for cluster totals only.
+var swarmMetricsLabelValues = []string{"stack", "service", "node", "none"}
+
+// builtinSensitiveDataPaths are always removed from the published data; the
+// configured SENSITIVE_DATA_PATHS are applied in addition.
+var builtinSensitiveDataPaths = []string{
+	"nodes.*.Description.Engine.Plugins",
+	"nodes.*.Description.TLSInfo",
+	"services.*.Spec.TaskTemplate.Placement.Platforms", // Although not sensitive, this can be very verbose
+	"services.*.Spec.TaskTemplate.ContainerSpec.Mounts.*.Source",
+	"tasks.*.Spec.Placement.Platforms", // Although not sensitive, this can be very verbose
+	"tasks.*.Spec.ContainerSpec.Mounts.*.Source",
+}
+
+// minimalDataPaths is the "minimal" allowedDataPaths preset: exactly the
+// fields the web UI renders, and the service updates webhook events are
+// found from.
+var minimalDataPaths = []string{
+	"networks.*.Id",
+	"networks.*.Name",
+
+	"nodes.*.ID",
+	"nodes.*.CreatedAt",
+	"nodes.*.UpdatedAt",
+	"nodes.*.Spec.Role",
+	"nodes.*.Spec.Availability",
+	"nodes.*.Spec.Labels",
+	"nodes.*.Status.State",
+	"nodes.*.Status.Addr",
+	"nodes.*.ManagerStatus.Leader",
+	"nodes.*.ManagerStatus.Reachability",
+	"nodes.*.ManagerStatus.Addr",
+	"nodes.*.Description.Hostname",
+	"nodes.*.Description.Platform.OS",
+	"nodes.*.Description.Platform.Architecture",
+	"nodes.*.Description.Resources.NanoCPUs",
+	"nodes.*.Description.Resources.MemoryBytes",
+	"nodes.*.Description.Engine.EngineVersion",
+
+	"services.*.ID",
+	"services.*.CreatedAt",
+	"services.*.UpdatedAt",
+	"services.*.Endpoint.Ports.*.Protocol",
+	"services.*.Endpoint.Ports.*.PublishedPort",
+	"services.*.Endpoint.Ports.*.TargetPort",
+	"services.*.Endpoint.Ports.*.PublishMode",
+	"services.*.Spec.Name",
+	"services.*.Spec.Labels",
+	"services.*.Spec.Mode.Replicated.Replicas",
+	"services.*.Spec.Mode.Global",
+	"services.*.Spec.EndpointSpec.Ports.*.Protocol",
+	"services.*.Spec.EndpointSpec.Ports.*.PublishedPort",
+	"services.*.Spec.EndpointSpec.Ports.*.TargetPort",
+	"services.*.Spec.EndpointSpec.Ports.*.PublishMode",
+	"services.*.Spec.UpdateConfig.Parallelism",
+	"services.*.Spec.UpdateConfig.Delay",
+	"services.*.Spec.UpdateConfig.FailureAction",
+	"services.*.Spec.UpdateConfig.Order",
+	"services.*.UpdateStatus.State",
+	"services.*.UpdateStatus.StartedAt",
+	"services.*.UpdateStatus.Message",
+
+	"services.*.Spec.TaskTemplate.ContainerSpec.Image",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Args",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Env",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Mounts.*.Type",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Mounts.*.Target",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Mounts.*.ReadOnly",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Configs.*.ConfigID",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Configs.*.ConfigName",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Secrets.*.SecretID",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Secrets.*.SecretName",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Healthcheck.Test",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Healthcheck.Interval",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Healthcheck.Timeout",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Healthcheck.Retries",
+	"services.*.Spec.TaskTemplate.Networks.*.Target",
+	"services.*.Spec.TaskTemplate.Networks.*.Aliases",
+	"services.*.Spec.TaskTemplate.Resources.Reservations.NanoCPUs",
+	"services.*.Spec.TaskTemplate.Resources.Reservations.MemoryBytes",
+	"services.*.Spec.TaskTemplate.Resources.Limits.NanoCPUs",
+	"services.*.Spec.TaskTemplate.Resources.Limits.MemoryBytes",
+	"services.*.Spec.TaskTemplate.RestartPolicy.Condition",
+	"services.*.Spec.TaskTemplate.RestartPolicy.Delay",
+	"services.*.Spec.TaskTemplate.RestartPolicy.MaxAttempts",
+	"services.*.Spec.TaskTemplate.ContainerSpec.Hosts",
+
+	"tasks.*.ID",
+	"tasks.*.ServiceID",
+	"tasks.*.NodeID",
+	"tasks.*.Slot",
+	"tasks.*.CreatedAt",
+	"tasks.*.UpdatedAt",
+	"tasks.*.DesiredState",
+	"tasks.*.Status.State",
+	"tasks.*.Status.Message",
+	"tasks.*.Status.Err",
+	"tasks.*.Status.ContainerStatus.ContainerID",
+	"tasks.*.Status.ContainerStatus.ExitCode",
+
+	"tasks.*.Spec.ContainerSpec.Image",
+	"tasks.*.Spec.ContainerSpec.Args",
+	"tasks.*.Spec.ContainerSpec.Env",
+	"tasks.*.Spec.ContainerSpec.Mounts.*.Type",
+	"tasks.*.Spec.ContainerSpec.Mounts.*.Target",
+	"tasks.*.Spec.ContainerSpec.Mounts.*.ReadOnly",
+	"tasks.*.Spec.ContainerSpec.Configs.*.ConfigID",
+	"tasks.*.Spec.ContainerSpec.Configs.*.ConfigName",
+	"tasks.*.Spec.ContainerSpec.Secrets.*.SecretID",
+	"tasks.*.Spec.ContainerSpec.Secrets.*.SecretName",
+	"tasks.*.Spec.ContainerSpec.Healthcheck.Test",
+	"tasks.*.Spec.ContainerSpec.Healthcheck.Interval",
+	"tasks.*.Spec.ContainerSpec.Healthcheck.Timeout",
+	"tasks.*.Spec.ContainerSpec.Healthcheck.Retries",
+	"tasks.*.Spec.Networks.*.Target",
+	"tasks.*.Spec.Networks.*.Aliases",
+	"tasks.*.Spec.Resources.Reservations.NanoCPUs",
+	"tasks.*.Spec.Resources.Reservations.MemoryBytes",
+	"tasks.*.Spec.Resources.Limits.NanoCPUs",
+	"tasks.*.Spec.Resources.Limits.MemoryBytes",
+	"tasks.*.Spec.RestartPolicy.Condition",
+	"tasks.*.Spec.RestartPolicy.Delay",
+	"tasks.*.Spec.RestartPolicy.MaxAttempts",
+	"tasks.*.Spec.ContainerSpec.Labels",
+
+	"serviceStatuses",
+	"stackStatuses",
+}
+
+// dataPathPresets are the names that may stand for a set of allowedDataPaths.
+var dataPathPresets = map[string][]string{
+	"minimal": minimalDataPaths,
+}
+
+// hideLabelsValues are the accepted HIDE_LABELS entries.
+var hideLabelsValues = []string{"all", "container", "network", "node", "service"}
+
+// LoadConfig builds the configuration from defaults, then the optional config
+// file named by CONFIG_FILE, then environment variables, each layer overriding
+// individual settings of the one before. Every problem found is reported
+// together in the returned error rather than stopping at the first.
+func LoadConfig() (*Config, error) {
+	s := defaultSettings()
 
-	contextRoot := getEnv("CONTEXT_ROOT", defaultContextRoot)
+	var errs []error
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := s.loadFile(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	errs = append(errs, s.applyEnv()...)
+
+	cfg, validationErrs := s.build()
+	errs = append(errs, validationErrs...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// build validates the merged settings and converts them into a Config. It
+// returns every validation problem found.
+func (s *settings) build() (*Config, []error) {
+	var errs []error
+	errorf := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	contextRoot := s.ContextRoot
+	if !strings.HasPrefix(contextRoot, "/") {
+		errorf("contextRoot %q must start with /", contextRoot)
+	}
 	if !strings.HasSuffix(contextRoot, "/") {
 		contextRoot += "/"
 	}
 
-	clientSecret := ""
-	clientSecretFile := os.Getenv("OIDC_CLIENT_SECRET_FILE")
-	if clientSecretFile != "" {
-		clientSecretBytes, err := os.ReadFile(clientSecretFile)
-		if err != nil {
-			log.Fatal(err)
+	if port, err := strconv.Atoi(s.ListenerPort); err != nil || port < 1 || port > 65535 {
+		errorf("listenerPort %q must be a port number between 1 and 65535", s.ListenerPort)
+	}
+	if s.AdminListen != "" {
+		if _, _, err := net.SplitHostPort(s.AdminListen); err != nil {
+			errorf("adminListen %q must be host:port: %v", s.AdminListen, err)
 		}
-		clientSecret = string(clientSecretBytes)
+	}
+	if s.MaxWSConnections <= 0 {
+		errorf("maxWSConnections %d must be positive", s.MaxWSConnections)
+	}
+	if s.MaxWSConnectionsPerIP < 0 {
+		errorf("maxWSConnectionsPerIP %d must not be negative", s.MaxWSConnectionsPerIP)
+	}
+	if s.TimelineSize <= 0 {
+		errorf("timelineSize %d must be positive", s.TimelineSize)
 	}
 
-	sensitiveDataPaths := []string{
-		"nodes.*.Description.Engine.Plugins",
-		"nodes.*.Description.TLSInfo",
-		"services.*.Spec.TaskTemplate.Placement.Platforms", // Although not sensitive, this can be very verbose
-		"services.*.Spec.TaskTemplate.ContainerSpec.Mounts.*.Source",
-		"tasks.*.Spec.Placement.Platforms", // Although not sensitive, this can be very verbose
-		"tasks.*.Spec.ContainerSpec.Mounts.*.Source",
+	for _, v := range s.HideLabels {
+		if !slices.Contains(hideLabelsValues, v) {
+			errorf("hideLabels entry %q must be one of %s", v, strings.Join(hideLabelsValues, ", "))
+		}
 	}
 
-	sensitiveDataPaths = append(sensitiveDataPaths, splitList(os.Getenv("SENSITIVE_DATA_PATHS"))...)
+	var swarmMetricsLabels []string
+	for _, v := range s.SwarmMetricsLabels {
+		switch {
+		case !slices.Contains(swarmMetricsLabelValues, v):
+			errorf("swarmMetricsLabels entry %q must be one of %s", v, strings.Join(swarmMetricsLabelValues, ", "))
+		case v == "none" && len(s.SwarmMetricsLabels) > 1:
+			errorf("swarmMetricsLabels entry \"none\" cannot be combined with others")
+		case v != "none" && !slices.Contains(swarmMetricsLabels, v):
+			swarmMetricsLabels = append(swarmMetricsLabels, v)
+		}
+	}
+	failedWindow, err := time.ParseDuration(s.SwarmMetricsFailedWindow)
+	if err != nil || failedWindow <= 0 {
+		errorf("swarmMetricsFailedWindow %q must be a positive duration such as 15m", s.SwarmMetricsFailedWindow)
+	}
+	webhooks := buildWebhooks(s.Webhooks, errorf)
 
-	sessionMaxAge := defaultSessionMaxAge
-	if s := os.Getenv("OIDC_SESSION_MAX_AGE"); s != "" {
-		if v, err := strconv.Atoi(s); err == nil && v > 0 {
-			sessionMaxAge = v
-		} else {
-			log.Printf("Warning: invalid OIDC_SESSION_MAX_AGE %q, using default %d", s, defaultSessionMaxAge)
+	historyRetention, err := time.ParseDuration(s.HistoryRetention)
+	if err != nil || historyRetention <= 0 {
+		errorf("historyRetention %q must be a positive duration such as 168h", s.HistoryRetention)
+	}
+	historyMaxBytes, err := parseSize(s.HistoryMaxSize)
+	if err != nil || historyMaxBytes <= 0 {
+		errorf("historyMaxSize %q must be a positive size such as 500MB", s.HistoryMaxSize)
+	}
+	keyframeInterval, err := time.ParseDuration(s.HistoryKeyframeInterval)
+	if err != nil || keyframeInterval <= 0 {
+		errorf("historyKeyframeInterval %q must be a positive duration such as 10m", s.HistoryKeyframeInterval)
+	}
+
+	readyStaleAfter, err := time.ParseDuration(s.ReadyStaleAfter)
+	if err != nil || readyStaleAfter <= 0 {
+		errorf("readyStaleAfter %q must be a positive duration such as 1m", s.ReadyStaleAfter)
+	}
+
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(s.LogLevel)); err != nil {
+		errorf("logLevel %q must be one of debug, info, warn, error", s.LogLevel)
+	}
+	if !slices.Contains(logFormatValues, s.LogFormat) {
+		errorf("logFormat %q must be one of %s", s.LogFormat, strings.Join(logFormatValues, ", "))
+	}
+
+	sensitiveDataPaths := append(slices.Clone(builtinSensitiveDataPaths), s.SensitiveDataPaths...)
+	compiledPaths := make([]*internal.Path, 0, len(sensitiveDataPaths))
+	for _, p := range sensitiveDataPaths {
+		compiled, err := internal.CompilePath(p)
+		if err != nil {
+			errorf("sensitiveDataPaths entry %q: %v", p, err)
+			continue
 		}
+		compiledPaths = append(compiledPaths, compiled)
 	}
 
-	maxWSConnections := defaultMaxWSConnections
-	if s := os.Getenv("MAX_WS_CONNECTIONS"); s != "" {
-		if v, err := strconv.Atoi(s); err == nil && v > 0 {
-			maxWSConnections = v
-		} else {
-			log.Printf("Warning: invalid MAX_WS_CONNECTIONS %q, using default %d", s, defaultMaxWSConnections)
+	var allowedPaths []*internal.Path
+	for _, entry := range s.AllowedDataPaths {
+		paths, isPreset := dataPathPresets[entry]
+		if !isPreset {
+			paths = []string{entry}
+		}
+		for _, p := range paths {
+			compiled, err := internal.CompilePath(p)
+			switch {
+			case err != nil:
+				errorf("allowedDataPaths entry %q: %v", p, err)
+			case compiled.Action() != internal.ActionClear:
+				errorf("allowedDataPaths entry %q: actions are not supported", p)
+			case compiled.Recursive():
+				errorf("allowedDataPaths entry %q: ** is not supported", p)
+			default:
+				allowedPaths = append(allowedPaths, compiled)
+			}
 		}
 	}
 
+	var hiddenNodeLabels []internal.LabelSelector
+	for _, entry := range s.HiddenNodeLabels {
+		selector, err := internal.ParseLabelSelector(entry)
+		if err != nil {
+			errorf("hiddenNodeLabels entry %q: %v", entry, err)
+			continue
+		}
+		hiddenNodeLabels = append(hiddenNodeLabels, selector)
+	}
+
+	var secretDetector *internal.SecretDetector
+	if s.DetectSecrets {
+		secretDetector = internal.NewSecretDetector(s.SecretKeyPatterns)
+	}
+
+	rateLimits := maps.Clone(defaultRateLimits)
+	for _, group := range slices.Sorted(maps.Keys(s.RateLimits)) {
+		limit, err := parseRateLimit(group, s.RateLimits[group])
+		if err != nil {
+			errorf("rateLimits %s: %v", group, err)
+			continue
+		}
+		rateLimits[group] = limit
+	}
+
 	var trustedProxies []*net.IPNet
-	if tp := os.Getenv("TRUSTED_PROXIES"); tp != "" {
-		for _, entry := range strings.Split(tp, ",") {
-			entry = strings.TrimSpace(entry)
-			if !strings.Contains(entry, "/") {
-				ip := net.ParseIP(entry)
-				if ip == nil {
-					log.Printf("Warning: invalid trusted proxy address %q, skipping", entry)
-					continue
-				}
-				if ip.To4() != nil {
-					entry += "/32"
-				} else {
-					entry += "/128"
-				}
+	for _, entry := range s.TrustedProxies {
+		cidr, err := parseTrustedProxy(entry)
+		if err != nil {
+			errorf("trustedProxies entry %q: %v", entry, err)
+			continue
+		}
+		trustedProxies = append(trustedProxies, cidr)
+	}
+
+	oidc := s.OIDC
+	clientSecret := oidc.ClientSecret
+	if oidc.SessionMaxAge <= 0 {
+		errorf("oidc.sessionMaxAge %d must be positive", oidc.SessionMaxAge)
+	}
+	if oidc.Enabled {
+		for _, required := range []struct{ name, value string }{
+			{"oidc.clientId", oidc.ClientID},
+			{"oidc.redirectUrl", oidc.RedirectURL},
+			{"oidc.wellKnownUrl", oidc.WellKnownURL},
+		} {
+			if required.value == "" {
+				errorf("%s is required when authentication is enabled", required.name)
 			}
-			_, cidr, err := net.ParseCIDR(entry)
-			if err != nil {
-				log.Printf("Warning: invalid trusted proxy CIDR %q: %v, skipping", entry, err)
+		}
+		for _, u := range []struct{ name, value string }{
+			{"oidc.redirectUrl", oidc.RedirectURL},
+			{"oidc.wellKnownUrl", oidc.WellKnownURL},
+			{"oidc.authUrl", oidc.AuthURL},
+			{"oidc.tokenUrl", oidc.TokenURL},
+		} {
+			if u.value == "" {
 				continue
 			}
-			trustedProxies = append(trustedProxies, cidr)
+			if parsed, err := url.Parse(u.value); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+				errorf("%s %q must be an absolute URL", u.name, u.value)
+			}
 		}
 	}
+	if oidc.ClientSecretFile != "" {
+		if clientSecret != "" {
+			errorf("oidc.clientSecret and oidc.clientSecretFile are both set; use only one")
+		}
+		b, err := os.ReadFile(oidc.ClientSecretFile)
+		if err != nil {
+			errorf("oidc.clientSecretFile: %v", err)
+		}
+		clientSecret = strings.TrimRight(string(b), "\r\n")
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
 	return &Config{
-		ClusterName:  os.Getenv("CLUSTER_NAME"),
+		ClusterName:  s.ClusterName,
 		ContextRoot:  contextRoot,
-		ListenerPort: getEnv("LISTENER_PORT", defaultListenerPort),
-		AuthEnabled:  authEnabled,
+		ListenerPort: s.ListenerPort,
+		AdminListen:  s.AdminListen,
+		AuthEnabled:  oidc.Enabled,
 		OAuthConfig: OAuthConfig{
-			ClientID:         os.Getenv("OIDC_CLIENT_ID"),
-			ClientSecret:     strings.TrimSpace(clientSecret),
-			RedirectURL:      os.Getenv("OIDC_REDIRECT_URL"),
-			Scopes:           splitList(os.Getenv("OIDC_SCOPES")),
-			AuthURL:          os.Getenv("OIDC_AUTH_URL"),
-			TokenURL:         os.Getenv("OIDC_TOKEN_URL"),
-			OIDCWellKnownURL: os.Getenv("OIDC_WELL_KNOWN_URL"),
-			UsernameClaim:    getEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
-			SessionMaxAge:    sessionMaxAge,
+			ClientID:         oidc.ClientID,
+			ClientSecret:     clientSecret,
+			RedirectURL:      oidc.RedirectURL,
+			Scopes:           oidc.Scopes,
+			AuthURL:          oidc.AuthURL,
+			TokenURL:         oidc.TokenURL,
+			OIDCWellKnownURL: oidc.WellKnownURL,
+			UsernameClaim:    oidc.UsernameClaim,
+			SessionMaxAge:    oidc.SessionMaxAge,
+		},
+		TrustedProxies:             trustedProxies,
+		HideAllConfigs:             s.HideAllConfigs,
+		HideAllEnvs:                s.HideAllEnvs,
+		HideAllMounts:              s.HideAllMounts,
+		HideAllSecrets:             s.HideAllSecrets,
+		HideLabels:                 s.HideLabels,
+		HiddenStacks:               s.HiddenStacks,
+		HiddenServices:             s.HiddenServices,
+		HiddenNodeLabels:           s.HiddenNodeLabels,
+		CompiledHiddenStacks:       internal.CompileGlobs(s.HiddenStacks),
+		CompiledHiddenServices:     internal.CompileGlobs(s.HiddenServices),
+		CompiledHiddenNodeLabels:   hiddenNodeLabels,
+		SanitizeHashSalt:           s.SanitizeHashSalt,
+		SecretDetector:             secretDetector,
+		SecretKeyPatterns:          s.SecretKeyPatterns,
+		SensitiveDataPaths:         sensitiveDataPaths,
+		CompiledSensitiveDataPaths: compiledPaths,
+		AllowedDataPaths:           s.AllowedDataPaths,
+		CompiledAllowedDataPaths:   allowedPaths,
+		MaxWSConnections:           s.MaxWSConnections,
+		MaxWSConnectionsPerIP:      s.MaxWSConnectionsPerIP,
+		SwarmMetricsLabels:         swarmMetricsLabels,
+		SwarmMetricsFailedWindow:   failedWindow,
+		ReadyStaleAfter:            readyStaleAfter,
+		TimelineSize:               s.TimelineSize,
+		HistoryPath:                s.HistoryPath,
+		HistoryRetention:           historyRetention,
+		HistoryMaxBytes:            historyMaxBytes,
+		HistoryKeyframeInterval:    keyframeInterval,
+		LogLevel:                   logLevel,
+		LogFormat:                  s.LogFormat,
+		AdminToken:                 s.AdminToken,
+		Webhooks:                   webhooks,
+		RateLimits:                 rateLimits,
+	}, nil
+}
+
+// maskedSecret replaces secret values in Masked output.
+const maskedSecret = "********"
+
+// Masked returns the configuration in config file form, with the client
+// secret, hash salt, admin token, and webhook secrets and URLs masked, for
+// display. Rate limits are
+// given for every group and sensitiveDataPaths includes the built-in paths,
+// so the result shows exactly what is in effect.
+func (c *Config) Masked() any {
+	s := &settings{
+		ClusterName:              c.ClusterName,
+		ContextRoot:              c.ContextRoot,
+		ListenerPort:             c.ListenerPort,
+		AdminListen:              c.AdminListen,
+		MaxWSConnections:         c.MaxWSConnections,
+		MaxWSConnectionsPerIP:    c.MaxWSConnectionsPerIP,
+		RateLimits:               make(map[string]string, len(c.RateLimits)),
+		SensitiveDataPaths:       c.SensitiveDataPaths,
+		AllowedDataPaths:         c.AllowedDataPaths,
+		HideAllConfigs:           c.HideAllConfigs,
+		HideAllEnvs:              c.HideAllEnvs,
+		HideAllMounts:            c.HideAllMounts,
+		HideAllSecrets:           c.HideAllSecrets,
+		HideLabels:               c.HideLabels,
+		HiddenStacks:             c.HiddenStacks,
+		HiddenServices:           c.HiddenServices,
+		HiddenNodeLabels:         c.HiddenNodeLabels,
+		DetectSecrets:            c.SecretDetector != nil,
+		SecretKeyPatterns:        c.SecretKeyPatterns,
+		SwarmMetricsLabels:       c.SwarmMetricsLabels,
+		SwarmMetricsFailedWindow: c.SwarmMetricsFailedWindow.String(),
+		ReadyStaleAfter:          c.ReadyStaleAfter.String(),
+		TimelineSize:             c.TimelineSize,
+		HistoryPath:              c.HistoryPath,
+		HistoryRetention:         c.HistoryRetention.String(),
+		HistoryMaxSize:           formatSize(c.HistoryMaxBytes),
+		HistoryKeyframeInterval:  c.HistoryKeyframeInterval.String(),
+		LogLevel:                 strings.ToLower(c.LogLevel.String()),
+		LogFormat:                c.LogFormat,
+		OIDC: oidcSettings{
+			Enabled:       c.AuthEnabled,
+			ClientID:      c.OAuthConfig.ClientID,
+			RedirectURL:   c.OAuthConfig.RedirectURL,
+			Scopes:        c.OAuthConfig.Scopes,
+			WellKnownURL:  c.OAuthConfig.OIDCWellKnownURL,
+			AuthURL:       c.OAuthConfig.AuthURL,
+			TokenURL:      c.OAuthConfig.TokenURL,
+			UsernameClaim: c.OAuthConfig.UsernameClaim,
+			SessionMaxAge: c.OAuthConfig.SessionMaxAge,
 		},
-		TrustedProxies:     trustedProxies,
-		HideAllConfigs:     os.Getenv("HIDE_ALL_CONFIGS") == "true",
-		HideAllEnvs:        os.Getenv("HIDE_ALL_ENVS") == "true",
-		HideAllMounts:      os.Getenv("HIDE_ALL_MOUNTS") == "true",
-		HideAllSecrets:     os.Getenv("HIDE_ALL_SECRETS") == "true",
-		HideLabels:         splitList(os.Getenv("HIDE_LABELS")),
-		SensitiveDataPaths: sensitiveDataPaths,
-		MaxWSConnections:   maxWSConnections,
 	}
+	if c.OAuthConfig.ClientSecret != "" {
+		s.OIDC.ClientSecret = maskedSecret
+	}
+	if c.SanitizeHashSalt != "" {
+		s.SanitizeHashSalt = maskedSecret
+	}
+	if c.AdminToken != "" {
+		s.AdminToken = maskedSecret
+	}
+	for _, w := range c.Webhooks {
+		s.Webhooks = append(s.Webhooks, w.masked())
+	}
+	for _, cidr := range c.TrustedProxies {
+		s.TrustedProxies = append(s.TrustedProxies, cidr.String())
+	}
+	for group, limit := range c.RateLimits {
+		s.RateLimits[group] = limit.String()
+	}
+	return s
+}
+
+// SanitizationFingerprint identifies the settings that decide what is left
+// out of and redacted in the published snapshots, so data sanitized under
+// other settings can be told apart. The hash salt is not part of it, as
+// hashed values reveal nothing under any salt.
+func (c *Config) SanitizationFingerprint() string {
+	s := &settings{
+		SensitiveDataPaths: c.SensitiveDataPaths,
+		AllowedDataPaths:   c.AllowedDataPaths,
+		HideAllConfigs:     c.HideAllConfigs,
+		HideAllEnvs:        c.HideAllEnvs,
+		HideAllMounts:      c.HideAllMounts,
+		HideAllSecrets:     c.HideAllSecrets,
+		HideLabels:         c.HideLabels,
+		HiddenStacks:       c.HiddenStacks,
+		HiddenServices:     c.HiddenServices,
+		HiddenNodeLabels:   c.HiddenNodeLabels,
+		DetectSecrets:      c.SecretDetector != nil,
+		SecretKeyPatterns:  c.SecretKeyPatterns,
+	}
+	b, _ := json.Marshal(s)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// String formats the policy in the form parseRateLimit accepts.
+func (l RateLimit) String() string {
+	if l.Requests == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s:%d", l.Requests, l.Period, l.Burst)
 }
 
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// parseTrustedProxy parses a trusted proxy entry, accepting a plain IP address
+// as a single-host range alongside CIDR notation.
+func parseTrustedProxy(entry string) (*net.IPNet, error) {
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address")
+		}
+		if ip.To4() != nil {
+			entry += "/32"
+		} else {
+			entry += "/128"
+		}
 	}
-	return value
+	_, cidr, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, err
+	}
+	return cidr, nil
+}
+
+// parseRateLimit parses a rate limit policy for group of the form
+// requests/period[:burst], e.g. "30/1m:10", or "off". The burst defaults to
+// requests.
+func parseRateLimit(group, spec string) (RateLimit, error) {
+	if _, known := defaultRateLimits[group]; !known {
+		return RateLimit{}, fmt.Errorf("unknown group, expected one of %s", strings.Join(RateLimitGroups, ", "))
+	}
+	if spec == "off" {
+		return RateLimit{}, nil
+	}
+
+	spec, burstStr, hasBurst := strings.Cut(spec, ":")
+	reqStr, periodStr, ok := strings.Cut(spec, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("expected requests/period[:burst] or off")
+	}
+	requests, err := strconv.Atoi(reqStr)
+	if err != nil || requests <= 0 {
+		return RateLimit{}, fmt.Errorf("requests must be a positive integer")
+	}
+	period, err := time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return RateLimit{}, fmt.Errorf("period must be a positive duration such as 1m")
+	}
+	burst := requests
+	if hasBurst {
+		if burst, err = strconv.Atoi(burstStr); err != nil || burst <= 0 {
+			return RateLimit{}, fmt.Errorf("burst must be a positive integer")
+		}
+	}
+	return RateLimit{Requests: requests, Period: period, Burst: burst}, nil
 }
 
 // splitList parses a comma-separated value into a slice, trimming whitespace
@@ -163,3 +756,47 @@ func splitList(value string) []string {
 	}
 	return out
 }
+
+// sizeUnits are the suffixes parseSize accepts, largest first. They are
+// powers of 1024.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize parses a size in bytes, optionally with a KB, MB, or GB suffix,
+// e.g. "500MB".
+func parseSize(v string) (int64, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(v, u.suffix); ok {
+			v, unit = strings.TrimSpace(n), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/unit || n < math.MinInt64/unit {
+		return 0, strconv.ErrRange
+	}
+	return n * unit, nil
+}
+
+// formatSize formats n in the form parseSize accepts, in the largest unit
+// that divides it.
+func formatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n != 0 && n%u.bytes == 0 {
+			return strconv.FormatInt(n/u.bytes, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
@@ -1,8 +1,14 @@
 package config
 
 import (
+	"log/slog"
 	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func setEnv(t *testing.T, key, value string) {
@@ -10,6 +16,27 @@ func setEnv(t *testing.T, key, value string) {
 	t.Setenv(key, value)
 }
 
+// mustLoad calls LoadConfig and fails the test on error.
+func mustLoad(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return cfg
+}
+
+// writeFile writes content to a file named name in a temporary directory and
+// returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
 // TestLoadConfig_TrustedProxies verifies TRUSTED_PROXIES parsing.
 func TestLoadConfig_TrustedProxies(t *testing.T) {
 	tests := []struct {
@@ -18,6 +45,7 @@ func TestLoadConfig_TrustedProxies(t *testing.T) {
 		wantLen     int
 		wantContain []string // IPs that should be contained
 		wantExclude []string // IPs that should NOT be contained
+		wantErr     bool
 	}{
 		{
 			name:     "empty env produces no proxies",
@@ -52,9 +80,9 @@ func TestLoadConfig_TrustedProxies(t *testing.T) {
 			wantContain: []string{"10.0.0.1", "192.168.1.100"},
 		},
 		{
-			name:     "invalid entry is skipped",
+			name:     "invalid entry is an error",
 			envValue: "not-an-ip,10.0.0.1",
-			wantLen:  1,
+			wantErr:  true,
 		},
 		{
 			name:        "plain IPv6 accepted",
@@ -69,7 +97,16 @@ func TestLoadConfig_TrustedProxies(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			setEnv(t, "TRUSTED_PROXIES", tc.envValue)
 
-			cfg := LoadConfig()
+			cfg, err := LoadConfig()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
 
 			if len(cfg.TrustedProxies) != tc.wantLen {
 				t.Errorf("TrustedProxies length = %d, want %d", len(cfg.TrustedProxies), tc.wantLen)
@@ -133,44 +170,30 @@ func TestLoadConfig_SessionMaxAge(t *testing.T) {
 		name     string
 		envValue string
 		want     int
+		wantErr  bool
 	}{
-		{
-			name:     "unset uses default",
-			envValue: "",
-			want:     defaultSessionMaxAge,
-		},
-		{
-			name:     "valid positive integer",
-			envValue: "7200",
-			want:     7200,
-		},
-		{
-			name:     "invalid string falls back to default",
-			envValue: "notanumber",
-			want:     defaultSessionMaxAge,
-		},
-		{
-			name:     "zero falls back to default",
-			envValue: "0",
-			want:     defaultSessionMaxAge,
-		},
-		{
-			name:     "negative falls back to default",
-			envValue: "-60",
-			want:     defaultSessionMaxAge,
-		},
-		{
-			name:     "value of 1 is accepted",
-			envValue: "1",
-			want:     1,
-		},
+		{name: "unset uses default", envValue: "", want: defaultSessionMaxAge},
+		{name: "valid positive integer", envValue: "7200", want: 7200},
+		{name: "invalid string is an error", envValue: "notanumber", wantErr: true},
+		{name: "zero is an error", envValue: "0", wantErr: true},
+		{name: "negative is an error", envValue: "-60", wantErr: true},
+		{name: "value of 1 is accepted", envValue: "1", want: 1},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			setEnv(t, "OIDC_SESSION_MAX_AGE", tc.envValue)
 
-			cfg := LoadConfig()
+			cfg, err := LoadConfig()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
 
 			if cfg.OAuthConfig.SessionMaxAge != tc.want {
 				t.Errorf("SessionMaxAge = %d, want %d", cfg.OAuthConfig.SessionMaxAge, tc.want)
@@ -185,19 +208,29 @@ func TestLoadConfig_MaxWSConnections(t *testing.T) {
 		name     string
 		envValue string
 		want     int
+		wantErr  bool
 	}{
 		{name: "unset uses default", envValue: "", want: defaultMaxWSConnections},
 		{name: "valid positive integer", envValue: "50", want: 50},
-		{name: "invalid string falls back to default", envValue: "lots", want: defaultMaxWSConnections},
-		{name: "zero falls back to default", envValue: "0", want: defaultMaxWSConnections},
-		{name: "negative falls back to default", envValue: "-1", want: defaultMaxWSConnections},
+		{name: "invalid string is an error", envValue: "lots", wantErr: true},
+		{name: "zero is an error", envValue: "0", wantErr: true},
+		{name: "negative is an error", envValue: "-1", wantErr: true},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			setEnv(t, "MAX_WS_CONNECTIONS", tc.envValue)
 
-			cfg := LoadConfig()
+			cfg, err := LoadConfig()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
 
 			if cfg.MaxWSConnections != tc.want {
 				t.Errorf("MaxWSConnections = %d, want %d", cfg.MaxWSConnections, tc.want)
@@ -205,3 +238,510 @@ func TestLoadConfig_MaxWSConnections(t *testing.T) {
 		})
 	}
 }
+
+// TestLoadConfig_RateLimits verifies RATE_LIMITS overrides individual groups
+// and leaves the rest at their defaults.
+func TestLoadConfig_RateLimits(t *testing.T) {
+	setEnv(t, "RATE_LIMITS", "ws=60/1m:20, login=off, api=10/30s")
+
+	cfg := mustLoad(t)
+
+	tests := []struct {
+		group string
+		want  RateLimit
+	}{
+		{group: RateLimitWS, want: RateLimit{Requests: 60, Period: time.Minute, Burst: 20}},
+		{group: RateLimitLogin, want: RateLimit{}},
+		{group: RateLimitAPI, want: RateLimit{Requests: 10, Period: 30 * time.Second, Burst: 10}},
+		{group: RateLimitCallback, want: defaultRateLimits[RateLimitCallback]},
+		{group: RateLimitLogout, want: defaultRateLimits[RateLimitLogout]},
+	}
+	for _, tc := range tests {
+		if got := cfg.RateLimits[tc.group]; got != tc.want {
+			t.Errorf("RateLimits[%s] = %+v, want %+v", tc.group, got, tc.want)
+		}
+	}
+}
+
+// TestLoadConfig_ReportsEveryProblem verifies validation collects all problems
+// rather than stopping at the first.
+func TestLoadConfig_ReportsEveryProblem(t *testing.T) {
+	setEnv(t, "RATE_LIMITS", "bogus=1/1s,callback=five/1m")
+	setEnv(t, "HIDE_LABELS", "node,everything")
+	setEnv(t, "LISTENER_PORT", "http")
+	setEnv(t, "HIDE_ALL_ENVS", "yes")
+	setEnv(t, "ENABLE_AUTHN", "true")
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"rateLimits bogus",
+		"rateLimits callback",
+		`hideLabels entry "everything"`,
+		`listenerPort "http"`,
+		`HIDE_ALL_ENVS "yes"`,
+		"oidc.clientId is required",
+		"oidc.redirectUrl is required",
+		"oidc.wellKnownUrl is required",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
+
+// TestLoadConfig_FilePrecedence verifies the config file overrides defaults,
+// environment variables override the file field by field, and keys absent
+// from both keep their defaults.
+func TestLoadConfig_FilePrecedence(t *testing.T) {
+	secret := writeFile(t, "secret", "s3cret\n")
+	path := writeFile(t, "config.yaml", `
+clusterName: From File
+maxWSConnections: 10
+hideLabels: [node]
+sensitiveDataPaths:
+  - services.*.Spec.Labels
+rateLimits:
+  ws: 5/1m
+  login: "off"
+oidc:
+  enabled: true
+  clientId: file-client
+  clientSecretFile: `+secret+`
+  redirectUrl: https://app.example.com/callback
+  wellKnownUrl: https://idp.example.com/.well-known/openid-configuration
+  scopes: [openid, profile]
+`)
+	setEnv(t, "CONFIG_FILE", path)
+	setEnv(t, "CLUSTER_NAME", "From Env")
+	setEnv(t, "RATE_LIMITS", "ws=7/1m")
+
+	cfg := mustLoad(t)
+
+	if cfg.ClusterName != "From Env" {
+		t.Errorf("ClusterName = %q, want the env override", cfg.ClusterName)
+	}
+	if cfg.MaxWSConnections != 10 {
+		t.Errorf("MaxWSConnections = %d, want 10 from the file", cfg.MaxWSConnections)
+	}
+	if cfg.ListenerPort != defaultListenerPort {
+		t.Errorf("ListenerPort = %q, want the default", cfg.ListenerPort)
+	}
+	if len(cfg.HideLabels) != 1 || cfg.HideLabels[0] != "node" {
+		t.Errorf("HideLabels = %v, want [node]", cfg.HideLabels)
+	}
+	if last := cfg.SensitiveDataPaths[len(cfg.SensitiveDataPaths)-1]; last != "services.*.Spec.Labels" {
+		t.Errorf("SensitiveDataPaths ends with %q, want the file's path after the built-ins", last)
+	}
+	if got := cfg.RateLimits[RateLimitWS]; got.Requests != 7 {
+		t.Errorf("RateLimits[ws] = %+v, want the env override", got)
+	}
+	if got := cfg.RateLimits[RateLimitLogin]; got.Requests != 0 {
+		t.Errorf("RateLimits[login] = %+v, want off from the file", got)
+	}
+	if !cfg.AuthEnabled || cfg.OAuthConfig.ClientID != "file-client" || cfg.OAuthConfig.ClientSecret != "s3cret" {
+		t.Errorf("OAuthConfig = %+v, want the file's OIDC settings", cfg.OAuthConfig)
+	}
+	if cfg.OAuthConfig.UsernameClaim != defaultUsernameClaim {
+		t.Errorf("UsernameClaim = %q, want the default", cfg.OAuthConfig.UsernameClaim)
+	}
+}
+
+// TestLoadConfig_FileJSON verifies JSON config files are accepted.
+func TestLoadConfig_FileJSON(t *testing.T) {
+	setEnv(t, "CONFIG_FILE", writeFile(t, "config.json", `{"clusterName": "JSON", "hideAllEnvs": true}`))
+
+	cfg := mustLoad(t)
+
+	if cfg.ClusterName != "JSON" || !cfg.HideAllEnvs {
+		t.Errorf("got ClusterName=%q HideAllEnvs=%v, want the file's values", cfg.ClusterName, cfg.HideAllEnvs)
+	}
+}
+
+// TestLoadConfig_FileUnknownKeys verifies unknown keys are hard errors in both
+// formats, including in nested sections.
+func TestLoadConfig_FileUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "config.yaml", content: "clusterName: x\nclustername: typo\n"},
+		{name: "config.yml", content: "oidc:\n  clientID: typo\n"},
+		{name: "config.json", content: `{"hideAllEnv": true}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			setEnv(t, "CONFIG_FILE", writeFile(t, tc.name, tc.content))
+			if _, err := LoadConfig(); err == nil {
+				t.Fatal("expected an unknown key to be an error")
+			}
+		})
+	}
+}
+
+// TestLoadConfig_FileVariants verifies any setting can be read from a file via
+// its _FILE variant, with trailing newlines trimmed and relative paths
+// resolved against the secrets directory.
+func TestLoadConfig_FileVariants(t *testing.T) {
+	dir := t.TempDir()
+	orig := secretsDir
+	secretsDir = dir
+	t.Cleanup(func() { secretsDir = orig })
+
+	if err := os.WriteFile(filepath.Join(dir, "client_id"), []byte("from-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	setEnv(t, "OIDC_CLIENT_ID_FILE", "client_id")
+	setEnv(t, "OIDC_CLIENT_SECRET_FILE", writeFile(t, "client_secret", " padded secret \r\n"))
+	setEnv(t, "MAX_WS_CONNECTIONS_FILE", writeFile(t, "max", "12\n"))
+
+	cfg := mustLoad(t)
+
+	if cfg.OAuthConfig.ClientID != "from-secret" {
+		t.Errorf("ClientID = %q, want the relative secret's contents", cfg.OAuthConfig.ClientID)
+	}
+	if cfg.OAuthConfig.ClientSecret != " padded secret " {
+		t.Errorf("ClientSecret = %q, want only trailing newlines trimmed", cfg.OAuthConfig.ClientSecret)
+	}
+	if cfg.MaxWSConnections != 12 {
+		t.Errorf("MaxWSConnections = %d, want 12", cfg.MaxWSConnections)
+	}
+}
+
+// TestLoadConfig_FileVariantConflicts verifies setting both a variable and its
+// _FILE variant, or naming a missing file, is an error.
+func TestLoadConfig_FileVariantConflicts(t *testing.T) {
+	setEnv(t, "OIDC_CLIENT_ID", "plain")
+	setEnv(t, "OIDC_CLIENT_ID_FILE", writeFile(t, "client_id", "file"))
+	setEnv(t, "CLUSTER_NAME_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"OIDC_CLIENT_ID and OIDC_CLIENT_ID_FILE are both set", "CLUSTER_NAME_FILE"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
+
+// TestLoadConfig_SensitiveDataPathPatterns verifies sensitive data paths are
+// compiled at load, so malformed patterns are rejected up front.
+func TestLoadConfig_SensitiveDataPathPatterns(t *testing.T) {
+	setEnv(t, "SENSITIVE_DATA_PATHS", "services.*.Spec.Labels.'com.example.secret.*',**.Env")
+	cfg := mustLoad(t)
+	if len(cfg.CompiledSensitiveDataPaths) != len(cfg.SensitiveDataPaths) {
+		t.Fatalf("compiled %d paths, want %d", len(cfg.CompiledSensitiveDataPaths), len(cfg.SensitiveDataPaths))
+	}
+	for i, p := range cfg.CompiledSensitiveDataPaths {
+		if p.String() != cfg.SensitiveDataPaths[i] {
+			t.Errorf("compiled path %d = %q, want %q", i, p, cfg.SensitiveDataPaths[i])
+		}
+	}
+
+	for _, bad := range []string{"services./(/", "services..Spec", "services.**"} {
+		t.Run(bad, func(t *testing.T) {
+			setEnv(t, "SENSITIVE_DATA_PATHS", bad)
+			_, err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), "sensitiveDataPaths entry") {
+				t.Fatalf("LoadConfig error = %v, want a sensitiveDataPaths error", err)
+			}
+		})
+	}
+}
+
+// TestLoadConfig_AllowedDataPaths verifies presets are expanded alongside
+// explicit paths, and that actions and ** are rejected in allow-list paths.
+func TestLoadConfig_AllowedDataPaths(t *testing.T) {
+	setEnv(t, "ALLOWED_DATA_PATHS", "minimal,services.*.Spec.TaskTemplate.Placement")
+	cfg := mustLoad(t)
+	if want := len(minimalDataPaths) + 1; len(cfg.CompiledAllowedDataPaths) != want {
+		t.Errorf("compiled %d allowed paths, want %d", len(cfg.CompiledAllowedDataPaths), want)
+	}
+	if !slices.Equal(cfg.AllowedDataPaths, []string{"minimal", "services.*.Spec.TaskTemplate.Placement"}) {
+		t.Errorf("AllowedDataPaths = %v, want the entries as configured", cfg.AllowedDataPaths)
+	}
+
+	for _, bad := range []string{"services.*.Spec=mask", "**.Name", "services./(/"} {
+		t.Run(bad, func(t *testing.T) {
+			setEnv(t, "ALLOWED_DATA_PATHS", bad)
+			_, err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), "allowedDataPaths entry") {
+				t.Fatalf("LoadConfig error = %v, want an allowedDataPaths error", err)
+			}
+		})
+	}
+}
+
+// TestLoadConfig_SanitizeHashSalt verifies the hash salt is carried into the
+// Config and masked for display.
+func TestLoadConfig_SanitizeHashSalt(t *testing.T) {
+	setEnv(t, "SANITIZE_HASH_SALT", "pepper")
+	cfg := mustLoad(t)
+	if cfg.SanitizeHashSalt != "pepper" {
+		t.Errorf("SanitizeHashSalt = %q, want %q", cfg.SanitizeHashSalt, "pepper")
+	}
+	if got := cfg.Masked().(*settings).SanitizeHashSalt; got != maskedSecret {
+		t.Errorf("masked SanitizeHashSalt = %q, want %q", got, maskedSecret)
+	}
+}
+
+func TestLoadConfig_AdminListen(t *testing.T) {
+	setEnv(t, "ADMIN_LISTEN", "127.0.0.1:9090")
+	if cfg := mustLoad(t); cfg.AdminListen != "127.0.0.1:9090" {
+		t.Errorf("AdminListen = %q, want %q", cfg.AdminListen, "127.0.0.1:9090")
+	}
+
+	setEnv(t, "ADMIN_LISTEN", "9090")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "adminListen") {
+		t.Fatalf("LoadConfig error = %v, want an adminListen error", err)
+	}
+}
+
+func TestLoadConfig_AdminToken(t *testing.T) {
+	setEnv(t, "ADMIN_TOKEN", "letmein")
+	cfg := mustLoad(t)
+	if cfg.AdminToken != "letmein" {
+		t.Errorf("AdminToken = %q, want %q", cfg.AdminToken, "letmein")
+	}
+	if got := cfg.Masked().(*settings).AdminToken; got != maskedSecret {
+		t.Errorf("masked AdminToken = %q, want %q", got, maskedSecret)
+	}
+}
+
+func TestLoadConfig_Hidden(t *testing.T) {
+	setEnv(t, "HIDDEN_STACKS", "monitoring,infra-*")
+	setEnv(t, "HIDDEN_SERVICES", "*_socket-proxy")
+	setEnv(t, "HIDDEN_NODE_LABELS", "role=build*")
+	cfg := mustLoad(t)
+	if !cfg.CompiledHiddenStacks.Match("infra-logging") || cfg.CompiledHiddenStacks.Match("app") {
+		t.Errorf("CompiledHiddenStacks = %v, want infra-* matched and app not", cfg.HiddenStacks)
+	}
+	if !cfg.CompiledHiddenServices.Match("viz_socket-proxy") {
+		t.Errorf("CompiledHiddenServices = %v, want viz_socket-proxy matched", cfg.HiddenServices)
+	}
+	if len(cfg.CompiledHiddenNodeLabels) != 1 || !cfg.CompiledHiddenNodeLabels[0].Matches(map[string]string{"role": "builder"}) {
+		t.Errorf("CompiledHiddenNodeLabels = %v, want role=build* to match role=builder", cfg.CompiledHiddenNodeLabels)
+	}
+
+	if masked := cfg.Masked().(*settings); !slices.Equal(masked.HiddenStacks, cfg.HiddenStacks) || !slices.Equal(masked.HiddenNodeLabels, cfg.HiddenNodeLabels) {
+		t.Errorf("masked hidden settings = %v/%v, want them shown", masked.HiddenStacks, masked.HiddenNodeLabels)
+	}
+
+	setEnv(t, "HIDDEN_NODE_LABELS", "=x")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "hiddenNodeLabels entry") {
+		t.Fatalf("LoadConfig error = %v, want a hiddenNodeLabels error", err)
+	}
+}
+
+func TestLoadConfig_SwarmMetrics(t *testing.T) {
+	cfg := mustLoad(t)
+	if !slices.Equal(cfg.SwarmMetricsLabels, []string{"stack", "service", "node"}) || cfg.SwarmMetricsFailedWindow != 15*time.Minute {
+		t.Errorf("defaults = %v %v, want all labels and 15m", cfg.SwarmMetricsLabels, cfg.SwarmMetricsFailedWindow)
+	}
+
+	setEnv(t, "SWARM_METRICS_LABELS", "service,stack,service")
+	setEnv(t, "SWARM_METRICS_FAILED_WINDOW", "1h")
+	cfg = mustLoad(t)
+	if !slices.Equal(cfg.SwarmMetricsLabels, []string{"service", "stack"}) || cfg.SwarmMetricsFailedWindow != time.Hour {
+		t.Errorf("configured = %v %v, want [service stack] 1h", cfg.SwarmMetricsLabels, cfg.SwarmMetricsFailedWindow)
+	}
+
+	setEnv(t, "SWARM_METRICS_LABELS", "none")
+	if cfg = mustLoad(t); len(cfg.SwarmMetricsLabels) != 0 {
+		t.Errorf("none = %v, want no labels", cfg.SwarmMetricsLabels)
+	}
+
+	for env, bad := range map[string]string{
+		"SWARM_METRICS_LABELS":        "none,stack",
+		"SWARM_METRICS_FAILED_WINDOW": "0s",
+	} {
+		t.Run(env, func(t *testing.T) {
+			setEnv(t, "SWARM_METRICS_LABELS", "stack")
+			setEnv(t, env, bad)
+			if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "swarmMetrics") {
+				t.Fatalf("LoadConfig error = %v, want a swarmMetrics error", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_ReadyStaleAfter(t *testing.T) {
+	if cfg := mustLoad(t); cfg.ReadyStaleAfter != time.Minute {
+		t.Errorf("default = %v, want 1m", cfg.ReadyStaleAfter)
+	}
+
+	setEnv(t, "READY_STALE_AFTER", "90s")
+	if cfg := mustLoad(t); cfg.ReadyStaleAfter != 90*time.Second {
+		t.Errorf("configured = %v, want 1m30s", cfg.ReadyStaleAfter)
+	}
+
+	setEnv(t, "READY_STALE_AFTER", "-1s")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "readyStaleAfter") {
+		t.Fatalf("LoadConfig error = %v, want a readyStaleAfter error", err)
+	}
+}
+
+func TestLoadConfig_Logging(t *testing.T) {
+	cfg := mustLoad(t)
+	if cfg.LogLevel != slog.LevelInfo || cfg.LogFormat != "text" {
+		t.Errorf("defaults = %v %q, want INFO text", cfg.LogLevel, cfg.LogFormat)
+	}
+
+	setEnv(t, "LOG_LEVEL", "debug")
+	setEnv(t, "LOG_FORMAT", "json")
+	cfg = mustLoad(t)
+	if cfg.LogLevel != slog.LevelDebug || cfg.LogFormat != "json" {
+		t.Errorf("configured = %v %q, want DEBUG json", cfg.LogLevel, cfg.LogFormat)
+	}
+	if got := cfg.Masked().(*settings).LogLevel; got != "debug" {
+		t.Errorf("masked LogLevel = %q, want debug", got)
+	}
+
+	for env, bad := range map[string]string{"LOG_LEVEL": "verbose", "LOG_FORMAT": "xml"} {
+		t.Run(env, func(t *testing.T) {
+			setEnv(t, env, bad)
+			if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "log") {
+				t.Fatalf("LoadConfig error = %v, want a logging error", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Webhooks(t *testing.T) {
+	setEnv(t, "WEBHOOKS", `[
+		{"url": "https://hooks.slack.com/services/T0/B0/token", "events": ["task_failed"], "template": "{\"text\": {{json .Message}}}"},
+		{"name": "pager", "url": "http://pager:8080", "secret": "s3cret", "dedupeWindow": "1h", "maxAttempts": 2}
+	]`)
+	cfg := mustLoad(t)
+	if len(cfg.Webhooks) != 2 {
+		t.Fatalf("Webhooks = %v, want 2", cfg.Webhooks)
+	}
+	slack, pager := cfg.Webhooks[0], cfg.Webhooks[1]
+	if slack.Name != "webhook1" || slack.Template == nil || slack.ContentType != "application/json" ||
+		slack.UnderReplicatedFor != time.Minute || slack.DedupeWindow != 10*time.Minute || slack.MaxAttempts != 5 {
+		t.Errorf("defaulted webhook = %+v", slack)
+	}
+	if !slack.Wants(EventTaskFailed) || slack.Wants(EventNodeDown) || !pager.Wants(EventNodeDown) {
+		t.Errorf("Wants: slack %v, pager %v", slack.Events, pager.Events)
+	}
+	if pager.DedupeWindow != time.Hour || pager.MaxAttempts != 2 {
+		t.Errorf("configured webhook = %+v", pager)
+	}
+
+	masked := cfg.Masked().(*settings).Webhooks
+	if masked[0].URL != "https://hooks.slack.com/"+maskedSecret || masked[0].Secret != "" {
+		t.Errorf("masked slack = %+v, want its URL path masked", masked[0])
+	}
+	if masked[1].URL != "http://pager:8080" || masked[1].Secret != maskedSecret {
+		t.Errorf("masked pager = %+v, want its secret masked", masked[1])
+	}
+
+	for name, bad := range map[string]string{
+		"not JSON":    `{"url": "http://x"}`,
+		"unknown key": `[{"url": "http://x", "retries": 3}]`,
+		"bad URL":     `[{"url": "ftp://x"}]`,
+		"bad event":   `[{"url": "http://x", "events": ["node_up"]}]`,
+		"bad tmpl":    `[{"url": "http://x", "template": "{{.Message"}]`,
+		"duplicate":   `[{"name": "a", "url": "http://x"}, {"name": "a", "url": "http://y"}]`,
+		"bad window":  `[{"url": "http://x", "dedupeWindow": "soon"}]`,
+		"bad retries": `[{"url": "http://x", "maxAttempts": -1}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			setEnv(t, "WEBHOOKS", bad)
+			if _, err := LoadConfig(); err == nil || !strings.Contains(strings.ToLower(err.Error()), "webhooks") {
+				t.Fatalf("LoadConfig error = %v, want a webhooks error", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_TimelineSize(t *testing.T) {
+	if cfg := mustLoad(t); cfg.TimelineSize != defaultTimelineSize {
+		t.Errorf("default = %d, want %d", cfg.TimelineSize, defaultTimelineSize)
+	}
+
+	setEnv(t, "TIMELINE_SIZE", "50")
+	if cfg := mustLoad(t); cfg.TimelineSize != 50 {
+		t.Errorf("configured = %d, want 50", cfg.TimelineSize)
+	}
+
+	setEnv(t, "TIMELINE_SIZE", "0")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "timelineSize") {
+		t.Fatalf("LoadConfig error = %v, want a timelineSize error", err)
+	}
+}
+
+func TestLoadConfig_History(t *testing.T) {
+	cfg := mustLoad(t)
+	if cfg.HistoryPath != "" || cfg.HistoryRetention != 168*time.Hour || cfg.HistoryMaxBytes != 1<<30 || cfg.HistoryKeyframeInterval != 10*time.Minute {
+		t.Errorf("defaults = %q, %s, %d, %s", cfg.HistoryPath, cfg.HistoryRetention, cfg.HistoryMaxBytes, cfg.HistoryKeyframeInterval)
+	}
+
+	setEnv(t, "HISTORY_PATH", "/data/history.db")
+	setEnv(t, "HISTORY_RETENTION", "24h")
+	setEnv(t, "HISTORY_MAX_SIZE", "500mb")
+	cfg = mustLoad(t)
+	if cfg.HistoryPath != "/data/history.db" || cfg.HistoryRetention != 24*time.Hour || cfg.HistoryMaxBytes != 500<<20 {
+		t.Errorf("configured = %q, %s, %d", cfg.HistoryPath, cfg.HistoryRetention, cfg.HistoryMaxBytes)
+	}
+	if got := cfg.Masked().(*settings).HistoryMaxSize; got != "500MB" {
+		t.Errorf("masked size = %q, want 500MB", got)
+	}
+
+	for env, value := range map[string]string{
+		"HISTORY_RETENTION":         "-1h",
+		"HISTORY_MAX_SIZE":          "lots",
+		"HISTORY_KEYFRAME_INTERVAL": "0s",
+	} {
+		t.Run(env, func(t *testing.T) {
+			setEnv(t, env, value)
+			if _, err := LoadConfig(); err == nil {
+				t.Fatalf("LoadConfig accepted %s=%s", env, value)
+			}
+		})
+	}
+}
+
+func TestConfig_SanitizationFingerprint(t *testing.T) {
+	base := &Config{SensitiveDataPaths: []string{"Spec.Labels"}, HideAllEnvs: true}
+	other := *base
+	other.SanitizeHashSalt, other.MaxWSConnections = "salt", 10
+	if base.SanitizationFingerprint() != other.SanitizationFingerprint() {
+		t.Error("settings other than sanitization changed the fingerprint")
+	}
+	other.HiddenStacks = []string{"secret"}
+	if base.SanitizationFingerprint() == other.SanitizationFingerprint() {
+		t.Error("hiding a stack did not change the fingerprint")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"64KB", 64 << 10, false},
+		{" 2 gb", 2 << 30, false},
+		{"10B", 10, false},
+		{"1.5GB", 0, true},
+		{"9999999999GB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+		if err == nil {
+			if back, _ := parseSize(formatSize(got)); back != got {
+				t.Errorf("formatSize(%d) = %q does not parse back", got, formatSize(got))
+			}
+		}
+	}
+}
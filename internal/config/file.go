@@ -0,0 +1,263 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jtgasper3/swarm-visualizer/internal"
+)
+
+// settings is the raw configuration as read from the config file and the
+// environment, before validation. The struct tags give the config file keys;
+// the comments give the overriding environment variable, each of which may
+// instead be read from a file named by the variable with a _FILE suffix.
+type settings struct {
+	ClusterName              string            `json:"clusterName" yaml:"clusterName"`                           // CLUSTER_NAME
+	ContextRoot              string            `json:"contextRoot" yaml:"contextRoot"`                           // CONTEXT_ROOT
+	ListenerPort             string            `json:"listenerPort" yaml:"listenerPort"`                         // LISTENER_PORT
+	AdminListen              string            `json:"adminListen" yaml:"adminListen"`                           // ADMIN_LISTEN
+	MaxWSConnections         int               `json:"maxWSConnections" yaml:"maxWSConnections"`                 // MAX_WS_CONNECTIONS
+	MaxWSConnectionsPerIP    int               `json:"maxWSConnectionsPerIP" yaml:"maxWSConnectionsPerIP"`       // MAX_WS_CONNECTIONS_PER_IP
+	RateLimits               map[string]string `json:"rateLimits" yaml:"rateLimits"`                             // RATE_LIMITS
+	TrustedProxies           []string          `json:"trustedProxies" yaml:"trustedProxies"`                     // TRUSTED_PROXIES
+	SensitiveDataPaths       []string          `json:"sensitiveDataPaths" yaml:"sensitiveDataPaths"`             // SENSITIVE_DATA_PATHS
+	AllowedDataPaths         []string          `json:"allowedDataPaths" yaml:"allowedDataPaths"`                 // ALLOWED_DATA_PATHS
+	HideAllConfigs           bool              `json:"hideAllConfigs" yaml:"hideAllConfigs"`                     // HIDE_ALL_CONFIGS
+	HideAllEnvs              bool              `json:"hideAllEnvs" yaml:"hideAllEnvs"`                           // HIDE_ALL_ENVS
+	HideAllMounts            bool              `json:"hideAllMounts" yaml:"hideAllMounts"`                       // HIDE_ALL_MOUNTS
+	HideAllSecrets           bool              `json:"hideAllSecrets" yaml:"hideAllSecrets"`                     // HIDE_ALL_SECRETS
+	HideLabels               []string          `json:"hideLabels" yaml:"hideLabels"`                             // HIDE_LABELS
+	HiddenStacks             []string          `json:"hiddenStacks" yaml:"hiddenStacks"`                         // HIDDEN_STACKS
+	HiddenServices           []string          `json:"hiddenServices" yaml:"hiddenServices"`                     // HIDDEN_SERVICES
+	HiddenNodeLabels         []string          `json:"hiddenNodeLabels" yaml:"hiddenNodeLabels"`                 // HIDDEN_NODE_LABELS
+	SanitizeHashSalt         string            `json:"sanitizeHashSalt" yaml:"sanitizeHashSalt"`                 // SANITIZE_HASH_SALT
+	DetectSecrets            bool              `json:"detectSecrets" yaml:"detectSecrets"`                       // DETECT_SECRETS
+	SecretKeyPatterns        []string          `json:"secretKeyPatterns" yaml:"secretKeyPatterns"`               // SECRET_KEY_PATTERNS
+	AdminToken               string            `json:"adminToken" yaml:"adminToken"`                             // ADMIN_TOKEN
+	SwarmMetricsLabels       []string          `json:"swarmMetricsLabels" yaml:"swarmMetricsLabels"`             // SWARM_METRICS_LABELS
+	SwarmMetricsFailedWindow string            `json:"swarmMetricsFailedWindow" yaml:"swarmMetricsFailedWindow"` // SWARM_METRICS_FAILED_WINDOW
+	ReadyStaleAfter          string            `json:"readyStaleAfter" yaml:"readyStaleAfter"`                   // READY_STALE_AFTER
+	TimelineSize             int               `json:"timelineSize" yaml:"timelineSize"`                         // TIMELINE_SIZE
+	HistoryPath              string            `json:"historyPath" yaml:"historyPath"`                           // HISTORY_PATH
+	HistoryRetention         string            `json:"historyRetention" yaml:"historyRetention"`                 // HISTORY_RETENTION
+	HistoryMaxSize           string            `json:"historyMaxSize" yaml:"historyMaxSize"`                     // HISTORY_MAX_SIZE
+	HistoryKeyframeInterval  string            `json:"historyKeyframeInterval" yaml:"historyKeyframeInterval"`   // HISTORY_KEYFRAME_INTERVAL
+	LogLevel                 string            `json:"logLevel" yaml:"logLevel"`                                 // LOG_LEVEL
+	LogFormat                string            `json:"logFormat" yaml:"logFormat"`                               // LOG_FORMAT
+	Webhooks                 []webhookSettings `json:"webhooks" yaml:"webhooks"`                                 // WEBHOOKS (a JSON array)
+	OIDC                     oidcSettings      `json:"oidc" yaml:"oidc"`
+}
+
+type oidcSettings struct {
+	Enabled          bool     `json:"enabled" yaml:"enabled"`                                       // ENABLE_AUTHN
+	ClientID         string   `json:"clientId" yaml:"clientId"`                                     // OIDC_CLIENT_ID
+	ClientSecret     string   `json:"clientSecret" yaml:"clientSecret"`                             // OIDC_CLIENT_SECRET
+	ClientSecretFile string   `json:"clientSecretFile,omitempty" yaml:"clientSecretFile,omitempty"` // (file only; use OIDC_CLIENT_SECRET_FILE)
+	RedirectURL      string   `json:"redirectUrl" yaml:"redirectUrl"`                               // OIDC_REDIRECT_URL
+	Scopes           []string `json:"scopes" yaml:"scopes"`                                         // OIDC_SCOPES
+	WellKnownURL     string   `json:"wellKnownUrl" yaml:"wellKnownUrl"`                             // OIDC_WELL_KNOWN_URL
+	AuthURL          string   `json:"authUrl" yaml:"authUrl"`                                       // OIDC_AUTH_URL
+	TokenURL         string   `json:"tokenUrl" yaml:"tokenUrl"`                                     // OIDC_TOKEN_URL
+	UsernameClaim    string   `json:"usernameClaim" yaml:"usernameClaim"`                           // OIDC_USERNAME_CLAIM
+	SessionMaxAge    int      `json:"sessionMaxAge" yaml:"sessionMaxAge"`                           // OIDC_SESSION_MAX_AGE
+}
+
+// defaultSettings returns the settings used when neither the config file nor
+// the environment provides a value.
+func defaultSettings() *settings {
+	return &settings{
+		ContextRoot:              defaultContextRoot,
+		ListenerPort:             defaultListenerPort,
+		MaxWSConnections:         defaultMaxWSConnections,
+		SecretKeyPatterns:        internal.DefaultSecretKeyPatterns,
+		SwarmMetricsLabels:       defaultSwarmMetricsLabels,
+		SwarmMetricsFailedWindow: defaultSwarmMetricsFailedWindow,
+		ReadyStaleAfter:          defaultReadyStaleAfter,
+		TimelineSize:             defaultTimelineSize,
+		HistoryRetention:         defaultHistoryRetention,
+		HistoryMaxSize:           defaultHistoryMaxSize,
+		HistoryKeyframeInterval:  defaultHistoryKeyframeInterval,
+		LogLevel:                 defaultLogLevel,
+		LogFormat:                defaultLogFormat,
+		OIDC: oidcSettings{
+			UsernameClaim: defaultUsernameClaim,
+			SessionMaxAge: defaultSessionMaxAge,
+		},
+	}
+}
+
+// loadFile decodes the YAML or JSON config file at path over s, so keys absent
+// from the file keep their current values. The format is chosen by extension
+// (.json, otherwise YAML). Unknown keys are an error.
+func (s *settings) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %v", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(s); err != nil {
+			return fmt.Errorf("config file %s: %v", path, err)
+		}
+		return nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// An empty file decodes to io.EOF; treat it as setting nothing.
+	if err := dec.Decode(s); err != nil && len(bytes.TrimSpace(data)) > 0 {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// secretsDir is where Docker mounts secrets. Relative _FILE paths are
+// resolved against it. It is a variable so tests can point it elsewhere.
+var secretsDir = "/run/secrets"
+
+// readEnv returns the value of the environment variable key or, if
+// <key>_FILE is set instead, the contents of the file it names with trailing
+// newlines trimmed. A relative _FILE path is resolved against /run/secrets,
+// so a Docker secret can be referenced by name. It is an error for both to be
+// set.
+func readEnv(key string) (string, error) {
+	value := os.Getenv(key)
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("%s and %s_FILE are both set; use only one", key, key)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(secretsDir, path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: %v", key, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// applyEnv overrides s with every environment variable (or its _FILE variant)
+// that is set to a non-empty value. It returns a problem for each value that
+// cannot be read or parsed.
+func (s *settings) applyEnv() []error {
+	var errs []error
+
+	getenv := func(key string) string {
+		v, err := readEnv(key)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return v
+	}
+	envString := func(key string, dst *string) {
+		if v := getenv(key); v != "" {
+			*dst = v
+		}
+	}
+	envList := func(key string, dst *[]string) {
+		if v := getenv(key); v != "" {
+			*dst = splitList(v)
+		}
+	}
+	envBool := func(key string, dst *bool) {
+		if v := getenv(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %q must be true or false", key, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	envInt := func(key string, dst *int) {
+		if v := getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %q must be an integer", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+
+	envString("CLUSTER_NAME", &s.ClusterName)
+	envString("CONTEXT_ROOT", &s.ContextRoot)
+	envString("LISTENER_PORT", &s.ListenerPort)
+	envString("ADMIN_LISTEN", &s.AdminListen)
+	envInt("MAX_WS_CONNECTIONS", &s.MaxWSConnections)
+	envInt("MAX_WS_CONNECTIONS_PER_IP", &s.MaxWSConnectionsPerIP)
+	envList("TRUSTED_PROXIES", &s.TrustedProxies)
+	envList("SENSITIVE_DATA_PATHS", &s.SensitiveDataPaths)
+	envList("ALLOWED_DATA_PATHS", &s.AllowedDataPaths)
+	envBool("HIDE_ALL_CONFIGS", &s.HideAllConfigs)
+	envBool("HIDE_ALL_ENVS", &s.HideAllEnvs)
+	envBool("HIDE_ALL_MOUNTS", &s.HideAllMounts)
+	envBool("HIDE_ALL_SECRETS", &s.HideAllSecrets)
+	envList("HIDE_LABELS", &s.HideLabels)
+	envList("HIDDEN_STACKS", &s.HiddenStacks)
+	envList("HIDDEN_SERVICES", &s.HiddenServices)
+	envList("HIDDEN_NODE_LABELS", &s.HiddenNodeLabels)
+	envString("SANITIZE_HASH_SALT", &s.SanitizeHashSalt)
+	envBool("DETECT_SECRETS", &s.DetectSecrets)
+	envList("SECRET_KEY_PATTERNS", &s.SecretKeyPatterns)
+	envString("ADMIN_TOKEN", &s.AdminToken)
+	envList("SWARM_METRICS_LABELS", &s.SwarmMetricsLabels)
+	envString("SWARM_METRICS_FAILED_WINDOW", &s.SwarmMetricsFailedWindow)
+	envString("READY_STALE_AFTER", &s.ReadyStaleAfter)
+	envInt("TIMELINE_SIZE", &s.TimelineSize)
+	envString("HISTORY_PATH", &s.HistoryPath)
+	envString("HISTORY_RETENTION", &s.HistoryRetention)
+	envString("HISTORY_MAX_SIZE", &s.HistoryMaxSize)
+	envString("HISTORY_KEYFRAME_INTERVAL", &s.HistoryKeyframeInterval)
+	envString("LOG_LEVEL", &s.LogLevel)
+	envString("LOG_FORMAT", &s.LogFormat)
+	if v := getenv("WEBHOOKS"); v != "" {
+		hooks, err := parseWebhooksEnv(v)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			s.Webhooks = hooks
+		}
+	}
+
+	// RATE_LIMITS overrides individual groups rather than the whole map.
+	for _, entry := range splitList(getenv("RATE_LIMITS")) {
+		group, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("RATE_LIMITS entry %q must be group=requests/period[:burst]", entry))
+			continue
+		}
+		if s.RateLimits == nil {
+			s.RateLimits = make(map[string]string)
+		}
+		s.RateLimits[strings.TrimSpace(group)] = strings.TrimSpace(spec)
+	}
+
+	envBool("ENABLE_AUTHN", &s.OIDC.Enabled)
+	envString("OIDC_CLIENT_ID", &s.OIDC.ClientID)
+	// The environment's secret replaces a secret file named in the config file.
+	if v := getenv("OIDC_CLIENT_SECRET"); v != "" {
+		s.OIDC.ClientSecret, s.OIDC.ClientSecretFile = v, ""
+	}
+	envString("OIDC_REDIRECT_URL", &s.OIDC.RedirectURL)
+	envList("OIDC_SCOPES", &s.OIDC.Scopes)
+	envString("OIDC_WELL_KNOWN_URL", &s.OIDC.WellKnownURL)
+	envString("OIDC_AUTH_URL", &s.OIDC.AuthURL)
+	envString("OIDC_TOKEN_URL", &s.OIDC.TokenURL)
+	envString("OIDC_USERNAME_CLAIM", &s.OIDC.UsernameClaim)
+	envInt("OIDC_SESSION_MAX_AGE", &s.OIDC.SessionMaxAge)
+
+	return errs
+}
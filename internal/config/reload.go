@@ -0,0 +1,166 @@
+package config
+
+import (
+	"log/slog"
+	"maps"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Holder holds the running Config and lets its reloadable subset be replaced
+// at runtime. Readers call Load for the current value; the value is swapped
+// atomically and never mutated afterwards, so a reader sees either the old or
+// the new configuration in full. It is safe for concurrent use.
+type Holder struct {
+	cur atomic.Pointer[Config]
+
+	// mu serializes reloads and guards subs.
+	mu   sync.Mutex
+	subs []func(*Config)
+}
+
+// NewHolder creates a Holder for cfg.
+func NewHolder(cfg *Config) *Holder {
+	h := &Holder{}
+	h.cur.Store(cfg)
+	return h
+}
+
+// Load returns the current configuration. Callers must not modify it.
+func (h *Holder) Load() *Config {
+	return h.cur.Load()
+}
+
+// Subscribe registers fn to be called with the new configuration after each
+// successful reload.
+func (h *Holder) Subscribe(fn func(*Config)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs = append(h.subs, fn)
+}
+
+// Reload re-reads the configuration and swaps in its reloadable settings:
+// cluster name, sanitization options, connection caps, swarm metrics
+// settings, logging, and the admin token.
+// Other settings keep their running values until restart; changes to them are
+// logged. An invalid configuration is rejected whole and the running one kept.
+func (h *Holder) Reload() error {
+	next, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	merged := h.Load().withReloadable(next)
+	if restart := merged.restartRequired(next); len(restart) > 0 {
+		slog.Warn("Configuration changes require a restart to take effect", "settings", restart)
+	}
+	h.cur.Store(merged)
+	for _, fn := range h.subs {
+		fn(merged)
+	}
+	return nil
+}
+
+// withReloadable returns a copy of c with the reloadable settings taken from
+// next.
+func (c *Config) withReloadable(next *Config) *Config {
+	merged := *c
+	merged.ClusterName = next.ClusterName
+	merged.SensitiveDataPaths = next.SensitiveDataPaths
+	merged.CompiledSensitiveDataPaths = next.CompiledSensitiveDataPaths
+	merged.AllowedDataPaths = next.AllowedDataPaths
+	merged.CompiledAllowedDataPaths = next.CompiledAllowedDataPaths
+	merged.HideAllConfigs = next.HideAllConfigs
+	merged.HiddenStacks = next.HiddenStacks
+	merged.HiddenServices = next.HiddenServices
+	merged.HiddenNodeLabels = next.HiddenNodeLabels
+	merged.CompiledHiddenStacks = next.CompiledHiddenStacks
+	merged.CompiledHiddenServices = next.CompiledHiddenServices
+	merged.CompiledHiddenNodeLabels = next.CompiledHiddenNodeLabels
+	merged.HideAllEnvs = next.HideAllEnvs
+	merged.HideAllMounts = next.HideAllMounts
+	merged.HideAllSecrets = next.HideAllSecrets
+	merged.HideLabels = next.HideLabels
+	merged.SanitizeHashSalt = next.SanitizeHashSalt
+	merged.SecretDetector = next.SecretDetector
+	merged.SecretKeyPatterns = next.SecretKeyPatterns
+	merged.MaxWSConnections = next.MaxWSConnections
+	merged.MaxWSConnectionsPerIP = next.MaxWSConnectionsPerIP
+	merged.SwarmMetricsLabels = next.SwarmMetricsLabels
+	merged.SwarmMetricsFailedWindow = next.SwarmMetricsFailedWindow
+	merged.ReadyStaleAfter = next.ReadyStaleAfter
+	merged.LogLevel = next.LogLevel
+	merged.LogFormat = next.LogFormat
+	merged.AdminToken = next.AdminToken
+	merged.Webhooks = next.Webhooks
+	return &merged
+}
+
+// restartRequired names the settings that differ between c and next but are
+// not reloadable.
+func (c *Config) restartRequired(next *Config) []string {
+	var names []string
+	if c.ContextRoot != next.ContextRoot {
+		names = append(names, "contextRoot")
+	}
+	if c.ListenerPort != next.ListenerPort {
+		names = append(names, "listenerPort")
+	}
+	if c.AdminListen != next.AdminListen {
+		names = append(names, "adminListen")
+	}
+	if c.AuthEnabled != next.AuthEnabled || !reflect.DeepEqual(c.OAuthConfig, next.OAuthConfig) {
+		names = append(names, "oidc")
+	}
+	if !reflect.DeepEqual(c.TrustedProxies, next.TrustedProxies) {
+		names = append(names, "trustedProxies")
+	}
+	if c.TimelineSize != next.TimelineSize {
+		names = append(names, "timelineSize")
+	}
+	if c.HistoryPath != next.HistoryPath || c.HistoryRetention != next.HistoryRetention ||
+		c.HistoryMaxBytes != next.HistoryMaxBytes || c.HistoryKeyframeInterval != next.HistoryKeyframeInterval {
+		names = append(names, "history")
+	}
+	if !maps.Equal(c.RateLimits, next.RateLimits) {
+		names = append(names, "rateLimits")
+	}
+	return names
+}
+
+// configFilePollInterval is how often WatchFile checks the config file for
+// changes.
+const configFilePollInterval = 5 * time.Second
+
+// WatchFile reloads the configuration whenever the file named by CONFIG_FILE
+// changes, for the life of the process. It polls the file's size and
+// modification time rather than relying on filesystem notifications, which
+// are unreliable for the bind mounts and symlink swaps used to deliver
+// Docker configs. It returns immediately if CONFIG_FILE is not set.
+func (h *Holder) WatchFile() {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return
+	}
+
+	last, _ := os.Stat(path)
+	ticker := time.NewTicker(configFilePollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		fi, err := os.Stat(path)
+		if err != nil || (last != nil && fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size()) {
+			continue
+		}
+		last = fi
+		slog.Info("Config file changed, reloading", "path", path)
+		if err := h.Reload(); err != nil {
+			slog.Error("Config reload failed, keeping the running configuration", "error", err)
+		}
+	}
+}
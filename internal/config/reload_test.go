@@ -0,0 +1,58 @@
+package config
+
+import "testing"
+
+// TestHolder_Reload verifies a reload swaps in the reloadable settings, keeps
+// the rest at their running values, and notifies subscribers.
+func TestHolder_Reload(t *testing.T) {
+	setEnv(t, "CLUSTER_NAME", "before")
+	setEnv(t, "LISTENER_PORT", "8080")
+	h := NewHolder(mustLoad(t))
+
+	var notified *Config
+	h.Subscribe(func(cfg *Config) { notified = cfg })
+
+	setEnv(t, "CLUSTER_NAME", "after")
+	setEnv(t, "HIDE_ALL_ENVS", "true")
+	setEnv(t, "MAX_WS_CONNECTIONS", "5")
+	setEnv(t, "LISTENER_PORT", "9090") // not reloadable
+
+	if err := h.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	cfg := h.Load()
+	if cfg.ClusterName != "after" || !cfg.HideAllEnvs || cfg.MaxWSConnections != 5 {
+		t.Errorf("reloadable settings not applied: %+v", cfg)
+	}
+	if cfg.ListenerPort != "8080" {
+		t.Errorf("ListenerPort = %q, want the running value kept until restart", cfg.ListenerPort)
+	}
+	if notified != cfg {
+		t.Error("subscriber was not notified with the new configuration")
+	}
+}
+
+// TestHolder_ReloadRejectsInvalid verifies an invalid configuration leaves the
+// running one in place and notifies nobody.
+func TestHolder_ReloadRejectsInvalid(t *testing.T) {
+	setEnv(t, "CLUSTER_NAME", "before")
+	h := NewHolder(mustLoad(t))
+	before := h.Load()
+
+	notified := false
+	h.Subscribe(func(*Config) { notified = true })
+
+	setEnv(t, "CLUSTER_NAME", "after")
+	setEnv(t, "HIDE_LABELS", "bogus")
+
+	if err := h.Reload(); err == nil {
+		t.Fatal("expected an invalid configuration to be rejected")
+	}
+	if h.Load() != before {
+		t.Error("running configuration was replaced by an invalid one")
+	}
+	if notified {
+		t.Error("subscriber notified of a rejected reload")
+	}
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Webhook event types, raised when the swarm changes for the worse.
+const (
+	// EventTaskFailed is a task that failed or was rejected.
+	EventTaskFailed = "task_failed"
+	// EventServiceUnderReplicated is a service running fewer replicas than
+	// desired for longer than its webhook's UnderReplicatedFor.
+	EventServiceUnderReplicated = "service_under_replicated"
+	// EventNodeDown and EventNodeDrained are a node going down or being
+	// drained.
+	EventNodeDown    = "node_down"
+	EventNodeDrained = "node_drained"
+	// EventManagerUnreachable is a manager becoming unreachable.
+	EventManagerUnreachable = "manager_unreachable"
+	// EventUpdatePaused and EventUpdateRolledBack are a service update
+	// pausing, on failure, or being rolled back.
+	EventUpdatePaused     = "update_paused"
+	EventUpdateRolledBack = "update_rolled_back"
+)
+
+// WebhookEvents are the event types a webhook may subscribe to.
+var WebhookEvents = []string{
+	EventTaskFailed,
+	EventServiceUnderReplicated,
+	EventNodeDown,
+	EventNodeDrained,
+	EventManagerUnreachable,
+	EventUpdatePaused,
+	EventUpdateRolledBack,
+}
+
+// Webhook is a URL notified of swarm events.
+type Webhook struct {
+	// Name identifies the webhook in logs and metrics.
+	Name string
+	URL  string
+	// Events are the event types sent, all of them when empty.
+	Events []string
+	// Secret, when set, keys the HMAC-SHA256 signature of each delivery.
+	Secret string
+	// Template, when not nil, renders the body from the event instead of
+	// the default JSON, e.g. for a Slack or Teams payload. TemplateText is
+	// its source.
+	Template     *template.Template
+	TemplateText string
+	ContentType  string
+	// UnderReplicatedFor is how long a service must run fewer replicas than
+	// desired before it is reported.
+	UnderReplicatedFor time.Duration
+	// DedupeWindow is how long an event is not sent again after it has
+	// been, e.g. for a flapping node.
+	DedupeWindow time.Duration
+	// MaxAttempts caps the deliveries of an event, retried with exponential
+	// backoff while they fail.
+	MaxAttempts int
+}
+
+// Wants reports whether the webhook is sent events of type event.
+func (w *Webhook) Wants(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// webhookSettings is a webhook as configured.
+type webhookSettings struct {
+	Name               string   `json:"name" yaml:"name"`
+	URL                string   `json:"url" yaml:"url"`
+	Events             []string `json:"events" yaml:"events"`
+	Secret             string   `json:"secret" yaml:"secret"`
+	Template           string   `json:"template" yaml:"template"`
+	ContentType        string   `json:"contentType" yaml:"contentType"`
+	UnderReplicatedFor string   `json:"underReplicatedFor" yaml:"underReplicatedFor"`
+	DedupeWindow       string   `json:"dedupeWindow" yaml:"dedupeWindow"`
+	MaxAttempts        int      `json:"maxAttempts" yaml:"maxAttempts"`
+}
+
+const (
+	defaultWebhookContentType        = "application/json"
+	defaultWebhookUnderReplicatedFor = "1m"
+	defaultWebhookDedupeWindow       = "10m"
+	defaultWebhookMaxAttempts        = 5
+)
+
+// WebhookTemplateFuncs are the functions available to webhook templates:
+// json quotes a value as JSON, so event fields can be embedded in a JSON
+// payload safely.
+var WebhookTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// parseWebhooksEnv decodes the WEBHOOKS environment variable, a JSON array of
+// webhooks in config file form.
+func parseWebhooksEnv(v string) ([]webhookSettings, error) {
+	dec := json.NewDecoder(strings.NewReader(v))
+	dec.DisallowUnknownFields()
+	var hooks []webhookSettings
+	if err := dec.Decode(&hooks); err != nil {
+		return nil, fmt.Errorf("WEBHOOKS must be a JSON array of webhooks: %v", err)
+	}
+	return hooks, nil
+}
+
+// buildWebhooks validates the configured webhooks, applying their defaults,
+// and reports each problem through errorf.
+func buildWebhooks(hooks []webhookSettings, errorf func(format string, args ...any)) []Webhook {
+	var out []Webhook
+	names := make(map[string]bool)
+	for i, s := range hooks {
+		w := Webhook{
+			Name:         s.Name,
+			URL:          s.URL,
+			Events:       s.Events,
+			Secret:       s.Secret,
+			TemplateText: s.Template,
+			ContentType:  s.ContentType,
+			MaxAttempts:  s.MaxAttempts,
+		}
+		if w.Name == "" {
+			w.Name = fmt.Sprintf("webhook%d", i+1)
+		}
+		if names[w.Name] {
+			errorf("webhooks[%d]: name %q is used twice", i, w.Name)
+		}
+		names[w.Name] = true
+
+		if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errorf("webhooks[%d].url must be an http or https URL", i)
+		}
+		for _, e := range s.Events {
+			if !slices.Contains(WebhookEvents, e) {
+				errorf("webhooks[%d].events entry %q must be one of %s", i, e, strings.Join(WebhookEvents, ", "))
+			}
+		}
+		if s.Template != "" {
+			tmpl, err := template.New(w.Name).Funcs(WebhookTemplateFuncs).Option("missingkey=error").Parse(s.Template)
+			if err != nil {
+				errorf("webhooks[%d].template: %v", i, err)
+			}
+			w.Template = tmpl
+		}
+		if w.ContentType == "" {
+			w.ContentType = defaultWebhookContentType
+		}
+		w.UnderReplicatedFor = parseWebhookDuration(i, "underReplicatedFor", s.UnderReplicatedFor, defaultWebhookUnderReplicatedFor, errorf)
+		w.DedupeWindow = parseWebhookDuration(i, "dedupeWindow", s.DedupeWindow, defaultWebhookDedupeWindow, errorf)
+		switch {
+		case w.MaxAttempts == 0:
+			w.MaxAttempts = defaultWebhookMaxAttempts
+		case w.MaxAttempts < 0:
+			errorf("webhooks[%d].maxAttempts %d must be positive", i, s.MaxAttempts)
+		}
+		out = append(out, w)
+	}
+	return out
+}
+
+// parseWebhookDuration parses the duration setting key of webhook i, or def
+// when it is empty.
+func parseWebhookDuration(i int, key, v, def string, errorf func(format string, args ...any)) time.Duration {
+	if v == "" {
+		v = def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		errorf("webhooks[%d].%s %q must be a duration such as %s", i, key, v, def)
+	}
+	return d
+}
+
+// masked returns the webhook in config file form with its secret and the
+// path and query of its URL, which often carry a token, masked.
+func (w *Webhook) masked() webhookSettings {
+	s := webhookSettings{
+		Name:               w.Name,
+		URL:                w.URL,
+		Events:             w.Events,
+		Template:           w.TemplateText,
+		ContentType:        w.ContentType,
+		UnderReplicatedFor: w.UnderReplicatedFor.String(),
+		DedupeWindow:       w.DedupeWindow.String(),
+		MaxAttempts:        w.MaxAttempts,
+	}
+	if u, err := url.Parse(w.URL); err == nil && (u.Path != "" || u.RawQuery != "") {
+		s.URL = u.Scheme + "://" + u.Host + "/" + maskedSecret
+	}
+	if w.Secret != "" {
+		s.Secret = maskedSecret
+	}
+	return s
+}
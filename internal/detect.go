@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+// DefaultSecretKeyPatterns are the key globs a SecretDetector matches when
+// none are configured.
+var DefaultSecretKeyPatterns = []string{"*PASSWORD*", "*TOKEN*", "*SECRET*", "*KEY*"}
+
+// credentialPatterns match values with a recognizable credential format.
+var credentialPatterns = []*regexp.Regexp{
+	// JSON Web Tokens: base64url header and payload, both starting "{".
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]*`),
+	// PEM blocks such as private keys and certificates.
+	regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+-----`),
+	// AWS access key IDs.
+	regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),
+}
+
+const (
+	// minTokenLength is the shortest value considered as a random token.
+	minTokenLength = 20
+	// minTokenEntropy is the Shannon entropy, in bits per character, above
+	// which a token-like value is taken to be random.
+	minTokenEntropy = 4.0
+)
+
+// SecretDetector recognizes likely secrets by the name of the key they are
+// stored under or by the look of the value itself. It is immutable and safe
+// for concurrent use.
+type SecretDetector struct {
+	keys []*regexp.Regexp
+}
+
+// NewSecretDetector creates a detector matching keys against patterns, which
+// are case-insensitive globs as in sanitization paths.
+func NewSecretDetector(patterns []string) *SecretDetector {
+	d := &SecretDetector{}
+	for _, p := range patterns {
+		d.keys = append(d.keys, regexp.MustCompile("(?i)"+globToRegexp(p).String()))
+	}
+	return d
+}
+
+// IsSecret reports whether value, stored under key, is likely a secret. Values
+// already redacted are not.
+func (d *SecretDetector) IsSecret(key, value string) bool {
+	if value == "" || strings.HasPrefix(value, Sanitized) || strings.HasPrefix(value, hashedPrefix) {
+		return false
+	}
+	for _, re := range d.keys {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return LooksLikeCredential(value)
+}
+
+// LooksLikeCredential reports whether value resembles a credential: a JWT, a
+// PEM block, an AWS access key ID, or a long, high-entropy token.
+func LooksLikeCredential(value string) bool {
+	for _, re := range credentialPatterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return isRandomToken(value)
+}
+
+// isRandomToken reports whether value is a single long word of token
+// characters with the character distribution of random data. Hex strings are
+// excluded: they are far more often commit hashes and image digests than
+// secrets.
+func isRandomToken(value string) bool {
+	if len(value) < minTokenLength {
+		return false
+	}
+	hex, letters, digits := true, false, false
+	for _, c := range value {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			letters = true
+		case c >= 'g' && c <= 'z', c >= 'G' && c <= 'Z':
+			hex, letters = false, true
+		case strings.ContainsRune("+/=_-", c):
+			hex = false
+		default:
+			return false
+		}
+	}
+	// Require a mix of letters and digits, so long words and numbers pass.
+	if !letters || !digits {
+		return false
+	}
+	return !hex && entropy(value) >= minTokenEntropy
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	var h float64
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
@@ -0,0 +1,180 @@
+package docker
+
+import (
+	"cmp"
+	"fmt"
+	"time"
+
+	"github.com/moby/moby/api/types/swarm"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/notify"
+)
+
+// eventNotifier is told of the events found in each published snapshot.
+type eventNotifier interface {
+	Notify(events []notify.Event)
+}
+
+// alertWatcher finds the events webhooks are notified of by comparing each
+// published snapshot of the swarm with the last. The first, and the first
+// under a reloaded configuration, which may show whole stacks, services, or
+// nodes, only record the state, so neither a restart nor a reload reports
+// what was already wrong. Hidden
+// stacks, services, and nodes are not in the snapshots, so they raise no
+// events, and the sensitive data paths have applied to them, so an event
+// reveals nothing the dashboard does not. It is only used from the single
+// inspectSwarmServices goroutine.
+type alertWatcher struct {
+	// cfg is the configuration the last snapshot was published under.
+	cfg    *config.Config
+	primed bool
+	// tasks, nodes, and updates are the last state seen of each task, node,
+	// and service update, by ID.
+	tasks   map[string]swarm.TaskState
+	nodes   map[string]nodeAlertState
+	updates map[string]swarm.UpdateState
+	// underSince is when each under-replicated service went under, by ID.
+	underSince map[string]time.Time
+}
+
+type nodeAlertState struct {
+	status       swarm.NodeState
+	availability swarm.NodeAvailability
+	reachability swarm.Reachability
+}
+
+// observe compares data, the snapshot published as of now under cfg, with
+// the last and returns the events found. The replica counts are taken from
+// the snapshot's service statuses. A service under replicated is reported on
+// every observation, with when it went under, for the notifier to hold back
+// until it has been for long enough.
+func (a *alertWatcher) observe(cfg *config.Config, data *SwarmData, now time.Time) []notify.Event {
+	if cfg != a.cfg {
+		a.cfg, a.primed = cfg, false
+	}
+	var events []notify.Event
+	primed := a.primed
+	emit := func(e notify.Event) {
+		if primed {
+			e.Time, e.Cluster = now, cfg.ClusterName
+			events = append(events, e)
+		}
+	}
+
+	nodeNames := make(map[string]string, len(data.Nodes))
+	nodeStates := make(map[string]nodeAlertState, len(data.Nodes))
+	for _, n := range data.Nodes {
+		name := cmp.Or(n.Description.Hostname, n.ID)
+		nodeNames[n.ID] = name
+		st := nodeAlertState{status: n.Status.State, availability: n.Spec.Availability}
+		if n.ManagerStatus != nil {
+			st.reachability = n.ManagerStatus.Reachability
+		}
+		nodeStates[n.ID] = st
+		prev, seen := a.nodes[n.ID]
+		if st.status == swarm.NodeStateDown && (!seen || prev.status != swarm.NodeStateDown) {
+			emit(notify.Event{Type: config.EventNodeDown, Key: n.ID, Node: name,
+				Message: fmt.Sprintf("Node %s is down", name)})
+		}
+		if st.availability == swarm.NodeAvailabilityDrain && (!seen || prev.availability != swarm.NodeAvailabilityDrain) {
+			emit(notify.Event{Type: config.EventNodeDrained, Key: n.ID, Node: name,
+				Message: fmt.Sprintf("Node %s is drained", name)})
+		}
+		if st.reachability == swarm.ReachabilityUnreachable && (!seen || prev.reachability != swarm.ReachabilityUnreachable) {
+			emit(notify.Event{Type: config.EventManagerUnreachable, Key: n.ID, Node: name,
+				Message: fmt.Sprintf("Manager %s is unreachable", name)})
+		}
+	}
+
+	serviceNames := make(map[string]string, len(data.Services))
+	stacks := make(map[string]string, len(data.Services))
+	updates := make(map[string]swarm.UpdateState, len(data.Services))
+	for _, s := range data.Services {
+		name := cmp.Or(s.Spec.Name, s.ID)
+		serviceNames[s.ID] = name
+		stacks[s.ID] = s.Spec.Labels[stackNamespaceLabel]
+
+		if s.UpdateStatus == nil {
+			continue
+		}
+		state := s.UpdateStatus.State
+		updates[s.ID] = state
+		if state == a.updates[s.ID] {
+			continue
+		}
+		stack := stacks[s.ID]
+		switch state {
+		case swarm.UpdateStatePaused, swarm.UpdateStateRollbackPaused:
+			emit(notify.Event{Type: config.EventUpdatePaused, Key: updateKey(&s), Stack: stack, Service: name,
+				Message: withReason(fmt.Sprintf("Update of service %s is paused", name), s.UpdateStatus.Message)})
+		case swarm.UpdateStateRollbackCompleted:
+			emit(notify.Event{Type: config.EventUpdateRolledBack, Key: updateKey(&s), Stack: stack, Service: name,
+				Message: withReason(fmt.Sprintf("Update of service %s was rolled back", name), s.UpdateStatus.Message)})
+		}
+	}
+
+	taskStates := make(map[string]swarm.TaskState, len(data.Tasks))
+	for _, t := range data.Tasks {
+		state := t.Status.State
+		taskStates[t.ID] = state
+
+		if state != swarm.TaskStateFailed && state != swarm.TaskStateRejected {
+			continue
+		}
+		if prev, seen := a.tasks[t.ID]; seen && prev == state {
+			continue
+		}
+		service := cmp.Or(serviceNames[t.ServiceID], t.ServiceID)
+		task := taskName(service, &t)
+		emit(notify.Event{Type: config.EventTaskFailed, Key: t.ID, Stack: stacks[t.ServiceID], Service: service,
+			Node: nodeNames[t.NodeID], Task: task,
+			Message: withReason(fmt.Sprintf("Task %s %s", task, state), cmp.Or(t.Status.Err, t.Status.Message))})
+	}
+
+	underSince := make(map[string]time.Time)
+	for _, s := range data.ServiceStatuses {
+		if s.Mode != "replicated" && s.Mode != "global" || s.Running >= s.Desired {
+			continue
+		}
+		since, ok := a.underSince[s.ID]
+		if !ok {
+			since = now
+		}
+		underSince[s.ID] = since
+		emit(notify.Event{Type: config.EventServiceUnderReplicated, Key: fmt.Sprintf("%s@%d", s.ID, since.Unix()),
+			Stack: s.Stack, Service: s.Name, Status: s.Status, Since: since,
+			Message: fmt.Sprintf("Service %s is running %d of %d replicas", s.Name, s.Running, s.Desired)})
+	}
+
+	a.primed = true
+	a.tasks, a.nodes, a.updates, a.underSince = taskStates, nodeStates, updates, underSince
+	return events
+}
+
+// updateKey identifies a service's current update, so each update's pause
+// or rollback is reported once.
+func updateKey(s *swarm.Service) string {
+	key := s.ID
+	if at := s.UpdateStatus.StartedAt; at != nil {
+		key += "@" + at.UTC().Format(time.RFC3339Nano)
+	}
+	return key
+}
+
+// taskName names a task as the Docker CLI does: its service's name and its
+// slot, or for a global service the node it runs on.
+func taskName(service string, t *swarm.Task) string {
+	if t.Slot > 0 {
+		return fmt.Sprintf("%s.%d", service, t.Slot)
+	}
+	return service + "." + t.NodeID
+}
+
+// withReason appends reason, if any, to msg.
+func withReason(msg, reason string) string {
+	if reason == "" {
+		return msg
+	}
+	return msg + ": " + reason
+}
@@ -0,0 +1,246 @@
+package docker
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/moby/api/types/swarm"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/notify"
+)
+
+// alertFixture is a healthy swarm: a manager, a worker, and a service with
+// two replicas running.
+func alertFixture() ([]swarm.Node, []swarm.Service, []swarm.Task) {
+	nodes := []swarm.Node{
+		{ID: "n1", Description: swarm.NodeDescription{Hostname: "mgr1"},
+			Spec:          swarm.NodeSpec{Availability: swarm.NodeAvailabilityActive},
+			Status:        swarm.NodeStatus{State: swarm.NodeStateReady},
+			ManagerStatus: &swarm.ManagerStatus{Reachability: swarm.ReachabilityReachable}},
+		{ID: "n2", Description: swarm.NodeDescription{Hostname: "wrk1"},
+			Spec:   swarm.NodeSpec{Availability: swarm.NodeAvailabilityActive},
+			Status: swarm.NodeStatus{State: swarm.NodeStateReady}},
+	}
+	replicas := uint64(2)
+	web := namedService("web", "app_web", nil)
+	web.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+	tasks := []swarm.Task{
+		{ID: "t1", ServiceID: "web", NodeID: "n1", Slot: 1, DesiredState: swarm.TaskStateRunning,
+			Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+		{ID: "t2", ServiceID: "web", NodeID: "n2", Slot: 2, DesiredState: swarm.TaskStateRunning,
+			Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+	}
+	return nodes, []swarm.Service{web}, tasks
+}
+
+// alertData returns the snapshot of nodes, services, and tasks, unsanitized,
+// with the services' statuses.
+func alertData(nodes []swarm.Node, services []swarm.Service, tasks []swarm.Task) *SwarmData {
+	return &SwarmData{Nodes: nodes, Services: services, Tasks: tasks, ServiceStatuses: serviceStatuses(services, tasks, nil)}
+}
+
+// eventTypes returns the types of events.
+func eventTypes(events []notify.Event) []string {
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestAlertWatcher(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(nodes []swarm.Node, services []swarm.Service, tasks []swarm.Task) []swarm.Task
+		want   []string
+	}{
+		{
+			name:   "no change",
+			change: func(_ []swarm.Node, _ []swarm.Service, tasks []swarm.Task) []swarm.Task { return tasks },
+		},
+		{
+			name: "node down",
+			change: func(nodes []swarm.Node, _ []swarm.Service, tasks []swarm.Task) []swarm.Task {
+				nodes[1].Status.State = swarm.NodeStateDown
+				return tasks
+			},
+			want: []string{config.EventNodeDown},
+		},
+		{
+			name: "node drained",
+			change: func(nodes []swarm.Node, _ []swarm.Service, tasks []swarm.Task) []swarm.Task {
+				nodes[1].Spec.Availability = swarm.NodeAvailabilityDrain
+				return tasks
+			},
+			want: []string{config.EventNodeDrained},
+		},
+		{
+			name: "manager unreachable",
+			change: func(nodes []swarm.Node, _ []swarm.Service, tasks []swarm.Task) []swarm.Task {
+				nodes[0].ManagerStatus.Reachability = swarm.ReachabilityUnreachable
+				return tasks
+			},
+			want: []string{config.EventManagerUnreachable},
+		},
+		{
+			name: "update paused",
+			change: func(_ []swarm.Node, services []swarm.Service, tasks []swarm.Task) []swarm.Task {
+				services[0].UpdateStatus = &swarm.UpdateStatus{State: swarm.UpdateStatePaused, Message: "update paused due to failure"}
+				return tasks
+			},
+			want: []string{config.EventUpdatePaused},
+		},
+		{
+			name: "update rolled back",
+			change: func(_ []swarm.Node, services []swarm.Service, tasks []swarm.Task) []swarm.Task {
+				services[0].UpdateStatus = &swarm.UpdateStatus{State: swarm.UpdateStateRollbackCompleted}
+				return tasks
+			},
+			want: []string{config.EventUpdateRolledBack},
+		},
+		{
+			name: "task failed and replaced",
+			change: func(_ []swarm.Node, _ []swarm.Service, tasks []swarm.Task) []swarm.Task {
+				tasks[1].DesiredState = swarm.TaskStateShutdown
+				tasks[1].Status = swarm.TaskStatus{State: swarm.TaskStateFailed, Err: "exit 1"}
+				return append(tasks, swarm.Task{ID: "t3", ServiceID: "web", NodeID: "n2", Slot: 2,
+					DesiredState: swarm.TaskStateRunning, Status: swarm.TaskStatus{State: swarm.TaskStateRunning}})
+			},
+			want: []string{config.EventTaskFailed},
+		},
+		{
+			name: "task rejected",
+			change: func(_ []swarm.Node, _ []swarm.Service, tasks []swarm.Task) []swarm.Task {
+				tasks[1].Status = swarm.TaskStatus{State: swarm.TaskStateRejected, Err: "no suitable node"}
+				return tasks
+			},
+			want: []string{config.EventTaskFailed, config.EventServiceUnderReplicated},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a alertWatcher
+			prod := &config.Config{ClusterName: "prod"}
+			now := time.Now()
+			nodes, services, tasks := alertFixture()
+			if events := a.observe(prod, alertData(nodes, services, tasks), now); len(events) != 0 {
+				t.Fatalf("first poll events = %v, want none", eventTypes(events))
+			}
+			tasks = tt.change(nodes, services, tasks)
+			events := a.observe(prod, alertData(nodes, services, tasks), now.Add(time.Second))
+			if got := eventTypes(events); !slices.Equal(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			for _, e := range events {
+				if e.Cluster != "prod" || e.Message == "" || e.Key == "" {
+					t.Errorf("event = %+v, want its cluster, message, and key", e)
+				}
+			}
+			// Only the under replication is reported again.
+			again := slices.DeleteFunc(tt.want, func(e string) bool { return e != config.EventServiceUnderReplicated })
+			events = a.observe(prod, alertData(nodes, services, tasks), now.Add(2*time.Second))
+			if got := eventTypes(events); !slices.Equal(got, again) {
+				t.Errorf("repeated poll events = %v, want %v", got, again)
+			}
+		})
+	}
+}
+
+func TestAlertWatcher_UnderReplicatedSince(t *testing.T) {
+	var a alertWatcher
+	cfg := &config.Config{}
+	start := time.Now()
+	nodes, services, tasks := alertFixture()
+	tasks[1].Status.State = swarm.TaskStatePending
+	a.observe(cfg, alertData(nodes, services, tasks), start)
+
+	for i := 1; i <= 2; i++ {
+		events := a.observe(cfg, alertData(nodes, services, tasks), start.Add(time.Duration(i)*time.Minute))
+		if len(events) != 1 || !events[0].Since.Equal(start) {
+			t.Fatalf("poll %d events = %+v, want under replicated since the start", i, events)
+		}
+		if want := "Service app_web is running 1 of 2 replicas"; events[0].Message != want {
+			t.Errorf("message = %q, want %q", events[0].Message, want)
+		}
+	}
+
+	tasks[1].Status.State = swarm.TaskStateRunning
+	if events := a.observe(cfg, alertData(nodes, services, tasks), start.Add(3*time.Minute)); len(events) != 0 {
+		t.Fatalf("recovered events = %v, want none", eventTypes(events))
+	}
+	tasks[1].Status.State = swarm.TaskStatePending
+	later := start.Add(4 * time.Minute)
+	if events := a.observe(cfg, alertData(nodes, services, tasks), later); len(events) != 1 || !events[0].Since.Equal(later) {
+		t.Fatalf("events = %+v, want under replicated again since %v", events, later)
+	}
+}
+
+// TestAlertWatcher_Reload verifies that the first snapshot under a reloaded
+// configuration only records the state, so a node it shows again is not
+// reported down for having been hidden.
+func TestAlertWatcher_Reload(t *testing.T) {
+	var a alertWatcher
+	now := time.Now()
+	nodes, services, tasks := alertFixture()
+	nodes[1].Status.State = swarm.NodeStateDown
+	tasks[1].NodeID = "n1"
+
+	hiding := &config.Config{}
+	a.observe(hiding, alertData(nodes[:1], services, tasks), now)
+	if events := a.observe(hiding, alertData(nodes[:1], services, tasks), now.Add(time.Second)); len(events) != 0 {
+		t.Fatalf("events = %v, want none", eventTypes(events))
+	}
+
+	reloaded := &config.Config{}
+	if events := a.observe(reloaded, alertData(nodes, services, tasks), now.Add(2*time.Second)); len(events) != 0 {
+		t.Fatalf("events after the reload = %v, want none for the node it shows", eventTypes(events))
+	}
+	nodes[0].Status.State = swarm.NodeStateDown
+	events := a.observe(reloaded, alertData(nodes, services, tasks), now.Add(3*time.Second))
+	if len(events) != 1 || events[0].Type != config.EventNodeDown || events[0].Node != "mgr1" {
+		t.Errorf("events = %+v, want mgr1 down", events)
+	}
+}
+
+// TestAlertWatcher_PublishedSnapshot verifies events are found in the
+// snapshot as published, so values the sensitive data paths redact are not
+// sent to webhooks.
+func TestAlertWatcher_PublishedSnapshot(t *testing.T) {
+	t.Setenv("SENSITIVE_DATA_PATHS", "tasks.*.Status.Err=mask,services.*.UpdateStatus.Message")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s snapshotter
+	var a alertWatcher
+	now := time.Now()
+	publish := func(nodes []swarm.Node, services []swarm.Service, tasks []swarm.Task) []notify.Event {
+		data := SwarmData{Nodes: nodes, Services: services, Tasks: tasks, ServiceStatuses: serviceStatuses(services, tasks, nil)}
+		if s.frame(context.Background(), cfg, data) == nil {
+			t.Fatal("snapshot not published")
+		}
+		now = now.Add(time.Second)
+		return a.observe(cfg, &s.published, now)
+	}
+
+	nodes, services, tasks := alertFixture()
+	publish(nodes, services, tasks)
+	tasks[1].Status = swarm.TaskStatus{State: swarm.TaskStateRejected, Err: "secret mount /run/secrets/db-password missing"}
+	tasks[1].Version.Index++
+	services[0].UpdateStatus = &swarm.UpdateStatus{State: swarm.UpdateStatePaused, Message: "task failed: db-password"}
+	services[0].Version.Index++
+	events := publish(nodes, services, tasks)
+
+	if got, want := eventTypes(events), []string{config.EventUpdatePaused, config.EventTaskFailed, config.EventServiceUnderReplicated}; !slices.Equal(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for _, e := range events {
+		if strings.Contains(e.Message, "db-password") {
+			t.Errorf("event %s message = %q, want the redacted values left out", e.Type, e.Message)
+		}
+	}
+}
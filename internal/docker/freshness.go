@@ -0,0 +1,63 @@
+package docker
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Freshness is how current one group of a snapshot is: "nodes", "services",
+// "networks", or "tasks". Each group is fetched and kept independently, so
+// when one fetch fails the others stay current while it keeps its last
+// successfully fetched data.
+type Freshness struct {
+	// FetchedAt is when the group was last fetched successfully, or zero if
+	// it never has been.
+	FetchedAt time.Time `json:"fetchedAt,omitzero"`
+	// LastError is the error of the last fetch, if it failed.
+	LastError string `json:"lastError,omitempty"`
+	// Stale reports that the last fetch failed, so the group is as of
+	// FetchedAt.
+	Stale bool `json:"stale"`
+}
+
+// freshnessTracker records the outcome of each group's fetches. It is only
+// used from the single inspectSwarmServices goroutine.
+type freshnessTracker map[string]Freshness
+
+// record records the outcome of fetching group at now, err being nil on
+// success, and reports whether it succeeded.
+func (f freshnessTracker) record(group string, err error, now time.Time) bool {
+	if err != nil {
+		f[group] = Freshness{FetchedAt: f[group].FetchedAt, LastError: err.Error(), Stale: true}
+		return false
+	}
+	f[group] = Freshness{FetchedAt: now}
+	return true
+}
+
+// attempted reports whether group has been fetched, successfully or not.
+func (f freshnessTracker) attempted(group string) bool {
+	_, ok := f[group]
+	return ok
+}
+
+// snapshot returns a copy of the freshness of every group, for a snapshot.
+func (f freshnessTracker) snapshot() map[string]Freshness {
+	return maps.Clone(map[string]Freshness(f))
+}
+
+// freshnessKey summarises what of freshness decides whether a snapshot has
+// changed: each group's error and staleness. The fetch times are left out,
+// as they move on every poll; they are published with the next change, and
+// while a group is stale its fetch time does not move.
+func freshnessKey(freshness map[string]Freshness) string {
+	var b strings.Builder
+	for _, group := range slices.Sorted(maps.Keys(freshness)) {
+		f := freshness[group]
+		fmt.Fprintf(&b, "%s %t %q;", group, f.Stale, f.LastError)
+	}
+	return b.String()
+}
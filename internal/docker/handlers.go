@@ -1,17 +1,29 @@
 package docker
 
 import (
-	"log"
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"os"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/history"
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+	"github.com/jtgasper3/swarm-visualizer/internal/notify"
+	"github.com/jtgasper3/swarm-visualizer/internal/ratelimit"
 )
 
 // TokenValidator validates the ID token on an incoming request and returns its
@@ -52,11 +64,46 @@ type Hub struct {
 	// make the client briefly roll back to older state.
 	lastFanned []byte
 	// maxClients caps concurrent connections to bound resource use. 0 means
-	// unlimited.
+	// unlimited. It and maxPerIP are guarded by mu, as they change on reload.
 	maxClients int
+	// perIP counts connected clients by client IP, guarded by mu.
+	perIP map[string]int
+	// maxPerIP caps concurrent connections from a single client IP. 0 means
+	// unlimited.
+	maxPerIP int
+
+	// nextID numbers the registered clients, guarded by mu.
+	nextID uint64
+	// polled is when each poll group, "tasks" or "structural", last
+	// refreshed successfully, guarded by mu.
+	polled map[string]time.Time
+
+	// timeline keeps the latest changes to the swarm.
+	timeline *timeline
+	// history keeps the published snapshots, or is nil when the history is
+	// not enabled.
+	history *history.Store
+	// notifier sends the events found in the published snapshots to the
+	// webhooks. It is nil in tests.
+	notifier *notify.Notifier
+	// inspected is closed when the inspector returns. It is nil in tests,
+	// which run the inspector themselves.
+	inspected chan struct{}
+
+	// broadcast carries marshalled snapshots and changes from the inspector
+	// to runBroadcasts.
+	broadcast chan broadcastFrame
+	// stop is closed by stopBroadcasts to end runBroadcasts.
+	stop     chan struct{}
+	stopOnce sync.Once
+}
 
-	// broadcast carries marshalled snapshots from the inspector to runBroadcasts.
-	broadcast chan []byte
+// broadcastFrame is a published snapshot, or a changes message, and the span
+// that published it, so its fan-out is traced as part of the same poll.
+type broadcastFrame struct {
+	msg     []byte
+	changes bool
+	span    trace.SpanContext
 }
 
 // newHub creates a Hub configured from cfg. validate may be nil when auth is
@@ -67,22 +114,80 @@ func newHub(cfg *config.Config, validate TokenValidator) *Hub {
 		validate:   validate,
 		clients:    make(map[*wsClient]struct{}),
 		maxClients: cfg.MaxWSConnections,
-		broadcast:  make(chan []byte, 1),
+		perIP:      make(map[string]int),
+		polled:     make(map[string]time.Time),
+		maxPerIP:   cfg.MaxWSConnectionsPerIP,
+		timeline:   newTimeline(cfg.TimelineSize),
+		broadcast:  make(chan broadcastFrame, 1),
+		stop:       make(chan struct{}),
 	}
 }
 
+// applyConfig adopts the connection caps from a reloaded configuration.
+// Connections already above a lowered cap are kept; new ones are refused until
+// the count drops below it.
+func (h *Hub) applyConfig(cfg *config.Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maxClients = cfg.MaxWSConnections
+	h.maxPerIP = cfg.MaxWSConnectionsPerIP
+}
+
 // Ready reports whether at least one snapshot has been fanned out, i.e. the
-// Docker API is reachable and data has been published. It stays true once the
-// first frame is sent, so it does not flap on transient errors.
+// Docker API has been reachable and data published. It stays true once the
+// first frame is sent; LastPoll tells whether the data is still fresh.
 func (h *Hub) Ready() bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	return h.lastFanned != nil
 }
 
-// Publish hands a marshalled snapshot to the fan-out goroutine.
-func (h *Hub) Publish(frame []byte) {
-	h.broadcast <- frame
+// LastPoll returns when the poll group, "tasks" or "structural", last
+// refreshed successfully, or the zero time if it never has.
+func (h *Hub) LastPoll(group string) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.polled[group]
+}
+
+// polledAt records that the poll group refreshed successfully at t.
+func (h *Hub) polledAt(group string, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.polled[group] = t
+}
+
+// Publish hands a marshalled snapshot to the fan-out goroutine. Its fan-out
+// is traced as a child of the span in ctx.
+func (h *Hub) Publish(ctx context.Context, frame []byte) {
+	h.send(broadcastFrame{msg: frame, span: trace.SpanContextFromContext(ctx)})
+}
+
+// send hands f to runBroadcasts, or drops it once the fan-out has stopped.
+func (h *Hub) send(f broadcastFrame) {
+	select {
+	case h.broadcast <- f:
+	case <-h.stop:
+	}
+}
+
+// record queues frame, published at at under cfg, to be kept in the history,
+// if it is enabled.
+func (h *Hub) record(cfg *config.Config, at time.Time, frame []byte) {
+	if h.history != nil {
+		h.history.Record(at, frame, cfg.SanitizationFingerprint())
+	}
+}
+
+// PublishChanges adds changes to the timeline and hands them to the fan-out
+// goroutine. Their fan-out is traced as a child of the span in ctx.
+func (h *Hub) PublishChanges(ctx context.Context, changes []Change) {
+	msg, err := json.Marshal(changesMessage{Type: "changes", Changes: h.timeline.add(changes)})
+	if err != nil {
+		slog.Error("Marshalling the changes failed", logging.Err(err))
+		return
+	}
+	h.send(broadcastFrame{msg: msg, changes: true, span: trace.SpanContextFromContext(ctx)})
 }
 
 // Keepalive timings: a ping is sent every pingPeriod(), and the read side must
@@ -103,73 +208,194 @@ func init() {
 func pingPeriod() time.Duration { return time.Duration(pingPeriodNanos.Load()) }
 func pongWait() time.Duration   { return time.Duration(pongWaitNanos.Load()) }
 
+// changeQueueSize is how many changes messages a client may have pending
+// before further ones are dropped.
+const changeQueueSize = 16
+
 // wsClient is a single WebSocket connection. All writes to conn happen on its
 // writePump goroutine. send is a depth-1 buffer holding the latest pending
 // snapshot: the broadcaster never blocks on a slow client, and a client that
 // falls behind receives the most recent state rather than a backlog of stale
-// frames.
+// frames. Changes messages are not superseded by later ones, so they are
+// queued in changes instead, and dropped when it is full.
 type wsClient struct {
-	conn *websocket.Conn
-	send chan []byte
+	conn    *websocket.Conn
+	send    chan []byte
+	changes chan []byte
+	// ip is the client IP the connection counts against for the per-IP cap.
+	ip string
+	// logger logs about the connection, with its client IP and user.
+	logger *slog.Logger
+
+	// id identifies the client to the admin endpoints. It is assigned by
+	// register, in the order clients connect.
+	id uint64
+	// remoteAddr is the address of the connection's peer, which is a proxy
+	// when there is one, and user the authenticated user, if any.
+	remoteAddr  string
+	user        string
+	connectedAt time.Time
+	// replay is the range of the history a historical connection replays,
+	// or nil for a live one. Historical connections are sent neither the
+	// published snapshots nor the changes.
+	replay *replayRange
+	// framesSent and bytesSent count the snapshot frames and changes
+	// messages written to the connection.
+	framesSent atomic.Uint64
+	bytesSent  atomic.Uint64
+}
+
+// ClientInfo describes a connected WebSocket client.
+type ClientInfo struct {
+	ID uint64 `json:"id"`
+	// RemoteAddr is the address of the connection's peer, and ClientIP the
+	// client's IP taking trusted proxies into account.
+	RemoteAddr  string    `json:"remoteAddr"`
+	ClientIP    string    `json:"clientIp"`
+	User        string    `json:"user,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	FramesSent  uint64    `json:"framesSent"`
+	BytesSent   uint64    `json:"bytesSent"`
 }
 
-func RegisterDockerHandlers(mux *http.ServeMux, cfg *config.Config, validate TokenValidator) *Hub {
+// capacityRetryAfter is the Retry-After hint sent with connections refused by
+// the connection caps.
+const capacityRetryAfter = 10 * time.Second
+
+// RegisterDockerHandlers opens the history, if enabled, starts the inspector
+// and broadcaster, and wires the WebSocket endpoint, the changes and history
+// APIs, and the admin sanitization report onto mux, rate limited by limiter
+// (which may be nil).
+// Reloads of cfgs are applied to the connection caps and trigger an immediate
+// re-publish; one that changes the sanitization settings clears the history.
+// Cancelling ctx stops the inspector and abandons the webhook
+// deliveries in flight.
+func RegisterDockerHandlers(ctx context.Context, mux *http.ServeMux, cfgs *config.Holder, validate TokenValidator, limiter *ratelimit.Limiter) *Hub {
+	cfg := cfgs.Load()
 	hub := newHub(cfg, validate)
+	cfgs.Subscribe(hub.applyConfig)
+
+	if cfg.HistoryPath != "" {
+		store, err := openHistory(cfg)
+		if err != nil {
+			slog.Error("Opening the history failed", logging.Err(err))
+			os.Exit(1)
+		}
+		hub.history = store
+		cfgs.Subscribe(func(cfg *config.Config) {
+			if err := store.SetFingerprint(cfg.SanitizationFingerprint()); err != nil {
+				slog.Error("Clearing the history failed", logging.Err(err))
+			}
+		})
+	}
 
 	src, err := newMobySource()
 	if err != nil {
-		log.Fatal("Docker client error:", err)
+		slog.Error("Creating the Docker client failed", logging.Err(err))
+		os.Exit(1)
 	}
 
-	go inspectSwarmServices(cfg, src, hub)
+	hub.notifier = notify.New(ctx, cfgs)
+	hub.inspected = make(chan struct{})
+	go func() {
+		defer close(hub.inspected)
+		inspectSwarmServices(ctx, cfgs, src, hub, hub.notifier)
+	}()
 	go hub.runBroadcasts()
 
-	mux.HandleFunc(cfg.ContextRoot+"ws", hub.handleConnections)
+	mux.Handle(cfg.ContextRoot+"ws", limiter.Wrap(config.RateLimitWS, http.HandlerFunc(hub.handleConnections)))
+	mux.Handle(cfg.ContextRoot+"api/changes", limiter.Wrap(config.RateLimitAPI, http.HandlerFunc(hub.handleChanges)))
+	mux.Handle(cfg.ContextRoot+"api/history", limiter.Wrap(config.RateLimitAPI, http.HandlerFunc(hub.handleHistory)))
+	mux.Handle(cfg.ContextRoot+"api/history/snapshot", limiter.Wrap(config.RateLimitAPI, http.HandlerFunc(hub.handleHistorySnapshot)))
+	mux.Handle(cfg.ContextRoot+"api/history/diff", limiter.Wrap(config.RateLimitAPI, http.HandlerFunc(hub.handleHistoryDiff)))
+	mux.Handle(cfg.ContextRoot+"api/admin/sanitization",
+		limiter.Wrap(config.RateLimitAPI, RequireAdmin(cfgs, handleSanitizationReport(cfgs, src))))
 
 	return hub
 }
 
+// handleConnections serves the WebSocket. With mode=historical, the
+// connection replays a range of the history instead of following the swarm.
 func (h *Hub) handleConnections(w http.ResponseWriter, r *http.Request) {
 	cfg := h.cfg
+	ip := ratelimit.ClientIP(r, cfg.TrustedProxies)
+
+	var replay *replayRange
+	if r.URL.Query().Get("mode") == "historical" {
+		if !h.historyAvailable(w) {
+			return
+		}
+		var err error
+		if replay, err = parseReplayRange(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Shed load before the WebSocket handshake when already at capacity. This
 	// is best effort; register performs the authoritative check.
 	if h.atCapacity() {
+		metrics.WSRejections.WithLabelValues("capacity").Inc()
+		ratelimit.SetRetryAfter(w, capacityRetryAfter)
 		http.Error(w, "Too many connections", http.StatusServiceUnavailable)
-		log.Printf("Connection rejected, server at capacity (%d): %s", h.maxClients, r.RemoteAddr)
+		slog.Warn("WebSocket connection rejected, server at capacity", logging.ClientIP(ip))
+		return
+	}
+	if h.ipAtCapacity(ip) {
+		metrics.WSRejections.WithLabelValues("per_ip").Inc()
+		ratelimit.SetRetryAfter(w, capacityRetryAfter)
+		http.Error(w, "Too many connections from this address", http.StatusTooManyRequests)
+		slog.Warn("WebSocket connection rejected, client at per-IP capacity", logging.ClientIP(ip))
 		return
 	}
 
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Upgrade error:", err)
+		slog.Warn("WebSocket upgrade failed", logging.ClientIP(ip), logging.Err(err))
 		http.Error(w, "Could not upgrade to WebSocket", http.StatusInternalServerError)
 		return
 	}
 
+	logger := slog.With(logging.ClientIP(ip))
+	var user string
 	if cfg.AuthEnabled {
 		claims, err := h.validate(r)
 		if err != nil {
 			ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
 			ws.WriteMessage(websocket.TextMessage, []byte("401-Unauthorized"))
-			log.Printf("Client unauthorized: %s %v", r.RemoteAddr, err)
+			logger.Warn("WebSocket client unauthorized", logging.Err(err))
 			ws.Close()
 			return
 		}
-		log.Printf("Client connected: %s, %s", r.RemoteAddr, claims[cfg.OAuthConfig.UsernameClaim])
-	} else {
-		log.Printf("Client connected: %s", r.RemoteAddr)
+		user, _ = claims[cfg.OAuthConfig.UsernameClaim].(string)
+		logger = logger.With(logging.User(user))
+	}
+	logger.Info("WebSocket client connected")
+
+	c := &wsClient{
+		conn:        ws,
+		send:        make(chan []byte, 1),
+		changes:     make(chan []byte, changeQueueSize),
+		ip:          ip,
+		logger:      logger,
+		remoteAddr:  r.RemoteAddr,
+		user:        user,
+		connectedAt: time.Now(),
+		replay:      replay,
 	}
-
-	c := &wsClient{conn: ws, send: make(chan []byte, 1)}
 
 	if !h.register(c) {
-		// Capacity was reached between the pre-upgrade check and here.
-		log.Printf("Connection rejected, server at capacity (%d): %s", h.maxClients, r.RemoteAddr)
+		// A cap was reached between the pre-upgrade check and here.
+		logger.Warn("WebSocket connection rejected, server or client at capacity")
 		ws.Close()
 		return
 	}
-	go c.writePump()
+	if replay != nil {
+		logger.Info("WebSocket client replaying the history", "from", replay.from, "to", replay.to)
+		go c.replayPump(h.history)
+	} else {
+		go c.writePump()
+	}
 
 	// Detect dead peers: require a pong (or any frame) within pongWait and
 	// extend the deadline whenever one arrives. writePump's pings keep a live
@@ -187,15 +413,15 @@ func (h *Hub) handleConnections(w http.ResponseWriter, r *http.Request) {
 	// that drive the deadline above. When it returns, the client is gone.
 	for {
 		if _, _, err := ws.ReadMessage(); err != nil {
-			log.Printf("Client disconnected: %s, %v", r.RemoteAddr, err)
+			logger.Info("WebSocket client disconnected", logging.Err(err))
 			break
 		}
 	}
 	h.unregister(c)
 }
 
-// writePump owns every write to the connection: snapshot frames from send and
-// periodic keepalive pings. It exits, closing the connection, when the client
+// writePump owns every write to the connection: snapshot frames from send,
+// changes messages from changes, and periodic keepalive pings. It exits, closing the connection, when the client
 // is unregistered (send closed) or a write fails.
 func (c *wsClient) writePump() {
 	ticker := time.NewTicker(pingPeriod())
@@ -211,15 +437,18 @@ func (c *wsClient) writePump() {
 				c.conn.Close()
 				return
 			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-				log.Printf("Write error; closing: %s, %v", c.conn.RemoteAddr(), err)
-				c.conn.Close()
+			if !c.write(msg) {
+				return
+			}
+		case msg := <-c.changes:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !c.write(msg) {
 				return
 			}
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("Ping error; closing: %s, %v", c.conn.RemoteAddr(), err)
+				c.logger.Warn("WebSocket ping failed, closing", logging.Err(err))
 				c.conn.Close()
 				return
 			}
@@ -227,27 +456,53 @@ func (c *wsClient) writePump() {
 	}
 }
 
-// atCapacity reports whether the concurrent connection limit is reached.
-func (h *Hub) atCapacity() bool {
-	if h.maxClients <= 0 {
+// write writes msg to the connection, closing it and reporting false if that
+// fails.
+func (c *wsClient) write(msg []byte) bool {
+	if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		c.logger.Warn("WebSocket write failed, closing", logging.Err(err))
+		c.conn.Close()
 		return false
 	}
+	c.framesSent.Add(1)
+	c.bytesSent.Add(uint64(len(msg)))
+	return true
+}
+
+// atCapacity reports whether the concurrent connection limit is reached.
+func (h *Hub) atCapacity() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.maxClients > 0 && len(h.clients) >= h.maxClients
+}
+
+// ipAtCapacity reports whether ip has reached the per-IP connection limit.
+func (h *Hub) ipAtCapacity(ip string) bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	return len(h.clients) >= h.maxClients
+	return h.maxPerIP > 0 && h.perIP[ip] >= h.maxPerIP
 }
 
 // register adds the client to the registry and seeds it with the latest
 // snapshot, all under the broadcast lock. It returns false (registering
-// nothing) if the concurrent connection cap is reached.
+// nothing) if the global or per-IP concurrent connection cap is reached.
 func (h *Hub) register(c *wsClient) bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	if h.maxClients > 0 && len(h.clients) >= h.maxClients {
+		metrics.WSRejections.WithLabelValues("capacity").Inc()
+		return false
+	}
+	if h.maxPerIP > 0 && h.perIP[c.ip] >= h.maxPerIP {
+		metrics.WSRejections.WithLabelValues("per_ip").Inc()
 		return false
 	}
+	h.nextID++
+	c.id = h.nextID
 	h.clients[c] = struct{}{}
+	h.perIP[c.ip]++
+	metrics.WSClients.Inc()
 
 	// Seed the most recently fanned-out frame, if any, under the same lock that
 	// guards broadcasts. This keeps registration and seeding atomic with respect
@@ -255,7 +510,7 @@ func (h *Hub) register(c *wsClient) bool {
 	// "null" frame before the first poll, and is never seeded with a frame newer
 	// than one still queued for fan-out (which would cause a visible rollback).
 	// The send buffer was just created with cap 1, so this never blocks.
-	if h.lastFanned != nil {
+	if h.lastFanned != nil && c.replay == nil {
 		c.send <- h.lastFanned
 	}
 	return true
@@ -265,6 +520,10 @@ func (h *Hub) unregister(c *wsClient) {
 	h.mu.Lock()
 	if _, ok := h.clients[c]; ok {
 		delete(h.clients, c)
+		metrics.WSClients.Dec()
+		if h.perIP[c.ip]--; h.perIP[c.ip] <= 0 {
+			delete(h.perIP, c.ip)
+		}
 		// Closing send terminates writePump's range. Safe against the
 		// broadcaster because it only enqueues to clients still in the map
 		// and holds the same lock.
@@ -273,18 +532,115 @@ func (h *Hub) unregister(c *wsClient) {
 	h.mu.Unlock()
 }
 
-// runBroadcasts fans out each published frame to all connected clients.
+// Clients describes the connected clients, in the order they connected.
+func (h *Hub) Clients() []ClientInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	infos := make([]ClientInfo, 0, len(h.clients))
+	for c := range h.clients {
+		infos = append(infos, ClientInfo{
+			ID:          c.id,
+			RemoteAddr:  c.remoteAddr,
+			ClientIP:    c.ip,
+			User:        c.user,
+			ConnectedAt: c.connectedAt,
+			FramesSent:  c.framesSent.Load(),
+			BytesSent:   c.bytesSent.Load(),
+		})
+	}
+	slices.SortFunc(infos, func(a, b ClientInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return infos
+}
+
+// Disconnect closes the connection of the client with the given ID, freeing
+// its slot. It reports whether the client was connected.
+func (h *Hub) Disconnect(id uint64) bool {
+	h.mu.Lock()
+	var found *wsClient
+	for c := range h.clients {
+		if c.id == id {
+			found = c
+			break
+		}
+	}
+	h.mu.Unlock()
+	if found == nil {
+		return false
+	}
+	// Unregistering closes send, on which writePump sends a close frame and
+	// closes the connection, ending the read loop.
+	h.unregister(found)
+	slog.Info("WebSocket client disconnected by an administrator", logging.ClientIP(found.ip), logging.User(found.user))
+	return true
+}
+
+// runBroadcasts fans out each published frame to all connected clients,
+// until stopBroadcasts is called.
 func (h *Hub) runBroadcasts() {
-	for msg := range h.broadcast {
-		h.mu.Lock()
-		// Record the frame being fanned out so a client registering concurrently
-		// is seeded with this frame (or a newer one), never a stale one.
-		h.lastFanned = msg
+	for {
+		var f broadcastFrame
+		select {
+		case f = <-h.broadcast:
+		case <-h.stop:
+			return
+		}
+		h.fanOut(f)
+	}
+}
+
+// stopBroadcasts ends runBroadcasts. Frames published afterwards are
+// dropped.
+func (h *Hub) stopBroadcasts() {
+	h.stopOnce.Do(func() { close(h.stop) })
+}
+
+// Close waits for the inspector to return, which it does once the context
+// given to RegisterDockerHandlers is cancelled, and stops the fan-out to
+// clients. It then waits for the webhook deliveries in flight, which the
+// cancelled context cuts short, and stores the snapshots still queued for
+// the history and closes it.
+func (h *Hub) Close() error {
+	if h.inspected != nil {
+		<-h.inspected
+	}
+	h.stopBroadcasts()
+	if h.notifier != nil {
+		h.notifier.Wait()
+	}
+	if h.history == nil {
+		return nil
+	}
+	return h.history.Close()
+}
+
+// fanOut hands f to the connected clients.
+func (h *Hub) fanOut(f broadcastFrame) {
+	msg := f.msg
+	_, span := tracer.Start(trace.ContextWithSpanContext(context.Background(), f.span), "fan-out",
+		trace.WithAttributes(attribute.Int("frame.bytes", len(msg)), attribute.Bool("frame.changes", f.changes)))
+	defer span.End()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if f.changes {
 		for c := range h.clients {
+			if c.replay == nil {
+				enqueueChanges(c, msg)
+			}
+		}
+		span.SetAttributes(attribute.Int("websocket.clients", len(h.clients)))
+		return
+	}
+	// Record the frame being fanned out so a client registering concurrently
+	// is seeded with this frame (or a newer one), never a stale one.
+	h.lastFanned = msg
+	metrics.FramesPublished.Inc()
+	metrics.FrameBytes.Observe(float64(len(msg)))
+	for c := range h.clients {
+		if c.replay == nil {
 			enqueue(c, msg)
 		}
-		h.mu.Unlock()
 	}
+	span.SetAttributes(attribute.Int("websocket.clients", len(h.clients)))
 }
 
 // enqueue performs a non-blocking, latest-wins handoff to a client's send
@@ -298,6 +654,7 @@ func enqueue(c *wsClient, msg []byte) {
 		// Buffer full: drop the stale pending frame, then enqueue the latest.
 		select {
 		case <-c.send:
+			metrics.EnqueueDrops.Inc()
 		default:
 		}
 		select {
@@ -306,3 +663,14 @@ func enqueue(c *wsClient, msg []byte) {
 		}
 	}
 }
+
+// enqueueChanges hands msg, a changes message, to a client's changes queue
+// without blocking, dropping it if the queue is full. Callers must hold the
+// hub's lock.
+func enqueueChanges(c *wsClient, msg []byte) {
+	select {
+	case c.changes <- msg:
+	default:
+		metrics.ChangeDrops.Inc()
+	}
+}
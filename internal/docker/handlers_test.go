@@ -1,13 +1,31 @@
 package docker
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 // TestRegisterClient_EnforcesCap verifies the concurrent connection cap.
+// runHub runs h's fan-out for the duration of the test, so no broadcast
+// goroutine outlives it.
+func runHub(t *testing.T, h *Hub) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		h.runBroadcasts()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		h.stopBroadcasts()
+		<-done
+	})
+}
+
 func TestRegisterClient_EnforcesCap(t *testing.T) {
 	h := newHub(&config.Config{MaxWSConnections: 2}, nil)
 
@@ -35,6 +53,35 @@ func TestRegisterClient_EnforcesCap(t *testing.T) {
 	}
 }
 
+// TestRegisterClient_EnforcesPerIPCap verifies the per-IP connection cap is
+// applied independently of the global cap and counted as a rejection.
+func TestRegisterClient_EnforcesPerIPCap(t *testing.T) {
+	h := newHub(&config.Config{MaxWSConnectionsPerIP: 1}, nil)
+	rejected := testutil.ToFloat64(metrics.WSRejections.WithLabelValues("per_ip"))
+
+	a1 := &wsClient{send: make(chan []byte, 1), ip: "1.1.1.1"}
+	a2 := &wsClient{send: make(chan []byte, 1), ip: "1.1.1.1"}
+	b1 := &wsClient{send: make(chan []byte, 1), ip: "2.2.2.2"}
+
+	if !h.register(a1) || !h.register(b1) {
+		t.Fatal("expected one client per IP to register")
+	}
+	if h.register(a2) {
+		t.Fatal("expected a second client from the same IP to be rejected")
+	}
+	if !h.ipAtCapacity("1.1.1.1") || h.ipAtCapacity("3.3.3.3") {
+		t.Fatal("ipAtCapacity reported the wrong address as full")
+	}
+	if got := testutil.ToFloat64(metrics.WSRejections.WithLabelValues("per_ip")) - rejected; got != 1 {
+		t.Fatalf("per-IP rejections = %v, want 1", got)
+	}
+
+	h.unregister(a1)
+	if !h.register(a2) {
+		t.Fatal("expected registration to succeed after the IP's slot freed")
+	}
+}
+
 // TestRegisterClient_SeedsLatestSnapshot verifies a newly registered client is
 // seeded with the most recent snapshot.
 func TestRegisterClient_SeedsLatestSnapshot(t *testing.T) {
@@ -125,3 +172,78 @@ func TestEnqueue_NonBlocking(t *testing.T) {
 		t.Fatal("enqueue blocked with a full buffer and no consumer")
 	}
 }
+
+// TestHub_Metrics verifies the hub keeps the client, rejection, frame, and
+// drop metrics.
+func TestHub_Metrics(t *testing.T) {
+	clients := testutil.ToFloat64(metrics.WSClients)
+	rejected := testutil.ToFloat64(metrics.WSRejections.WithLabelValues("capacity"))
+	frames := testutil.ToFloat64(metrics.FramesPublished)
+	drops := testutil.ToFloat64(metrics.EnqueueDrops)
+
+	h := newHub(&config.Config{MaxWSConnections: 1}, nil)
+	c := &wsClient{send: make(chan []byte, 1)}
+	if !h.register(c) || h.register(&wsClient{send: make(chan []byte, 1)}) {
+		t.Fatal("expected one client to register and the second to be rejected")
+	}
+	if got := testutil.ToFloat64(metrics.WSClients) - clients; got != 1 {
+		t.Errorf("clients gauge moved by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.WSRejections.WithLabelValues("capacity")) - rejected; got != 1 {
+		t.Errorf("capacity rejections moved by %v, want 1", got)
+	}
+
+	// The client never drains its buffer, so the second frame replaces the
+	// first.
+	runHub(t, h)
+	h.Publish(context.Background(), []byte("one"))
+	h.Publish(context.Background(), []byte("two"))
+	if !waitFor(t, func() bool { return testutil.ToFloat64(metrics.FramesPublished)-frames == 2 }, time.Second) {
+		t.Fatal("expected two frames to be counted")
+	}
+	h.unregister(c)
+	if got := testutil.ToFloat64(metrics.EnqueueDrops) - drops; got != 1 {
+		t.Errorf("enqueue drops moved by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.WSClients) - clients; got != 0 {
+		t.Errorf("clients gauge moved by %v after unregister, want 0", got)
+	}
+}
+
+// TestHub_ClientsAndDisconnect verifies clients are listed in the order they
+// connected and that disconnecting one frees its slot.
+func TestHub_ClientsAndDisconnect(t *testing.T) {
+	h := newHub(&config.Config{MaxWSConnections: 2}, nil)
+	first := &wsClient{send: make(chan []byte, 1), ip: "10.0.0.1", user: "alice"}
+	second := &wsClient{send: make(chan []byte, 1), ip: "10.0.0.2"}
+	if !h.register(first) || !h.register(second) {
+		t.Fatal("expected both clients to register")
+	}
+
+	infos := h.Clients()
+	if len(infos) != 2 || infos[0].ID != 1 || infos[1].ID != 2 {
+		t.Fatalf("Clients() = %+v, want IDs 1 and 2 in order", infos)
+	}
+	if infos[0].ClientIP != "10.0.0.1" || infos[0].User != "alice" {
+		t.Errorf("first client = %+v, want its IP and user", infos[0])
+	}
+
+	if h.Disconnect(99) {
+		t.Error("Disconnect of an unknown ID reported success")
+	}
+	if !h.Disconnect(1) {
+		t.Fatal("Disconnect(1) reported no such client")
+	}
+	if _, open := <-first.send; open {
+		t.Error("disconnected client's send channel is still open")
+	}
+	if infos := h.Clients(); len(infos) != 1 || infos[0].ID != 2 {
+		t.Errorf("Clients() after disconnect = %+v, want only ID 2", infos)
+	}
+	if !h.register(&wsClient{send: make(chan []byte, 1)}) {
+		t.Error("the disconnected client's slot was not freed")
+	}
+	if h.Disconnect(1) {
+		t.Error("a second Disconnect(1) reported success")
+	}
+}
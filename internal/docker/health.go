@@ -0,0 +1,262 @@
+package docker
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/moby/moby/api/types/swarm"
+)
+
+// Service statuses, from the least to the most in need of attention, which
+// is how a stack's status is chosen from its services'.
+const (
+	StatusCompleted = "completed"
+	StatusHealthy   = "healthy"
+	StatusUpdating  = "updating"
+	StatusDegraded  = "degraded"
+	StatusPaused    = "paused"
+	StatusFailing   = "failing"
+)
+
+// statusSeverity orders the statuses, from the least to the most in need of
+// attention.
+var statusSeverity = []string{StatusCompleted, StatusHealthy, StatusUpdating, StatusDegraded, StatusPaused, StatusFailing}
+
+// Status reason codes.
+const (
+	ReasonReplicas         = "replicas"
+	ReasonCompletions      = "completions"
+	ReasonTaskFailed       = "task_failed"
+	ReasonTaskRejected     = "task_rejected"
+	ReasonTaskPending      = "task_pending"
+	ReasonUpdating         = "update_in_progress"
+	ReasonRollingBack      = "rollback_in_progress"
+	ReasonUpdatePaused     = "update_paused"
+	ReasonRollbackPaused   = "rollback_paused"
+	ReasonUpdateRolledBack = "update_rolled_back"
+)
+
+// ServiceStatus is a service's status, computed from its mode, update, and
+// tasks, with the reasons for it.
+type ServiceStatus struct {
+	// ID is the service's ID, and Name and Stack its name and stack.
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Stack string `json:"stack,omitempty"`
+	// Status is one of the Status values.
+	Status string `json:"status"`
+	// Mode is "replicated", "global", "replicated-job", or "global-job".
+	Mode string `json:"mode"`
+	// Desired and Running count the tasks meant to be running and those
+	// running. Completed counts a job's completed tasks, and Total the
+	// completions it needs, or 0 for a global job.
+	Desired   int `json:"desired"`
+	Running   int `json:"running"`
+	Completed int `json:"completed,omitempty"`
+	Total     int `json:"total,omitempty"`
+	// Reasons explain a status other than healthy, most important first.
+	Reasons []StatusReason `json:"reasons,omitempty"`
+}
+
+// StatusReason is a reason for a service's status.
+type StatusReason struct {
+	// Code is one of the Reason codes.
+	Code string `json:"code"`
+	// Message describes the reason, e.g. "2/3 running" or "task rejected:
+	// no suitable node".
+	Message string `json:"message"`
+	// Tasks counts the tasks a task reason applies to.
+	Tasks int `json:"tasks,omitempty"`
+}
+
+// StackStatus rolls up the statuses of a stack's services. Services outside
+// a stack are rolled up under the empty name.
+type StackStatus struct {
+	Name string `json:"name"`
+	// Status is the status of the service most in need of attention.
+	Status string `json:"status"`
+	// Services counts the stack's services by status.
+	Services map[string]int `json:"services"`
+}
+
+// serviceStatuses computes the status of each service from its tasks, in
+// the order of services. rules are the services' rules by ID, which carry
+// their stacks.
+//
+// A service is failing when none of the tasks meant to be running are, and
+// degraded when some are not, unless its update is paused, which needs an
+// operator, or in progress, which explains the shortfall. A job is completed
+// once it has all its completions, and otherwise judged by its failed tasks.
+// Failed, rejected, and unschedulable tasks give the reasons.
+func serviceStatuses(services []swarm.Service, tasks []swarm.Task, rules map[string]serviceRules) []ServiceStatus {
+	byService := make(map[string][]*swarm.Task, len(services))
+	for i := range tasks {
+		t := &tasks[i]
+		byService[t.ServiceID] = append(byService[t.ServiceID], t)
+	}
+
+	statuses := make([]ServiceStatus, 0, len(services))
+	for i := range services {
+		statuses = append(statuses, serviceStatus(&services[i], byService[services[i].ID], rules[services[i].ID].stack))
+	}
+	return statuses
+}
+
+func serviceStatus(s *swarm.Service, tasks []*swarm.Task, stack string) ServiceStatus {
+	st := ServiceStatus{ID: s.ID, Name: cmp.Or(s.Spec.Name, s.ID), Stack: stack, Status: StatusHealthy}
+	mode := s.Spec.Mode
+	switch {
+	case mode.Replicated != nil:
+		st.Mode = "replicated"
+		if mode.Replicated.Replicas != nil {
+			st.Desired = int(*mode.Replicated.Replicas)
+		}
+	case mode.Global != nil:
+		st.Mode = "global"
+	case mode.ReplicatedJob != nil:
+		st.Mode = "replicated-job"
+		st.Total = int(cmp.Or(ptrValue(mode.ReplicatedJob.TotalCompletions), ptrValue(mode.ReplicatedJob.MaxConcurrent), 1))
+	case mode.GlobalJob != nil:
+		st.Mode = "global-job"
+	}
+	job := mode.ReplicatedJob != nil || mode.GlobalJob != nil
+
+	// taskReasons are the reasons given by failed, rejected, and
+	// unschedulable tasks, in the order first seen, counting the tasks
+	// sharing one.
+	var taskReasons []StatusReason
+	addTaskReason := func(code, message string) {
+		for i := range taskReasons {
+			if taskReasons[i].Code == code && taskReasons[i].Message == message {
+				taskReasons[i].Tasks++
+				return
+			}
+		}
+		taskReasons = append(taskReasons, StatusReason{Code: code, Message: message, Tasks: 1})
+	}
+	failed := 0
+	for _, t := range tasks {
+		state := t.Status.State
+		// A job's tasks are meant to run to completion.
+		if t.DesiredState == swarm.TaskStateRunning || (job && t.DesiredState == swarm.TaskStateComplete) {
+			if mode.Global != nil {
+				st.Desired++
+			}
+			if state == swarm.TaskStateRunning {
+				st.Running++
+			}
+		}
+		if state == swarm.TaskStateComplete {
+			st.Completed++
+		}
+		reason := cmp.Or(t.Status.Err, t.Status.Message)
+		switch {
+		case state == swarm.TaskStateFailed:
+			failed++
+			addTaskReason(ReasonTaskFailed, withReason("task failed", reason))
+		case state == swarm.TaskStateRejected:
+			failed++
+			addTaskReason(ReasonTaskRejected, withReason("task rejected", reason))
+		case state == swarm.TaskStatePending && t.Status.Err != "":
+			// The scheduler leaves a task it cannot place pending, with
+			// why, e.g. "no suitable node".
+			addTaskReason(ReasonTaskPending, withReason("task pending", t.Status.Err))
+		}
+	}
+
+	var reasons []StatusReason
+	if job {
+		if js := s.ServiceStatus; js != nil {
+			// The daemon counts completions long after the stopped
+			// tasks are no longer listed.
+			st.Completed = int(js.CompletedTasks)
+			if mode.GlobalJob != nil {
+				st.Total = int(js.DesiredTasks)
+			}
+		}
+		switch {
+		case st.Total > 0 && st.Completed >= st.Total, st.Total == 0 && st.Running == 0 && st.Completed > 0 && failed == 0:
+			st.Status = StatusCompleted
+		case failed > 0 && st.Running == 0:
+			st.Status = StatusFailing
+		case failed > 0:
+			st.Status = StatusDegraded
+		}
+		msg := fmt.Sprintf("%d completed", st.Completed)
+		if st.Total > 0 {
+			msg = fmt.Sprintf("%d/%d completed", st.Completed, st.Total)
+		}
+		reasons = append(reasons, StatusReason{Code: ReasonCompletions, Message: msg})
+	} else if st.Running < st.Desired {
+		st.Status = StatusDegraded
+		if st.Running == 0 {
+			st.Status = StatusFailing
+		}
+		reasons = append(reasons, StatusReason{Code: ReasonReplicas, Message: fmt.Sprintf("%d/%d running", st.Running, st.Desired)})
+	}
+
+	if u := s.UpdateStatus; u != nil {
+		var update *StatusReason
+		switch u.State {
+		case swarm.UpdateStateUpdating:
+			st.Status, update = StatusUpdating, &StatusReason{Code: ReasonUpdating, Message: "update in progress"}
+		case swarm.UpdateStateRollbackStarted:
+			st.Status, update = StatusUpdating, &StatusReason{Code: ReasonRollingBack, Message: "rollback in progress"}
+		case swarm.UpdateStatePaused:
+			st.Status, update = StatusPaused, &StatusReason{Code: ReasonUpdatePaused, Message: withReason("update paused", u.Message)}
+		case swarm.UpdateStateRollbackPaused:
+			st.Status, update = StatusPaused, &StatusReason{Code: ReasonRollbackPaused, Message: withReason("rollback paused", u.Message)}
+		case swarm.UpdateStateRollbackCompleted:
+			// The previous spec runs again, so the replicas decide the
+			// status, but the rollback is worth a reason.
+			if st.Status != StatusHealthy {
+				update = &StatusReason{Code: ReasonUpdateRolledBack, Message: withReason("update rolled back", u.Message)}
+			}
+		}
+		if update != nil {
+			reasons = append([]StatusReason{*update}, reasons...)
+		}
+	}
+
+	switch st.Status {
+	case StatusHealthy:
+	case StatusCompleted:
+		st.Reasons = reasons
+	default:
+		st.Reasons = append(reasons, taskReasons...)
+	}
+	return st
+}
+
+// stackStatuses rolls up statuses by stack, in order of stack name.
+func stackStatuses(statuses []ServiceStatus) []StackStatus {
+	byName := make(map[string]*StackStatus)
+	var stacks []*StackStatus
+	for _, s := range statuses {
+		stack, ok := byName[s.Stack]
+		if !ok {
+			stack = &StackStatus{Name: s.Stack, Status: s.Status, Services: make(map[string]int)}
+			byName[s.Stack] = stack
+			stacks = append(stacks, stack)
+		}
+		stack.Services[s.Status]++
+		if slices.Index(statusSeverity, s.Status) > slices.Index(statusSeverity, stack.Status) {
+			stack.Status = s.Status
+		}
+	}
+	slices.SortFunc(stacks, func(a, b *StackStatus) int { return cmp.Compare(a.Name, b.Name) })
+	out := make([]StackStatus, len(stacks))
+	for i, s := range stacks {
+		out[i] = *s
+	}
+	return out
+}
+
+// ptrValue returns *p, or 0 if p is nil.
+func ptrValue(p *uint64) uint64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
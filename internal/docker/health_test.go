@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/moby/moby/api/types/swarm"
+)
+
+// replicatedService returns a service of n replicas.
+func replicatedService(id, name string, n uint64) swarm.Service {
+	s := namedService(id, name, nil)
+	s.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &n}
+	return s
+}
+
+// serviceTask returns a task of service in state, meant to be desired.
+func serviceTask(id, service string, desired, state swarm.TaskState, err string) swarm.Task {
+	return swarm.Task{ID: id, ServiceID: service, DesiredState: desired,
+		Status: swarm.TaskStatus{State: state, Err: err}}
+}
+
+func TestServiceStatuses(t *testing.T) {
+	const run, shut, complete = swarm.TaskStateRunning, swarm.TaskStateShutdown, swarm.TaskStateComplete
+	jobTotal := uint64(3)
+
+	tests := []struct {
+		name    string
+		service func() swarm.Service
+		tasks   []swarm.Task
+		want    string
+	}{
+		{
+			name:    "healthy",
+			service: func() swarm.Service { return replicatedService("web", "app_web", 2) },
+			tasks:   []swarm.Task{serviceTask("t1", "web", run, run, ""), serviceTask("t2", "web", run, run, "")},
+			want:    `{"id":"web","name":"app_web","status":"healthy","mode":"replicated","desired":2,"running":2}`,
+		},
+		{
+			name:    "healthy after a failure",
+			service: func() swarm.Service { return replicatedService("web", "app_web", 1) },
+			tasks:   []swarm.Task{serviceTask("t1", "web", shut, swarm.TaskStateFailed, "exit 1"), serviceTask("t2", "web", run, run, "")},
+			want:    `{"id":"web","name":"app_web","status":"healthy","mode":"replicated","desired":1,"running":1}`,
+		},
+		{
+			name:    "degraded by an unschedulable task",
+			service: func() swarm.Service { return replicatedService("web", "app_web", 3) },
+			tasks: []swarm.Task{
+				serviceTask("t1", "web", run, run, ""), serviceTask("t2", "web", run, run, ""),
+				serviceTask("t3", "web", run, swarm.TaskStatePending, "no suitable node (1 node not available for new tasks)"),
+			},
+			want: `{"id":"web","name":"app_web","status":"degraded","mode":"replicated","desired":3,"running":2,"reasons":[` +
+				`{"code":"replicas","message":"2/3 running"},` +
+				`{"code":"task_pending","message":"task pending: no suitable node (1 node not available for new tasks)","tasks":1}]}`,
+		},
+		{
+			name:    "failing with rejected tasks",
+			service: func() swarm.Service { return replicatedService("web", "app_web", 2) },
+			tasks: []swarm.Task{
+				serviceTask("t1", "web", shut, swarm.TaskStateRejected, "no suitable node"),
+				serviceTask("t2", "web", shut, swarm.TaskStateRejected, "no suitable node"),
+			},
+			want: `{"id":"web","name":"app_web","status":"failing","mode":"replicated","desired":2,"running":0,"reasons":[` +
+				`{"code":"replicas","message":"0/2 running"},` +
+				`{"code":"task_rejected","message":"task rejected: no suitable node","tasks":2}]}`,
+		},
+		{
+			name: "updating",
+			service: func() swarm.Service {
+				s := replicatedService("web", "app_web", 2)
+				s.UpdateStatus = &swarm.UpdateStatus{State: swarm.UpdateStateUpdating}
+				return s
+			},
+			tasks: []swarm.Task{serviceTask("t1", "web", run, run, ""), serviceTask("t2", "web", run, swarm.TaskStateStarting, "")},
+			want: `{"id":"web","name":"app_web","status":"updating","mode":"replicated","desired":2,"running":1,"reasons":[` +
+				`{"code":"update_in_progress","message":"update in progress"},{"code":"replicas","message":"1/2 running"}]}`,
+		},
+		{
+			name: "update paused",
+			service: func() swarm.Service {
+				s := replicatedService("web", "app_web", 1)
+				s.UpdateStatus = &swarm.UpdateStatus{State: swarm.UpdateStatePaused, Message: "update paused due to failure or early termination of task t9"}
+				return s
+			},
+			tasks: []swarm.Task{serviceTask("t1", "web", run, run, "")},
+			want: `{"id":"web","name":"app_web","status":"paused","mode":"replicated","desired":1,"running":1,"reasons":[` +
+				`{"code":"update_paused","message":"update paused: update paused due to failure or early termination of task t9"}]}`,
+		},
+		{
+			name: "global",
+			service: func() swarm.Service {
+				s := namedService("agent", "ops_agent", nil)
+				s.Spec.Mode.Global = &swarm.GlobalService{}
+				return s
+			},
+			tasks: []swarm.Task{serviceTask("t1", "agent", run, run, ""), serviceTask("t2", "agent", run, swarm.TaskStatePreparing, "")},
+			want: `{"id":"agent","name":"ops_agent","status":"degraded","mode":"global","desired":2,"running":1,"reasons":[` +
+				`{"code":"replicas","message":"1/2 running"}]}`,
+		},
+		{
+			name: "job completed",
+			service: func() swarm.Service {
+				s := namedService("migrate", "app_migrate", nil)
+				s.Spec.Mode.ReplicatedJob = &swarm.ReplicatedJob{TotalCompletions: &jobTotal}
+				s.ServiceStatus = &swarm.ServiceStatus{CompletedTasks: 3}
+				return s
+			},
+			tasks: []swarm.Task{serviceTask("t1", "migrate", complete, complete, "")},
+			want: `{"id":"migrate","name":"app_migrate","status":"completed","mode":"replicated-job","desired":0,"running":0,"completed":3,"total":3,"reasons":[` +
+				`{"code":"completions","message":"3/3 completed"}]}`,
+		},
+		{
+			name: "job running",
+			service: func() swarm.Service {
+				s := namedService("migrate", "app_migrate", nil)
+				s.Spec.Mode.ReplicatedJob = &swarm.ReplicatedJob{TotalCompletions: &jobTotal}
+				return s
+			},
+			tasks: []swarm.Task{serviceTask("t1", "migrate", complete, complete, ""), serviceTask("t2", "migrate", complete, run, "")},
+			want:  `{"id":"migrate","name":"app_migrate","status":"healthy","mode":"replicated-job","desired":0,"running":1,"completed":1,"total":3}`,
+		},
+		{
+			name: "job failing",
+			service: func() swarm.Service {
+				s := namedService("backup", "ops_backup", nil)
+				s.Spec.Mode.GlobalJob = &swarm.GlobalJob{}
+				return s
+			},
+			tasks: []swarm.Task{serviceTask("t1", "backup", complete, swarm.TaskStateFailed, "task: non-zero exit (2)")},
+			want: `{"id":"backup","name":"ops_backup","status":"failing","mode":"global-job","desired":0,"running":0,"reasons":[` +
+				`{"code":"completions","message":"0 completed"},` +
+				`{"code":"task_failed","message":"task failed: task: non-zero exit (2)","tasks":1}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := serviceStatuses([]swarm.Service{tt.service()}, tt.tasks, nil)
+			if len(got) != 1 {
+				t.Fatalf("got %d statuses, want 1", len(got))
+			}
+			gotJSON, _ := json.Marshal(got[0])
+			if string(gotJSON) != tt.want {
+				t.Errorf("status =\n%s\nwant\n%s", gotJSON, tt.want)
+			}
+		})
+	}
+}
+
+func TestStackStatuses(t *testing.T) {
+	statuses := []ServiceStatus{
+		{ID: "a", Stack: "app", Status: StatusHealthy},
+		{ID: "b", Stack: "app", Status: StatusDegraded},
+		{ID: "c", Stack: "app", Status: StatusUpdating},
+		{ID: "d", Stack: "ops", Status: StatusCompleted},
+		{ID: "e", Status: StatusHealthy},
+	}
+	got, _ := json.Marshal(stackStatuses(statuses))
+	want := `[{"name":"","status":"healthy","services":{"healthy":1}},` +
+		`{"name":"app","status":"degraded","services":{"degraded":1,"healthy":1,"updating":1}},` +
+		`{"name":"ops","status":"completed","services":{"completed":1}}]`
+	if string(got) != want {
+		t.Errorf("stacks =\n%s\nwant\n%s", got, want)
+	}
+}
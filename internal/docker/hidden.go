@@ -0,0 +1,71 @@
+package docker
+
+import (
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/api/types/swarm"
+)
+
+const (
+	// hiddenLabel on a service, node, or network leaves it out of the
+	// published data, as the HIDDEN_* settings do, unless set to a false
+	// value.
+	hiddenLabel = visualizerLabelPrefix + "hidden"
+	// stackNamespaceLabel names the stack a service or network was deployed
+	// with.
+	stackNamespaceLabel = "com.docker.stack.namespace"
+)
+
+// serviceHidden reports whether svc is left out of the published data, by its
+// hidden label, its name, or its stack.
+func serviceHidden(svc *swarm.Service, cfg *config.Config) bool {
+	labels := svc.Spec.Labels
+	return labelEnabled(labels, hiddenLabel) ||
+		cfg.CompiledHiddenServices.Match(svc.Spec.Name) ||
+		stackHidden(labels, cfg)
+}
+
+// nodeHidden reports whether node is left out of the published data, by its
+// hidden label or the HIDDEN_NODE_LABELS selectors.
+func nodeHidden(node *swarm.Node, cfg *config.Config) bool {
+	labels := node.Spec.Labels
+	if labelEnabled(labels, hiddenLabel) {
+		return true
+	}
+	for _, selector := range cfg.CompiledHiddenNodeLabels {
+		if selector.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// networkHidden reports whether net is left out of the published data, by its
+// hidden label or its stack.
+func networkHidden(net *network.Summary, cfg *config.Config) bool {
+	return labelEnabled(net.Labels, hiddenLabel) || stackHidden(net.Labels, cfg)
+}
+
+// stackHidden reports whether the object with labels belongs to a hidden
+// stack.
+func stackHidden(labels map[string]string, cfg *config.Config) bool {
+	namespace, ok := labels[stackNamespaceLabel]
+	return ok && cfg.CompiledHiddenStacks.Match(namespace)
+}
+
+// taskOwners is what sanitizing tasks needs to know about the services and
+// nodes they belong to, from the last refresh of those groups.
+type taskOwners struct {
+	// services holds each service's sanitization rules by ID.
+	services map[string]serviceRules
+	// hiddenNodes holds the IDs of hidden nodes, whose tasks are hidden too.
+	hiddenNodes map[string]bool
+}
+
+// hidden reports whether t belongs to a hidden service or node. The tasks of
+// a service created since the services were last refreshed are hidden too,
+// as its rules, and whether it is hidden, are not known until then.
+func (o taskOwners) hidden(t *swarm.Task) bool {
+	r, known := o.services[t.ServiceID]
+	return !known || r.hidden || o.hiddenNodes[t.NodeID]
+}
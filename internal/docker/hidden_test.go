@@ -0,0 +1,95 @@
+package docker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/api/types/swarm"
+)
+
+func hiddenConfig(t *testing.T) *config.Config {
+	t.Setenv("HIDDEN_STACKS", "monitoring")
+	t.Setenv("HIDDEN_SERVICES", "*_socket-proxy")
+	t.Setenv("HIDDEN_NODE_LABELS", "role=build*")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func namedService(id, name string, labels map[string]string) swarm.Service {
+	return swarm.Service{ID: id, Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: name, Labels: labels}}}
+}
+
+func TestSanitizeServices_Hidden(t *testing.T) {
+	services := []swarm.Service{
+		namedService("web", "app_web", map[string]string{"com.docker.stack.namespace": "app"}),
+		namedService("agent", "monitoring_agent", map[string]string{"com.docker.stack.namespace": "monitoring"}),
+		namedService("proxy", "viz_socket-proxy", nil),
+		namedService("labelled", "app_secret", map[string]string{"io.github.jtgasper3.visualizer.hidden": "true"}),
+		namedService("shown", "app_shown", map[string]string{"io.github.jtgasper3.visualizer.hidden": "false"}),
+	}
+
+	out, rules := sanitizeServices(services, hiddenConfig(t))
+	if len(out) != 2 || out[0].ID != "web" || out[1].ID != "shown" {
+		t.Fatalf("services = %+v, want only web and shown", out)
+	}
+	for _, id := range []string{"agent", "proxy", "labelled"} {
+		if !rules[id].hidden {
+			t.Errorf("rules[%s].hidden = false, want true", id)
+		}
+	}
+}
+
+func TestSanitizeNodes_Hidden(t *testing.T) {
+	nodes := []swarm.Node{
+		{ID: "n1"},
+		{ID: "n2", Spec: swarm.NodeSpec{Annotations: swarm.Annotations{Labels: map[string]string{"role": "builder"}}}},
+		{ID: "n3", Spec: swarm.NodeSpec{Annotations: swarm.Annotations{Labels: map[string]string{"io.github.jtgasper3.visualizer.hidden": ""}}}},
+	}
+
+	out, hidden := sanitizeNodes(nodes, hiddenConfig(t))
+	if len(out) != 1 || out[0].ID != "n1" {
+		t.Fatalf("nodes = %+v, want only n1", out)
+	}
+	if !hidden["n2"] || !hidden["n3"] || hidden["n1"] {
+		t.Errorf("hidden = %v, want n2 and n3", hidden)
+	}
+}
+
+func TestSanitizeTasks_HiddenOwners(t *testing.T) {
+	owners := taskOwners{
+		services:    map[string]serviceRules{"agent": {hidden: true}, "web": {}},
+		hiddenNodes: map[string]bool{"n2": true},
+	}
+	tasks := []swarm.Task{
+		{ID: "t1", ServiceID: "web", NodeID: "n1"},
+		{ID: "t2", ServiceID: "agent", NodeID: "n1"},
+		{ID: "t3", ServiceID: "web", NodeID: "n2"},
+		{ID: "t4", ServiceID: "web"},
+	}
+
+	out := sanitizeTasks(tasks, &config.Config{}, owners)
+	if ids := taskIDs(out); len(out) != 2 || !ids["t1"] || !ids["t4"] {
+		t.Fatalf("tasks = %v, want t1 and t4", ids)
+	}
+}
+
+func TestGetNetworksInfo_HiddenStack(t *testing.T) {
+	src := fakeSource{networks: []network.Summary{
+		{Network: network.Network{Name: "app_default", Labels: map[string]string{"com.docker.stack.namespace": "app"}}},
+		{Network: network.Network{Name: "monitoring_default", Labels: map[string]string{"com.docker.stack.namespace": "monitoring"}}},
+		{Network: network.Network{Name: "private", Labels: map[string]string{"io.github.jtgasper3.visualizer.hidden": "1"}}},
+	}}
+
+	out, err := getNetworksInfo(context.Background(), src, hiddenConfig(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0].Name != "app_default" {
+		t.Fatalf("networks = %+v, want only app_default", out)
+	}
+}
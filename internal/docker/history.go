@@ -0,0 +1,312 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/history"
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+)
+
+// historyGroups are the groups of a snapshot the history stores as deltas.
+var historyGroups = []string{"networks", "nodes", "services", "tasks", "serviceStatuses"}
+
+// openHistory opens the history configured by cfg.
+func openHistory(cfg *config.Config) (*history.Store, error) {
+	return history.Open(cfg.HistoryPath, history.Options{
+		Groups:           historyGroups,
+		Retention:        cfg.HistoryRetention,
+		MaxBytes:         cfg.HistoryMaxBytes,
+		KeyframeInterval: cfg.HistoryKeyframeInterval,
+		Fingerprint:      cfg.SanitizationFingerprint(),
+	})
+}
+
+// authorizeGet reports whether r may be served by a read-only API: it is a
+// GET and, when authentication is enabled, carries a valid token, as the
+// WebSocket requires. Otherwise it writes the error response.
+func (h *Hub) authorizeGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if h.cfg.AuthEnabled {
+		if _, err := h.validate(r); err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return false
+		}
+	}
+	return true
+}
+
+// historyAvailable reports whether the history is enabled, writing a 404
+// when it is not.
+func (h *Hub) historyAvailable(w http.ResponseWriter) bool {
+	if h.history == nil {
+		http.Error(w, "History is not enabled", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// parseTimeParam parses the query parameter name of r as an RFC 3339 time,
+// returning def if it is not set.
+func parseTimeParam(r *http.Request, name string, def time.Time) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		if def.IsZero() {
+			return time.Time{}, fmt.Errorf("%s is required", name)
+		}
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s %q must be an RFC 3339 time", name, v)
+	}
+	return t, nil
+}
+
+// writeJSON writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Writing the response failed", logging.Err(err))
+	}
+}
+
+// handleHistory serves the extent of the history.
+func (h *Hub) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGet(w, r) || !h.historyAvailable(w) {
+		return
+	}
+	bounds, err := h.history.Bounds()
+	if err != nil {
+		slog.Error("Reading the history failed", logging.Err(err))
+		http.Error(w, "Reading the history failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, bounds)
+}
+
+// handleHistorySnapshot serves the snapshot as it was at the at query
+// parameter, the latest published at or before it.
+func (h *Hub) handleHistorySnapshot(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGet(w, r) || !h.historyAvailable(w) {
+		return
+	}
+	at, err := parseTimeParam(r, "at", time.Time{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	frame, _, ok := h.historyAt(w, at)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(frame)
+}
+
+// historyDiff is the response of the history diff API.
+type historyDiff struct {
+	// From and To are when the compared snapshots were published, at or
+	// before the requested times.
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Changes []Change  `json:"changes"`
+}
+
+// handleHistoryDiff serves the changes from the snapshot at the from query
+// parameter to the one at to, which defaults to now. The changes are not
+// numbered, as they are not part of the timeline.
+func (h *Hub) handleHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGet(w, r) || !h.historyAvailable(w) {
+		return
+	}
+	from, err := parseTimeParam(r, "from", time.Time{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(r, "to", time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var snaps [2]SwarmData
+	diff := historyDiff{Changes: []Change{}}
+	for i, t := range []time.Time{from, to} {
+		frame, at, ok := h.historyAt(w, t)
+		if !ok {
+			return
+		}
+		if err := json.Unmarshal(frame, &snaps[i]); err != nil {
+			slog.Error("Decoding a snapshot from the history failed", logging.Err(err))
+			http.Error(w, "Reading the history failed", http.StatusInternalServerError)
+			return
+		}
+		if i == 0 {
+			diff.From = at
+		} else {
+			diff.To = at
+		}
+	}
+	for _, c := range diffSnapshots(newSnapshotIndex(&snaps[0]), newSnapshotIndex(&snaps[1])) {
+		c.Sequence, c.Time = snaps[1].Sequence, diff.To
+		diff.Changes = append(diff.Changes, c)
+	}
+	writeJSON(w, diff)
+}
+
+// historyAt returns the snapshot at t and when it was published, writing
+// the error response and reporting false if there is none.
+func (h *Hub) historyAt(w http.ResponseWriter, t time.Time) ([]byte, time.Time, bool) {
+	frame, at, err := h.history.At(t)
+	switch {
+	case errors.Is(err, history.ErrNotFound):
+		http.Error(w, fmt.Sprintf("No snapshot is kept from before %s", t.Format(time.RFC3339)), http.StatusNotFound)
+		return nil, time.Time{}, false
+	case err != nil:
+		slog.Error("Reading the history failed", logging.Err(err))
+		http.Error(w, "Reading the history failed", http.StatusInternalServerError)
+		return nil, time.Time{}, false
+	}
+	return frame, at, true
+}
+
+// Replay pacing: the time between snapshots is divided by the speed, which
+// defaults to defaultReplaySpeed, and capped at maxReplayGap so a quiet
+// stretch of the history does not stall the replay.
+const (
+	defaultReplaySpeed = 10
+	maxReplayGap       = 5 * time.Second
+)
+
+// replayRange is the range of the history a historical connection replays.
+type replayRange struct {
+	from, to time.Time
+	speed    float64
+}
+
+// replayMessage is the WebSocket message that starts a replay, before its
+// snapshots, and, with type "replay_end", the one that ends it.
+type replayMessage struct {
+	Type  string    `json:"type"`
+	From  time.Time `json:"from,omitzero"`
+	To    time.Time `json:"to,omitzero"`
+	Speed float64   `json:"speed,omitempty"`
+	// Error is set when the replay ended early because the history could
+	// not be read.
+	Error string `json:"error,omitempty"`
+}
+
+// parseReplayRange parses the range of a historical connection from the
+// from, to, and speed query parameters. to defaults to now.
+func parseReplayRange(r *http.Request) (*replayRange, error) {
+	from, err := parseTimeParam(r, "from", time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTimeParam(r, "to", time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if to.Before(from) {
+		return nil, errors.New("to must not be before from")
+	}
+	speed := float64(defaultReplaySpeed)
+	if v := r.URL.Query().Get("speed"); v != "" {
+		if speed, err = strconv.ParseFloat(v, 64); err != nil || speed <= 0 {
+			return nil, fmt.Errorf("speed %q must be a positive number", v)
+		}
+	}
+	return &replayRange{from: from, to: to, speed: speed}, nil
+}
+
+// errReplayStopped ends a replay whose client was unregistered.
+var errReplayStopped = errors.New("replay stopped")
+
+// replayPump owns every write to a historical connection: the snapshots of
+// its range, paced by its speed, and keepalive pings. It closes the
+// connection, ending the read loop, when the replay ends, the client is
+// unregistered, or a write fails.
+func (c *wsClient) replayPump(store *history.Store) {
+	defer c.conn.Close()
+	ticker := time.NewTicker(pingPeriod())
+	defer ticker.Stop()
+
+	// wait waits for d, pinging meanwhile, and reports whether the replay
+	// should go on.
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				return true
+			case <-c.send:
+				// Nothing is sent to a historical client; send is closed
+				// when it is unregistered.
+				return false
+			case <-ticker.C:
+				c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					c.logger.Warn("WebSocket ping failed, closing", logging.Err(err))
+					return false
+				}
+			}
+		}
+	}
+	writeJSON := func(v any) bool {
+		msg, err := json.Marshal(v)
+		if err != nil {
+			c.logger.Error("Marshalling a replay message failed", logging.Err(err))
+			return false
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return c.write(msg)
+	}
+
+	r := c.replay
+	if !writeJSON(replayMessage{Type: "replay", From: r.from, To: r.to, Speed: r.speed}) {
+		return
+	}
+	var last time.Time
+	err := store.Replay(r.from, r.to, func(at time.Time, frame []byte) error {
+		if !last.IsZero() && !wait(min(time.Duration(float64(at.Sub(last))/r.speed), maxReplayGap)) {
+			return errReplayStopped
+		}
+		last = at
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if !c.write(frame) {
+			return errReplayStopped
+		}
+		return nil
+	})
+	end := replayMessage{Type: "replay_end"}
+	switch {
+	case errors.Is(err, errReplayStopped):
+		return
+	case errors.Is(err, history.ErrCleared):
+		end.Error = "The history was cleared, as the sanitization settings changed"
+	case err != nil:
+		c.logger.Error("Replaying the history failed", logging.Err(err))
+		end.Error = "Reading the history failed"
+	}
+	if writeJSON(end) {
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "replay ended"))
+	}
+}
@@ -0,0 +1,204 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/moby/moby/api/types/swarm"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+)
+
+var historyStart = time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC)
+
+// historyHub returns a hub whose history holds the timeline fixture at
+// historyStart and, a minute later, the same swarm with its node down.
+func historyHub(t *testing.T) *Hub {
+	t.Helper()
+	cfg := &config.Config{
+		ContextRoot:             "/",
+		HistoryPath:             filepath.Join(t.TempDir(), "history.db"),
+		HistoryRetention:        time.Hour,
+		HistoryMaxBytes:         1 << 20,
+		HistoryKeyframeInterval: time.Hour,
+	}
+	h := newHub(cfg, nil)
+	store, err := openHistory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.history = store
+	t.Cleanup(func() { h.Close() })
+
+	data := timelineFixture()
+	for i := range 2 {
+		data.Sequence = uint64(i + 1)
+		data.GeneratedAt = historyStart.Add(time.Duration(i) * time.Minute)
+		if i == 1 {
+			data.Nodes[0].Status.State = swarm.NodeStateDown
+		}
+		frame, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.record(cfg, data.GeneratedAt, frame)
+	}
+	if !waitFor(t, func() bool {
+		b, err := store.Bounds()
+		return err == nil && b.Entries == 2
+	}, 2*time.Second) {
+		t.Fatal("snapshots were not stored")
+	}
+	return h
+}
+
+func TestHandleHistory(t *testing.T) {
+	h := historyHub(t)
+	at := func(d time.Duration) string { return url.QueryEscape(historyStart.Add(d).Format(time.RFC3339)) }
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		target     string
+		wantStatus int
+		check      func(t *testing.T, body []byte)
+	}{
+		{
+			name: "bounds", handler: h.handleHistory, target: "/api/history", wantStatus: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var b struct {
+					Oldest  time.Time
+					Entries int
+				}
+				if err := json.Unmarshal(body, &b); err != nil || !b.Oldest.Equal(historyStart) || b.Entries != 2 {
+					t.Errorf("bounds = %s, want 2 entries from the start", body)
+				}
+			},
+		},
+		{
+			name: "snapshot", handler: h.handleHistorySnapshot, target: "/api/history/snapshot?at=" + at(90*time.Second),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var data SwarmData
+				if err := json.Unmarshal(body, &data); err != nil || data.Sequence != 2 || data.Nodes[0].Status.State != swarm.NodeStateDown {
+					t.Errorf("snapshot = %s, want the second", body)
+				}
+			},
+		},
+		{
+			name: "snapshot before the history", handler: h.handleHistorySnapshot,
+			target: "/api/history/snapshot?at=" + at(-time.Minute), wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "snapshot without a time", handler: h.handleHistorySnapshot,
+			target: "/api/history/snapshot", wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "snapshot at a bad time", handler: h.handleHistorySnapshot,
+			target: "/api/history/snapshot?at=yesterday", wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "diff", handler: h.handleHistoryDiff, target: "/api/history/diff?from=" + at(0) + "&to=" + at(time.Hour),
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var diff historyDiff
+				if err := json.Unmarshal(body, &diff); err != nil {
+					t.Fatal(err)
+				}
+				if len(diff.Changes) != 1 || diff.Changes[0].Kind != ChangeNodeState || !diff.To.Equal(historyStart.Add(time.Minute)) {
+					t.Errorf("diff = %s, want the node going down a minute in", body)
+				}
+			},
+		},
+		{
+			name: "diff without a start", handler: h.handleHistoryDiff,
+			target: "/api/history/diff?to=" + at(0), wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "not enabled", handler: newHub(&config.Config{}, nil).handleHistory,
+			target: "/api/history", wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.check != nil {
+				tt.check(t, rec.Body.Bytes())
+			}
+		})
+	}
+}
+
+// TestWS_HistoricalReplay verifies that a historical connection is sent the
+// range of the history it asked for, and not the live swarm, and is then
+// closed.
+func TestWS_HistoricalReplay(t *testing.T) {
+	h := historyHub(t)
+	runHub(t, h)
+	h.Publish(context.Background(), []byte(`{"clusterName":"live"}`))
+	if !waitFor(t, h.Ready, time.Second) {
+		t.Fatal("frame was never fanned out")
+	}
+	_, wsURL := wsServer(t, h)
+
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL+"?mode=historical&from=soon", nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad range dial err = %v, want a 400", err)
+	}
+
+	query := url.Values{
+		"mode":  {"historical"},
+		"from":  {historyStart.Add(30 * time.Second).Format(time.RFC3339)},
+		"to":    {historyStart.Add(time.Hour).Format(time.RFC3339)},
+		"speed": {"1000"},
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	var types []string
+	var sequences []uint64
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatalf("read: %v", err)
+			}
+			break
+		}
+		var m struct {
+			Type     string `json:"type"`
+			Sequence uint64 `json:"sequence"`
+		}
+		if err := json.Unmarshal(msg, &m); err != nil {
+			t.Fatalf("invalid message %s: %v", msg, err)
+		}
+		if m.Type == "" {
+			sequences = append(sequences, m.Sequence)
+		} else {
+			types = append(types, m.Type)
+		}
+	}
+	if len(types) != 2 || types[0] != "replay" || types[1] != "replay_end" {
+		t.Errorf("message types = %v, want replay and replay_end", types)
+	}
+	if len(sequences) != 2 || sequences[0] != 1 || sequences[1] != 2 {
+		t.Errorf("replayed snapshots = %v, want [1 2]", sequences)
+	}
+	if !waitFor(t, func() bool { return clientCount(h) == 0 }, time.Second) {
+		t.Error("the replay's client was not unregistered")
+	}
+}
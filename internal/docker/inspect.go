@@ -2,22 +2,33 @@ package docker
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
 	"reflect"
 	"slices"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/jtgasper3/swarm-visualizer/internal"
 	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+	"github.com/jtgasper3/swarm-visualizer/internal/tracing"
 
 	"github.com/moby/moby/api/types/network"
 	"github.com/moby/moby/api/types/swarm"
 	"github.com/moby/moby/client"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer traces the polling of the swarm, its sanitization, and the fan-out
+// of snapshots.
+var tracer = otel.Tracer("github.com/jtgasper3/swarm-visualizer/internal/docker")
+
 type SwarmData struct {
 	ClusterName string            `json:"clusterName"`
 	AuthEnabled bool              `json:"authEnabled"`
@@ -25,15 +36,47 @@ type SwarmData struct {
 	Nodes       []swarm.Node      `json:"nodes"`
 	Services    []swarm.Service   `json:"services"`
 	Tasks       []swarm.Task      `json:"tasks"`
+
+	// ServiceStatuses are the services' statuses, in the order of Services,
+	// and StackStatuses their rollup by stack.
+	ServiceStatuses []ServiceStatus `json:"serviceStatuses"`
+	StackStatuses   []StackStatus   `json:"stackStatuses"`
+
+	// Freshness is how current each group is, by group name.
+	Freshness map[string]Freshness `json:"freshness,omitempty"`
+	// Sequence numbers the published snapshots, from 1, and GeneratedAt is
+	// when this one was published.
+	Sequence    uint64    `json:"sequence,omitzero"`
+	GeneratedAt time.Time `json:"generatedAt,omitzero"`
+}
+
+// ValidateDataPath reports whether path names a location within SwarmData,
+// catching misspelled field names before any data is published.
+func ValidateDataPath(path string) error {
+	return internal.ValidatePath(reflect.TypeOf(SwarmData{}), path)
+}
+
+// projectSwarmData returns the allow-listed view of data: only the values
+// selected by paths, with everything else left empty. The cluster name, auth
+// flag, and freshness are not Docker data and are always kept.
+func projectSwarmData(data *SwarmData, paths []*internal.Path) SwarmData {
+	out := SwarmData{ClusterName: data.ClusterName, AuthEnabled: data.AuthEnabled, Freshness: data.Freshness}
+	internal.Project(&out, data, paths)
+	return out
 }
 
 type cachedTask struct {
 	task      swarm.Task
 	firstSeen time.Time
+	// rules are the task's service's sanitization rules when last seen, so
+	// they still apply after the service is removed.
+	rules serviceRules
 }
 
-// stoppedTaskCache is only accessed from the single inspectSwarmServices goroutine.
-var stoppedTaskCache = make(map[string]cachedTask)
+// stoppedTasks holds recently stopped tasks by ID, so they stay visible for
+// failedTaskGracePeriod. Each inspector has its own, accessed only from its
+// goroutine.
+type stoppedTasks map[string]cachedTask
 
 // swarmSource is the subset of the Docker API the inspector needs. It is an
 // interface so tests can substitute a fake daemon for the real client.
@@ -58,7 +101,9 @@ func newMobySource() (swarmSource, error) {
 }
 
 func (m mobySource) Nodes(ctx context.Context) ([]swarm.Node, error) {
+	start := time.Now()
 	res, err := m.cli.NodeList(ctx, client.NodeListOptions{})
+	metrics.ObserveDockerRequest("nodes", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -66,7 +111,9 @@ func (m mobySource) Nodes(ctx context.Context) ([]swarm.Node, error) {
 }
 
 func (m mobySource) Services(ctx context.Context) ([]swarm.Service, error) {
+	start := time.Now()
 	res, err := m.cli.ServiceList(ctx, client.ServiceListOptions{})
+	metrics.ObserveDockerRequest("services", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -74,7 +121,9 @@ func (m mobySource) Services(ctx context.Context) ([]swarm.Service, error) {
 }
 
 func (m mobySource) Tasks(ctx context.Context) ([]swarm.Task, error) {
+	start := time.Now()
 	res, err := m.cli.TaskList(ctx, client.TaskListOptions{})
+	metrics.ObserveDockerRequest("tasks", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -82,7 +131,9 @@ func (m mobySource) Tasks(ctx context.Context) ([]swarm.Task, error) {
 }
 
 func (m mobySource) Networks(ctx context.Context) ([]network.Summary, error) {
+	start := time.Now()
 	res, err := m.cli.NetworkList(ctx, client.NetworkListOptions{Filters: make(client.Filters).Add("scope", "swarm")})
+	metrics.ObserveDockerRequest("networks", start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -105,12 +156,36 @@ const (
 // recently fetched value for each group is cached between ticks and reassembled
 // on every publish.
 //
-// Reassembly applies SensitiveDataPaths via ClearByPath, which only zeroes
-// fields and is therefore idempotent; re-applying it to a cached (already
-// cleared) slice that is also referenced by the previously published snapshot
-// leaves that snapshot's bytes unchanged, so change detection stays correct.
-func inspectSwarmServices(cfg *config.Config, src swarmSource, hub *Hub) {
-	ctx := context.Background()
+// Each group is fetched and kept independently: when one fetch fails, the
+// group keeps its last data and is marked stale in the snapshot's Freshness
+// while the others stay current.
+//
+// The SensitiveDataPaths are applied to copies of the cached groups each
+// time a frame is built, since Plan.Apply copies what it writes through, so
+// the cache is never redacted and no action is applied twice; see
+// snapshotter for how unchanged snapshots are skipped.
+//
+// The configuration is re-read from cfgs on every refresh. When it is
+// reloaded, the cached groups (sanitized under the old configuration) are
+// discarded and everything is re-fetched and re-published at once, so
+// connected clients see the change without reconnecting and nothing sanitized
+// under the old rules is published under the new ones. The history, which
+// keeps only snapshots sanitized under the running rules, is cleared when
+// they change.
+//
+// Each published snapshot is also compared with the last for the changes
+// added to the hub's timeline and the events notifier, which may be nil, is
+// told of.
+//
+// It runs until ctx is cancelled.
+func inspectSwarmServices(ctx context.Context, cfgs *config.Holder, src swarmSource, hub *Hub, notifier eventNotifier) {
+	reloaded := make(chan struct{}, 1)
+	cfgs.Subscribe(func(*config.Config) {
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	})
 
 	var (
 		nodes    []swarm.Node
@@ -118,41 +193,95 @@ func inspectSwarmServices(cfg *config.Config, src swarmSource, hub *Hub) {
 		networks []network.Summary
 		tasks    []swarm.Task
 
-		haveStructural bool
-		haveTasks      bool
+		// owners is what task refreshes need from the structural groups.
+		owners taskOwners
+
+		haveNodes    bool
+		haveServices bool
+		haveTasks    bool
 
-		// lastPublished is the previous snapshot, kept for change detection. It is
-		// only touched by this single goroutine.
-		lastPublished *SwarmData
+		stopped   = make(stoppedTasks)
+		fresh     = make(freshnessTracker)
+		snapshots snapshotter
+		alerts    alertWatcher
+		differ    changeDiffer
 	)
 
-	refreshStructural := func() bool {
-		n, errN := getNodesInfo(ctx, src, cfg)
-		s, errS := getServicesInfo(ctx, src, cfg)
-		nw, errNw := getNetworksInfo(ctx, src, cfg)
-		if errN != nil || errS != nil || errNw != nil {
+	refreshNodes := func(ctx context.Context, cfg *config.Config) bool {
+		n, hiddenNodes, err := getNodesInfo(ctx, src, cfg)
+		if !fresh.record("nodes", err, time.Now()) {
 			return false
 		}
-		nodes, services, networks = n, s, nw
-		haveStructural = true
+		nodes, owners.hiddenNodes, haveNodes = n, hiddenNodes, true
 		return true
 	}
 
-	refreshTasks := func() bool {
-		t, err := getTasksInfo(ctx, src, cfg)
-		if err != nil {
+	refreshServices := func(ctx context.Context, cfg *config.Config) bool {
+		s, rules, err := getServicesInfo(ctx, src, cfg)
+		if !fresh.record("services", err, time.Now()) {
 			return false
 		}
+		services, owners.services, haveServices = s, rules, true
+		return true
+	}
+
+	refreshNetworks := func(ctx context.Context, cfg *config.Config) bool {
+		nw, err := getNetworksInfo(ctx, src, cfg)
+		if !fresh.record("networks", err, time.Now()) {
+			return false
+		}
+		networks = nw
+		return true
+	}
+
+	refreshStructural := func(ctx context.Context) {
+		defer observePoll("structural", time.Now())
+		cfg := cfgs.Load()
+		okNodes := refreshNodes(ctx, cfg)
+		okServices := refreshServices(ctx, cfg)
+		okNetworks := refreshNetworks(ctx, cfg)
+		if (okNodes || okServices) && haveNodes && haveServices {
+			swarmState.setStructural(cfg, nodes, services, owners.services)
+		}
+		if okNodes && okServices && okNetworks {
+			hub.polledAt("structural", time.Now())
+		}
+	}
+
+	refreshTasks := func(ctx context.Context) {
+		// Tasks are sanitized and hidden according to their services and
+		// nodes, so wait for those.
+		if !haveNodes || !haveServices {
+			return
+		}
+		defer observePoll("tasks", time.Now())
+		cfg := cfgs.Load()
+		t, err := getTasksInfo(ctx, src, cfg, owners, stopped)
+		if !fresh.record("tasks", err, time.Now()) {
+			return
+		}
 		tasks = t
 		haveTasks = true
-		return true
+		swarmState.setTasks(cfg, t)
+		hub.polledAt("tasks", time.Now())
 	}
 
-	publish := func() {
-		// Don't publish a partial view before every group has loaded once.
-		if !haveStructural || !haveTasks {
+	publish := func(ctx context.Context) {
+		// Don't publish before the tasks, and so the nodes and services they
+		// depend on, have loaded once and the networks have been fetched
+		// once; after that, a failing group is published stale.
+		if !haveTasks || !fresh.attempted("networks") {
 			return
 		}
+		ctx, span := tracer.Start(ctx, "publish")
+		defer span.End()
+		cfg := cfgs.Load()
+
+		// The metrics count the statuses of the data as polled; the
+		// snapshotter computes them again, with their stacks, from the data
+		// as sanitized for publishing.
+		statuses := serviceStatuses(services, tasks, owners.services)
+		swarmState.setStatuses(statuses)
 
 		data := SwarmData{
 			ClusterName: cfg.ClusterName,
@@ -161,30 +290,49 @@ func inspectSwarmServices(cfg *config.Config, src swarmSource, hub *Hub) {
 			Nodes:       nodes,
 			Networks:    networks,
 			Tasks:       tasks,
+			Freshness:   fresh.snapshot(),
+
+			ServiceStatuses: statuses,
+			StackStatuses:   stackStatuses(statuses),
+		}
+		if data.Networks == nil {
+			// They have never loaded; publish none rather than null.
+			data.Networks = []network.Summary{}
 		}
 
-		for _, item := range cfg.SensitiveDataPaths {
-			if clearErr := internal.ClearByPath(&data, item); clearErr != nil {
-				log.Println("Error clearing sensitive data:", clearErr, item)
+		frame := snapshots.frame(ctx, cfg, data)
+		span.SetAttributes(attribute.Bool("snapshot.changed", frame != nil))
+		if frame != nil {
+			hub.Publish(ctx, frame)
+			hub.record(cfg, snapshots.published.GeneratedAt, frame)
+			if changes := differ.observe(cfg, &snapshots.published, time.Now()); len(changes) > 0 {
+				hub.PublishChanges(ctx, changes)
 			}
 		}
-
-		if lastPublished == nil || !reflect.DeepEqual(data, *lastPublished) {
-			jsonBytes, err := json.Marshal(data)
-			if err != nil {
-				log.Println("Error marshalling combined data:", err)
-				return
+		// Events are found in the snapshot as published, which an unchanged
+		// snapshot still is, so that under-replication is timed on every
+		// publish.
+		if notifier != nil && snapshots.sequence > 0 {
+			if events := alerts.observe(cfg, &snapshots.published, time.Now()); len(events) > 0 {
+				notifier.Notify(events)
 			}
-			lastPublished = &data
-			hub.Publish(jsonBytes)
 		}
 	}
 
+	// poll runs one trace of refreshes of group and publishes, failed
+	// refreshes included, so a group going stale is published.
+	poll := func(group string, refreshes ...func(context.Context)) {
+		ctx, span := tracer.Start(ctx, "poll "+group)
+		defer span.End()
+		for _, refresh := range refreshes {
+			refresh(ctx)
+		}
+		publish(ctx)
+	}
+
 	// Initial fetch so clients connecting at startup get a full snapshot
 	// promptly rather than waiting for the first structural tick.
-	refreshStructural()
-	refreshTasks()
-	publish()
+	poll("all", refreshStructural, refreshTasks)
 
 	taskTicker := time.NewTicker(taskPollInterval)
 	defer taskTicker.Stop()
@@ -193,27 +341,80 @@ func inspectSwarmServices(cfg *config.Config, src swarmSource, hub *Hub) {
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-taskTicker.C:
-			if refreshTasks() {
-				publish()
-			}
+			poll("tasks", refreshTasks)
 		case <-structuralTicker.C:
-			if refreshStructural() {
-				publish()
-			}
+			poll("structural", refreshStructural)
+		case <-reloaded:
+			slog.Info("Configuration reloaded, re-publishing")
+			haveNodes, haveServices, haveTasks = false, false, false
+			// Nothing sanitized under the old configuration may be
+			// published stale under the new one.
+			nodes, services, networks, tasks = nil, nil, nil, nil
+			owners = taskOwners{}
+			clear(fresh)
+			poll("all", refreshStructural, refreshTasks)
 		}
 	}
 }
 
+// observePoll records the duration of a refresh of group that started at
+// start.
+func observePoll(group string, start time.Time) {
+	metrics.PollDuration.WithLabelValues(group).Observe(time.Since(start).Seconds())
+}
+
+// pollErrors bounds the logging of failed Docker API requests while polling,
+// which would otherwise repeat every second while the daemon is unreachable.
+var pollErrors = logging.NewRepeats(time.Minute)
+
+// listPolled calls list and logs its failure, or recovery from failures,
+// through pollErrors.
+func listPolled[T any](ctx context.Context, resource string, fetch func(context.Context) ([]T, error)) ([]T, error) {
+	items, err := list(ctx, resource, fetch)
+	if err != nil {
+		pollErrors.Error(resource, "Docker API request failed", err, logging.Resource(resource))
+	} else {
+		pollErrors.Success(resource, "Docker API request succeeded again", logging.Resource(resource))
+	}
+	return items, err
+}
+
+// list calls fetch, the Docker API list call for resource, in a span.
+func list[T any](ctx context.Context, resource string, fetch func(context.Context) ([]T, error)) ([]T, error) {
+	ctx, span := tracer.Start(ctx, "docker.list "+resource, trace.WithAttributes(attribute.String("docker.resource", resource)))
+	items, err := fetch(ctx)
+	span.SetAttributes(attribute.Int("docker.items", len(items)))
+	tracing.End(span, err)
+	return items, err
+}
+
+// startSanitize starts the span of sanitizing resource.
+func startSanitize(ctx context.Context, resource string) trace.Span {
+	_, span := tracer.Start(ctx, "sanitize "+resource, trace.WithAttributes(attribute.String("docker.resource", resource)))
+	return span
+}
+
 func getNetworksInfo(ctx context.Context, src swarmSource, cfg *config.Config) ([]network.Summary, error) {
-	networks, err := src.Networks(ctx)
+	networks, err := listPolled(ctx, "networks", src.Networks)
 	if err != nil {
-		log.Printf("Error fetching networks: %v", err)
 		return nil, err
 	}
 
+	defer startSanitize(ctx, "networks").End()
+	return sanitizeNetworks(networks, cfg), nil
+}
+
+// sanitizeNetworks leaves out the ingress network and hidden networks, and
+// removes network labels if configured to.
+func sanitizeNetworks(networks []network.Summary, cfg *config.Config) []network.Summary {
 	filteredNetworks := make([]network.Summary, 0, len(networks))
 	for _, net := range networks {
+		if networkHidden(&net, cfg) {
+			continue
+		}
 		if slices.Contains(cfg.HideLabels, "all") || slices.Contains(cfg.HideLabels, "network") {
 			net.Labels = nil
 		}
@@ -223,36 +424,61 @@ func getNetworksInfo(ctx context.Context, src swarmSource, cfg *config.Config) (
 			filteredNetworks = append(filteredNetworks, net)
 		}
 	}
-
-	return filteredNetworks, nil
+	return filteredNetworks
 }
 
-func getNodesInfo(ctx context.Context, src swarmSource, cfg *config.Config) ([]swarm.Node, error) {
-	nodes, err := src.Nodes(ctx)
+// getNodesInfo fetches and sanitizes the nodes. It also returns the IDs of
+// the hidden nodes left out, whose tasks getTasksInfo leaves out too.
+func getNodesInfo(ctx context.Context, src swarmSource, cfg *config.Config) ([]swarm.Node, map[string]bool, error) {
+	nodes, err := listPolled(ctx, "nodes", src.Nodes)
 	if err != nil {
-		log.Printf("Error fetching nodes: %v", err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	return sanitizeNodes(nodes, cfg), nil
+	defer startSanitize(ctx, "nodes").End()
+	nodes, hidden := sanitizeNodes(nodes, cfg)
+	return nodes, hidden, nil
 }
 
-func getServicesInfo(ctx context.Context, src swarmSource, cfg *config.Config) ([]swarm.Service, error) {
-	services, err := src.Services(ctx)
+// getServicesInfo fetches and sanitizes the services. It also returns the
+// rules their sanitization labels set, by service ID, for getTasksInfo.
+func getServicesInfo(ctx context.Context, src swarmSource, cfg *config.Config) ([]swarm.Service, map[string]serviceRules, error) {
+	services, err := listPolled(ctx, "services", src.Services)
 	if err != nil {
-		log.Printf("Error fetching services: %v", err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	return sanitizeServices(services, cfg), nil
+	defer startSanitize(ctx, "services").End()
+	services, rules := sanitizeServices(services, cfg)
+	return services, rules, nil
+}
+
+// processHashSalt keys the hash sanitization action when no salt is
+// configured, so hashes are stable for the life of the process.
+var processHashSalt = sync.OnceValue(func() []byte {
+	salt := make([]byte, 32)
+	_, _ = rand.Read(salt)
+	return salt
+})
+
+// hashSalt returns the salt for the hash sanitization action.
+func hashSalt(cfg *config.Config) []byte {
+	if cfg.SanitizeHashSalt != "" {
+		return []byte(cfg.SanitizeHashSalt)
+	}
+	return processHashSalt()
 }
 
 const failedTaskGracePeriod = 30 * time.Second
 
-func getTasksInfo(ctx context.Context, src swarmSource, cfg *config.Config) ([]swarm.Task, error) {
-	tasks, err := src.Tasks(ctx)
+// getTasksInfo fetches the running tasks and recently stopped ones, kept in
+// stopped, and sanitizes them, applying the sanitization rules of their
+// services and leaving out those of hidden services and nodes, as given by
+// owners.
+func getTasksInfo(ctx context.Context, src swarmSource, cfg *config.Config, owners taskOwners, stopped stoppedTasks) ([]swarm.Task, error) {
+	rules := owners.services
+	tasks, err := listPolled(ctx, "tasks", src.Tasks)
 	if err != nil {
-		log.Printf("Error fetching tasks: %v", err)
 		return nil, err
 	}
 
@@ -263,11 +489,15 @@ func getTasksInfo(ctx context.Context, src swarmSource, cfg *config.Config) ([]s
 	resultIDs := make(map[string]struct{})
 	for _, t := range tasks {
 		if t.Status.State == swarm.TaskStateFailed || t.Status.State == swarm.TaskStateComplete {
-			if entry, exists := stoppedTaskCache[t.ID]; exists {
-				stoppedTaskCache[t.ID] = cachedTask{task: t, firstSeen: entry.firstSeen}
+			if entry, exists := stopped[t.ID]; exists {
+				entry.task = t
+				if r, ok := rules[t.ServiceID]; ok {
+					entry.rules = r
+				}
+				stopped[t.ID] = entry
 			} else if now.Sub(t.UpdatedAt) < failedTaskGracePeriod {
 				// Only cache tasks that stopped recently; skip historical tasks.
-				stoppedTaskCache[t.ID] = cachedTask{task: t, firstSeen: now}
+				stopped[t.ID] = cachedTask{task: t, firstSeen: now, rules: rules[t.ServiceID]}
 			}
 		}
 		if t.DesiredState == swarm.TaskStateRunning || t.DesiredState == swarm.TaskStateAccepted {
@@ -277,9 +507,9 @@ func getTasksInfo(ctx context.Context, src swarmSource, cfg *config.Config) ([]s
 	}
 
 	// Evict expired cache entries.
-	for id, entry := range stoppedTaskCache {
+	for id, entry := range stopped {
 		if now.Sub(entry.firstSeen) >= failedTaskGracePeriod {
-			delete(stoppedTaskCache, id)
+			delete(stopped, id)
 		}
 	}
 
@@ -288,7 +518,11 @@ func getTasksInfo(ctx context.Context, src swarmSource, cfg *config.Config) ([]s
 	// services (slot == 0) it is "serviceID:nodeID". Only the newest entry
 	// per slot is kept so we don't flood the view with historical tasks.
 	newestStopped := make(map[string]swarm.Task)
-	for _, entry := range stoppedTaskCache {
+	taskRules := maps.Clone(rules)
+	if taskRules == nil {
+		taskRules = make(map[string]serviceRules)
+	}
+	for _, entry := range stopped {
 		t := entry.task
 		if _, inResult := resultIDs[t.ID]; inResult {
 			continue
@@ -302,134 +536,153 @@ func getTasksInfo(ctx context.Context, src swarmSource, cfg *config.Config) ([]s
 		if existing, ok := newestStopped[key]; !ok || t.CreatedAt.After(existing.CreatedAt) {
 			newestStopped[key] = t
 		}
+		if _, known := taskRules[t.ServiceID]; !known {
+			// The service is gone; keep applying its last known rules.
+			taskRules[t.ServiceID] = entry.rules
+		}
 	}
-	for _, t := range newestStopped {
-		result = append(result, t)
+	// Map iteration order is random; keep the stopped tasks in a stable
+	// order so an unchanged set is not published as a change.
+	for _, key := range slices.Sorted(maps.Keys(newestStopped)) {
+		result = append(result, newestStopped[key])
 	}
 
 	// Sanitize tasks
-	result = sanitizeTasks(result, cfg)
+	owners.services = taskRules
+	span := startSanitize(ctx, "tasks")
+	result = sanitizeTasks(result, cfg, owners)
+	span.End()
 
 	return result, nil
 }
 
-// sanitizeNodes removes or redacts fields on nodes according to the configuration.
-func sanitizeNodes(nodes []swarm.Node, cfg *config.Config) []swarm.Node {
+// sanitizeNodes removes or redacts fields on nodes according to the
+// configuration, and leaves out hidden nodes, whose IDs it returns.
+func sanitizeNodes(nodes []swarm.Node, cfg *config.Config) ([]swarm.Node, map[string]bool) {
+	hidden := make(map[string]bool)
+	nodes = slices.DeleteFunc(nodes, func(n swarm.Node) bool {
+		if nodeHidden(&n, cfg) {
+			hidden[n.ID] = true
+			return true
+		}
+		return false
+	})
 	for i := range nodes {
 		if slices.Contains(cfg.HideLabels, "all") || slices.Contains(cfg.HideLabels, "node") {
 			nodes[i].Spec.Labels = nil
 		}
 	}
-	return nodes
+	return nodes, hidden
 }
 
-// sanitizeServices removes or redacts fields on services according to the configuration.
-func sanitizeServices(services []swarm.Service, cfg *config.Config) []swarm.Service {
+// sanitizeServices removes or redacts fields on services according to the
+// configuration and each service's own sanitization labels, and leaves out
+// hidden services. It returns each service's rules by ID, read before
+// sanitization can remove the labels, for sanitizeTasks to apply to the
+// services' tasks.
+func sanitizeServices(services []swarm.Service, cfg *config.Config) ([]swarm.Service, map[string]serviceRules) {
+	return sanitizeServicesReporting(services, cfg, secretsFound)
+}
+
+// sanitizeServicesReporting is sanitizeServices, recording likely secrets in
+// report.
+func sanitizeServicesReporting(services []swarm.Service, cfg *config.Config, report *secretReport) ([]swarm.Service, map[string]serviceRules) {
+	rules := serviceRulesByID(services, cfg)
+	services = slices.DeleteFunc(services, func(svc swarm.Service) bool {
+		return rules[svc.ID].hidden
+	})
+	findings := make(map[string][]string)
+	names := make(map[string]string, len(services))
 	for i := range services {
 		svc := &services[i]
+		names[svc.ID] = svc.Spec.Name
 
-		if cfg.HideAllConfigs {
-			svc.Spec.TaskTemplate.ContainerSpec.Configs = nil
-		}
-		if cfg.HideAllEnvs {
-			svc.Spec.TaskTemplate.ContainerSpec.Env = nil
-		}
-		if cfg.HideAllMounts {
-			svc.Spec.TaskTemplate.ContainerSpec.Mounts = nil
-		}
-		if cfg.HideAllSecrets {
-			svc.Spec.TaskTemplate.ContainerSpec.Secrets = nil
-		}
+		hideContainerFields(svc.Spec.TaskTemplate.ContainerSpec, cfg)
 		if slices.Contains(cfg.HideLabels, "all") || slices.Contains(cfg.HideLabels, "service") {
 			svc.Spec.Labels = nil
 		}
-		if slices.Contains(cfg.HideLabels, "all") || slices.Contains(cfg.HideLabels, "container") {
-			svc.Spec.TaskTemplate.ContainerSpec.Labels = nil
-		}
 
-		// Service-level hide-envs label
-		if svc.Spec.Labels != nil {
-			if hideEnvsRaw, ok := svc.Spec.Labels["io.github.jtgasper3.visualizer.hide-envs"]; ok {
-				hideEnvs := strings.Split(hideEnvsRaw, ",")
-				hideSet := make(map[string]struct{}, len(hideEnvs))
-				for j := range hideEnvs {
-					hideEnvs[j] = strings.TrimSpace(hideEnvs[j])
-					if hideEnvs[j] != "" {
-						hideSet[hideEnvs[j]] = struct{}{}
-					}
-				}
-				envs := svc.Spec.TaskTemplate.ContainerSpec.Env
-				for k, env := range envs {
-					if eq := strings.IndexByte(env, '='); eq > 0 {
-						key := env[:eq]
-						if _, found := hideSet[key]; found {
-							envs[k] = key + "=(sanitized)"
-						}
-					} else {
-						if _, found := hideSet[env]; found {
-							envs[k] = env + "=(sanitized)"
-						}
-					}
-				}
-				svc.Spec.TaskTemplate.ContainerSpec.Env = envs
-			}
+		r := rules[svc.ID]
+		redactLabels(svc.Spec.Labels, r.hideLabels)
+		r.applyContainer(svc.Spec.TaskTemplate.ContainerSpec)
 
-			// Service-level hide-labels
-			if hideLabels, ok := svc.Spec.Labels["io.github.jtgasper3.visualizer.hide-labels"]; ok {
-				labelsToHide := strings.Split(hideLabels, ",")
-				for _, label := range labelsToHide {
-					label = strings.TrimSpace(label)
-					if label == "" {
-						continue
-					}
-					if svc.Spec.Labels == nil {
-						continue
-					}
-					if _, exists := svc.Spec.Labels[label]; exists {
-						svc.Spec.Labels[label] = "(sanitized)"
-					}
-				}
+		// Likely secrets left in plain sight
+		if d := cfg.SecretDetector; d != nil {
+			found := redactLabelSecrets(svc.Spec.Labels, "label", d)
+			if cs := svc.Spec.TaskTemplate.ContainerSpec; cs != nil {
+				found = append(found, redactEnvSecrets(cs.Env, d)...)
+				found = append(found, redactLabelSecrets(cs.Labels, "container label", d)...)
 			}
-		}
-
-		// Container-level hide-labels
-		if svc.Spec.TaskTemplate.ContainerSpec.Labels != nil {
-			if hideLabels, ok := svc.Spec.TaskTemplate.ContainerSpec.Labels["io.github.jtgasper3.visualizer.hide-labels"]; ok {
-				labelsToHide := strings.Split(hideLabels, ",")
-				for _, label := range labelsToHide {
-					label = strings.TrimSpace(label)
-					if label == "" {
-						continue
-					}
-					if _, exists := svc.Spec.TaskTemplate.ContainerSpec.Labels[label]; exists {
-						svc.Spec.TaskTemplate.ContainerSpec.Labels[label] = "(sanitized)"
-					}
-				}
+			if len(found) > 0 {
+				findings[svc.ID] = found
 			}
 		}
 	}
-	return services
+
+	if cfg.SecretDetector != nil {
+		report.set("services", findings, names)
+	} else {
+		report.clear()
+	}
+	return services, rules
+}
+
+// hideContainerFields applies the HIDE_ALL_* settings and the "container"
+// category of HIDE_LABELS to a service's or task's container spec.
+func hideContainerFields(cs *swarm.ContainerSpec, cfg *config.Config) {
+	if cs == nil {
+		return
+	}
+	if cfg.HideAllConfigs {
+		cs.Configs = nil
+	}
+	if cfg.HideAllEnvs {
+		cs.Env = nil
+	}
+	if cfg.HideAllMounts {
+		cs.Mounts = nil
+	}
+	if cfg.HideAllSecrets {
+		cs.Secrets = nil
+	}
+	if slices.Contains(cfg.HideLabels, "all") || slices.Contains(cfg.HideLabels, "container") {
+		cs.Labels = nil
+	}
 }
 
-// sanitizeTasks removes or redacts fields on tasks according to the configuration.
-func sanitizeTasks(tasks []swarm.Task, cfg *config.Config) []swarm.Task {
+// sanitizeTasks removes or redacts fields on tasks according to the
+// configuration and the sanitization rules of each task's service, and leaves
+// out the tasks of hidden services and nodes.
+func sanitizeTasks(tasks []swarm.Task, cfg *config.Config, owners taskOwners) []swarm.Task {
+	return sanitizeTasksReporting(tasks, cfg, owners, secretsFound)
+}
+
+// sanitizeTasksReporting is sanitizeTasks, recording likely secrets in report.
+func sanitizeTasksReporting(tasks []swarm.Task, cfg *config.Config, owners taskOwners, report *secretReport) []swarm.Task {
+	tasks = slices.DeleteFunc(tasks, func(t swarm.Task) bool {
+		return owners.hidden(&t)
+	})
+	findings := make(map[string][]string)
 	for i := range tasks {
 		t := &tasks[i]
-		if cfg.HideAllConfigs {
-			t.Spec.ContainerSpec.Configs = nil
-		}
-		if cfg.HideAllEnvs {
-			t.Spec.ContainerSpec.Env = nil
-		}
-		if cfg.HideAllMounts {
-			t.Spec.ContainerSpec.Mounts = nil
-		}
-		if cfg.HideAllSecrets {
-			t.Spec.ContainerSpec.Secrets = nil
-		}
-		if slices.Contains(cfg.HideLabels, "all") || slices.Contains(cfg.HideLabels, "container") {
-			t.Spec.ContainerSpec.Labels = nil
+		hideContainerFields(t.Spec.ContainerSpec, cfg)
+
+		r := owners.services[t.ServiceID]
+		redactLabels(t.Labels, r.hideLabels)
+		r.applyContainer(t.Spec.ContainerSpec)
+
+		// Likely secrets left in plain sight
+		if d := cfg.SecretDetector; d != nil && t.Spec.ContainerSpec != nil {
+			found := redactEnvSecrets(t.Spec.ContainerSpec.Env, d)
+			found = append(found, redactLabelSecrets(t.Spec.ContainerSpec.Labels, "container label", d)...)
+			if len(found) > 0 {
+				findings[t.ServiceID] = append(findings[t.ServiceID], found...)
+			}
 		}
 	}
+
+	if cfg.SecretDetector != nil {
+		report.set("tasks", findings, nil)
+	}
 	return tasks
 }
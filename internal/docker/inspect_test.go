@@ -1,10 +1,15 @@
 package docker
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/jtgasper3/swarm-visualizer/internal/config"
 	"github.com/moby/moby/api/types/mount"
+	"github.com/moby/moby/api/types/network"
 	"github.com/moby/moby/api/types/swarm"
 )
 
@@ -21,10 +26,44 @@ func TestReady(t *testing.T) {
 	}
 }
 
+// TestInspect_RecordsPolls verifies that LastPoll reports successful polls
+// and not failed ones.
+func TestInspect_RecordsPolls(t *testing.T) {
+	tests := []struct {
+		name string
+		src  fakeSource
+		want bool
+	}{
+		{"success", fakeSource{}, true},
+		{"failure", fakeSource{err: errors.New("daemon down")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			h := newHub(cfg, nil)
+			runHub(t, h)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				inspectSwarmServices(ctx, config.NewHolder(cfg), tt.src, h, nil)
+				close(done)
+			}()
+
+			polled := func() bool { return !h.LastPoll("tasks").IsZero() && !h.LastPoll("structural").IsZero() }
+			got := waitFor(t, polled, 500*time.Millisecond)
+			cancel()
+			<-done
+			if got != tt.want {
+				t.Errorf("polls recorded = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestSanitizeNodes_HideLabels(t *testing.T) {
 	nodes := []swarm.Node{{Spec: swarm.NodeSpec{Annotations: swarm.Annotations{Labels: map[string]string{"secret": "v", "keep": "v2"}}}}}
 	cfg := &config.Config{HideLabels: []string{"node"}}
-	out := sanitizeNodes(nodes, cfg)
+	out, _ := sanitizeNodes(nodes, cfg)
 	if out[0].Spec.Labels != nil {
 		t.Fatalf("expected labels to be nil, got: %#v", out[0].Spec.Labels)
 	}
@@ -45,7 +84,7 @@ func TestSanitizeServices_HideAllFlags(t *testing.T) {
 		},
 	}
 	cfg := &config.Config{HideAllEnvs: true, HideAllConfigs: true, HideAllMounts: true, HideAllSecrets: true}
-	out := sanitizeServices([]swarm.Service{svc}, cfg)
+	out, _ := sanitizeServices([]swarm.Service{svc}, cfg)
 	cs := out[0].Spec.TaskTemplate.ContainerSpec
 	if cs == nil {
 		t.Fatalf("expected container spec to exist")
@@ -75,7 +114,7 @@ func TestSanitizeServices_HideEnvsLabel(t *testing.T) {
 			Annotations: swarm.Annotations{Labels: map[string]string{"io.github.jtgasper3.visualizer.hide-envs": "TEST"}},
 		},
 	}
-	out := sanitizeServices([]swarm.Service{svc}, &config.Config{})
+	out, _ := sanitizeServices([]swarm.Service{svc}, &config.Config{})
 	envs := out[0].Spec.TaskTemplate.ContainerSpec.Env
 	if len(envs) != 2 {
 		t.Fatalf("expected 2 envs, got %d", len(envs))
@@ -88,6 +127,26 @@ func TestSanitizeServices_HideEnvsLabel(t *testing.T) {
 	}
 }
 
+// TestSanitizeServices_HideEnvsLabelBareKey verifies a variable passed
+// through by name, which holds no value, keeps its name when hidden.
+func TestSanitizeServices_HideEnvsLabelBareKey(t *testing.T) {
+	svc := swarm.Service{
+		Spec: swarm.ServiceSpec{
+			TaskTemplate: swarm.TaskSpec{
+				ContainerSpec: &swarm.ContainerSpec{
+					Env: []string{"TEST", "OTHER"},
+				},
+			},
+			Annotations: swarm.Annotations{Labels: map[string]string{"io.github.jtgasper3.visualizer.hide-envs": "TEST"}},
+		},
+	}
+	out, _ := sanitizeServices([]swarm.Service{svc}, &config.Config{})
+	envs := out[0].Spec.TaskTemplate.ContainerSpec.Env
+	if len(envs) != 2 || envs[0] != "TEST=(sanitized)" || envs[1] != "OTHER" {
+		t.Fatalf("Env = %v, want [TEST=(sanitized) OTHER]", envs)
+	}
+}
+
 func TestSanitizeServices_HideLabels(t *testing.T) {
 	svc := swarm.Service{
 		Spec: swarm.ServiceSpec{
@@ -99,7 +158,7 @@ func TestSanitizeServices_HideLabels(t *testing.T) {
 			Annotations: swarm.Annotations{Labels: map[string]string{"secret": "v", "io.github.jtgasper3.visualizer.hide-labels": "secret"}},
 		},
 	}
-	out := sanitizeServices([]swarm.Service{svc}, &config.Config{})
+	out, _ := sanitizeServices([]swarm.Service{svc}, &config.Config{})
 	if val, ok := out[0].Spec.Labels["secret"]; !ok || val != "(sanitized)" {
 		t.Fatalf("expected service label 'secret' to be sanitized, got %q, ok=%v", val, ok)
 	}
@@ -113,7 +172,7 @@ func TestSanitizeServices_HideLabels(t *testing.T) {
 			},
 		},
 	}
-	out2 := sanitizeServices([]swarm.Service{svc2}, &config.Config{})
+	out2, _ := sanitizeServices([]swarm.Service{svc2}, &config.Config{})
 	if val, ok := out2[0].Spec.TaskTemplate.ContainerSpec.Labels["secret"]; !ok || val != "(sanitized)" {
 		t.Fatalf("expected container label 'secret' to be sanitized, got %q, ok=%v", val, ok)
 	}
@@ -134,7 +193,7 @@ func TestSanitizeServices_HideLabelsCategory(t *testing.T) {
 	}
 
 	// "service" hides service-level labels but leaves container labels.
-	out := sanitizeServices([]swarm.Service{newSvc()}, &config.Config{HideLabels: []string{"service"}})
+	out, _ := sanitizeServices([]swarm.Service{newSvc()}, &config.Config{HideLabels: []string{"service"}})
 	if out[0].Spec.Labels != nil {
 		t.Fatalf("expected service labels to be nil, got: %#v", out[0].Spec.Labels)
 	}
@@ -143,7 +202,7 @@ func TestSanitizeServices_HideLabelsCategory(t *testing.T) {
 	}
 
 	// "container" hides container labels but leaves service-level labels.
-	out = sanitizeServices([]swarm.Service{newSvc()}, &config.Config{HideLabels: []string{"container"}})
+	out, _ = sanitizeServices([]swarm.Service{newSvc()}, &config.Config{HideLabels: []string{"container"}})
 	if out[0].Spec.TaskTemplate.ContainerSpec.Labels != nil {
 		t.Fatalf("expected container labels to be nil, got: %#v", out[0].Spec.TaskTemplate.ContainerSpec.Labels)
 	}
@@ -152,34 +211,230 @@ func TestSanitizeServices_HideLabelsCategory(t *testing.T) {
 	}
 
 	// "all" hides both.
-	out = sanitizeServices([]swarm.Service{newSvc()}, &config.Config{HideLabels: []string{"all"}})
+	out, _ = sanitizeServices([]swarm.Service{newSvc()}, &config.Config{HideLabels: []string{"all"}})
 	if out[0].Spec.Labels != nil || out[0].Spec.TaskTemplate.ContainerSpec.Labels != nil {
 		t.Fatalf("expected all labels to be nil under 'all'")
 	}
 }
 
+// knownServices returns the owners of tasks of the services ids, with no
+// sanitization rules.
+func knownServices(ids ...string) taskOwners {
+	owners := taskOwners{services: make(map[string]serviceRules)}
+	for _, id := range ids {
+		owners.services[id] = serviceRules{}
+	}
+	return owners
+}
+
 func TestSanitizeTasks_HideLabelsCategory(t *testing.T) {
 	newTask := func() swarm.Task {
-		return swarm.Task{Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Labels: map[string]string{"c": "v"}}}}
+		return swarm.Task{ServiceID: "svc", Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Labels: map[string]string{"c": "v"}}}}
 	}
 
 	// "container" hides task container labels.
-	out := sanitizeTasks([]swarm.Task{newTask()}, &config.Config{HideLabels: []string{"container"}})
+	out := sanitizeTasks([]swarm.Task{newTask()}, &config.Config{HideLabels: []string{"container"}}, knownServices("svc"))
 	if out[0].Spec.ContainerSpec.Labels != nil {
 		t.Fatalf("expected task container labels to be nil, got: %#v", out[0].Spec.ContainerSpec.Labels)
 	}
 
 	// "task" is no longer a recognized category and must be a no-op.
-	out = sanitizeTasks([]swarm.Task{newTask()}, &config.Config{HideLabels: []string{"task"}})
+	out = sanitizeTasks([]swarm.Task{newTask()}, &config.Config{HideLabels: []string{"task"}}, knownServices("svc"))
 	if out[0].Spec.ContainerSpec.Labels == nil {
 		t.Fatalf("expected task container labels to be retained for unrecognized 'task' category")
 	}
 }
 
 func TestSanitizeTasks_HideAllEnvs(t *testing.T) {
-	tsk := swarm.Task{Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Env: []string{"A=1"}, Labels: map[string]string{"l": "v"}}}}
-	out := sanitizeTasks([]swarm.Task{tsk}, &config.Config{HideAllEnvs: true})
+	tsk := swarm.Task{ServiceID: "svc", Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Env: []string{"A=1"}, Labels: map[string]string{"l": "v"}}}}
+	out := sanitizeTasks([]swarm.Task{tsk}, &config.Config{HideAllEnvs: true}, knownServices("svc"))
 	if out[0].Spec.ContainerSpec.Env != nil {
 		t.Fatalf("expected task envs to be nil, got: %#v", out[0].Spec.ContainerSpec.Env)
 	}
 }
+
+func serviceWithRuleLabels() swarm.Service {
+	return swarm.Service{
+		ID: "svc",
+		Spec: swarm.ServiceSpec{
+			Annotations: swarm.Annotations{Labels: map[string]string{
+				"io.github.jtgasper3.visualizer.hide-envs":    "TEST",
+				"io.github.jtgasper3.visualizer.hide-mounts":  "true",
+				"io.github.jtgasper3.visualizer.hide-configs": "false",
+				"io.github.jtgasper3.visualizer.hide-secrets": "yes please",
+			}},
+			TaskTemplate: swarm.TaskSpec{ContainerSpec: newRuleContainerSpec()},
+		},
+	}
+}
+
+func newRuleContainerSpec() *swarm.ContainerSpec {
+	return &swarm.ContainerSpec{
+		Env:     []string{"TEST=1", "OTHER=2"},
+		Labels:  map[string]string{"secret": "v", "io.github.jtgasper3.visualizer.hide-labels": "secret"},
+		Mounts:  []mount.Mount{{Source: "s"}},
+		Configs: []*swarm.ConfigReference{{}},
+		Secrets: []*swarm.SecretReference{{}},
+	}
+}
+
+func TestSanitizeServices_HideMountsConfigsSecretsLabels(t *testing.T) {
+	out, _ := sanitizeServices([]swarm.Service{serviceWithRuleLabels()}, &config.Config{})
+	cs := out[0].Spec.TaskTemplate.ContainerSpec
+	if cs.Mounts != nil {
+		t.Errorf("Mounts = %#v, want nil under hide-mounts=true", cs.Mounts)
+	}
+	if cs.Configs == nil {
+		t.Error("Configs removed under hide-configs=false")
+	}
+	if cs.Secrets != nil {
+		t.Errorf("Secrets = %#v, want nil for an unparsable hide-secrets value", cs.Secrets)
+	}
+}
+
+func TestSanitizeServices_HideEnvsLabelWithLabelsHidden(t *testing.T) {
+	out, _ := sanitizeServices([]swarm.Service{serviceWithRuleLabels()}, &config.Config{HideLabels: []string{"all"}})
+	if env := out[0].Spec.TaskTemplate.ContainerSpec.Env[0]; env != "TEST=(sanitized)" {
+		t.Fatalf("Env[0] = %q, want hide-envs honored though HIDE_LABELS removed the label", env)
+	}
+}
+
+func TestSanitizeTasks_ServiceLabels(t *testing.T) {
+	_, rules := sanitizeServices([]swarm.Service{serviceWithRuleLabels()}, &config.Config{})
+	rules["other"] = serviceRules{}
+	tasks := []swarm.Task{
+		{ServiceID: "svc", Spec: swarm.TaskSpec{ContainerSpec: newRuleContainerSpec()}},
+		{ServiceID: "other", Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Env: []string{"TEST=1"}}}},
+	}
+
+	out := sanitizeTasks(tasks, &config.Config{}, taskOwners{services: rules})
+	cs := out[0].Spec.ContainerSpec
+	if cs.Env[0] != "TEST=(sanitized)" || cs.Env[1] != "OTHER=2" {
+		t.Errorf("Env = %v, want only TEST sanitized", cs.Env)
+	}
+	if cs.Labels["secret"] != "(sanitized)" {
+		t.Errorf("Labels[secret] = %q, want sanitized", cs.Labels["secret"])
+	}
+	if cs.Mounts != nil || cs.Secrets != nil || cs.Configs == nil {
+		t.Errorf("Mounts/Secrets/Configs = %v/%v/%v, want the service's rules applied", cs.Mounts, cs.Secrets, cs.Configs)
+	}
+	if env := out[1].Spec.ContainerSpec.Env[0]; env != "TEST=1" {
+		t.Errorf("other service's task Env[0] = %q, want unchanged", env)
+	}
+}
+
+func TestMinimalPresetPathsAreValid(t *testing.T) {
+	t.Setenv("ALLOWED_DATA_PATHS", "minimal")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.CompiledAllowedDataPaths) == 0 {
+		t.Fatal("the minimal preset expanded to no paths")
+	}
+	for _, p := range cfg.CompiledAllowedDataPaths {
+		if err := ValidateDataPath(p.String()); err != nil {
+			t.Errorf("minimal preset path %q: %v", p, err)
+		}
+	}
+}
+
+func TestProjectSwarmData_Minimal(t *testing.T) {
+	t.Setenv("ALLOWED_DATA_PATHS", "minimal")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replicas := uint64(2)
+	data := SwarmData{
+		ClusterName: "c",
+		AuthEnabled: true,
+		Services: []swarm.Service{{
+			ID: "svc",
+			Spec: swarm.ServiceSpec{
+				Annotations: swarm.Annotations{Name: "web", Labels: map[string]string{"com.docker.stack.namespace": "app"}},
+				Mode:        swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+				TaskTemplate: swarm.TaskSpec{
+					ContainerSpec: &swarm.ContainerSpec{Image: "nginx", Env: []string{"A=1"}, Hostname: "unrendered"},
+					Resources:     &swarm.ResourceRequirements{Limits: &swarm.Limit{NanoCPUs: 1e9, Pids: 100}},
+				},
+			},
+		}},
+		Tasks: []swarm.Task{{ID: "t1", ServiceID: "svc", NodeID: "n1", Annotations: swarm.Annotations{Name: "unrendered"}}},
+	}
+
+	out := projectSwarmData(&data, cfg.CompiledAllowedDataPaths)
+
+	if out.ClusterName != "c" || !out.AuthEnabled {
+		t.Errorf("ClusterName/AuthEnabled = %q/%v, want them kept", out.ClusterName, out.AuthEnabled)
+	}
+	svc := out.Services[0]
+	if svc.ID != "svc" || svc.Spec.Name != "web" || svc.Spec.Labels["com.docker.stack.namespace"] != "app" {
+		t.Errorf("rendered service fields dropped: %+v", svc)
+	}
+	if *svc.Spec.Mode.Replicated.Replicas != 2 || svc.Spec.TaskTemplate.ContainerSpec.Image != "nginx" || svc.Spec.TaskTemplate.ContainerSpec.Env[0] != "A=1" {
+		t.Errorf("rendered service spec fields dropped: %+v", svc.Spec)
+	}
+	if svc.Spec.TaskTemplate.Resources.Limits.NanoCPUs != 1e9 {
+		t.Errorf("Limits.NanoCPUs = %d, want kept", svc.Spec.TaskTemplate.Resources.Limits.NanoCPUs)
+	}
+	if svc.Spec.TaskTemplate.ContainerSpec.Hostname != "" || svc.Spec.TaskTemplate.Resources.Limits.Pids != 0 {
+		t.Errorf("unrendered fields published: %+v", svc.Spec.TaskTemplate)
+	}
+	if tsk := out.Tasks[0]; tsk.ID != "t1" || tsk.ServiceID != "svc" || tsk.NodeID != "n1" || tsk.Name != "" {
+		t.Errorf("task = %+v, want rendered fields only", tsk)
+	}
+	if data.Services[0].Spec.TaskTemplate.ContainerSpec.Hostname != "unrendered" {
+		t.Error("projection modified the source data")
+	}
+}
+
+// networksDown is a source whose networks cannot be listed.
+type networksDown struct{ fakeSource }
+
+func (networksDown) Networks(context.Context) ([]network.Summary, error) {
+	return nil, errors.New("daemon down")
+}
+
+// TestInspect_PublishesPartialFailure verifies that a group that fails to
+// load is published stale, with its error, rather than holding back the
+// others.
+func TestInspect_PublishesPartialFailure(t *testing.T) {
+	cfg := &config.Config{}
+	h := newHub(cfg, nil)
+	runHub(t, h)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		inspectSwarmServices(ctx, config.NewHolder(cfg), networksDown{}, h, nil)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	if !waitFor(t, h.Ready, time.Second) {
+		t.Fatal("nothing was published while the networks were failing")
+	}
+	h.mu.Lock()
+	frame := h.lastFanned
+	h.mu.Unlock()
+	var data SwarmData
+	if err := json.Unmarshal(frame, &data); err != nil {
+		t.Fatal(err)
+	}
+	if nw := data.Freshness["networks"]; !nw.Stale || nw.LastError != "daemon down" || !nw.FetchedAt.IsZero() {
+		t.Errorf("networks freshness = %+v, want stale with the error and never fetched", nw)
+	}
+	if nodes := data.Freshness["nodes"]; nodes.Stale || nodes.FetchedAt.IsZero() {
+		t.Errorf("nodes freshness = %+v, want fetched and not stale", nodes)
+	}
+	if data.Networks == nil || data.Sequence != 1 {
+		t.Errorf("networks = %v, sequence = %d; want an empty list in frame 1", data.Networks, data.Sequence)
+	}
+	if !h.LastPoll("structural").IsZero() {
+		t.Error("structural poll recorded as successful while the networks were failing")
+	}
+}
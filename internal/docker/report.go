@@ -0,0 +1,344 @@
+package docker
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jtgasper3/swarm-visualizer/internal"
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+	"github.com/moby/moby/api/types/swarm"
+)
+
+// maxReportExamples caps the example paths given per rule in a sanitization
+// report.
+const maxReportExamples = 10
+
+// sanitizationReport is the result of a sanitization dry run: what each rule
+// of the pipeline redacted from the live swarm, in the order they apply.
+type sanitizationReport struct {
+	GeneratedAt time.Time    `json:"generatedAt"`
+	Rules       []ruleReport `json:"rules"`
+}
+
+// ruleReport describes what one sanitization rule did.
+type ruleReport struct {
+	// Rule names the setting or label family, such as "hideAllEnvs" or
+	// "sensitiveDataPaths".
+	Rule string `json:"rule"`
+	// Setting is the rule's value, such as a single sensitive data path.
+	Setting string `json:"setting,omitempty"`
+	// Matches counts the values the rule removed or redacted that earlier
+	// rules had left in place. A removed object or list counts once.
+	Matches int `json:"matches"`
+	// Examples gives the paths of up to maxReportExamples of those values,
+	// indexed as in the unsanitized data.
+	Examples []string `json:"examples,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// handleSanitizationReport serves a dry run of the current sanitization
+// pipeline against the live swarm, for tuning the sanitization settings.
+func handleSanitizationReport(cfgs *config.Holder, src swarmSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg := cfgs.Load()
+		data, err := fetchSwarmData(r.Context(), src, cfg)
+		if err != nil {
+			http.Error(w, "Error fetching swarm data", http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(dryRunSanitization(data, cfg)); err != nil {
+			slog.Error("Writing the sanitization report failed", logging.Err(err))
+		}
+	}
+}
+
+// fetchSwarmData fetches the unsanitized swarm state that would be
+// published: the running tasks and those stopped within the grace period.
+// Unlike getTasksInfo it leaves the stopped-task cache alone.
+func fetchSwarmData(ctx context.Context, src swarmSource, cfg *config.Config) (SwarmData, error) {
+	data := SwarmData{ClusterName: cfg.ClusterName, AuthEnabled: cfg.AuthEnabled}
+	var err error
+	if data.Nodes, err = list(ctx, "nodes", src.Nodes); err != nil {
+		slog.Error("Docker API request failed", logging.Resource("nodes"), logging.Err(err))
+		return data, err
+	}
+	if data.Services, err = list(ctx, "services", src.Services); err != nil {
+		slog.Error("Docker API request failed", logging.Resource("services"), logging.Err(err))
+		return data, err
+	}
+	if data.Networks, err = list(ctx, "networks", src.Networks); err != nil {
+		slog.Error("Docker API request failed", logging.Resource("networks"), logging.Err(err))
+		return data, err
+	}
+	tasks, err := list(ctx, "tasks", src.Tasks)
+	if err != nil {
+		slog.Error("Docker API request failed", logging.Resource("tasks"), logging.Err(err))
+		return data, err
+	}
+	now := time.Now()
+	for _, t := range tasks {
+		stopped := t.Status.State == swarm.TaskStateFailed || t.Status.State == swarm.TaskStateComplete
+		if t.DesiredState == swarm.TaskStateRunning || t.DesiredState == swarm.TaskStateAccepted ||
+			(stopped && now.Sub(t.UpdatedAt) < failedTaskGracePeriod) {
+			data.Tasks = append(data.Tasks, t)
+		}
+	}
+	return data, nil
+}
+
+// dryRunStage is one step of the sanitization functions, enabled in a
+// configuration on top of the steps before it.
+type dryRunStage struct {
+	rule    string
+	setting string
+	enable  func(stage *config.Config)
+}
+
+// dryRunSanitization runs the sanitization pipeline of cfg over a copy of
+// data one rule at a time, reporting what each rule changed relative to the
+// rules before it. Detection findings go to a throwaway report, so the dry
+// run neither logs nor disturbs the running server's.
+func dryRunSanitization(data SwarmData, cfg *config.Config) sanitizationReport {
+	report := sanitizationReport{GeneratedAt: time.Now().UTC()}
+	prev := jsonTree(data)
+	record := func(rule, setting string, next SwarmData, errs ...error) {
+		tree := jsonTree(next)
+		var d treeDiff
+		d.compare("", prev, tree)
+		rr := ruleReport{Rule: rule, Setting: setting, Matches: d.count, Examples: d.examples}
+		for _, err := range errs {
+			if err != nil {
+				rr.Errors = append(rr.Errors, strings.Split(err.Error(), "\n")...)
+			}
+		}
+		report.Rules = append(report.Rules, rr)
+		prev = tree
+	}
+
+	// The sanitization functions are applied together, so each stage reruns
+	// them over the unsanitized data with one more setting enabled.
+	stage := &config.Config{
+		ClusterName:      cfg.ClusterName,
+		AuthEnabled:      cfg.AuthEnabled,
+		SanitizeHashSalt: cfg.SanitizeHashSalt,
+	}
+	var result SwarmData
+	for _, st := range dryRunStages(cfg) {
+		st.enable(stage)
+		result = sanitizeSwarmData(copySwarmData(data), stage)
+		record(st.rule, st.setting, result)
+	}
+
+	for _, p := range cfg.CompiledSensitiveDataPaths {
+		next := copySwarmData(result)
+		plan, err := internal.NewPlan(reflect.TypeOf(SwarmData{}), []*internal.Path{p})
+		var applyErr error
+		if err == nil {
+			applyErr = plan.Apply(&next, hashSalt(cfg))
+		}
+		record("sensitiveDataPaths", p.String(), next, err, applyErr)
+		result = next
+	}
+
+	if len(cfg.CompiledAllowedDataPaths) > 0 {
+		result = projectSwarmData(&result, cfg.CompiledAllowedDataPaths)
+		record("allowedDataPaths", strings.Join(cfg.AllowedDataPaths, ","), result)
+	}
+	return report
+}
+
+// dryRunStages lists the sanitization function settings cfg enables, in
+// the order sanitization applies them. Service, node, and network labels
+// always apply, so they come first.
+func dryRunStages(cfg *config.Config) []dryRunStage {
+	stages := []dryRunStage{{rule: "serviceLabels", enable: func(*config.Config) {}}}
+	add := func(enabled bool, rule, setting string, enable func(*config.Config)) {
+		if enabled {
+			stages = append(stages, dryRunStage{rule: rule, setting: setting, enable: enable})
+		}
+	}
+	add(len(cfg.HiddenStacks) > 0, "hiddenStacks", strings.Join(cfg.HiddenStacks, ","),
+		func(c *config.Config) { c.CompiledHiddenStacks = cfg.CompiledHiddenStacks })
+	add(len(cfg.HiddenServices) > 0, "hiddenServices", strings.Join(cfg.HiddenServices, ","),
+		func(c *config.Config) { c.CompiledHiddenServices = cfg.CompiledHiddenServices })
+	add(len(cfg.HiddenNodeLabels) > 0, "hiddenNodeLabels", strings.Join(cfg.HiddenNodeLabels, ","),
+		func(c *config.Config) { c.CompiledHiddenNodeLabels = cfg.CompiledHiddenNodeLabels })
+	add(cfg.HideAllConfigs, "hideAllConfigs", "true", func(c *config.Config) { c.HideAllConfigs = true })
+	add(cfg.HideAllEnvs, "hideAllEnvs", "true", func(c *config.Config) { c.HideAllEnvs = true })
+	add(cfg.HideAllMounts, "hideAllMounts", "true", func(c *config.Config) { c.HideAllMounts = true })
+	add(cfg.HideAllSecrets, "hideAllSecrets", "true", func(c *config.Config) { c.HideAllSecrets = true })
+	add(len(cfg.HideLabels) > 0, "hideLabels", strings.Join(cfg.HideLabels, ","),
+		func(c *config.Config) { c.HideLabels = cfg.HideLabels })
+	add(cfg.SecretDetector != nil, "detectSecrets", strings.Join(cfg.SecretKeyPatterns, ","),
+		func(c *config.Config) { c.SecretDetector = cfg.SecretDetector })
+	return stages
+}
+
+// sanitizeSwarmData applies the sanitization functions of cfg to data, as
+// the inspector does before the sensitive data paths.
+func sanitizeSwarmData(data SwarmData, cfg *config.Config) SwarmData {
+	quiet := &secretReport{quiet: true}
+	nodes, hiddenNodes := sanitizeNodes(data.Nodes, cfg)
+	services, rules := sanitizeServicesReporting(data.Services, cfg, quiet)
+	data.Nodes = nodes
+	data.Services = services
+	data.Tasks = sanitizeTasksReporting(data.Tasks, cfg, taskOwners{services: rules, hiddenNodes: hiddenNodes}, quiet)
+	data.Networks = sanitizeNetworks(data.Networks, cfg)
+	return data
+}
+
+// copySwarmData returns a deep copy of data, as published.
+func copySwarmData(data SwarmData) SwarmData {
+	var out SwarmData
+	b, err := json.Marshal(data)
+	if err == nil {
+		err = json.Unmarshal(b, &out)
+	}
+	if err != nil {
+		slog.Error("Copying swarm data failed", logging.Err(err))
+	}
+	return out
+}
+
+// jsonTree returns data as published, decoded into generic JSON values.
+func jsonTree(data SwarmData) any {
+	var tree any
+	b, _ := json.Marshal(data)
+	_ = json.Unmarshal(b, &tree)
+	return tree
+}
+
+// treeDiff counts the values of one JSON tree that are missing or changed in
+// another, keeping the paths of the first few.
+type treeDiff struct {
+	count    int
+	examples []string
+}
+
+func (d *treeDiff) add(path string) {
+	d.count++
+	if len(d.examples) < maxReportExamples {
+		d.examples = append(d.examples, path)
+	}
+}
+
+// compare records the values under path in before that are missing or
+// changed in after. Values added in after, including in place of a null, are
+// ignored, as sanitization only removes or replaces. Elements of lists of objects with an ID are matched by
+// ID, since removing one shifts the rest.
+func (d *treeDiff) compare(path string, before, after any) {
+	switch b := before.(type) {
+	case nil:
+		return
+	case map[string]any:
+		a, ok := after.(map[string]any)
+		if !ok {
+			d.add(path)
+			return
+		}
+		for _, key := range slices.Sorted(maps.Keys(b)) {
+			av, ok := a[key]
+			if !ok {
+				d.add(joinTreePath(path, key))
+				continue
+			}
+			d.compare(joinTreePath(path, key), b[key], av)
+		}
+	case []any:
+		a, ok := after.([]any)
+		if !ok {
+			d.add(path)
+			return
+		}
+		var byID map[string]any
+		if len(b) > 0 && treeID(b[0]) != "" {
+			byID = make(map[string]any, len(a))
+			for _, v := range a {
+				byID[treeID(v)] = v
+			}
+		}
+		for i, bv := range b {
+			p := joinTreePath(path, strconv.Itoa(i))
+			var av any
+			var ok bool
+			if byID != nil {
+				av, ok = byID[treeID(bv)]
+			} else if i < len(a) {
+				av, ok = a[i], true
+			}
+			if !ok {
+				d.add(p)
+				continue
+			}
+			d.compare(p, bv, av)
+		}
+	default:
+		if !reflect.DeepEqual(before, after) {
+			d.add(path)
+		}
+	}
+}
+
+// treeID returns the ID of a JSON object, or "" if it has none. Swarm
+// objects use "ID" and networks "Id".
+func treeID(v any) string {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return ""
+	}
+	if id, ok := obj["ID"].(string); ok {
+		return id
+	}
+	id, _ := obj["Id"].(string)
+	return id
+}
+
+// joinTreePath appends key to path in sanitization path syntax, quoting it
+// if it contains a period or "=".
+func joinTreePath(path, key string) string {
+	if strings.ContainsAny(key, ".=") {
+		key = "'" + key + "'"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// RequireAdmin serves next only to requests bearing the admin token as a
+// bearer token. While no token is configured the admin endpoints do not
+// exist, so it responds 404.
+func RequireAdmin(cfgs *config.Holder, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := cfgs.Load().AdminToken
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
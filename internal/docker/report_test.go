@@ -0,0 +1,218 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jtgasper3/swarm-visualizer/internal"
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/moby/moby/api/types/swarm"
+)
+
+func reportSwarm() SwarmData {
+	web := namedService("web", "app_web", map[string]string{hideEnvsLabel: "DB_PASSWORD"})
+	web.Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{
+		Env:    []string{"DB_PASSWORD=hunter2", "MODE=prod"},
+		Mounts: []mount.Mount{{Source: "/srv"}},
+	}
+	return SwarmData{
+		Services: []swarm.Service{
+			web,
+			namedService("proxy", "app_socket-proxy", nil),
+		},
+	}
+}
+
+func findRule(t *testing.T, report sanitizationReport, rule, setting string) ruleReport {
+	t.Helper()
+	for _, rr := range report.Rules {
+		if rr.Rule == rule && rr.Setting == setting {
+			return rr
+		}
+	}
+	t.Fatalf("no %s %q rule in %+v", rule, setting, report.Rules)
+	return ruleReport{}
+}
+
+func mustCompile(t *testing.T, specs ...string) []*internal.Path {
+	t.Helper()
+	paths := make([]*internal.Path, len(specs))
+	for i, spec := range specs {
+		p, err := internal.CompilePath(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths[i] = p
+	}
+	return paths
+}
+
+func TestDryRunSanitization(t *testing.T) {
+	cfg := &config.Config{
+		HiddenServices:         []string{"*_socket-proxy"},
+		CompiledHiddenServices: internal.CompileGlobs([]string{"*_socket-proxy"}),
+		HideAllMounts:          true,
+		CompiledSensitiveDataPaths: mustCompile(t,
+			"services.*.Spec.Labels",
+			"services.5.Spec.Labels",
+			"services.*.Spec.Nope",
+		),
+	}
+	data := reportSwarm()
+	report := dryRunSanitization(data, cfg)
+
+	labels := findRule(t, report, "serviceLabels", "")
+	if labels.Matches != 1 || !slices.Equal(labels.Examples, []string{"services.0.Spec.TaskTemplate.ContainerSpec.Env.0"}) {
+		t.Errorf("serviceLabels = %+v, want the hide-envs variable", labels)
+	}
+	if hidden := findRule(t, report, "hiddenServices", "*_socket-proxy"); hidden.Matches != 1 || hidden.Examples[0] != "services.1" {
+		t.Errorf("hiddenServices = %+v, want the proxy service", hidden)
+	}
+	if mounts := findRule(t, report, "hideAllMounts", "true"); mounts.Matches != 1 {
+		t.Errorf("hideAllMounts = %+v, want one match", mounts)
+	}
+	if got := findRule(t, report, "sensitiveDataPaths", "services.*.Spec.Labels"); got.Matches != 1 || len(got.Errors) > 0 {
+		t.Errorf("matching path = %+v, want one match and no errors", got)
+	}
+	if got := findRule(t, report, "sensitiveDataPaths", "services.5.Spec.Labels"); got.Matches != 0 || len(got.Errors) != 1 {
+		t.Errorf("out of range path = %+v, want no matches and an error", got)
+	}
+	if got := findRule(t, report, "sensitiveDataPaths", "services.*.Spec.Nope"); len(got.Errors) != 1 {
+		t.Errorf("unresolvable path = %+v, want an error", got)
+	}
+
+	// The dry run works on copies.
+	if data.Services[0].Spec.TaskTemplate.ContainerSpec.Env[0] != "DB_PASSWORD=hunter2" || len(data.Services) != 2 {
+		t.Error("dry run modified its input")
+	}
+}
+
+func TestDryRunSanitization_DetectSecretsIsQuiet(t *testing.T) {
+	t.Cleanup(secretsFound.clear)
+	secretsFound.clear()
+
+	cfg := detectingConfig()
+	data := SwarmData{Services: []swarm.Service{{ID: "svc1",
+		Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Labels: map[string]string{"API_TOKEN": "abc"}}}}}}
+	report := dryRunSanitization(data, cfg)
+
+	got := findRule(t, report, "detectSecrets", "")
+	if got.Matches != 1 || got.Examples[0] != "services.0.Spec.Labels.API_TOKEN" {
+		t.Errorf("detectSecrets = %+v, want the token label", got)
+	}
+	if counts := secretsFound.counts(); len(counts) != 0 {
+		t.Errorf("running report = %v, want it untouched by the dry run", counts)
+	}
+}
+
+func TestTreeDiff_MatchesByIDAndQuotes(t *testing.T) {
+	before := jsonTree(SwarmData{Services: []swarm.Service{
+		namedService("a", "a", map[string]string{"com.example.key": "v"}),
+		namedService("b", "b", map[string]string{"com.example.key": "v"}),
+	}})
+	after := jsonTree(SwarmData{Services: []swarm.Service{
+		namedService("b", "b", map[string]string{"com.example.key": "(sanitized)"}),
+	}})
+	var d treeDiff
+	d.compare("", before, after)
+	want := []string{"services.0", "services.1.Spec.Labels.'com.example.key'"}
+	if d.count != 2 || !slices.Equal(d.examples, want) {
+		t.Errorf("diff = %d %v, want %v", d.count, d.examples, want)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	cfg := &config.Config{}
+	cfgs := config.NewHolder(cfg)
+	h := RequireAdmin(cfgs, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(auth string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/sanitization", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve("Bearer anything"); code != http.StatusNotFound {
+		t.Errorf("no token configured: status %d, want 404", code)
+	}
+	cfg.AdminToken = "s3cret"
+	for auth, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Basic s3cret":  http.StatusUnauthorized,
+		"Bearer s3cret": http.StatusNoContent,
+	} {
+		if code := serve(auth); code != want {
+			t.Errorf("Authorization %q: status %d, want %d", auth, code, want)
+		}
+	}
+}
+
+func TestHandleSanitizationReport(t *testing.T) {
+	cfgs := config.NewHolder(&config.Config{HideAllEnvs: true})
+	data := reportSwarm()
+	src := fakeSource{services: data.Services, tasks: []swarm.Task{
+		{ID: "t1", ServiceID: "web", DesiredState: swarm.TaskStateRunning,
+			Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Env: []string{"A=1"}}}},
+		{ID: "old", ServiceID: "web", DesiredState: swarm.TaskStateShutdown,
+			Status: swarm.TaskStatus{State: swarm.TaskStateComplete}},
+	}}
+
+	rec := httptest.NewRecorder()
+	handleSanitizationReport(cfgs, src)(rec, httptest.NewRequest(http.MethodGet, "/api/admin/sanitization", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var report sanitizationReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	envs := findRule(t, report, "hideAllEnvs", "true")
+	// The service's and the running task's env; the historical task is not
+	// published, so it is not reported.
+	if envs.Matches != 2 || !strings.HasPrefix(envs.Examples[1], "tasks.0.") {
+		t.Errorf("hideAllEnvs = %+v, want the service and running task env", envs)
+	}
+
+	rec = httptest.NewRecorder()
+	handleSanitizationReport(cfgs, src)(rec, httptest.NewRequest(http.MethodPost, "/api/admin/sanitization", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d, want 405", rec.Code)
+	}
+}
+
+func TestSnapshotter_ApplyErrorsLoggedOnce(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	var s snapshotter
+	fail := errors.New("services.5.Spec.Labels: invalid index \"5\"")
+	for range 3 {
+		s.reportApplyErr(fail)
+	}
+	for range 3 {
+		s.reportApplyErr(nil)
+	}
+	out := buf.String()
+	if n := strings.Count(out, "invalid index"); n != 1 {
+		t.Errorf("error logged %d times, want once:\n%s", n, out)
+	}
+	if n := strings.Count(out, "apply cleanly"); n != 1 {
+		t.Errorf("recovery logged %d times, want once:\n%s", n, out)
+	}
+}
@@ -0,0 +1,141 @@
+package docker
+
+import (
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jtgasper3/swarm-visualizer/internal"
+)
+
+// visualizerLabelPrefix starts the labels that configure the visualizer
+// itself, which are never treated as secrets.
+const visualizerLabelPrefix = "io.github.jtgasper3.visualizer."
+
+// redactEnvSecrets replaces the value of each KEY=value entry in env that
+// looks like a secret, as the hide-envs label does. It returns a finding for
+// each entry redacted.
+func redactEnvSecrets(env []string, d *internal.SecretDetector) []string {
+	var found []string
+	for i, entry := range env {
+		key, value, ok := strings.Cut(entry, "=")
+		if ok && d.IsSecret(key, value) {
+			env[i] = internal.SanitizeEnv(entry)
+			found = append(found, "env "+key)
+		}
+	}
+	return found
+}
+
+// redactLabelSecrets replaces each label value that looks like a secret. kind
+// names the labels in the returned findings.
+func redactLabelSecrets(labels map[string]string, kind string, d *internal.SecretDetector) []string {
+	var found []string
+	for key, value := range labels {
+		if !strings.HasPrefix(key, visualizerLabelPrefix) && d.IsSecret(key, value) {
+			labels[key] = internal.Sanitized
+			found = append(found, kind+" "+key)
+		}
+	}
+	return found
+}
+
+// secretReport collects, per service, the likely secrets that detection
+// redacted from the service and its tasks, and logs whenever they change so
+// owners can move them into Docker secrets. It is safe for concurrent use.
+type secretReport struct {
+	mu sync.Mutex
+	// found holds the findings for each group ("services", "tasks") by
+	// service ID.
+	found map[string]map[string][]string
+	// names maps service IDs to names for log messages.
+	names map[string]string
+	// logged holds the findings last logged for each service ID.
+	logged map[string][]string
+	// quiet disables logging, for reports that are not the running
+	// server's.
+	quiet bool
+}
+
+// secretsFound is the report for the running server.
+var secretsFound = &secretReport{}
+
+// set replaces the findings for group and logs services whose findings
+// changed. names, if not nil, replaces the service names used in log
+// messages.
+func (r *secretReport) set(group string, findings map[string][]string, names map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.found == nil {
+		r.found = make(map[string]map[string][]string)
+	}
+	r.found[group] = findings
+	if names != nil {
+		r.names = names
+	}
+
+	current := r.byServiceLocked()
+	if r.quiet {
+		r.logged = current
+		return
+	}
+	for id, keys := range current {
+		if !slices.Equal(keys, r.logged[id]) {
+			slog.Warn("Redacted likely secrets; store them as Docker secrets instead",
+				"service", r.nameLocked(id), "count", len(keys), "secrets", strings.Join(keys, ", "))
+		}
+	}
+	for id := range r.logged {
+		if _, ok := current[id]; !ok {
+			slog.Info("No likely secrets found anymore", "service", r.nameLocked(id))
+		}
+	}
+	r.logged = current
+}
+
+// clear discards all findings without logging, for when detection is off.
+func (r *secretReport) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.found, r.logged = nil, nil
+}
+
+// counts returns the number of distinct likely secrets redacted per service
+// ID.
+func (r *secretReport) counts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := make(map[string]int)
+	for id, keys := range r.byServiceLocked() {
+		counts[id] = len(keys)
+	}
+	return counts
+}
+
+// byServiceLocked merges the findings of every group into one sorted,
+// de-duplicated list per service ID. r.mu must be held.
+func (r *secretReport) byServiceLocked() map[string][]string {
+	merged := make(map[string][]string)
+	for _, group := range slices.Sorted(maps.Keys(r.found)) {
+		for id, keys := range r.found[group] {
+			merged[id] = append(merged[id], keys...)
+		}
+	}
+	for id, keys := range merged {
+		slices.Sort(keys)
+		merged[id] = slices.Compact(keys)
+	}
+	return merged
+}
+
+// nameLocked returns the service's name, or its ID if unknown. r.mu must be
+// held.
+func (r *secretReport) nameLocked(id string) string {
+	if name := r.names[id]; name != "" {
+		return name
+	}
+	return id
+}
@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/jtgasper3/swarm-visualizer/internal"
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/moby/moby/api/types/swarm"
+)
+
+func detectingConfig() *config.Config {
+	return &config.Config{SecretDetector: internal.NewSecretDetector(internal.DefaultSecretKeyPatterns)}
+}
+
+func TestSanitizeServices_DetectSecrets(t *testing.T) {
+	t.Cleanup(secretsFound.clear)
+
+	svc := swarm.Service{
+		ID: "svc1",
+		Spec: swarm.ServiceSpec{
+			Annotations: swarm.Annotations{Name: "web", Labels: map[string]string{
+				"com.example.api-token":                     "abc",
+				"com.example.owner":                         "team-a",
+				"io.github.jtgasper3.visualizer.hide-envs":  "NONE",
+				"io.github.jtgasper3.visualizer.secret-key": "x",
+			}},
+			TaskTemplate: swarm.TaskSpec{
+				ContainerSpec: &swarm.ContainerSpec{
+					Env:    []string{"DB_PASSWORD=hunter2", "PORT=8080", "SESSION=eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxMjM0NTY3ODkwIn0.sig"},
+					Labels: map[string]string{"cert": "-----BEGIN CERTIFICATE-----"},
+				},
+			},
+		},
+	}
+
+	out, _ := sanitizeServices([]swarm.Service{svc}, detectingConfig())
+
+	spec := out[0].Spec
+	if spec.Labels["com.example.api-token"] != internal.Sanitized || spec.Labels["com.example.owner"] != "team-a" {
+		t.Errorf("Labels = %v, want only the token redacted", spec.Labels)
+	}
+	if spec.Labels["io.github.jtgasper3.visualizer.secret-key"] != "x" {
+		t.Errorf("visualizer label redacted: %v", spec.Labels)
+	}
+	wantEnv := []string{"DB_PASSWORD=(sanitized)", "PORT=8080", "SESSION=(sanitized)"}
+	for i, want := range wantEnv {
+		if got := spec.TaskTemplate.ContainerSpec.Env[i]; got != want {
+			t.Errorf("Env[%d] = %q, want %q", i, got, want)
+		}
+	}
+	if spec.TaskTemplate.ContainerSpec.Labels["cert"] != internal.Sanitized {
+		t.Errorf("container label cert = %q, want redacted", spec.TaskTemplate.ContainerSpec.Labels["cert"])
+	}
+	if got := secretsFound.counts()["svc1"]; got != 4 {
+		t.Errorf("redaction count = %d, want 4", got)
+	}
+}
+
+func TestSanitizeTasks_DetectSecrets(t *testing.T) {
+	t.Cleanup(secretsFound.clear)
+
+	newTask := func(id string) swarm.Task {
+		return swarm.Task{
+			ID:        id,
+			ServiceID: "svc1",
+			Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{
+				Env: []string{"API_KEY=abc", "MODE=prod"},
+			}},
+		}
+	}
+	out := sanitizeTasks([]swarm.Task{newTask("t1"), newTask("t2")}, detectingConfig(), knownServices("svc1"))
+
+	for _, tsk := range out {
+		if env := tsk.Spec.ContainerSpec.Env; env[0] != "API_KEY=(sanitized)" || env[1] != "MODE=prod" {
+			t.Errorf("task %s Env = %v, want only API_KEY redacted", tsk.ID, env)
+		}
+	}
+	// The same variable in several tasks counts once for the service.
+	if got := secretsFound.counts()["svc1"]; got != 1 {
+		t.Errorf("redaction count = %d, want 1", got)
+	}
+}
+
+func TestSanitizeServices_DetectionDisabled(t *testing.T) {
+	t.Cleanup(secretsFound.clear)
+
+	svc := swarm.Service{Spec: swarm.ServiceSpec{TaskTemplate: swarm.TaskSpec{
+		ContainerSpec: &swarm.ContainerSpec{Env: []string{"DB_PASSWORD=hunter2"}},
+	}}}
+	out, _ := sanitizeServices([]swarm.Service{svc}, &config.Config{})
+	if env := out[0].Spec.TaskTemplate.ContainerSpec.Env[0]; env != "DB_PASSWORD=hunter2" {
+		t.Errorf("Env[0] = %q, want unchanged when detection is off", env)
+	}
+}
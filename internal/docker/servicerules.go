@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jtgasper3/swarm-visualizer/internal"
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/moby/moby/api/types/swarm"
+)
+
+// Labels a service owner sets to sanitize that service and its tasks.
+const (
+	// hideEnvsLabel lists environment variables whose values are redacted.
+	hideEnvsLabel = visualizerLabelPrefix + "hide-envs"
+	// hideLabelsLabel lists labels whose values are redacted. On the service
+	// it names service labels; in the container labels, container labels.
+	hideLabelsLabel = visualizerLabelPrefix + "hide-labels"
+	// hideMountsLabel, hideConfigsLabel, and hideSecretsLabel remove the
+	// container's mounts, configs, or secret references unless set to a
+	// false value.
+	hideMountsLabel  = visualizerLabelPrefix + "hide-mounts"
+	hideConfigsLabel = visualizerLabelPrefix + "hide-configs"
+	hideSecretsLabel = visualizerLabelPrefix + "hide-secrets"
+)
+
+// serviceRules is the sanitization a service opts into with its labels. Tasks
+// carry a copy of their service's container spec, so the same rules are
+// applied to each of its tasks.
+type serviceRules struct {
+	hideEnvs            []string
+	hideLabels          []string
+	hideContainerLabels []string
+	hideMounts          bool
+	hideConfigs         bool
+	hideSecrets         bool
+	// hidden leaves the service and its tasks out of the published data.
+	hidden bool
+	// stack is the service's stack namespace, kept here for the swarm state
+	// metrics since HIDE_LABELS may remove the label itself.
+	stack string
+}
+
+// rulesFor reads svc's sanitization labels and whether cfg hides it. It must
+// be called before the service is sanitized, since HIDE_LABELS and
+// SENSITIVE_DATA_PATHS may remove the labels themselves.
+func rulesFor(svc *swarm.Service, cfg *config.Config) serviceRules {
+	labels := svc.Spec.Labels
+	r := serviceRules{
+		hidden:      serviceHidden(svc, cfg),
+		stack:       labels[stackNamespaceLabel],
+		hideEnvs:    splitLabelList(labels[hideEnvsLabel]),
+		hideLabels:  splitLabelList(labels[hideLabelsLabel]),
+		hideMounts:  labelEnabled(labels, hideMountsLabel),
+		hideConfigs: labelEnabled(labels, hideConfigsLabel),
+		hideSecrets: labelEnabled(labels, hideSecretsLabel),
+	}
+	if cs := svc.Spec.TaskTemplate.ContainerSpec; cs != nil {
+		r.hideContainerLabels = splitLabelList(cs.Labels[hideLabelsLabel])
+	}
+	return r
+}
+
+// serviceRulesByID reads the sanitization rules of each service.
+func serviceRulesByID(services []swarm.Service, cfg *config.Config) map[string]serviceRules {
+	rules := make(map[string]serviceRules, len(services))
+	for i := range services {
+		rules[services[i].ID] = rulesFor(&services[i], cfg)
+	}
+	return rules
+}
+
+// applyContainer redacts cs, the container spec of the service or of one of
+// its tasks, according to r.
+func (r serviceRules) applyContainer(cs *swarm.ContainerSpec) {
+	if cs == nil {
+		return
+	}
+	if len(r.hideEnvs) > 0 {
+		for i, env := range cs.Env {
+			key, _, _ := strings.Cut(env, "=")
+			for _, name := range r.hideEnvs {
+				if key == name {
+					cs.Env[i] = internal.SanitizeEnv(env)
+					break
+				}
+			}
+		}
+	}
+	redactLabels(cs.Labels, r.hideContainerLabels)
+	if r.hideMounts {
+		cs.Mounts = nil
+	}
+	if r.hideConfigs {
+		cs.Configs = nil
+	}
+	if r.hideSecrets {
+		cs.Secrets = nil
+	}
+}
+
+// redactLabels replaces the values of the named labels that are present.
+func redactLabels(labels map[string]string, names []string) {
+	for _, name := range names {
+		if _, exists := labels[name]; exists {
+			labels[name] = internal.Sanitized
+		}
+	}
+}
+
+// splitLabelList splits a comma separated label value, dropping empty
+// entries.
+func splitLabelList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// labelEnabled reports whether a boolean label is set. Any value other than
+// one strconv.ParseBool reads as false enables it, so a mistyped value errs
+// on the side of hiding.
+func labelEnabled(labels map[string]string, key string) bool {
+	value, ok := labels[key]
+	if !ok {
+		return false
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+	return enabled || err != nil
+}
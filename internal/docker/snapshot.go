@@ -0,0 +1,214 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/maphash"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"github.com/jtgasper3/swarm-visualizer/internal"
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+	"github.com/jtgasper3/swarm-visualizer/internal/tracing"
+)
+
+// snapshotter turns the cached groups into published frames. It keeps the
+// sanitization plan compiled for the current configuration, so paths are
+// resolved once per configuration rather than on every publish, and enough
+// of the last frame to recognise an unchanged snapshot cheaply. It is only
+// used from the single inspectSwarmServices goroutine.
+type snapshotter struct {
+	cfg  *config.Config
+	plan *internal.Plan
+
+	// fingerprint summarises the unsanitized inputs of last, and freshness
+	// the groups' freshness when it was published.
+	fingerprint uint64
+	last        []byte
+	freshness   string
+	// sequence is the sequence number of the last frame. It is not reset
+	// when the configuration changes, so clients can tell frames apart
+	// across a reload.
+	sequence uint64
+	// published is the data of the last frame, as sanitized, for the
+	// timeline.
+	published SwarmData
+
+	// applyErr is the last error applying the plan, logged only when it
+	// changes so a failing path is not reported on every publish.
+	applyErr string
+}
+
+// frame returns the JSON to publish for data under cfg, or nil if it would
+// be the same as the last frame. Sanitizing it is traced under ctx.
+//
+// Swarm bumps an object's Version.Index whenever it changes, so when the
+// fingerprint of the inputs is unchanged the snapshot is skipped without
+// sanitizing or marshalling it. Otherwise the marshalled bytes are compared
+// with the last frame's, so changes confined to data that sanitization
+// removes are not published either. A change to a group's staleness or error
+// is published, but not one to its fetch time alone, which moves on every
+// poll. Published frames are numbered and stamped, which costs a second
+// marshal only when there is a change.
+func (s *snapshotter) frame(ctx context.Context, cfg *config.Config, data SwarmData) []byte {
+	if cfg != s.cfg {
+		s.cfg = cfg
+		s.plan = sanitizationPlan(cfg)
+		s.last = nil
+		s.applyErr = ""
+	}
+
+	fp := fingerprint(&data)
+	if s.last != nil && fp == s.fingerprint {
+		return nil
+	}
+
+	span := startSanitize(ctx, "snapshot")
+	err := s.sanitize(&data, hashSalt(cfg))
+	s.reportApplyErr(err)
+	if len(cfg.CompiledAllowedDataPaths) > 0 {
+		data = projectSwarmData(&data, cfg.CompiledAllowedDataPaths)
+	}
+	tracing.End(span, err)
+
+	freshness, key := data.Freshness, freshnessKey(data.Freshness)
+	data.Freshness = nil
+	content, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("Marshalling the snapshot failed", logging.Err(err))
+		return nil
+	}
+	s.fingerprint = fp
+	if bytes.Equal(content, s.last) && key == s.freshness {
+		return nil
+	}
+
+	s.sequence++
+	data.Freshness, data.Sequence, data.GeneratedAt = freshness, s.sequence, time.Now()
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("Marshalling the snapshot failed", logging.Err(err))
+		return nil
+	}
+	s.last, s.freshness, s.published = content, key, data
+	return jsonBytes
+}
+
+// sanitize applies the plan to data. The reasons of the service statuses
+// quote the tasks' errors and the updates' messages, so the statuses are
+// computed again from the services and tasks as sanitized, keeping the stacks
+// of those given, and the plan applied to them. The statuses match the
+// services one for one, so the second application finds no error the first
+// did not report.
+func (s *snapshotter) sanitize(data *SwarmData, salt []byte) error {
+	err := s.plan.Apply(data, salt)
+	if data.ServiceStatuses == nil {
+		return err
+	}
+
+	stacks := make(map[string]serviceRules, len(data.ServiceStatuses))
+	for _, st := range data.ServiceStatuses {
+		stacks[st.ID] = serviceRules{stack: st.Stack}
+	}
+	statuses := serviceStatuses(data.Services, data.Tasks, stacks)
+	derived := SwarmData{ServiceStatuses: statuses, StackStatuses: stackStatuses(statuses)}
+	_ = s.plan.Apply(&derived, salt)
+	data.ServiceStatuses, data.StackStatuses = derived.ServiceStatuses, derived.StackStatuses
+	return err
+}
+
+// reportApplyErr counts err and logs it if it differs from the last error
+// applying the plan, and logs when the errors stop.
+func (s *snapshotter) reportApplyErr(err error) {
+	msg := ""
+	if err != nil {
+		metrics.SanitizationErrors.Inc()
+		msg = err.Error()
+	}
+	if msg == s.applyErr {
+		return
+	}
+	if err != nil {
+		slog.Error("Applying sensitive data paths failed", logging.Err(err))
+	} else {
+		slog.Info("Sensitive data paths apply cleanly again")
+	}
+	s.applyErr = msg
+}
+
+// sanitizationPlan compiles cfg's sensitive data paths against SwarmData,
+// logging any that can never match once rather than on every publish.
+func sanitizationPlan(cfg *config.Config) *internal.Plan {
+	plan, err := internal.NewPlan(reflect.TypeOf(SwarmData{}), cfg.CompiledSensitiveDataPaths)
+	if err != nil {
+		slog.Warn("Ignoring sensitive data paths that match nothing", logging.Err(err))
+	}
+	return plan
+}
+
+var fingerprintSeed = maphash.MakeSeed()
+
+// fingerprint hashes what identifies the current state of data: the ID and
+// version of every node, service, and task, the task counts of services
+// (computed by the daemon rather than stored, so not versioned), the
+// networks, which carry no version and are few enough to hash whole, and
+// what of the groups' freshness is published as a change.
+func fingerprint(data *SwarmData) uint64 {
+	var h maphash.Hash
+	h.SetSeed(fingerprintSeed)
+
+	writeUint := func(n uint64) {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], n)
+		h.Write(buf[:])
+	}
+	writeString := func(s string) {
+		writeUint(uint64(len(s)))
+		h.WriteString(s)
+	}
+
+	h.WriteString(data.ClusterName)
+	if data.AuthEnabled {
+		h.WriteByte(1)
+	} else {
+		h.WriteByte(0)
+	}
+
+	writeUint(uint64(len(data.Nodes)))
+	for _, n := range data.Nodes {
+		writeString(n.ID)
+		writeUint(n.Version.Index)
+	}
+
+	writeUint(uint64(len(data.Services)))
+	for _, s := range data.Services {
+		writeString(s.ID)
+		writeUint(s.Version.Index)
+		if st := s.ServiceStatus; st != nil {
+			h.WriteByte(1)
+			writeUint(st.RunningTasks)
+			writeUint(st.DesiredTasks)
+			writeUint(st.CompletedTasks)
+		} else {
+			h.WriteByte(0)
+		}
+	}
+
+	writeUint(uint64(len(data.Tasks)))
+	for _, t := range data.Tasks {
+		writeString(t.ID)
+		writeUint(t.Version.Index)
+	}
+
+	networks, _ := json.Marshal(data.Networks)
+	h.Write(networks)
+
+	writeString(freshnessKey(data.Freshness))
+
+	return h.Sum64()
+}
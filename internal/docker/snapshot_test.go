@@ -0,0 +1,274 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jtgasper3/swarm-visualizer/internal"
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/moby/moby/api/types/swarm"
+)
+
+// benchmarkSwarm builds a cluster of the given size with the env, labels, and
+// node descriptions the built-in and typical sensitive data paths touch.
+func benchmarkSwarm(nodes, services, tasksPerService int) SwarmData {
+	data := SwarmData{ClusterName: "bench"}
+	for i := range nodes {
+		data.Nodes = append(data.Nodes, swarm.Node{
+			ID:   fmt.Sprintf("node%d", i),
+			Meta: swarm.Meta{Version: swarm.Version{Index: 1}},
+			Description: swarm.NodeDescription{
+				Hostname: fmt.Sprintf("host%d", i),
+				Engine:   swarm.EngineDescription{Plugins: []swarm.PluginDescription{{Type: "Volume", Name: "local"}}},
+				TLSInfo:  swarm.TLSInfo{TrustRoot: strings.Repeat("x", 512)},
+			},
+		})
+	}
+	for i := range services {
+		spec := swarm.ServiceSpec{
+			Annotations: swarm.Annotations{
+				Name:   fmt.Sprintf("svc%d", i),
+				Labels: map[string]string{"com.docker.stack.namespace": "app", "com.example.secret.token": "v"},
+			},
+			TaskTemplate: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{
+				Image: "nginx:latest",
+				Env:   []string{"A=1", "B=2", "C=3"},
+			}},
+		}
+		data.Services = append(data.Services, swarm.Service{
+			ID:   fmt.Sprintf("svc%d", i),
+			Meta: swarm.Meta{Version: swarm.Version{Index: 1}},
+			Spec: spec,
+		})
+		for j := range tasksPerService {
+			data.Tasks = append(data.Tasks, swarm.Task{
+				ID:        fmt.Sprintf("task%d.%d", i, j),
+				Meta:      swarm.Meta{Version: swarm.Version{Index: 1}},
+				ServiceID: fmt.Sprintf("svc%d", i),
+				NodeID:    fmt.Sprintf("node%d", j%nodes),
+				Slot:      j + 1,
+				Spec:      spec.TaskTemplate,
+				Status:    swarm.TaskStatus{State: swarm.TaskStateRunning},
+			})
+		}
+	}
+	return data
+}
+
+func loadSnapshotConfig(tb testing.TB) *config.Config {
+	tb.Setenv("SENSITIVE_DATA_PATHS", "services.*.Spec.Labels.'com.example.secret.*'=hash,**.Env=keys")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return cfg
+}
+
+func TestSnapshotter_PublishesOnlyChanges(t *testing.T) {
+	cfg := loadSnapshotConfig(t)
+	data := benchmarkSwarm(2, 2, 2)
+	var s snapshotter
+
+	first := s.frame(context.Background(), cfg, data)
+	if first == nil {
+		t.Fatal("expected the first snapshot to be published")
+	}
+	if strings.Contains(string(first), "com.example.secret.token\":\"v\"") || strings.Contains(string(first), "A=1") {
+		t.Fatalf("sensitive data published: %s", first)
+	}
+	if frame := s.frame(context.Background(), cfg, data); frame != nil {
+		t.Fatalf("unchanged snapshot published again: %s", frame)
+	}
+
+	data.Tasks[0].Version.Index++
+	data.Tasks[0].Status.State = swarm.TaskStateFailed
+	if frame := s.frame(context.Background(), cfg, data); frame == nil || !strings.Contains(string(frame), `"failed"`) {
+		t.Fatalf("changed task not published: %s", frame)
+	}
+
+	data.Tasks = data.Tasks[1:]
+	if frame := s.frame(context.Background(), cfg, data); frame == nil {
+		t.Fatal("removed task not published")
+	}
+}
+
+// TestSnapshotter_LeavesInputsUnsanitized verifies that sanitizing a
+// snapshot leaves the groups it was built from, which the inspector keeps
+// across polls, as they were, so a hashed value is the same in every frame.
+func TestSnapshotter_LeavesInputsUnsanitized(t *testing.T) {
+	cfg := loadSnapshotConfig(t)
+	data := benchmarkSwarm(1, 1, 1)
+	var s snapshotter
+
+	first := s.frame(context.Background(), cfg, data)
+	if got := data.Services[0].Spec.Labels["com.example.secret.token"]; got != "v" {
+		t.Errorf("cached label = %q, want it unsanitized", got)
+	}
+	if got := data.Tasks[0].Spec.ContainerSpec.Env; !slices.Equal(got, []string{"A=1", "B=2", "C=3"}) {
+		t.Errorf("cached env = %v, want it unsanitized", got)
+	}
+
+	data.Tasks[0].Version.Index++
+	data.Tasks[0].Status.State = swarm.TaskStateFailed
+	second := s.frame(context.Background(), cfg, data)
+	label := func(frame []byte) string {
+		var published SwarmData
+		if err := json.Unmarshal(frame, &published); err != nil {
+			t.Fatalf("frame = %s: %v", frame, err)
+		}
+		return published.Services[0].Spec.Labels["com.example.secret.token"]
+	}
+	if a, b := label(first), label(second); a != b || a == "v" {
+		t.Errorf("hashed label = %q then %q, want the same hash in both", a, b)
+	}
+}
+
+// TestSnapshotter_StatusesOfSanitizedTasks verifies that the service and
+// stack statuses are computed from the tasks and updates as sanitized, so a
+// redacted task error is not quoted by a reason.
+func TestSnapshotter_StatusesOfSanitizedTasks(t *testing.T) {
+	t.Setenv("SENSITIVE_DATA_PATHS", "tasks.*.Status.Err=mask,services.*.UpdateStatus.Message")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, services, tasks := alertFixture()
+	services[0].UpdateStatus = &swarm.UpdateStatus{State: swarm.UpdateStatePaused, Message: "task failed: db-password"}
+	tasks[1].Status = swarm.TaskStatus{State: swarm.TaskStateRejected, Err: "secret mount /run/secrets/db-password missing"}
+	rules := map[string]serviceRules{"web": {stack: "app"}}
+	data := SwarmData{Services: services, Tasks: tasks, ServiceStatuses: serviceStatuses(services, tasks, rules)}
+	data.StackStatuses = stackStatuses(data.ServiceStatuses)
+
+	var s snapshotter
+	frame := s.frame(context.Background(), cfg, data)
+	if frame == nil {
+		t.Fatal("snapshot not published")
+	}
+	if strings.Contains(string(frame), "db-password") {
+		t.Fatalf("redacted task error published: %s", frame)
+	}
+	st := s.published.ServiceStatuses[0]
+	want := []StatusReason{
+		{Code: ReasonUpdatePaused, Message: "update paused"},
+		{Code: ReasonReplicas, Message: "1/2 running"},
+		{Code: ReasonTaskRejected, Message: "task rejected: " + internal.Sanitized, Tasks: 1},
+	}
+	if st.Stack != "app" || st.Status != StatusPaused || !slices.Equal(st.Reasons, want) {
+		t.Errorf("status = %+v, want paused in stack app with reasons %+v", st, want)
+	}
+	if stacks := s.published.StackStatuses; len(stacks) != 1 || stacks[0].Name != "app" || stacks[0].Status != StatusPaused {
+		t.Errorf("stack statuses = %+v, want app paused", stacks)
+	}
+}
+
+// TestSnapshotter_Freshness verifies that frames are numbered and stamped and
+// that a group going stale is published but a new fetch time alone is not.
+func TestSnapshotter_Freshness(t *testing.T) {
+	cfg := loadSnapshotConfig(t)
+	data := benchmarkSwarm(1, 1, 1)
+	fetched := time.Now()
+	fresh := freshnessTracker{}
+	for _, group := range []string{"nodes", "services", "networks", "tasks"} {
+		fresh.record(group, nil, fetched)
+	}
+	var s snapshotter
+
+	decode := func(frame []byte) SwarmData {
+		t.Helper()
+		var got SwarmData
+		if err := json.Unmarshal(frame, &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	data.Freshness = fresh.snapshot()
+	first := decode(s.frame(context.Background(), cfg, data))
+	if first.Sequence != 1 || first.GeneratedAt.IsZero() || !first.Freshness["tasks"].FetchedAt.Equal(fetched) || first.Freshness["tasks"].Stale {
+		t.Fatalf("first frame = sequence %d at %v, freshness %+v", first.Sequence, first.GeneratedAt, first.Freshness)
+	}
+
+	fresh.record("tasks", nil, fetched.Add(time.Second))
+	data.Freshness = fresh.snapshot()
+	if frame := s.frame(context.Background(), cfg, data); frame != nil {
+		t.Fatalf("a new fetch time alone was published: %s", frame)
+	}
+
+	fresh.record("networks", errors.New("daemon down"), fetched.Add(2*time.Second))
+	data.Freshness = fresh.snapshot()
+	frame := s.frame(context.Background(), cfg, data)
+	if frame == nil {
+		t.Fatal("a group going stale was not published")
+	}
+	second := decode(frame)
+	networks := second.Freshness["networks"]
+	if second.Sequence != 2 || !networks.Stale || networks.LastError != "daemon down" || !networks.FetchedAt.Equal(fetched) {
+		t.Errorf("stale frame = sequence %d, networks %+v, want sequence 2 and networks stale since the first fetch", second.Sequence, networks)
+	}
+	if !second.Freshness["tasks"].FetchedAt.Equal(fetched.Add(time.Second)) {
+		t.Errorf("tasks fetched at %v, want the latest fetch", second.Freshness["tasks"].FetchedAt)
+	}
+}
+
+func TestSnapshotter_RepublishesOnConfigChange(t *testing.T) {
+	data := benchmarkSwarm(1, 1, 1)
+	var s snapshotter
+
+	if s.frame(context.Background(), loadSnapshotConfig(t), data) == nil {
+		t.Fatal("expected the first snapshot to be published")
+	}
+	if s.frame(context.Background(), loadSnapshotConfig(t), data) == nil {
+		t.Fatal("expected a reloaded configuration to re-publish")
+	}
+}
+
+func TestSnapshotter_UnmatchedPathsDoNotStopPublishing(t *testing.T) {
+	t.Setenv("SENSITIVE_DATA_PATHS", "nodes.*.Nope,services.*.Spec.TaskTemplate.ContainerSpec.Env")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s snapshotter
+
+	frame := s.frame(context.Background(), cfg, benchmarkSwarm(1, 1, 1))
+	var published SwarmData
+	if err := json.Unmarshal(frame, &published); err != nil {
+		t.Fatalf("frame = %s: %v", frame, err)
+	}
+	if env := published.Services[0].Spec.TaskTemplate.ContainerSpec.Env; env != nil {
+		t.Fatalf("service env = %v, want the valid path applied", env)
+	}
+}
+
+// BenchmarkSnapshot measures a publish for a 20 node, 200 service, 2000 task
+// cluster: "unchanged" is the steady state of most ticks, "changed" the cost
+// when a task has moved.
+func BenchmarkSnapshot(b *testing.B) {
+	cfg := loadSnapshotConfig(b)
+
+	b.Run("unchanged", func(b *testing.B) {
+		data := benchmarkSwarm(20, 200, 10)
+		var s snapshotter
+		s.frame(context.Background(), cfg, data)
+		b.ReportAllocs()
+		for b.Loop() {
+			s.frame(context.Background(), cfg, data)
+		}
+	})
+
+	b.Run("changed", func(b *testing.B) {
+		data := benchmarkSwarm(20, 200, 10)
+		var s snapshotter
+		b.ReportAllocs()
+		for b.Loop() {
+			data.Tasks[0].Version.Index++
+			s.frame(context.Background(), cfg, data)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package docker
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,9 +34,6 @@ func taskIDs(tasks []swarm.Task) map[string]bool {
 }
 
 func TestGetTasksInfo_FiltersRunningAndRecentlyStopped(t *testing.T) {
-	stoppedTaskCache = make(map[string]cachedTask)
-	t.Cleanup(func() { stoppedTaskCache = make(map[string]cachedTask) })
-
 	now := time.Now()
 	src := fakeSource{tasks: []swarm.Task{
 		// Running task: always included.
@@ -49,7 +47,7 @@ func TestGetTasksInfo_FiltersRunningAndRecentlyStopped(t *testing.T) {
 			Status: swarm.TaskStatus{State: swarm.TaskStateComplete}, Meta: swarm.Meta{UpdatedAt: now.Add(-time.Hour)}},
 	}}
 
-	out, err := getTasksInfo(context.Background(), src, &config.Config{})
+	out, err := getTasksInfo(context.Background(), src, &config.Config{}, knownServices("s1"), make(stoppedTasks))
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -66,9 +64,6 @@ func TestGetTasksInfo_FiltersRunningAndRecentlyStopped(t *testing.T) {
 }
 
 func TestGetTasksInfo_NewestStoppedPerSlotWins(t *testing.T) {
-	stoppedTaskCache = make(map[string]cachedTask)
-	t.Cleanup(func() { stoppedTaskCache = make(map[string]cachedTask) })
-
 	now := time.Now()
 	src := fakeSource{tasks: []swarm.Task{
 		// Two failed tasks for the same service+slot; only the newest should show.
@@ -78,7 +73,7 @@ func TestGetTasksInfo_NewestStoppedPerSlotWins(t *testing.T) {
 			Status: swarm.TaskStatus{State: swarm.TaskStateFailed}, Meta: swarm.Meta{UpdatedAt: now, CreatedAt: now.Add(-1 * time.Minute)}},
 	}}
 
-	out, err := getTasksInfo(context.Background(), src, &config.Config{})
+	out, err := getTasksInfo(context.Background(), src, &config.Config{}, knownServices("s1"), make(stoppedTasks))
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -121,10 +116,111 @@ func TestGetNetworksInfo_RemovesIngressAndHidesLabels(t *testing.T) {
 
 func TestGetInfo_PropagatesError(t *testing.T) {
 	src := fakeSource{err: context.DeadlineExceeded}
-	if _, err := getTasksInfo(context.Background(), src, &config.Config{}); err == nil {
+	if _, err := getTasksInfo(context.Background(), src, &config.Config{}, taskOwners{}, make(stoppedTasks)); err == nil {
 		t.Error("expected error from getTasksInfo")
 	}
-	if _, err := getNodesInfo(context.Background(), src, &config.Config{}); err == nil {
+	if _, _, err := getNodesInfo(context.Background(), src, &config.Config{}); err == nil {
 		t.Error("expected error from getNodesInfo")
 	}
 }
+
+// TestInspect_ReloadRepublishes verifies that a configuration reload is
+// published to clients immediately, without waiting for the data to change.
+func TestInspect_ReloadRepublishes(t *testing.T) {
+	t.Setenv("CLUSTER_NAME", "before")
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	cfgs := config.NewHolder(cfg)
+
+	h := newHub(cfg, nil)
+	cfgs.Subscribe(h.applyConfig)
+	runHub(t, h)
+
+	// Stop the inspector before the cleanup above resets its task cache.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		inspectSwarmServices(ctx, cfgs, fakeSource{}, h, nil)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	lastFrame := func() string {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return string(h.lastFanned)
+	}
+	if !waitFor(t, func() bool { return strings.Contains(lastFrame(), `"clusterName":"before"`) }, 2*time.Second) {
+		t.Fatalf("initial frame not published, last = %s", lastFrame())
+	}
+
+	t.Setenv("CLUSTER_NAME", "after")
+	t.Setenv("MAX_WS_CONNECTIONS", "1")
+	if err := cfgs.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	// Well under structuralPollInterval, so only the reload can explain it.
+	if !waitFor(t, func() bool { return strings.Contains(lastFrame(), `"clusterName":"after"`) }, time.Second) {
+		t.Fatalf("reload was not re-published, last = %s", lastFrame())
+	}
+	h.register(&wsClient{send: make(chan []byte, 1)})
+	if !h.atCapacity() {
+		t.Error("expected the reloaded connection cap to apply to the hub")
+	}
+}
+
+func TestGetTasksInfo_LeavesOutTasksOfUnknownServices(t *testing.T) {
+	// "new" was created after the services were last refreshed, so its
+	// rules, and whether it is hidden, are not known yet.
+	src := fakeSource{tasks: []swarm.Task{
+		{ID: "t1", ServiceID: "s1", DesiredState: swarm.TaskStateRunning,
+			Status: swarm.TaskStatus{State: swarm.TaskStateRunning}},
+		{ID: "t2", ServiceID: "new", DesiredState: swarm.TaskStateRunning,
+			Status: swarm.TaskStatus{State: swarm.TaskStateRunning},
+			Spec:   swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Env: []string{"TOKEN=abc"}}}},
+	}}
+	out, err := getTasksInfo(context.Background(), src, &config.Config{}, knownServices("s1"), make(stoppedTasks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := taskIDs(out); len(out) != 1 || !ids["t1"] {
+		t.Fatalf("tasks = %v, want only t1", ids)
+	}
+
+	out, err = getTasksInfo(context.Background(), src, &config.Config{}, knownServices("s1", "new"), make(stoppedTasks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := taskIDs(out); len(out) != 2 {
+		t.Fatalf("tasks = %v, want t2 once its service is known", ids)
+	}
+}
+
+func TestGetTasksInfo_StoppedTaskKeepsRemovedServiceRules(t *testing.T) {
+	src := fakeSource{tasks: []swarm.Task{
+		{ID: "fail1", ServiceID: "s1", Slot: 1, DesiredState: swarm.TaskStateShutdown,
+			Status: swarm.TaskStatus{State: swarm.TaskStateFailed}, Meta: swarm.Meta{UpdatedAt: time.Now()},
+			Spec: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Env: []string{"TOKEN=abc"}}}},
+	}}
+	rules := map[string]serviceRules{"s1": {hideEnvs: []string{"TOKEN"}}}
+	stopped := make(stoppedTasks)
+	if _, err := getTasksInfo(context.Background(), src, &config.Config{}, taskOwners{services: rules}, stopped); err != nil {
+		t.Fatal(err)
+	}
+
+	// The service is removed before the stopped task ages out.
+	src.tasks[0].Spec.ContainerSpec.Env = []string{"TOKEN=abc"}
+	out, err := getTasksInfo(context.Background(), src, &config.Config{}, taskOwners{}, stopped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0].Spec.ContainerSpec.Env[0] != "TOKEN=(sanitized)" {
+		t.Fatalf("tasks = %+v, want the removed service's hide-envs still applied", out)
+	}
+}
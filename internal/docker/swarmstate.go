@@ -0,0 +1,294 @@
+package docker
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+	"github.com/moby/moby/api/types/swarm"
+)
+
+// swarmState is the collector of the swarm state metrics for the running
+// server.
+var swarmState = &swarmStateCollector{}
+
+func init() {
+	metrics.MustRegister(swarmState)
+}
+
+// swarmStateCollector exposes the state of the swarm, as last polled, as
+// Prometheus gauges. The polled groups are summarised as they are refreshed,
+// after the sanitization functions have left out hidden objects but before
+// the sensitive data paths apply, so the metrics do not depend on what those
+// redact. It is safe for concurrent use.
+//
+// Which of the stack, service, and node labels the metrics carry is
+// configurable, so the number of series can be bounded on large swarms; the
+// values for the dimensions left out are summed.
+type swarmStateCollector struct {
+	mu       sync.Mutex
+	cfg      *config.Config
+	nodes    []nodeState
+	services []serviceState
+	tasks    []taskState
+	// statuses are the services' statuses, by ID.
+	statuses map[string]string
+	// failed holds the failed tasks seen within the failed window, by ID.
+	failed map[string]failedTask
+}
+
+type nodeState struct {
+	id, name                   string
+	role, availability, status string
+	// reachability is the manager's reachability, or "" for a worker.
+	reachability string
+}
+
+type serviceState struct {
+	id, name, stack string
+	// replicas is the replica count of a replicated service, or -1 for other
+	// modes, whose desired count is that of tasks meant to be running.
+	replicas int
+}
+
+type taskState struct {
+	serviceID, nodeID string
+	state             swarm.TaskState
+	desiredRunning    bool
+}
+
+type failedTask struct {
+	serviceID, nodeID string
+	at                time.Time
+}
+
+// setStructural replaces the nodes and services with those of a refresh
+// under cfg. rules are the services' rules by ID, which carry their stacks.
+func (c *swarmStateCollector) setStructural(cfg *config.Config, nodes []swarm.Node, services []swarm.Service, rules map[string]serviceRules) {
+	ns := make([]nodeState, len(nodes))
+	for i, n := range nodes {
+		ns[i] = nodeState{
+			id:           n.ID,
+			name:         cmp.Or(n.Description.Hostname, n.ID),
+			role:         string(n.Spec.Role),
+			availability: string(n.Spec.Availability),
+			status:       string(n.Status.State),
+		}
+		if n.ManagerStatus != nil {
+			ns[i].reachability = string(n.ManagerStatus.Reachability)
+		}
+	}
+	ss := make([]serviceState, len(services))
+	for i, s := range services {
+		ss[i] = serviceState{id: s.ID, name: cmp.Or(s.Spec.Name, s.ID), stack: rules[s.ID].stack, replicas: -1}
+		if r := s.Spec.Mode.Replicated; r != nil && r.Replicas != nil {
+			ss[i].replicas = int(*r.Replicas)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg, c.nodes, c.services = cfg, ns, ss
+}
+
+// setTasks replaces the tasks with those of a refresh under cfg, and records
+// newly failed ones.
+func (c *swarmStateCollector) setTasks(cfg *config.Config, tasks []swarm.Task) {
+	now := time.Now()
+	ts := make([]taskState, len(tasks))
+	for i, t := range tasks {
+		ts[i] = taskState{
+			serviceID:      t.ServiceID,
+			nodeID:         t.NodeID,
+			state:          t.Status.State,
+			desiredRunning: t.DesiredState == swarm.TaskStateRunning,
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg, c.tasks = cfg, ts
+	if c.failed == nil {
+		c.failed = make(map[string]failedTask)
+	}
+	for _, t := range tasks {
+		if _, seen := c.failed[t.ID]; t.Status.State != swarm.TaskStateFailed || seen {
+			continue
+		}
+		at := t.Status.Timestamp
+		if at.IsZero() || at.After(now) {
+			at = now
+		}
+		c.failed[t.ID] = failedTask{serviceID: t.ServiceID, nodeID: t.NodeID, at: at}
+	}
+	for id, f := range c.failed {
+		if now.Sub(f.at) >= cfg.SwarmMetricsFailedWindow {
+			delete(c.failed, id)
+		}
+	}
+}
+
+// setStatuses replaces the services' statuses with those last published.
+func (c *swarmStateCollector) setStatuses(statuses []ServiceStatus) {
+	m := make(map[string]string, len(statuses))
+	for _, s := range statuses {
+		m[s.ID] = s.Status
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statuses = m
+}
+
+// Describe sends nothing: the labels depend on the configuration, so the
+// collector is unchecked.
+func (c *swarmStateCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect sends the swarm state gauges.
+func (c *swarmStateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg == nil {
+		return
+	}
+
+	dims := c.cfg.SwarmMetricsLabels
+	services := make(map[string]serviceState, len(c.services))
+	for _, s := range c.services {
+		services[s.id] = s
+	}
+	nodes := make(map[string]nodeState, len(c.nodes))
+	for _, n := range c.nodes {
+		nodes[n.id] = n
+	}
+	// objectLabels returns the configured dimensions' values for an object
+	// on a service and node, either of which may be unknown.
+	objectLabels := func(serviceID, nodeID string, withNode bool) []string {
+		var values []string
+		for _, d := range dims {
+			switch d {
+			case "stack":
+				values = append(values, services[serviceID].stack)
+			case "service":
+				values = append(values, services[serviceID].name)
+			case "node":
+				if withNode {
+					values = append(values, nodes[nodeID].name)
+				}
+			}
+		}
+		return values
+	}
+	serviceDims := slices.DeleteFunc(slices.Clone(dims), func(d string) bool { return d == "node" })
+	nodeDims := slices.DeleteFunc(slices.Clone(dims), func(d string) bool { return d != "node" })
+
+	desired := newGaugeSet("service_replicas_desired", "Replicas the services are meant to run.", serviceDims)
+	running := newGaugeSet("service_replicas_running", "Replicas of the services that are running.", serviceDims)
+	for _, s := range c.services {
+		labels := objectLabels(s.id, "", false)
+		desired.add(labels, 0)
+		running.add(labels, 0)
+		if s.replicas >= 0 {
+			desired.add(labels, float64(s.replicas))
+		}
+	}
+	status := newGaugeSet("service_status", "Services by status.", append([]string{"status"}, serviceDims...))
+	if len(serviceDims) == 0 {
+		for _, st := range statusSeverity {
+			status.add([]string{st}, 0)
+		}
+	}
+	for _, s := range c.services {
+		if st, ok := c.statuses[s.id]; ok {
+			status.add(append([]string{st}, objectLabels(s.id, "", false)...), 1)
+		}
+	}
+	tasks := newGaugeSet("tasks", "Tasks by state.", append([]string{"state"}, dims...))
+	for _, t := range c.tasks {
+		if _, ok := services[t.serviceID]; !ok {
+			continue
+		}
+		labels := objectLabels(t.serviceID, t.nodeID, false)
+		if t.desiredRunning {
+			if services[t.serviceID].replicas < 0 {
+				desired.add(labels, 1)
+			}
+			if t.state == swarm.TaskStateRunning {
+				running.add(labels, 1)
+			}
+		}
+		tasks.add(append([]string{string(t.state)}, objectLabels(t.serviceID, t.nodeID, true)...), 1)
+	}
+
+	nodeGauge := newGaugeSet("nodes", "Nodes by role, availability, and status.", append([]string{"role", "availability", "status"}, nodeDims...))
+	managers := newGaugeSet("managers", "Managers by reachability.", append([]string{"reachability"}, nodeDims...))
+	for _, n := range c.nodes {
+		var name []string
+		if len(nodeDims) > 0 {
+			name = []string{n.name}
+		}
+		nodeGauge.add(append([]string{n.role, n.availability, n.status}, name...), 1)
+		if n.reachability != "" {
+			managers.add(append([]string{n.reachability}, name...), 1)
+		}
+	}
+
+	// The window is a gauge of its own rather than part of the help, which
+	// would change between scrapes on a reload.
+	window := newGaugeSet("tasks_failed_window_seconds", "How long a failed task is counted by tasks_failed_recent.", nil)
+	window.add(nil, c.cfg.SwarmMetricsFailedWindow.Seconds())
+	failed := newGaugeSet("tasks_failed_recent", "Tasks that failed within the failed window.", dims)
+	if len(dims) == 0 {
+		failed.add(nil, 0)
+	}
+	for _, f := range c.failed {
+		if _, ok := services[f.serviceID]; ok {
+			failed.add(objectLabels(f.serviceID, f.nodeID, true), 1)
+		}
+	}
+
+	for _, g := range []*gaugeSet{desired, running, status, tasks, nodeGauge, managers, failed, window} {
+		g.collect(ch)
+	}
+}
+
+// gaugeSet sums values into the series of one gauge by label values.
+type gaugeSet struct {
+	desc   *prometheus.Desc
+	series map[string]*gaugeSeries
+}
+
+type gaugeSeries struct {
+	labels []string
+	value  float64
+}
+
+func newGaugeSet(name, help string, labels []string) *gaugeSet {
+	return &gaugeSet{
+		desc:   prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "swarm", name), help, labels, nil),
+		series: make(map[string]*gaugeSeries),
+	}
+}
+
+// add adds v to the series with the given label values.
+func (g *gaugeSet) add(labels []string, v float64) {
+	key := strings.Join(labels, "\xff")
+	s, ok := g.series[key]
+	if !ok {
+		s = &gaugeSeries{labels: labels}
+		g.series[key] = s
+	}
+	s.value += v
+}
+
+func (g *gaugeSet) collect(ch chan<- prometheus.Metric) {
+	for _, s := range g.series {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, s.value, s.labels...)
+	}
+}
@@ -0,0 +1,181 @@
+package docker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/moby/moby/api/types/swarm"
+)
+
+func swarmStateFixture(c *swarmStateCollector, cfg *config.Config) {
+	replicas := uint64(3)
+	web := namedService("web", "app_web", map[string]string{stackNamespaceLabel: "app"})
+	web.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+	agent := namedService("agent", "ops_agent", map[string]string{stackNamespaceLabel: "ops"})
+	agent.Spec.Mode.Global = &swarm.GlobalService{}
+	services := []swarm.Service{web, agent}
+
+	nodes := []swarm.Node{
+		{ID: "n1", Description: swarm.NodeDescription{Hostname: "mgr1"},
+			Spec:          swarm.NodeSpec{Role: swarm.NodeRoleManager, Availability: swarm.NodeAvailabilityActive},
+			Status:        swarm.NodeStatus{State: swarm.NodeStateReady},
+			ManagerStatus: &swarm.ManagerStatus{Reachability: swarm.ReachabilityReachable}},
+		{ID: "n2", Description: swarm.NodeDescription{Hostname: "wrk1"},
+			Spec:   swarm.NodeSpec{Role: swarm.NodeRoleWorker, Availability: swarm.NodeAvailabilityDrain},
+			Status: swarm.NodeStatus{State: swarm.NodeStateDown}},
+	}
+	running := func(id, service, node string) swarm.Task {
+		return swarm.Task{ID: id, ServiceID: service, NodeID: node, DesiredState: swarm.TaskStateRunning,
+			Status: swarm.TaskStatus{State: swarm.TaskStateRunning}}
+	}
+	tasks := []swarm.Task{
+		running("w1", "web", "n1"),
+		running("w2", "web", "n2"),
+		{ID: "w3", ServiceID: "web", NodeID: "n1", DesiredState: swarm.TaskStateRunning,
+			Status: swarm.TaskStatus{State: swarm.TaskStatePending}},
+		{ID: "w0", ServiceID: "web", NodeID: "n1", DesiredState: swarm.TaskStateShutdown,
+			Status: swarm.TaskStatus{State: swarm.TaskStateFailed, Timestamp: time.Now()}},
+		running("a1", "agent", "n1"),
+	}
+
+	_, rules := sanitizeServices(services, cfg)
+	c.setStructural(cfg, nodes, services, rules)
+	c.setTasks(cfg, tasks)
+	c.setStatuses(serviceStatuses(services, tasks, rules))
+}
+
+// gaugeTotal sums the series of the named gauge collected from c.
+func gaugeTotal(t *testing.T, c prometheus.Collector, name string) float64 {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, f := range families {
+		if f.GetName() == name {
+			for _, m := range f.GetMetric() {
+				total += m.GetGauge().GetValue()
+			}
+		}
+	}
+	return total
+}
+
+func TestSwarmStateCollector(t *testing.T) {
+	cfg := &config.Config{SwarmMetricsLabels: []string{"stack", "service", "node"}, SwarmMetricsFailedWindow: time.Minute}
+	var c swarmStateCollector
+	swarmStateFixture(&c, cfg)
+
+	want := `
+# HELP swarm_visualizer_swarm_service_replicas_desired Replicas the services are meant to run.
+# TYPE swarm_visualizer_swarm_service_replicas_desired gauge
+swarm_visualizer_swarm_service_replicas_desired{service="app_web",stack="app"} 3
+swarm_visualizer_swarm_service_replicas_desired{service="ops_agent",stack="ops"} 1
+# HELP swarm_visualizer_swarm_service_replicas_running Replicas of the services that are running.
+# TYPE swarm_visualizer_swarm_service_replicas_running gauge
+swarm_visualizer_swarm_service_replicas_running{service="app_web",stack="app"} 2
+swarm_visualizer_swarm_service_replicas_running{service="ops_agent",stack="ops"} 1
+# HELP swarm_visualizer_swarm_service_status Services by status.
+# TYPE swarm_visualizer_swarm_service_status gauge
+swarm_visualizer_swarm_service_status{service="app_web",stack="app",status="degraded"} 1
+swarm_visualizer_swarm_service_status{service="ops_agent",stack="ops",status="healthy"} 1
+# HELP swarm_visualizer_swarm_managers Managers by reachability.
+# TYPE swarm_visualizer_swarm_managers gauge
+swarm_visualizer_swarm_managers{node="mgr1",reachability="reachable"} 1
+# HELP swarm_visualizer_swarm_nodes Nodes by role, availability, and status.
+# TYPE swarm_visualizer_swarm_nodes gauge
+swarm_visualizer_swarm_nodes{availability="active",node="mgr1",role="manager",status="ready"} 1
+swarm_visualizer_swarm_nodes{availability="drain",node="wrk1",role="worker",status="down"} 1
+# HELP swarm_visualizer_swarm_tasks_failed_recent Tasks that failed within the failed window.
+# TYPE swarm_visualizer_swarm_tasks_failed_recent gauge
+swarm_visualizer_swarm_tasks_failed_recent{node="mgr1",service="app_web",stack="app"} 1
+# HELP swarm_visualizer_swarm_tasks_failed_window_seconds How long a failed task is counted by tasks_failed_recent.
+# TYPE swarm_visualizer_swarm_tasks_failed_window_seconds gauge
+swarm_visualizer_swarm_tasks_failed_window_seconds 60
+`
+	names := []string{
+		"swarm_visualizer_swarm_service_replicas_desired",
+		"swarm_visualizer_swarm_service_replicas_running",
+		"swarm_visualizer_swarm_service_status",
+		"swarm_visualizer_swarm_managers",
+		"swarm_visualizer_swarm_nodes",
+		"swarm_visualizer_swarm_tasks_failed_recent",
+		"swarm_visualizer_swarm_tasks_failed_window_seconds",
+	}
+	if err := testutil.CollectAndCompare(&c, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+
+	// Tasks are broken down by state and every dimension.
+	if n := testutil.CollectAndCount(&c, "swarm_visualizer_swarm_tasks"); n != 5 {
+		t.Errorf("got %d task series, want 5", n)
+	}
+}
+
+func TestSwarmStateCollector_NoLabels(t *testing.T) {
+	cfg := &config.Config{SwarmMetricsFailedWindow: time.Minute}
+	var c swarmStateCollector
+	swarmStateFixture(&c, cfg)
+
+	want := `
+# HELP swarm_visualizer_swarm_service_replicas_desired Replicas the services are meant to run.
+# TYPE swarm_visualizer_swarm_service_replicas_desired gauge
+swarm_visualizer_swarm_service_replicas_desired 4
+# HELP swarm_visualizer_swarm_service_status Services by status.
+# TYPE swarm_visualizer_swarm_service_status gauge
+swarm_visualizer_swarm_service_status{status="completed"} 0
+swarm_visualizer_swarm_service_status{status="degraded"} 1
+swarm_visualizer_swarm_service_status{status="failing"} 0
+swarm_visualizer_swarm_service_status{status="healthy"} 1
+swarm_visualizer_swarm_service_status{status="paused"} 0
+swarm_visualizer_swarm_service_status{status="updating"} 0
+# HELP swarm_visualizer_swarm_tasks Tasks by state.
+# TYPE swarm_visualizer_swarm_tasks gauge
+swarm_visualizer_swarm_tasks{state="failed"} 1
+swarm_visualizer_swarm_tasks{state="pending"} 1
+swarm_visualizer_swarm_tasks{state="running"} 3
+# HELP swarm_visualizer_swarm_nodes Nodes by role, availability, and status.
+# TYPE swarm_visualizer_swarm_nodes gauge
+swarm_visualizer_swarm_nodes{availability="active",role="manager",status="ready"} 1
+swarm_visualizer_swarm_nodes{availability="drain",role="worker",status="down"} 1
+`
+	names := []string{
+		"swarm_visualizer_swarm_service_replicas_desired",
+		"swarm_visualizer_swarm_service_status",
+		"swarm_visualizer_swarm_tasks",
+		"swarm_visualizer_swarm_nodes",
+	}
+	if err := testutil.CollectAndCompare(&c, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSwarmStateCollector_FailedWindowExpires(t *testing.T) {
+	cfg := &config.Config{SwarmMetricsFailedWindow: time.Minute}
+	var c swarmStateCollector
+	swarmStateFixture(&c, cfg)
+
+	// Recorded once seen, the failure is still counted after the task ages
+	// out of the snapshot.
+	c.setTasks(cfg, nil)
+	if got := gaugeTotal(t, &c, "swarm_visualizer_swarm_tasks_failed_recent"); got != 1 {
+		t.Fatalf("failed tasks = %v, want 1", got)
+	}
+
+	for id, f := range c.failed {
+		f.at = f.at.Add(-2 * time.Minute)
+		c.failed[id] = f
+	}
+	c.setTasks(cfg, nil)
+	if got := gaugeTotal(t, &c, "swarm_visualizer_swarm_tasks_failed_recent"); got != 0 {
+		t.Errorf("failed tasks = %v after the window, want 0", got)
+	}
+}
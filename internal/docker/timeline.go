@@ -0,0 +1,394 @@
+package docker
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moby/moby/api/types/swarm"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+)
+
+// Change kinds.
+const (
+	ChangeServiceCreated   = "service_created"
+	ChangeServiceUpdated   = "service_updated"
+	ChangeServiceRemoved   = "service_removed"
+	ChangeServiceScaled    = "service_scaled"
+	ChangeTaskState        = "task_state"
+	ChangeNodeState        = "node_state"
+	ChangeNodeAvailability = "node_availability"
+	ChangeNodeRole         = "node_role"
+	ChangeNetworkCreated   = "network_created"
+	ChangeNetworkRemoved   = "network_removed"
+)
+
+// Change is a change to the swarm found between consecutive snapshots.
+type Change struct {
+	// ID numbers the changes, from 1, for the life of the server.
+	ID uint64 `json:"id"`
+	// Sequence is the snapshot the change was first published in.
+	Sequence uint64    `json:"sequence"`
+	Time     time.Time `json:"time"`
+	// Kind is one of the Change kinds.
+	Kind string `json:"kind"`
+	// ObjectID and Name identify the service, task, node, or network that
+	// changed.
+	ObjectID string `json:"objectId"`
+	Name     string `json:"name,omitempty"`
+	// Service is a task's service, and Stack a service's or task's stack.
+	Service string `json:"service,omitempty"`
+	Stack   string `json:"stack,omitempty"`
+	// From and To are the old and new replica count, task state, or node
+	// status, availability, or role. From is empty for a new task.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Fields are the spec fields of an updated service that changed, as
+	// dotted paths such as "TaskTemplate.ContainerSpec.Image".
+	Fields []string `json:"fields,omitempty"`
+	// Error is a task's error.
+	Error string `json:"error,omitempty"`
+	// Message describes the change in a sentence.
+	Message string `json:"message"`
+}
+
+// changesMessage is the WebSocket message carrying new changes. Snapshot
+// messages carry no type.
+type changesMessage struct {
+	Type    string   `json:"type"`
+	Changes []Change `json:"changes"`
+}
+
+// timeline keeps the latest changes in a ring buffer for the activity feed.
+// It is safe for concurrent use.
+type timeline struct {
+	mu sync.Mutex
+	// ring holds the latest changes, the oldest at ring[head] once it is
+	// full. It keeps nothing when its capacity is zero.
+	ring []Change
+	head int
+	// lastID is the ID of the latest change.
+	lastID uint64
+}
+
+// newTimeline returns a timeline keeping the latest size changes.
+func newTimeline(size int) *timeline {
+	return &timeline{ring: make([]Change, 0, max(size, 0))}
+}
+
+// add numbers changes, keeps them, evicting the oldest once the timeline is
+// full, and returns them numbered.
+func (t *timeline) add(changes []Change) []Change {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range changes {
+		t.lastID++
+		changes[i].ID = t.lastID
+		switch {
+		case cap(t.ring) == 0:
+		case len(t.ring) < cap(t.ring):
+			t.ring = append(t.ring, changes[i])
+		default:
+			t.ring[t.head] = changes[i]
+			t.head = (t.head + 1) % len(t.ring)
+		}
+	}
+	return changes
+}
+
+// since returns the kept changes after the ID after, oldest first, at most
+// the latest limit of them when limit is positive.
+func (t *timeline) since(after uint64, limit int) []Change {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]Change, 0, len(t.ring))
+	for i := range t.ring {
+		if c := t.ring[(t.head+i)%len(t.ring)]; c.ID > after {
+			out = append(out, c)
+		}
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+// handleChanges serves the kept changes, oldest first. The since query
+// parameter returns only those after a change ID, and limit only the latest
+// so many. When authentication is enabled, it requires a valid token, as the
+// WebSocket does.
+func (h *Hub) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeGet(w, r) {
+		return
+	}
+	var since uint64
+	var limit int
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("since %q must be a change ID", v), http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("limit %q must be a positive number", v), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, changesMessage{Type: "changes", Changes: h.timeline.since(since, limit)})
+}
+
+// changeDiffer finds the changes between consecutive published snapshots.
+// It compares their sanitized data, so a change reveals nothing a snapshot
+// does not. It is only used from the single inspectSwarmServices goroutine.
+type changeDiffer struct {
+	cfg  *config.Config
+	last *snapshotIndex
+}
+
+// observe returns the changes from the last snapshot to data, published at
+// now under cfg. The first snapshot, and the first under a reloaded
+// configuration, which may show or hide whole stacks, only set the baseline.
+func (d *changeDiffer) observe(cfg *config.Config, data *SwarmData, now time.Time) []Change {
+	next := newSnapshotIndex(data)
+	prev := d.last
+	d.last = next
+	if cfg != d.cfg || prev == nil {
+		d.cfg = cfg
+		return nil
+	}
+	changes := diffSnapshots(prev, next)
+	for i := range changes {
+		changes[i].Sequence, changes[i].Time = data.Sequence, now
+	}
+	return changes
+}
+
+// snapshotIndex is what changes are found from in a snapshot, by ID. The
+// orders keep the changes in the order the Docker API lists the objects.
+type snapshotIndex struct {
+	services     map[string]serviceEntry
+	serviceOrder []string
+	tasks        map[string]taskEntry
+	taskOrder    []string
+	nodes        map[string]nodeEntry
+	nodeOrder    []string
+	networks     map[string]string
+	networkOrder []string
+}
+
+type serviceEntry struct {
+	name, stack string
+	// spec is the service's spec as JSON, and replicas its replica count,
+	// or nil for a global service.
+	spec     []byte
+	replicas *uint64
+}
+
+type taskEntry struct {
+	name, service, stack string
+	state                swarm.TaskState
+	err                  string
+}
+
+type nodeEntry struct {
+	name         string
+	state        swarm.NodeState
+	availability swarm.NodeAvailability
+	role         swarm.NodeRole
+}
+
+// newSnapshotIndex indexes data.
+func newSnapshotIndex(data *SwarmData) *snapshotIndex {
+	x := &snapshotIndex{
+		services: make(map[string]serviceEntry, len(data.Services)),
+		tasks:    make(map[string]taskEntry, len(data.Tasks)),
+		nodes:    make(map[string]nodeEntry, len(data.Nodes)),
+		networks: make(map[string]string, len(data.Networks)),
+	}
+	for _, s := range data.Services {
+		e := serviceEntry{name: cmp.Or(s.Spec.Name, s.ID), stack: s.Spec.Labels[stackNamespaceLabel]}
+		e.spec, _ = json.Marshal(s.Spec)
+		if r := s.Spec.Mode.Replicated; r != nil && r.Replicas != nil {
+			n := *r.Replicas
+			e.replicas = &n
+		}
+		x.services[s.ID] = e
+		x.serviceOrder = append(x.serviceOrder, s.ID)
+	}
+	for _, t := range data.Tasks {
+		svc := x.services[t.ServiceID]
+		service := cmp.Or(svc.name, t.ServiceID)
+		x.tasks[t.ID] = taskEntry{name: taskName(service, &t), service: service, stack: svc.stack,
+			state: t.Status.State, err: t.Status.Err}
+		x.taskOrder = append(x.taskOrder, t.ID)
+	}
+	for _, n := range data.Nodes {
+		x.nodes[n.ID] = nodeEntry{name: cmp.Or(n.Description.Hostname, n.ID), state: n.Status.State,
+			availability: n.Spec.Availability, role: n.Spec.Role}
+		x.nodeOrder = append(x.nodeOrder, n.ID)
+	}
+	for _, n := range data.Networks {
+		x.networks[n.ID] = cmp.Or(n.Name, n.ID)
+		x.networkOrder = append(x.networkOrder, n.ID)
+	}
+	return x
+}
+
+// reportedTaskStates are the task states a transition into is reported. The
+// scheduling states a task passes through on its way to running are left out,
+// so a deployment is not reported several times over for every task.
+var reportedTaskStates = []swarm.TaskState{
+	swarm.TaskStateRunning,
+	swarm.TaskStateComplete,
+	swarm.TaskStateShutdown,
+	swarm.TaskStateFailed,
+	swarm.TaskStateRejected,
+	swarm.TaskStateRemove,
+	swarm.TaskStateOrphaned,
+}
+
+// diffSnapshots returns the changes from prev to next: services created,
+// updated, scaled, or removed, task state transitions, node status,
+// availability, and role changes, and networks created or removed.
+func diffSnapshots(prev, next *snapshotIndex) []Change {
+	var changes []Change
+
+	for _, id := range next.serviceOrder {
+		s := next.services[id]
+		old, ok := prev.services[id]
+		if !ok {
+			changes = append(changes, Change{Kind: ChangeServiceCreated, ObjectID: id, Name: s.name, Stack: s.stack,
+				Message: fmt.Sprintf("Service %s was created", s.name)})
+			continue
+		}
+		if old.replicas != nil && s.replicas != nil && *old.replicas != *s.replicas {
+			changes = append(changes, Change{Kind: ChangeServiceScaled, ObjectID: id, Name: s.name, Stack: s.stack,
+				From: strconv.FormatUint(*old.replicas, 10), To: strconv.FormatUint(*s.replicas, 10),
+				Message: fmt.Sprintf("Service %s was scaled from %d to %d replicas", s.name, *old.replicas, *s.replicas)})
+		}
+		if string(old.spec) == string(s.spec) {
+			continue
+		}
+		fields := slices.DeleteFunc(specFields(old.spec, s.spec), func(f string) bool { return f == "Mode.Replicated.Replicas" })
+		if len(fields) > 0 {
+			changes = append(changes, Change{Kind: ChangeServiceUpdated, ObjectID: id, Name: s.name, Stack: s.stack, Fields: fields,
+				Message: fmt.Sprintf("Service %s was updated: %s", s.name, strings.Join(fields, ", "))})
+		}
+	}
+	for _, id := range removed(prev.serviceOrder, next.services) {
+		s := prev.services[id]
+		changes = append(changes, Change{Kind: ChangeServiceRemoved, ObjectID: id, Name: s.name, Stack: s.stack,
+			Message: fmt.Sprintf("Service %s was removed", s.name)})
+	}
+
+	for _, id := range next.taskOrder {
+		t := next.tasks[id]
+		old, ok := prev.tasks[id]
+		if (ok && old.state == t.state) || !slices.Contains(reportedTaskStates, t.state) {
+			continue
+		}
+		c := Change{Kind: ChangeTaskState, ObjectID: id, Name: t.name, Service: t.service, Stack: t.stack,
+			From: string(old.state), To: string(t.state), Error: t.err,
+			Message: withReason(fmt.Sprintf("Task %s is %s", t.name, t.state), t.err)}
+		changes = append(changes, c)
+	}
+
+	for _, id := range next.nodeOrder {
+		n := next.nodes[id]
+		old, ok := prev.nodes[id]
+		if !ok {
+			continue
+		}
+		if old.state != n.state {
+			changes = append(changes, Change{Kind: ChangeNodeState, ObjectID: id, Name: n.name,
+				From: string(old.state), To: string(n.state),
+				Message: fmt.Sprintf("Node %s is %s", n.name, n.state)})
+		}
+		if old.availability != n.availability {
+			changes = append(changes, Change{Kind: ChangeNodeAvailability, ObjectID: id, Name: n.name,
+				From: string(old.availability), To: string(n.availability),
+				Message: fmt.Sprintf("Node %s availability changed from %s to %s", n.name, old.availability, n.availability)})
+		}
+		if old.role != n.role {
+			changes = append(changes, Change{Kind: ChangeNodeRole, ObjectID: id, Name: n.name,
+				From: string(old.role), To: string(n.role),
+				Message: fmt.Sprintf("Node %s is now a %s", n.name, n.role)})
+		}
+	}
+
+	for _, id := range next.networkOrder {
+		if _, ok := prev.networks[id]; !ok {
+			changes = append(changes, Change{Kind: ChangeNetworkCreated, ObjectID: id, Name: next.networks[id],
+				Message: fmt.Sprintf("Network %s was created", next.networks[id])})
+		}
+	}
+	for _, id := range removed(prev.networkOrder, next.networks) {
+		changes = append(changes, Change{Kind: ChangeNetworkRemoved, ObjectID: id, Name: prev.networks[id],
+			Message: fmt.Sprintf("Network %s was removed", prev.networks[id])})
+	}
+	return changes
+}
+
+// removed returns the IDs of order missing from next.
+func removed[V any](order []string, next map[string]V) []string {
+	var ids []string
+	for _, id := range order {
+		if _, ok := next[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// specFields returns the dotted paths of the fields that differ between two
+// specs given as JSON. Objects are compared field by field; arrays and
+// values are compared whole, so a changed environment variable is reported
+// as "TaskTemplate.ContainerSpec.Env" rather than by its index.
+func specFields(old, next []byte) []string {
+	var a, b any
+	if json.Unmarshal(old, &a) != nil || json.Unmarshal(next, &b) != nil {
+		return nil
+	}
+	var fields []string
+	var walk func(path string, a, b any)
+	walk = func(path string, a, b any) {
+		am, aok := a.(map[string]any)
+		bm, bok := b.(map[string]any)
+		if !aok || !bok {
+			if !reflect.DeepEqual(a, b) {
+				fields = append(fields, path)
+			}
+			return
+		}
+		keys := slices.Collect(maps.Keys(am))
+		for k := range bm {
+			if _, ok := am[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			walk(p, am[k], bm[k])
+		}
+	}
+	walk("", a, b)
+	return fields
+}
@@ -0,0 +1,262 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/api/types/swarm"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+)
+
+// changeIDs returns the IDs of changes.
+func changeIDs(changes []Change) []uint64 {
+	var ids []uint64
+	for _, c := range changes {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func TestTimeline_KeepsLatest(t *testing.T) {
+	tl := newTimeline(3)
+	tl.add(make([]Change, 2))
+	if got := changeIDs(tl.add(make([]Change, 3))); !slices.Equal(got, []uint64{3, 4, 5}) {
+		t.Fatalf("added IDs = %v, want [3 4 5]", got)
+	}
+
+	tests := []struct {
+		since uint64
+		limit int
+		want  []uint64
+	}{
+		{0, 0, []uint64{3, 4, 5}},
+		{3, 0, []uint64{4, 5}},
+		{5, 0, nil},
+		{0, 2, []uint64{4, 5}},
+	}
+	for _, tt := range tests {
+		if got := changeIDs(tl.since(tt.since, tt.limit)); !slices.Equal(got, tt.want) {
+			t.Errorf("since(%d, %d) = %v, want %v", tt.since, tt.limit, got, tt.want)
+		}
+	}
+
+	none := newTimeline(0)
+	if got := changeIDs(none.add(make([]Change, 2))); !slices.Equal(got, []uint64{1, 2}) || len(none.since(0, 0)) != 0 {
+		t.Errorf("empty timeline numbered %v and kept %v, want [1 2] and nothing", got, none.since(0, 0))
+	}
+}
+
+// timelineFixture is a swarm of a replicated service with one task, a node,
+// and a network.
+func timelineFixture() SwarmData {
+	replicas := uint64(1)
+	web := namedService("web", "app_web", map[string]string{stackNamespaceLabel: "app"})
+	web.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+	web.Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{Image: "nginx:1"}
+	return SwarmData{
+		Services: []swarm.Service{web},
+		Tasks: []swarm.Task{{ID: "t1", ServiceID: "web", NodeID: "n1", Slot: 1,
+			Status: swarm.TaskStatus{State: swarm.TaskStateRunning}}},
+		Nodes: []swarm.Node{{ID: "n1", Description: swarm.NodeDescription{Hostname: "wrk1"},
+			Spec:   swarm.NodeSpec{Role: swarm.NodeRoleWorker, Availability: swarm.NodeAvailabilityActive},
+			Status: swarm.NodeStatus{State: swarm.NodeStateReady}}},
+		Networks: []network.Summary{{Network: network.Network{ID: "net1", Name: "app_default"}}},
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(d *SwarmData)
+		want   []Change
+	}{
+		{
+			name:   "no change",
+			change: func(*SwarmData) {},
+		},
+		{
+			name: "service updated and scaled",
+			change: func(d *SwarmData) {
+				replicas := uint64(3)
+				d.Services[0].Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+				d.Services[0].Spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{Image: "nginx:2", Env: []string{"A=1"}}
+			},
+			want: []Change{
+				{Kind: ChangeServiceScaled, ObjectID: "web", Name: "app_web", Stack: "app", From: "1", To: "3",
+					Message: "Service app_web was scaled from 1 to 3 replicas"},
+				{Kind: ChangeServiceUpdated, ObjectID: "web", Name: "app_web", Stack: "app",
+					Fields:  []string{"TaskTemplate.ContainerSpec.Env", "TaskTemplate.ContainerSpec.Image"},
+					Message: "Service app_web was updated: TaskTemplate.ContainerSpec.Env, TaskTemplate.ContainerSpec.Image"},
+			},
+		},
+		{
+			name: "service created and removed",
+			change: func(d *SwarmData) {
+				d.Services = []swarm.Service{namedService("api", "app_api", nil)}
+				d.Tasks = nil
+			},
+			want: []Change{
+				{Kind: ChangeServiceCreated, ObjectID: "api", Name: "app_api", Message: "Service app_api was created"},
+				{Kind: ChangeServiceRemoved, ObjectID: "web", Name: "app_web", Stack: "app", Message: "Service app_web was removed"},
+			},
+		},
+		{
+			name: "task failed and replaced",
+			change: func(d *SwarmData) {
+				d.Tasks[0].Status = swarm.TaskStatus{State: swarm.TaskStateFailed, Err: "task: non-zero exit (1)"}
+				d.Tasks = append(d.Tasks,
+					swarm.Task{ID: "t2", ServiceID: "web", Slot: 1, Status: swarm.TaskStatus{State: swarm.TaskStatePending}})
+			},
+			want: []Change{
+				{Kind: ChangeTaskState, ObjectID: "t1", Name: "app_web.1", Service: "app_web", Stack: "app",
+					From: "running", To: "failed", Error: "task: non-zero exit (1)",
+					Message: "Task app_web.1 is failed: task: non-zero exit (1)"},
+			},
+		},
+		{
+			name: "node drained and promoted",
+			change: func(d *SwarmData) {
+				d.Nodes[0].Status.State = swarm.NodeStateDown
+				d.Nodes[0].Spec.Availability = swarm.NodeAvailabilityDrain
+				d.Nodes[0].Spec.Role = swarm.NodeRoleManager
+			},
+			want: []Change{
+				{Kind: ChangeNodeState, ObjectID: "n1", Name: "wrk1", From: "ready", To: "down", Message: "Node wrk1 is down"},
+				{Kind: ChangeNodeAvailability, ObjectID: "n1", Name: "wrk1", From: "active", To: "drain",
+					Message: "Node wrk1 availability changed from active to drain"},
+				{Kind: ChangeNodeRole, ObjectID: "n1", Name: "wrk1", From: "worker", To: "manager", Message: "Node wrk1 is now a manager"},
+			},
+		},
+		{
+			name: "network replaced",
+			change: func(d *SwarmData) {
+				d.Networks = []network.Summary{{Network: network.Network{ID: "net2", Name: "app_backend"}}}
+			},
+			want: []Change{
+				{Kind: ChangeNetworkCreated, ObjectID: "net2", Name: "app_backend", Message: "Network app_backend was created"},
+				{Kind: ChangeNetworkRemoved, ObjectID: "net1", Name: "app_default", Message: "Network app_default was removed"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prev, next := timelineFixture(), timelineFixture()
+			tt.change(&next)
+			got := diffSnapshots(newSnapshotIndex(&prev), newSnapshotIndex(&next))
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("changes =\n%s\nwant\n%s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestChangeDiffer_Baselines(t *testing.T) {
+	cfg := &config.Config{}
+	var d changeDiffer
+	data := timelineFixture()
+	if changes := d.observe(cfg, &data, time.Now()); changes != nil {
+		t.Fatalf("first snapshot changes = %+v, want none", changes)
+	}
+
+	data.Sequence = 7
+	data.Nodes[0].Status.State = swarm.NodeStateDown
+	now := time.Now()
+	changes := d.observe(cfg, &data, now)
+	if len(changes) != 1 || changes[0].Sequence != 7 || !changes[0].Time.Equal(now) {
+		t.Fatalf("changes = %+v, want the node down in snapshot 7", changes)
+	}
+
+	// A reload may hide whole stacks, which is not a change to the swarm.
+	data.Services = nil
+	if changes := d.observe(&config.Config{}, &data, now); changes != nil {
+		t.Errorf("changes after a reload = %+v, want none", changes)
+	}
+}
+
+func TestHub_PublishChanges(t *testing.T) {
+	h := newHub(&config.Config{TimelineSize: 10}, nil)
+	c := &wsClient{send: make(chan []byte, 1), changes: make(chan []byte, changeQueueSize)}
+	if !h.register(c) {
+		t.Fatal("expected the client to register")
+	}
+	runHub(t, h)
+
+	h.PublishChanges(context.Background(), []Change{{Kind: ChangeNodeState, Message: "Node wrk1 is down"}})
+	select {
+	case msg := <-c.changes:
+		var got changesMessage
+		if err := json.Unmarshal(msg, &got); err != nil || got.Type != "changes" || len(got.Changes) != 1 || got.Changes[0].ID != 1 {
+			t.Errorf("message = %s, want the change numbered 1", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("changes were not sent to the client")
+	}
+	if len(c.send) != 0 || h.Ready() {
+		t.Error("changes were sent as a snapshot")
+	}
+	if got := h.timeline.since(0, 0); len(got) != 1 {
+		t.Errorf("timeline = %+v, want the change kept", got)
+	}
+}
+
+func TestHandleChanges(t *testing.T) {
+	h := newHub(&config.Config{TimelineSize: 10}, nil)
+	h.timeline.add(make([]Change, 3))
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantIDs    []uint64
+	}{
+		{"", http.StatusOK, []uint64{1, 2, 3}},
+		{"?since=1", http.StatusOK, []uint64{2, 3}},
+		{"?limit=1", http.StatusOK, []uint64{3}},
+		{"?since=x", http.StatusBadRequest, nil},
+		{"?limit=0", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.handleChanges(rec, httptest.NewRequest(http.MethodGet, "/api/changes"+tt.query, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got changesMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if ids := changeIDs(got.Changes); !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("IDs = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.handleChanges(rec, httptest.NewRequest(http.MethodPost, "/api/changes", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rec.Code)
+	}
+
+	authed := newHub(&config.Config{AuthEnabled: true}, func(*http.Request) (jwt.MapClaims, error) {
+		return nil, errors.New("no token")
+	})
+	rec = httptest.NewRecorder()
+	authed.handleChanges(rec, httptest.NewRequest(http.MethodGet, "/api/changes", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated status = %d, want 401", rec.Code)
+	}
+}
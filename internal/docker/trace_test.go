@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+)
+
+var (
+	spanExporter        = tracetest.NewInMemoryExporter()
+	installSpanRecorder = sync.OnceFunc(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+	})
+)
+
+// recordSpans installs, once, a tracer provider recording spans in memory and
+// clears the spans recorded so far. The package tracer binds to the first
+// provider installed, so every test shares it.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	installSpanRecorder()
+	spanExporter.Reset()
+	t.Cleanup(spanExporter.Reset)
+	return spanExporter
+}
+
+// TestInspect_Traces verifies that a poll is traced as one trace of its
+// Docker API calls, sanitization, and publish, with the fan-out a child of the
+// publish.
+func TestInspect_Traces(t *testing.T) {
+	spans := recordSpans(t)
+
+	cfg := &config.Config{ClusterName: "traced"}
+	cfgs := config.NewHolder(cfg)
+	h := newHub(cfg, nil)
+	runHub(t, h)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		inspectSwarmServices(ctx, cfgs, fakeSource{}, h, nil)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	byName := func() map[string]tracetest.SpanStub {
+		named := make(map[string]tracetest.SpanStub)
+		for _, s := range spans.GetSpans() {
+			if _, seen := named[s.Name]; !seen {
+				named[s.Name] = s
+			}
+		}
+		return named
+	}
+	// The poll span ends last of the trace but for the fan-out, which runs
+	// concurrently.
+	recorded := func() bool {
+		named := byName()
+		_, polled := named["poll all"]
+		_, fanned := named["fan-out"]
+		return polled && fanned
+	}
+	if !waitFor(t, recorded, 2*time.Second) {
+		t.Fatalf("poll and fan-out spans not recorded: %v", byName())
+	}
+
+	named := byName()
+	poll := named["poll all"]
+	if !poll.SpanContext.IsValid() {
+		t.Fatal("no poll span recorded")
+	}
+	for _, name := range []string{
+		"docker.list nodes", "docker.list services", "docker.list networks", "docker.list tasks",
+		"sanitize nodes", "sanitize services", "sanitize networks", "sanitize tasks", "publish",
+	} {
+		s, ok := named[name]
+		if !ok {
+			t.Errorf("no %q span recorded", name)
+			continue
+		}
+		if s.SpanContext.TraceID() != poll.SpanContext.TraceID() {
+			t.Errorf("%q span is not in the poll's trace", name)
+		}
+	}
+	publish := named["publish"]
+	if got := named["sanitize snapshot"].Parent.SpanID(); got != publish.SpanContext.SpanID() {
+		t.Errorf("sanitize snapshot parent = %v, want the publish span", got)
+	}
+	if got := named["fan-out"].Parent.SpanID(); got != publish.SpanContext.SpanID() {
+		t.Errorf("fan-out parent = %v, want the publish span", got)
+	}
+}
@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -60,13 +61,13 @@ func TestWS_AuthRejectsUnauthorized(t *testing.T) {
 func TestWS_AuthAcceptsAndDelivers(t *testing.T) {
 	cfg := &config.Config{ContextRoot: "/", AuthEnabled: true, OAuthConfig: config.OAuthConfig{UsernameClaim: "sub"}}
 	h := newHub(cfg, bearerValidator)
-	go h.runBroadcasts()
+	runHub(t, h)
 	_, wsURL := wsServer(t, h)
 
 	// Publish a frame and wait until it has been fanned out, so a newly
 	// connecting client is seeded with it.
 	frame := []byte(`{"clusterName":"x"}`)
-	h.Publish(frame)
+	h.Publish(context.Background(), frame)
 	if !waitFor(t, h.Ready, time.Second) {
 		t.Fatal("frame was never fanned out")
 	}
@@ -87,4 +88,18 @@ func TestWS_AuthAcceptsAndDelivers(t *testing.T) {
 	if string(msg) != string(frame) {
 		t.Fatalf("got %q, want %q", msg, frame)
 	}
+
+	// The frame is counted once its write returns, which may be after the
+	// client has read it.
+	sent := func() bool {
+		infos := h.Clients()
+		return len(infos) == 1 && infos[0].FramesSent == 1
+	}
+	if !waitFor(t, sent, time.Second) {
+		t.Fatalf("Clients() = %+v, want one client with one frame sent", h.Clients())
+	}
+	info := h.Clients()[0]
+	if info.BytesSent != uint64(len(frame)) || info.RemoteAddr == "" || info.User != "alice" {
+		t.Errorf("client = %+v, want %d bytes sent, its remote address, and user alice", info, len(frame))
+	}
 }
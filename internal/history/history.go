@@ -0,0 +1,560 @@
+// Package history keeps past snapshots of the swarm in an embedded bbolt
+// database, so the swarm can be reconstructed as it was at any time within
+// the retention.
+//
+// Snapshots are JSON objects whose groups, arrays of objects with an ID, are
+// stored as deltas: every so often a keyframe holds a whole snapshot, and
+// each snapshot after it holds only the objects added, changed, or removed
+// since the one before. Entries are keyed by the time of their snapshot and
+// stored gzipped. The oldest entry is always a keyframe, so pruning whole
+// keyframes with their deltas keeps every remaining snapshot reconstructable.
+//
+// The store keeps only snapshots sanitized under one set of settings, named
+// by a fingerprint. Snapshots recorded under another are dropped, and when
+// the fingerprint changes the whole history is deleted, so nothing sanitized
+// under earlier settings can be read back under later ones.
+package history
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+)
+
+// ErrNotFound is returned when no snapshot was stored at or before a time.
+var ErrNotFound = errors.New("no snapshot stored at or before that time")
+
+// ErrCleared is returned by Replay when the history is deleted during the
+// replay, on a change of fingerprint.
+var ErrCleared = errors.New("the history was cleared")
+
+// Options configure a Store.
+type Options struct {
+	// Groups are the top-level keys of a snapshot stored as deltas. Their
+	// objects are told apart by their "ID" or "Id".
+	Groups []string
+	// Retention and MaxBytes bound the history kept by age and by the size
+	// of the stored entries. A little more is kept than either allows, as
+	// entries are pruned a keyframe at a time and the latest keyframe is
+	// never pruned.
+	Retention time.Duration
+	MaxBytes  int64
+	// KeyframeInterval is how often a whole snapshot is stored.
+	KeyframeInterval time.Duration
+	// Fingerprint names the settings the snapshots are sanitized under. A
+	// history stored under another is deleted when the store is opened.
+	Fingerprint string
+}
+
+// Entry kinds, the first byte of a stored value.
+const (
+	kindKeyframe = 'k'
+	kindDelta    = 'd'
+)
+
+var bucketName = []byte("snapshots")
+
+// metaBucket holds, under fingerprintKey, the fingerprint of the snapshots
+// stored.
+var (
+	metaBucket     = []byte("meta")
+	fingerprintKey = []byte("fingerprint")
+)
+
+// queueSize is how many snapshots may wait to be stored. When the writer
+// falls further behind, snapshots are dropped and the next is stored as a
+// keyframe, as a delta from a dropped one could not be applied.
+const queueSize = 64
+
+// pruneInterval is how often entries past the retention are pruned.
+const pruneInterval = time.Minute
+
+// replayBatch is how many entries Replay reads per transaction, so a slow
+// replay does not hold one open, which would stop the database from growing.
+const replayBatch = 256
+
+// Store is a history of snapshots. Record is safe for concurrent use, and so
+// are the readers.
+type Store struct {
+	db   *bolt.DB
+	opts Options
+
+	queue   chan record
+	done    chan struct{}
+	dropped atomic.Bool
+	closeMu sync.Mutex
+	closed  bool
+
+	// size is the total size of the stored values.
+	size atomic.Int64
+	// generation counts the times the history was deleted. It only changes
+	// within a write transaction, so one that sees it unchanged knows no
+	// entry it read before was deleted.
+	generation atomic.Uint64
+
+	// The fields below are only used by the writer goroutine. last is the
+	// snapshot last stored, which the next delta is taken from, or nil when
+	// the next must be a keyframe, and lastGeneration the generation it was
+	// stored in.
+	last           *snapshot
+	lastGeneration uint64
+	lastKey        uint64
+	lastKeyframe   time.Time
+	lastPrune      time.Time
+}
+
+type record struct {
+	at          time.Time
+	frame       []byte
+	fingerprint string
+}
+
+// Open opens, creating if need be, the store at path and starts its writer.
+// A history stored under a fingerprint other than opts.Fingerprint is
+// deleted. It fails if another process has the file open.
+func Open(path string, opts Options) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening the history at %s: %w", path, err)
+	}
+	s := &Store{db: db, opts: opts, queue: make(chan record, queueSize), done: make(chan struct{})}
+	var cleared bool
+	err = db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if cleared, err = s.setFingerprint(tx, opts.Fingerprint); err != nil {
+			return err
+		}
+		b := tx.Bucket(bucketName)
+		var size int64
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			size += int64(len(v))
+		}
+		s.size.Store(size)
+		if k, _ := c.Last(); k != nil {
+			s.lastKey = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening the history at %s: %w", path, err)
+	}
+	if cleared {
+		slog.Info("The sanitization settings changed, the history was cleared")
+	}
+	metrics.HistoryBytes.Set(float64(s.size.Load()))
+	go s.run()
+	return s, nil
+}
+
+// Record queues frame, the snapshot published at at and sanitized under the
+// settings named by fingerprint, to be stored. It never blocks; when the
+// writer is too far behind, the snapshot is dropped. So is a snapshot whose
+// fingerprint is not the store's when it comes to be stored.
+func (s *Store) Record(at time.Time, frame []byte, fingerprint string) {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- record{at: at, frame: frame, fingerprint: fingerprint}:
+	default:
+		s.dropped.Store(true)
+		metrics.HistoryWrites.WithLabelValues("dropped").Inc()
+	}
+}
+
+// SetFingerprint makes fingerprint the store's, deleting the history if it
+// was stored under another. Snapshots recorded under the old one that are
+// still queued are dropped.
+func (s *Store) SetFingerprint(fingerprint string) error {
+	var cleared bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		cleared, err = s.setFingerprint(tx, fingerprint)
+		return err
+	})
+	if cleared {
+		slog.Info("The sanitization settings changed, the history was cleared")
+	}
+	return err
+}
+
+// setFingerprint records fingerprint as the one the history is stored under
+// in tx, creating the buckets if need be. If another was recorded, it
+// deletes the history, reporting whether there were snapshots to delete.
+func (s *Store) setFingerprint(tx *bolt.Tx, fingerprint string) (bool, error) {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return false, err
+	}
+	b := tx.Bucket(bucketName)
+	if b != nil && string(meta.Get(fingerprintKey)) == fingerprint {
+		return false, nil
+	}
+	if err := meta.Put(fingerprintKey, []byte(fingerprint)); err != nil {
+		return false, err
+	}
+	cleared := b != nil && b.Stats().KeyN > 0
+	if b != nil {
+		if err := tx.DeleteBucket(bucketName); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.CreateBucket(bucketName); err != nil {
+		return false, err
+	}
+	s.size.Store(0)
+	s.generation.Add(1)
+	tx.OnCommit(func() { metrics.HistoryBytes.Set(0) })
+	return cleared, nil
+}
+
+// Close stores the queued snapshots and closes the database.
+func (s *Store) Close() error {
+	s.closeMu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.closeMu.Unlock()
+	<-s.done
+	return s.db.Close()
+}
+
+// run stores the queued snapshots and prunes the history until Close.
+func (s *Store) run() {
+	defer close(s.done)
+	for r := range s.queue {
+		if err := s.store(r); err != nil {
+			slog.Error("Storing a snapshot in the history failed", logging.Err(err))
+			s.last = nil
+		}
+		if s.size.Load() > s.opts.MaxBytes || time.Since(s.lastPrune) >= pruneInterval {
+			if err := s.prune(time.Now()); err != nil {
+				slog.Error("Pruning the history failed", logging.Err(err))
+			}
+			s.lastPrune = time.Now()
+		}
+	}
+}
+
+// store stores r as a keyframe or as a delta from the last snapshot stored.
+func (s *Store) store(r record) error {
+	next, err := parse(r.frame, s.opts.Groups)
+	if err != nil {
+		return err
+	}
+	if s.dropped.Swap(false) {
+		s.last = nil
+	}
+	generation := s.generation.Load()
+	if generation != s.lastGeneration {
+		// The snapshot last stored was deleted with the history.
+		s.last = nil
+	}
+
+	kind, body := byte(kindKeyframe), r.frame
+	keyframe := s.last == nil || !next.keyed || r.at.Sub(s.lastKeyframe) >= s.opts.KeyframeInterval
+	if !keyframe {
+		kind = kindDelta
+		if body, err = json.Marshal(diff(s.last, next, s.opts.Groups)); err != nil {
+			return err
+		}
+	}
+	value, err := compress(kind, body)
+	if err != nil {
+		return err
+	}
+
+	key := uint64(r.at.UnixNano())
+	if key <= s.lastKey {
+		// Keys must increase for the deltas to apply in order.
+		key = s.lastKey + 1
+	}
+	var stale bool
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		// Checked within the transaction, so the history cannot be deleted
+		// between the check and the write.
+		fingerprint := tx.Bucket(metaBucket).Get(fingerprintKey)
+		if stale = string(fingerprint) != r.fingerprint || s.generation.Load() != generation; stale {
+			return nil
+		}
+		return tx.Bucket(bucketName).Put(encodeKey(key), value)
+	})
+	if err != nil {
+		return err
+	}
+	if stale {
+		s.last = nil
+		metrics.HistoryWrites.WithLabelValues("dropped").Inc()
+		return nil
+	}
+	s.lastKey, s.lastGeneration = key, generation
+	if keyframe {
+		s.lastKeyframe = r.at
+		metrics.HistoryWrites.WithLabelValues("keyframe").Inc()
+	} else {
+		metrics.HistoryWrites.WithLabelValues("delta").Inc()
+	}
+	metrics.HistoryBytes.Set(float64(s.size.Add(int64(len(value)))))
+	s.last = nil
+	if next.keyed {
+		s.last = next
+	}
+	return nil
+}
+
+// prune deletes the keyframes, with their deltas, older than the retention
+// at now, and then the oldest until the history fits in MaxBytes. The latest
+// keyframe and its deltas are always kept.
+func (s *Store) prune(now time.Time) error {
+	cutoff := uint64(now.Add(-s.opts.Retention).UnixNano())
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		// keyframes are the keys of the keyframes, oldest first, and sizes
+		// the size of each keyframe with its deltas.
+		var keyframes [][]byte
+		var sizes []int64
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v[0] == kindKeyframe || len(keyframes) == 0 {
+				keyframes = append(keyframes, bytes.Clone(k))
+				sizes = append(sizes, 0)
+			}
+			sizes[len(sizes)-1] += int64(len(v))
+		}
+
+		// Keep from the latest keyframe at or before the cutoff, as the
+		// snapshots after the cutoff may be deltas from it, and then drop
+		// whole keyframes while over the size limit.
+		keep := 0
+		for i := 1; i < len(keyframes) && binary.BigEndian.Uint64(keyframes[i]) <= cutoff; i++ {
+			keep = i
+		}
+		size := s.size.Load()
+		for i := range keep {
+			size -= sizes[i]
+		}
+		for keep < len(keyframes)-1 && size > s.opts.MaxBytes {
+			size -= sizes[keep]
+			keep++
+		}
+		if keep == 0 {
+			return nil
+		}
+
+		var doomed [][]byte
+		for k, _ := c.First(); k != nil && bytes.Compare(k, keyframes[keep]) < 0; k, _ = c.Next() {
+			doomed = append(doomed, bytes.Clone(k))
+		}
+		for _, k := range doomed {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		s.size.Store(size)
+		metrics.HistoryBytes.Set(float64(size))
+		return nil
+	})
+}
+
+// Bounds are the extent of the history.
+type Bounds struct {
+	Oldest  time.Time `json:"oldest,omitzero"`
+	Newest  time.Time `json:"newest,omitzero"`
+	Entries int       `json:"entries"`
+	Bytes   int64     `json:"bytes"`
+}
+
+// Bounds returns the times of the oldest and newest snapshots stored, how
+// many there are, and their size.
+func (s *Store) Bounds() (Bounds, error) {
+	bounds := Bounds{Bytes: s.size.Load()}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		bounds.Entries = b.Stats().KeyN
+		c := b.Cursor()
+		if k, _ := c.First(); k != nil {
+			bounds.Oldest = decodeKey(k)
+		}
+		if k, _ := c.Last(); k != nil {
+			bounds.Newest = decodeKey(k)
+		}
+		return nil
+	})
+	return bounds, err
+}
+
+// At returns the snapshot as it was at t, the latest stored at or before
+// it, and when that was stored. It returns ErrNotFound if none was.
+func (s *Store) At(t time.Time) (frame []byte, at time.Time, err error) {
+	// entries are the values from the keyframe to the snapshot at t.
+	var entries [][]byte
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketName).Cursor()
+		k, v := c.Seek(encodeKey(uint64(t.UnixNano()) + 1))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k == nil {
+			return ErrNotFound
+		}
+		at = decodeKey(k)
+		for ; k != nil; k, v = c.Prev() {
+			entries = append(entries, bytes.Clone(v))
+			if v[0] == kindKeyframe {
+				return nil
+			}
+		}
+		return fmt.Errorf("the history before %s has no keyframe", at.Format(time.RFC3339))
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var snap *snapshot
+	for i := len(entries) - 1; i >= 0; i-- {
+		if snap, err = s.apply(snap, entries[i]); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	frame, err = snap.marshal(s.opts.Groups)
+	return frame, at, err
+}
+
+// Replay calls fn with the snapshot at from, as At returns it, and then with
+// each snapshot stored after it up to to, in order. It stops at the first
+// error fn returns, and returns it, or with ErrCleared when the history is
+// deleted meanwhile.
+func (s *Store) Replay(from, to time.Time, fn func(at time.Time, frame []byte) error) error {
+	generation := s.generation.Load()
+	frame, at, err := s.At(from)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// Start from the first snapshot after from, which is a keyframe.
+	case err != nil:
+		return err
+	case s.generation.Load() != generation:
+		return ErrCleared
+	default:
+		if err := fn(at, frame); err != nil {
+			return err
+		}
+	}
+
+	snap, err := parse(frame, s.opts.Groups)
+	if frame == nil || err != nil {
+		snap = nil
+	}
+	after := uint64(from.UnixNano())
+	end := uint64(to.UnixNano())
+	for {
+		type entry struct {
+			key   uint64
+			value []byte
+		}
+		var batch []entry
+		err := s.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(bucketName).Cursor()
+			for k, v := c.Seek(encodeKey(after + 1)); k != nil && len(batch) < replayBatch; k, v = c.Next() {
+				key := binary.BigEndian.Uint64(k)
+				if key > end {
+					break
+				}
+				batch = append(batch, entry{key, bytes.Clone(v)})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range batch {
+			if snap, err = s.apply(snap, e.value); err != nil {
+				return err
+			}
+			frame, err := snap.marshal(s.opts.Groups)
+			if err != nil {
+				return err
+			}
+			if s.generation.Load() != generation {
+				return ErrCleared
+			}
+			if err := fn(time.Unix(0, int64(e.key)), frame); err != nil {
+				return err
+			}
+			after = e.key
+		}
+		if len(batch) < replayBatch {
+			return nil
+		}
+	}
+}
+
+// apply returns snap with value, a stored entry, applied: the keyframe's
+// snapshot, or the delta applied to snap.
+func (s *Store) apply(snap *snapshot, value []byte) (*snapshot, error) {
+	body, err := decompress(value)
+	if err != nil {
+		return nil, err
+	}
+	if value[0] == kindKeyframe {
+		return parse(body, s.opts.Groups)
+	}
+	if snap == nil {
+		return nil, errors.New("a delta is stored without its keyframe")
+	}
+	var d delta
+	if err := json.Unmarshal(body, &d); err != nil {
+		return nil, err
+	}
+	return snap.apply(&d), nil
+}
+
+func encodeKey(key uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, key)
+}
+
+func decodeKey(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k)))
+}
+
+// compress returns the stored value of body: its kind and body gzipped.
+func compress(kind byte, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(kind)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress returns the body of a stored value.
+func decompress(value []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(value[1:]))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(zr)
+}
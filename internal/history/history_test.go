@@ -0,0 +1,335 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// openStore opens a store in a temporary directory, closed by the test's
+// cleanup. Tests store snapshots with store rather than Record, so they are
+// stored before it returns.
+func openStore(t *testing.T, opts Options) *Store {
+	t.Helper()
+	if opts.Groups == nil {
+		opts.Groups = []string{"services"}
+	}
+	if opts.KeyframeInterval == 0 {
+		opts.KeyframeInterval = time.Hour
+	}
+	if opts.Retention == 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = 1 << 30
+	}
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func mustStore(t *testing.T, s *Store, at time.Time, frame string) {
+	t.Helper()
+	if err := s.store(record{at: at, frame: []byte(frame), fingerprint: s.opts.Fingerprint}); err != nil {
+		t.Fatalf("storing %s: %v", frame, err)
+	}
+}
+
+// kinds returns the kinds of the stored entries, oldest first.
+func kinds(t *testing.T, s *Store) string {
+	t.Helper()
+	var out []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(_, v []byte) error {
+			out = append(out, v[0])
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// assertJSON fails the test unless got and want are the same JSON value.
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("snapshot = %s, want %s", got, want)
+	}
+}
+
+var base = time.Date(2026, 3, 1, 3, 0, 0, 0, time.UTC)
+
+// frames are snapshots a minute apart: a service is updated, one removed,
+// and one created.
+var frames = []string{
+	`{"sequence":1,"services":[{"ID":"a","v":1},{"ID":"b","v":1}]}`,
+	`{"sequence":2,"services":[{"ID":"a","v":2},{"ID":"b","v":1}]}`,
+	`{"sequence":3,"services":[{"ID":"a","v":2},{"ID":"c","v":1}]}`,
+}
+
+func TestStore_ReconstructsDeltas(t *testing.T) {
+	s := openStore(t, Options{})
+	for i, f := range frames {
+		mustStore(t, s, base.Add(time.Duration(i)*time.Minute), f)
+	}
+	if got := kinds(t, s); got != "kdd" {
+		t.Errorf("stored kinds = %q, want a keyframe then deltas", got)
+	}
+
+	tests := []struct {
+		at     time.Duration
+		want   string
+		stored time.Duration
+	}{
+		{0, frames[0], 0},
+		{90 * time.Second, frames[1], time.Minute},
+		{time.Hour, frames[2], 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		frame, at, err := s.At(base.Add(tt.at))
+		if err != nil {
+			t.Fatalf("At(+%s): %v", tt.at, err)
+		}
+		assertJSON(t, frame, tt.want)
+		if !at.Equal(base.Add(tt.stored)) {
+			t.Errorf("At(+%s) was stored at %s, want +%s", tt.at, at, tt.stored)
+		}
+	}
+
+	if _, _, err := s.At(base.Add(-time.Second)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("At before the history err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStore_Keyframes(t *testing.T) {
+	s := openStore(t, Options{KeyframeInterval: 90 * time.Second})
+	for i, f := range frames {
+		mustStore(t, s, base.Add(time.Duration(i)*time.Minute), f)
+	}
+	// An object without an ID cannot be told apart from the others.
+	mustStore(t, s, base.Add(3*time.Minute), `{"services":[{"v":1}]}`)
+	mustStore(t, s, base.Add(4*time.Minute), `{"services":[{"v":2}]}`)
+	if got := kinds(t, s); got != "kdkkk" {
+		t.Errorf("stored kinds = %q, want kdkkk", got)
+	}
+	frame, _, err := s.At(base.Add(4 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSON(t, frame, `{"services":[{"v":2}]}`)
+}
+
+func TestStore_Replay(t *testing.T) {
+	s := openStore(t, Options{})
+	for i, f := range frames {
+		mustStore(t, s, base.Add(time.Duration(i)*time.Minute), f)
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Duration
+		want     []string
+	}{
+		{"from the middle", 30 * time.Second, time.Hour, frames},
+		{"from before the history", -time.Hour, 90 * time.Second, frames[:2]},
+		{"after the history", time.Hour, 2 * time.Hour, []string{frames[2]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]byte
+			err := s.Replay(base.Add(tt.from), base.Add(tt.to), func(_ time.Time, frame []byte) error {
+				got = append(got, frame)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("replayed %d snapshots, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				assertJSON(t, got[i], tt.want[i])
+			}
+		})
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := s.Replay(base, base.Add(time.Hour), func(time.Time, []byte) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("Replay = %v after %d calls, want fn's error after 1", err, calls)
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	t.Run("by age", func(t *testing.T) {
+		s := openStore(t, Options{Retention: 150 * time.Second, KeyframeInterval: 90 * time.Second})
+		for i := range 5 {
+			mustStore(t, s, base.Add(time.Duration(i)*time.Minute), frames[i%len(frames)])
+		}
+		// Keyframes at 0, 2 and 4 minutes: at 5 minutes, the snapshots
+		// from 2:30 on need the keyframe at 2.
+		if err := s.prune(base.Add(5 * time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if got := kinds(t, s); got != "kdk" {
+			t.Errorf("kept kinds = %q, want kdk", got)
+		}
+		bounds, err := s.Bounds()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bounds.Oldest.Equal(base.Add(2*time.Minute)) || bounds.Entries != 3 {
+			t.Errorf("bounds = %+v, want 3 entries from +2m", bounds)
+		}
+		if _, _, err := s.At(base.Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("At a pruned time err = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("by size", func(t *testing.T) {
+		s := openStore(t, Options{MaxBytes: 1, KeyframeInterval: 90 * time.Second})
+		for i := range 5 {
+			mustStore(t, s, base.Add(time.Duration(i)*time.Minute), frames[i%len(frames)])
+		}
+		if err := s.prune(base.Add(5 * time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		// The latest keyframe is kept, however large.
+		if got := kinds(t, s); got != "k" {
+			t.Errorf("kept kinds = %q, want the latest keyframe", got)
+		}
+		frame, _, err := s.At(base.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertJSON(t, frame, frames[1])
+	})
+}
+
+func TestStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	opts := Options{Groups: []string{"services"}, Retention: time.Hour, MaxBytes: 1 << 20, KeyframeInterval: time.Hour, Fingerprint: "a"}
+	s, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Record(base, []byte(frames[0]), "a")
+	s.Record(base.Add(time.Minute), []byte(frames[1]), "a")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	bounds, err := s.Bounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds.Entries != 2 || bounds.Bytes == 0 || !bounds.Newest.Equal(base.Add(time.Minute)) {
+		t.Errorf("bounds after reopening = %+v, want both snapshots", bounds)
+	}
+	// The first snapshot after reopening is a keyframe, as the last one
+	// stored is not known.
+	mustStore(t, s, base.Add(2*time.Minute), frames[2])
+	if got := kinds(t, s); got != "kdk" {
+		t.Errorf("stored kinds = %q, want kdk", got)
+	}
+}
+
+func TestStore_Fingerprint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	opts := Options{Groups: []string{"services"}, Retention: time.Hour, MaxBytes: 1 << 20, KeyframeInterval: time.Hour, Fingerprint: "a"}
+	s, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeUnder := func(fingerprint string, at time.Time, frame string) {
+		t.Helper()
+		if err := s.store(record{at: at, frame: []byte(frame), fingerprint: fingerprint}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storeUnder("a", base, frames[0])
+	storeUnder("a", base.Add(time.Minute), frames[1])
+
+	// A snapshot sanitized under other settings is not stored.
+	storeUnder("b", base.Add(2*time.Minute), frames[2])
+	if got := kinds(t, s); got != "kd" {
+		t.Errorf("stored kinds = %q, want kd", got)
+	}
+
+	// Changing the fingerprint deletes the history, and the next snapshot
+	// is a keyframe.
+	if err := s.SetFingerprint("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.At(base.Add(time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("At after the change: err = %v, want ErrNotFound", err)
+	}
+	storeUnder("a", base.Add(3*time.Minute), frames[2])
+	storeUnder("b", base.Add(4*time.Minute), frames[2])
+	if got := kinds(t, s); got != "k" {
+		t.Errorf("stored kinds = %q, want k", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// So does opening the store under another fingerprint.
+	opts.Fingerprint = "c"
+	if s, err = Open(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	bounds, err := s.Bounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds.Entries != 0 || bounds.Bytes != 0 {
+		t.Errorf("bounds after reopening under another fingerprint = %+v, want none", bounds)
+	}
+}
+
+func TestStore_ReplayCleared(t *testing.T) {
+	s := openStore(t, Options{})
+	for i, f := range frames {
+		mustStore(t, s, base.Add(time.Duration(i)*time.Minute), f)
+	}
+
+	// The history is deleted after the second snapshot, when the rest have
+	// been read.
+	var replayed int
+	err := s.Replay(base, base.Add(time.Hour), func(time.Time, []byte) error {
+		if replayed++; replayed == 2 {
+			return s.SetFingerprint("b")
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrCleared) || replayed != 2 {
+		t.Errorf("Replay = %v after %d snapshots, want ErrCleared after 2", err, replayed)
+	}
+}
@@ -0,0 +1,142 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+)
+
+// snapshot is a decoded snapshot: its groups' objects by ID, in order, and
+// the rest of it as is.
+type snapshot struct {
+	meta   map[string]json.RawMessage
+	groups map[string]*group
+	// keyed reports whether every object has an ID, so deltas can be taken
+	// from the snapshot.
+	keyed bool
+}
+
+type group struct {
+	order   []string
+	objects map[string]json.RawMessage
+}
+
+// delta is a stored delta: the snapshot's meta, and for each group the
+// objects added or changed, in order, and the IDs of those removed.
+type delta struct {
+	Meta    map[string]json.RawMessage   `json:"meta"`
+	Upsert  map[string][]json.RawMessage `json:"upsert,omitempty"`
+	Removed map[string][]string          `json:"removed,omitempty"`
+}
+
+// objectID returns the ID of a group's object, or "" if it has none. Field
+// names match case-insensitively, so this reads both "ID" and the networks'
+// "Id".
+func objectID(o json.RawMessage) string {
+	var id struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(o, &id)
+	return id.ID
+}
+
+// parse decodes frame, whose groups are arrays of objects.
+func parse(frame []byte, groups []string) (*snapshot, error) {
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(frame, &meta); err != nil {
+		return nil, err
+	}
+	s := &snapshot{meta: meta, groups: make(map[string]*group, len(groups)), keyed: true}
+	for _, name := range groups {
+		var objects []json.RawMessage
+		if raw, ok := meta[name]; ok {
+			if err := json.Unmarshal(raw, &objects); err != nil {
+				return nil, err
+			}
+			delete(meta, name)
+		}
+		g := &group{objects: make(map[string]json.RawMessage, len(objects))}
+		for _, o := range objects {
+			id := objectID(o)
+			if _, dup := g.objects[id]; id == "" || dup {
+				s.keyed = false
+			}
+			g.order = append(g.order, id)
+			g.objects[id] = o
+		}
+		s.groups[name] = g
+	}
+	return s, nil
+}
+
+// marshal encodes s as a snapshot frame.
+func (s *snapshot) marshal(groups []string) ([]byte, error) {
+	out := make(map[string]any, len(s.meta)+len(groups))
+	for k, v := range s.meta {
+		out[k] = v
+	}
+	for _, name := range groups {
+		g := s.groups[name]
+		objects := make([]json.RawMessage, 0, len(g.order))
+		for _, id := range g.order {
+			objects = append(objects, g.objects[id])
+		}
+		out[name] = objects
+	}
+	return json.Marshal(out)
+}
+
+// diff returns the delta from prev to next.
+func diff(prev, next *snapshot, groups []string) *delta {
+	d := &delta{Meta: next.meta}
+	for _, name := range groups {
+		p, n := prev.groups[name], next.groups[name]
+		for _, id := range n.order {
+			if old, ok := p.objects[id]; !ok || !bytes.Equal(old, n.objects[id]) {
+				if d.Upsert == nil {
+					d.Upsert = make(map[string][]json.RawMessage)
+				}
+				d.Upsert[name] = append(d.Upsert[name], n.objects[id])
+			}
+		}
+		for _, id := range p.order {
+			if _, ok := n.objects[id]; !ok {
+				if d.Removed == nil {
+					d.Removed = make(map[string][]string)
+				}
+				d.Removed[name] = append(d.Removed[name], id)
+			}
+		}
+	}
+	return d
+}
+
+// apply returns a copy of s with d applied. Changed objects keep their
+// place, and added ones follow the rest of their group, so the order of a
+// group may differ from the snapshot's.
+func (s *snapshot) apply(d *delta) *snapshot {
+	out := &snapshot{meta: d.Meta, groups: make(map[string]*group, len(s.groups)), keyed: true}
+	for name, g := range s.groups {
+		removed := make(map[string]bool, len(d.Removed[name]))
+		for _, id := range d.Removed[name] {
+			removed[id] = true
+		}
+		next := &group{objects: maps.Clone(g.objects)}
+		for _, id := range g.order {
+			if removed[id] {
+				delete(next.objects, id)
+				continue
+			}
+			next.order = append(next.order, id)
+		}
+		for _, o := range d.Upsert[name] {
+			id := objectID(o)
+			if _, ok := next.objects[id]; !ok {
+				next.order = append(next.order, id)
+			}
+			next.objects[id] = o
+		}
+		out.groups[name] = next
+	}
+	return out
+}
@@ -0,0 +1,57 @@
+// Package logging configures the server's structured logger and defines the
+// attributes log records share, so records about the same client, user, or
+// resource can be found together whatever emitted them.
+package logging
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+)
+
+// Attribute keys shared by the server's log records.
+const (
+	KeyClientIP = "client_ip"
+	KeyUser     = "user"
+	KeyCluster  = "cluster"
+	KeyResource = "resource"
+	KeyError    = "error"
+)
+
+// ClientIP is the IP address of the client a record concerns.
+func ClientIP(ip string) slog.Attr { return slog.String(KeyClientIP, ip) }
+
+// User is the name of the authenticated user a record concerns.
+func User(name string) slog.Attr { return slog.String(KeyUser, name) }
+
+// Resource is the kind of Docker object a record concerns: "nodes",
+// "services", "tasks", or "networks".
+func Resource(resource string) slog.Attr { return slog.String(KeyResource, resource) }
+
+// Err is the error a record reports.
+func Err(err error) slog.Attr { return slog.Any(KeyError, err) }
+
+// New returns a logger writing to w in cfg's format, "text" or "json", at
+// cfg's level. Every record carries the cluster name when one is configured.
+func New(w io.Writer, cfg *config.Config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	var h slog.Handler
+	if cfg.LogFormat == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	logger := slog.New(h)
+	if cfg.ClusterName != "" {
+		logger = logger.With(slog.String(KeyCluster, cfg.ClusterName))
+	}
+	return logger
+}
+
+// Configure makes the logger for cfg, writing to w, the default, which the
+// standard log package writes through as well. It is called again on reload,
+// so changes to the level, format, or cluster name apply at once.
+func Configure(w io.Writer, cfg *config.Config) {
+	slog.SetDefault(New(w, cfg))
+}
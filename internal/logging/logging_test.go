@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+)
+
+func TestNew_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, &config.Config{ClusterName: "prod", LogFormat: "json", LogLevel: slog.LevelWarn})
+	logger.Info("dropped")
+	logger.Warn("kept", ClientIP("10.0.0.1"), User("alice"), Resource("tasks"), Err(errors.New("boom")))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d records, want only the warning:\n%s", len(lines), buf.String())
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"msg":       "kept",
+		KeyCluster:  "prod",
+		KeyClientIP: "10.0.0.1",
+		KeyUser:     "alice",
+		KeyResource: "tasks",
+		KeyError:    "boom",
+	} {
+		if rec[key] != want {
+			t.Errorf("%s = %v, want %q", key, rec[key], want)
+		}
+	}
+}
+
+func TestNew_Text(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, &config.Config{LogFormat: "text"}).Debug("hidden")
+	New(&buf, &config.Config{LogFormat: "text"}).Info("shown", Resource("nodes"))
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "msg=shown resource=nodes") || strings.Contains(out, KeyCluster) {
+		t.Errorf("text output = %q, want only the info record, without a cluster", out)
+	}
+}
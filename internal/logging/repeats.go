@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Repeats bounds the logging of errors that recur on every attempt of an
+// operation, such as a poll of a Docker daemon that is down. The first error
+// is logged; identical repeats are counted and summarised at most once per
+// interval; a different error is logged at once; and the recovery is logged
+// when the operation next succeeds. Operations are told apart by a key. It is
+// safe for concurrent use.
+type Repeats struct {
+	interval time.Duration
+	// now is the clock, replaced in tests.
+	now func() time.Time
+
+	mu  sync.Mutex
+	ops map[string]*failing
+}
+
+// failing is the state of an operation that has failed since it last
+// succeeded.
+type failing struct {
+	// msg is the message of the last error logged.
+	msg      string
+	since    time.Time
+	failures int
+	// suppressed counts the repeats since the error was last logged, at
+	// logged.
+	suppressed int
+	logged     time.Time
+}
+
+// NewRepeats returns a Repeats that summarises repeated errors once per
+// interval.
+func NewRepeats(interval time.Duration) *Repeats {
+	return &Repeats{interval: interval, now: time.Now, ops: make(map[string]*failing)}
+}
+
+// Error reports that the operation key failed with err. It logs msg with args
+// and err unless err repeats the error last logged for key within the
+// interval; a summary of the repeats carries their count and when the
+// failures began.
+func (r *Repeats) Error(key, msg string, err error, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	f, ok := r.ops[key]
+	if !ok {
+		f = &failing{since: now}
+		r.ops[key] = f
+	}
+	f.failures++
+
+	switch {
+	case err.Error() != f.msg:
+		f.msg, f.suppressed, f.logged = err.Error(), 0, now
+		slog.Error(msg, append(args, Err(err))...)
+	case now.Sub(f.logged) >= r.interval:
+		repeated := f.suppressed + 1
+		f.suppressed, f.logged = 0, now
+		slog.Error(msg, append(args, Err(err), slog.Int("repeated", repeated), slog.Time("failing_since", f.since))...)
+	default:
+		f.suppressed++
+	}
+}
+
+// Success reports that the operation key succeeded. If it had been failing,
+// msg is logged with args, the number of failures, and how long they lasted.
+func (r *Repeats) Success(key, msg string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.ops[key]
+	if !ok {
+		return
+	}
+	delete(r.ops, key)
+	slog.Info(msg, append(args, slog.Int("failures", f.failures), slog.Duration("failed_for", r.now().Sub(f.since)))...)
+}
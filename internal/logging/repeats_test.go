@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureDefault makes the default logger write text to the returned buffer
+// for the rest of the test.
+func captureDefault(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+func TestRepeats(t *testing.T) {
+	buf := captureDefault(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRepeats(time.Minute)
+	r.now = func() time.Time { return now }
+
+	down := errors.New("connection refused")
+	tick := func(n int, err error) {
+		for range n {
+			r.Error("tasks", "Docker API request failed", err, Resource("tasks"))
+			now = now.Add(time.Second)
+		}
+	}
+	count := func(s string) int { return strings.Count(buf.String(), s) }
+
+	// A minute and a half of failures every second: the first is logged,
+	// then one summary of the 60 repeats that followed.
+	tick(90, down)
+	if n := count("connection refused"); n != 2 {
+		t.Fatalf("error logged %d times in 90s, want 2:\n%s", n, buf)
+	}
+	if n := count("repeated=60"); n != 1 {
+		t.Errorf("want one summary of 60 repeats:\n%s", buf)
+	}
+
+	// A different error is logged at once.
+	tick(1, errors.New("i/o timeout"))
+	if n := count("i/o timeout"); n != 1 {
+		t.Errorf("new error logged %d times, want 1:\n%s", n, buf)
+	}
+
+	// Other operations are independent.
+	r.Error("nodes", "Docker API request failed", down)
+	if n := count("connection refused"); n != 3 {
+		t.Errorf("other operation's error not logged:\n%s", buf)
+	}
+
+	r.Success("tasks", "Docker API request succeeded again", Resource("tasks"))
+	r.Success("tasks", "Docker API request succeeded again", Resource("tasks"))
+	if n := count("succeeded again"); n != 1 || !strings.Contains(buf.String(), "failures=91") {
+		t.Errorf("want one recovery record with 91 failures:\n%s", buf)
+	}
+
+	// After recovering, the next failure is logged at once.
+	tick(1, down)
+	if n := count("resource=tasks error=\"connection refused\""); n != 3 {
+		t.Errorf("failure after recovery not logged:\n%s", buf)
+	}
+}
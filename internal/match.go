@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Globs is a set of glob patterns, in which "*" matches any run of characters
+// and "?" any single character, as in sanitization paths.
+type Globs []*regexp.Regexp
+
+// CompileGlobs compiles patterns into Globs.
+func CompileGlobs(patterns []string) Globs {
+	globs := make(Globs, len(patterns))
+	for i, p := range patterns {
+		globs[i] = globToRegexp(p)
+	}
+	return globs
+}
+
+// Match reports whether s matches any of the globs.
+func (g Globs) Match(s string) bool {
+	for _, re := range g {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelSelector matches labels by key, and optionally by a glob the value must
+// match. It is written "key" or "key=glob".
+type LabelSelector struct {
+	spec  string
+	key   string
+	value *regexp.Regexp
+}
+
+// ParseLabelSelector parses a selector written "key" or "key=glob".
+func ParseLabelSelector(spec string) (LabelSelector, error) {
+	key, value, hasValue := strings.Cut(spec, "=")
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return LabelSelector{}, fmt.Errorf("label selector %q has no key", spec)
+	}
+	s := LabelSelector{spec: spec, key: key}
+	if hasValue {
+		s.value = globToRegexp(strings.TrimSpace(value))
+	}
+	return s, nil
+}
+
+// String returns the selector as written.
+func (s LabelSelector) String() string {
+	return s.spec
+}
+
+// Matches reports whether labels has the selector's key with, if the selector
+// gives one, a value matching its glob.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	value, ok := labels[s.key]
+	if !ok {
+		return false
+	}
+	return s.value == nil || s.value.MatchString(value)
+}
@@ -0,0 +1,55 @@
+package internal
+
+import "testing"
+
+func TestGlobs(t *testing.T) {
+	globs := CompileGlobs([]string{"monitoring*", "proxy?", "exact"})
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"monitoring", true},
+		{"monitoring_agent", true},
+		{"proxy1", true},
+		{"proxy", false},
+		{"exact", true},
+		{"exactly", false},
+		{"app", false},
+	}
+	for _, tc := range tests {
+		if got := globs.Match(tc.name); got != tc.want {
+			t.Errorf("Match(%q) = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if CompileGlobs(nil).Match("anything") {
+		t.Error("empty Globs matched")
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"role": "monitoring-east", "empty": ""}
+	tests := []struct {
+		spec string
+		want bool
+	}{
+		{"role", true},
+		{"role=monitoring-*", true},
+		{"role=web", false},
+		{"empty", true},
+		{"empty=", true},
+		{"missing", false},
+		{" role = monitoring-east ", true},
+	}
+	for _, tc := range tests {
+		s, err := ParseLabelSelector(tc.spec)
+		if err != nil {
+			t.Fatalf("ParseLabelSelector(%q): %v", tc.spec, err)
+		}
+		if got := s.Matches(labels); got != tc.want {
+			t.Errorf("%q.Matches = %v, want %v", tc.spec, got, tc.want)
+		}
+	}
+	if _, err := ParseLabelSelector("=value"); err == nil {
+		t.Error("expected an error for a selector with no key")
+	}
+}
@@ -0,0 +1,181 @@
+// Package metrics defines the server's Prometheus metrics and serves them in
+// the Prometheus text format.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every metric name.
+const Namespace = "swarm_visualizer"
+
+// registry holds the server's metrics, along with the Go runtime and process
+// collectors. It is separate from the default registry so only what is
+// defined here is exposed.
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// WebSocket clients and the frames published to them.
+var (
+	WSClients = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "websocket_clients",
+		Help:      "Connected WebSocket clients.",
+	})
+	// WSRejections counts refused connections by reason: "capacity" for the
+	// global cap, "per_ip" for the per-IP cap.
+	WSRejections = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "websocket_rejections_total",
+		Help:      "WebSocket connections refused by the connection caps, by reason.",
+	}, []string{"reason"})
+	// RateLimitRejections counts requests answered 429 because the client's
+	// bucket for the endpoint group was empty, by group.
+	RateLimitRejections = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "ratelimit_rejections_total",
+		Help:      "Requests rejected by the rate limits, by endpoint group.",
+	}, []string{"group"})
+	FramesPublished = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "frames_published_total",
+		Help:      "Snapshot frames fanned out to WebSocket clients.",
+	})
+	FrameBytes = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "frame_size_bytes",
+		Help:      "Size of the snapshot frames fanned out.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 8),
+	})
+	// EnqueueDrops counts undelivered frames discarded because a slow client
+	// had not taken the previous one when the next was published.
+	EnqueueDrops = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "websocket_enqueue_drops_total",
+		Help:      "Undelivered frames replaced by a newer one for slow WebSocket clients.",
+	})
+	// ChangeDrops counts change messages not sent to a slow client because
+	// its queue of them was full. The client can fetch them from the API.
+	ChangeDrops = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "websocket_change_drops_total",
+		Help:      "Change messages dropped for slow WebSocket clients.",
+	})
+)
+
+// Snapshot history.
+var (
+	// HistoryBytes is the size of the snapshots kept in the history.
+	HistoryBytes = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "history_bytes",
+		Help:      "Size of the snapshots kept in the history, compressed.",
+	})
+	// HistoryWrites counts snapshots recorded in the history, by whether
+	// they were stored as a keyframe or a delta, or dropped because the
+	// writer fell behind.
+	HistoryWrites = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "history_writes_total",
+		Help:      "Snapshots recorded in the history by kind (keyframe, delta, dropped).",
+	}, []string{"kind"})
+)
+
+// Docker polling and sanitization.
+var (
+	DockerRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "docker_request_duration_seconds",
+		Help:      "Latency of Docker API list calls, by resource.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"resource"})
+	DockerErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "docker_errors_total",
+		Help:      "Failed Docker API list calls, by resource.",
+	}, []string{"resource"})
+	// PollDuration observes each refresh, by group: "tasks", or "structural"
+	// for nodes, services, and networks.
+	PollDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "poll_duration_seconds",
+		Help:      "Duration of each poll of the swarm, including sanitization, by group.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"group"})
+	SanitizationErrors = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "sanitization_errors_total",
+		Help:      "Publishes in which applying the sensitive data paths failed.",
+	})
+)
+
+// Authentication.
+var (
+	// OIDCLogins counts completed login callbacks by result, "success" or
+	// "failure".
+	OIDCLogins = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "oidc_logins_total",
+		Help:      "OIDC login callbacks, by result.",
+	}, []string{"result"})
+	JWKSRefreshes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "oidc_jwks_refreshes_total",
+		Help:      "Refreshes of the identity provider's signing keys, by result.",
+	}, []string{"result"})
+)
+
+// Notifications.
+var (
+	// WebhookDeliveries counts events sent to webhooks, by webhook and
+	// result: "success", "failure" once every attempt has failed, or
+	// "dropped" when too many deliveries were already in flight.
+	WebhookDeliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Events sent to webhooks, by webhook and result.",
+	}, []string{"webhook", "result"})
+)
+
+// Result returns the result label for an operation that returned err:
+// "success" or "failure".
+func Result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// ObserveDockerRequest records a Docker API list call for resource that
+// started at start and returned err.
+func ObserveDockerRequest(resource string, start time.Time, err error) {
+	DockerRequestDuration.WithLabelValues(resource).Observe(time.Since(start).Seconds())
+	if err != nil {
+		DockerErrors.WithLabelValues(resource).Inc()
+	}
+}
+
+// MustRegister registers collectors of further metrics, panicking if one
+// conflicts with those already registered.
+func MustRegister(cs ...prometheus.Collector) {
+	registry.MustRegister(cs...)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
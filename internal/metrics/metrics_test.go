@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	ObserveDockerRequest("tasks", time.Now(), errors.New("daemon unreachable"))
+	OIDCLogins.WithLabelValues(Result(nil)).Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`swarm_visualizer_docker_errors_total{resource="tasks"} 1`,
+		`swarm_visualizer_docker_request_duration_seconds_count{resource="tasks"} 1`,
+		`swarm_visualizer_oidc_logins_total{result="success"} 1`,
+		"swarm_visualizer_websocket_clients 0",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
+
+func TestResult(t *testing.T) {
+	if got := Result(nil); got != "success" {
+		t.Errorf("Result(nil) = %q, want success", got)
+	}
+	if got := Result(errors.New("x")); got != "failure" {
+		t.Errorf("Result(err) = %q, want failure", got)
+	}
+}
@@ -0,0 +1,291 @@
+// Package notify delivers swarm events to the configured webhooks. Each
+// delivery is signed when the webhook has a secret, retried with exponential
+// backoff while it fails, and not repeated for the same event within the
+// webhook's deduplication window.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+)
+
+// Event is a change for the worse in the swarm. It is the default body of a
+// delivery, and the data of a webhook's template.
+type Event struct {
+	// Type is one of config.WebhookEvents.
+	Type string `json:"type"`
+	// Key identifies what the event is about, e.g. a task's ID, so it is
+	// not delivered again within a webhook's deduplication window.
+	Key     string    `json:"key"`
+	Time    time.Time `json:"time"`
+	Cluster string    `json:"cluster,omitempty"`
+	// Message describes the event in a sentence, e.g. for a chat message.
+	Message string `json:"message"`
+	// Stack, Service, Node, and Task name what the event is about, where
+	// they apply.
+	Stack   string `json:"stack,omitempty"`
+	Service string `json:"service,omitempty"`
+	Node    string `json:"node,omitempty"`
+	Task    string `json:"task,omitempty"`
+	// Status is the service's status, for a service under replicated.
+	Status string `json:"status,omitempty"`
+	// Since is when the condition began, for a service under replicated.
+	Since time.Time `json:"since,omitzero"`
+}
+
+// Delivery headers. The signature is "sha256=" and the hex HMAC-SHA256,
+// keyed by the webhook's secret, of the timestamp, a ".", and the body.
+const (
+	HeaderEvent     = "X-Visualizer-Event"
+	HeaderDelivery  = "X-Visualizer-Delivery"
+	HeaderTimestamp = "X-Visualizer-Timestamp"
+	HeaderSignature = "X-Visualizer-Signature"
+)
+
+// maxInFlight caps the deliveries being attempted at once, so an unreachable
+// webhook cannot pile up goroutines during an incident. Further events are
+// dropped.
+const maxInFlight = 64
+
+// Notifier sends events to the webhooks of the current configuration. It is
+// safe for concurrent use.
+type Notifier struct {
+	// ctx ends the deliveries in flight, and their retries, when it is
+	// cancelled.
+	ctx    context.Context
+	cfgs   *config.Holder
+	client *http.Client
+	// backoff is the delay before the first retry, doubled for each
+	// further one up to maxBackoff. Tests shorten it.
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	inFlight chan struct{}
+	wg       sync.WaitGroup
+
+	mu sync.Mutex
+	// sent is when each event was last sent to each webhook, by dedupeKey.
+	sent map[string]time.Time
+}
+
+// New returns a Notifier for the webhooks of cfgs' configuration, as it is
+// when each event is sent. Cancelling ctx abandons the deliveries in flight;
+// Wait waits for them to return.
+func New(ctx context.Context, cfgs *config.Holder) *Notifier {
+	return &Notifier{
+		ctx:        ctx,
+		cfgs:       cfgs,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		backoff:    time.Second,
+		maxBackoff: time.Minute,
+		inFlight:   make(chan struct{}, maxInFlight),
+		sent:       make(map[string]time.Time),
+	}
+}
+
+// Notify sends each event to the webhooks that want it, unless it was sent to
+// them within their deduplication window. Deliveries run in the background.
+func (n *Notifier) Notify(events []Event) {
+	cfg := n.cfgs.Load()
+	if len(cfg.Webhooks) == 0 {
+		return
+	}
+	now := time.Now()
+
+	n.mu.Lock()
+	n.prune(cfg, now)
+	type delivery struct {
+		w config.Webhook
+		e Event
+	}
+	var due []delivery
+	for _, e := range events {
+		for _, w := range cfg.Webhooks {
+			if !w.Wants(e.Type) {
+				continue
+			}
+			if e.Type == config.EventServiceUnderReplicated && e.Time.Sub(e.Since) < w.UnderReplicatedFor {
+				continue
+			}
+			key := dedupeKey(w.Name, e)
+			if last, ok := n.sent[key]; ok && now.Sub(last) < w.DedupeWindow {
+				continue
+			}
+			n.sent[key] = now
+			due = append(due, delivery{w, e})
+		}
+	}
+	n.mu.Unlock()
+
+	for _, d := range due {
+		select {
+		case n.inFlight <- struct{}{}:
+		default:
+			slog.Warn("Too many webhook deliveries in flight, dropping an event", "webhook", d.w.Name, "event", d.e.Type)
+			metrics.WebhookDeliveries.WithLabelValues(d.w.Name, "dropped").Inc()
+			// Let a later occurrence through.
+			n.mu.Lock()
+			delete(n.sent, dedupeKey(d.w.Name, d.e))
+			n.mu.Unlock()
+			continue
+		}
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			defer func() { <-n.inFlight }()
+			n.deliver(d.w, d.e)
+		}()
+	}
+}
+
+// prune forgets events sent longer ago than their webhook's deduplication
+// window, and those of webhooks no longer configured. n.mu must be held.
+func (n *Notifier) prune(cfg *config.Config, now time.Time) {
+	windows := make(map[string]time.Duration, len(cfg.Webhooks))
+	for _, w := range cfg.Webhooks {
+		windows[w.Name] = w.DedupeWindow
+	}
+	for key, at := range n.sent {
+		name, _, _ := strings.Cut(key, "\x00")
+		window, ok := windows[name]
+		if !ok || now.Sub(at) >= window {
+			delete(n.sent, key)
+		}
+	}
+}
+
+// dedupeKey identifies e sent to the webhook name.
+func dedupeKey(name string, e Event) string {
+	return name + "\x00" + e.Type + "\x00" + e.Key
+}
+
+// deliver sends e to w, retrying with exponential backoff until it is
+// accepted, w.MaxAttempts have failed, or n.ctx is cancelled.
+func (n *Notifier) deliver(w config.Webhook, e Event) {
+	logger := slog.With("webhook", w.Name, "event", e.Type)
+	body, err := render(&w, e)
+	if err != nil {
+		logger.Error("Rendering a webhook payload failed", logging.Err(err))
+		metrics.WebhookDeliveries.WithLabelValues(w.Name, "failure").Inc()
+		return
+	}
+	id := deliveryID()
+
+	delay := n.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(&w, e, id, body)
+		if err == nil {
+			logger.Debug("Webhook delivered", "attempts", attempt)
+			metrics.WebhookDeliveries.WithLabelValues(w.Name, "success").Inc()
+			return
+		}
+		if n.ctx.Err() != nil {
+			n.abandon(logger, w.Name, attempt)
+			return
+		}
+		if !retry || attempt >= w.MaxAttempts {
+			logger.Error("Webhook delivery failed", "attempts", attempt, logging.Err(err))
+			metrics.WebhookDeliveries.WithLabelValues(w.Name, "failure").Inc()
+			return
+		}
+		logger.Debug("Webhook delivery failed, retrying", "attempt", attempt, "retry_in", delay, logging.Err(err))
+		timer := time.NewTimer(delay)
+		select {
+		case <-n.ctx.Done():
+			timer.Stop()
+			n.abandon(logger, w.Name, attempt)
+			return
+		case <-timer.C:
+		}
+		delay = min(2*delay, n.maxBackoff)
+	}
+}
+
+// abandon records a delivery to the webhook name given up after attempts
+// because n.ctx was cancelled, e.g. on shutdown. It counts as a failure.
+func (n *Notifier) abandon(logger *slog.Logger, name string, attempts int) {
+	logger.Warn("Webhook delivery abandoned on shutdown", "attempts", attempts)
+	metrics.WebhookDeliveries.WithLabelValues(name, "failure").Inc()
+}
+
+// post makes one attempt to deliver body, the payload of e, to w. It reports
+// whether a failure is worth retrying: a network error, a server error, or
+// 429 Too Many Requests.
+func (n *Notifier) post(w *config.Webhook, e Event, id string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", w.ContentType)
+	req.Header.Set(HeaderEvent, e.Type)
+	req.Header.Set(HeaderDelivery, id)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if w.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(w.Secret, timestamp, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status %d", resp.StatusCode)
+}
+
+// Sign returns the signature header value of a delivery of body at
+// timestamp, keyed by secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// render returns the payload of e for w: its template's output, or e as
+// JSON.
+func render(w *config.Webhook, e Event) ([]byte, error) {
+	if w.Template == nil {
+		return json.Marshal(e)
+	}
+	var buf bytes.Buffer
+	if err := w.Template.Execute(&buf, e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deliveryID returns a random ID for a delivery, the same across its retries
+// so the receiver can tell them apart from new events.
+func deliveryID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Wait waits for the deliveries in flight to finish, or to be abandoned once
+// the Notifier's context is cancelled.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
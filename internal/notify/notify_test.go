@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+)
+
+// receiver records the deliveries it is sent, answering each with the next
+// of statuses, and 200 once they run out.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	headers  []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.bodies = append(rc.bodies, string(body))
+	rc.headers = append(rc.headers, r.Header.Clone())
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// newNotifier returns a Notifier for hook, sent to a new receiver, with a
+// short backoff.
+func newNotifier(t *testing.T, hook config.Webhook, statuses ...int) (*Notifier, *receiver) {
+	t.Helper()
+	rc := &receiver{statuses: statuses}
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+	hook.URL = srv.URL
+	if hook.Name == "" {
+		hook.Name = "test"
+	}
+	if hook.ContentType == "" {
+		hook.ContentType = "application/json"
+	}
+	if hook.MaxAttempts == 0 {
+		hook.MaxAttempts = 3
+	}
+	n := New(context.Background(), config.NewHolder(&config.Config{Webhooks: []config.Webhook{hook}}))
+	n.backoff = time.Millisecond
+	return n, rc
+}
+
+var taskFailed = Event{
+	Type:    config.EventTaskFailed,
+	Key:     "t1",
+	Time:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	Message: "Task web.1 failed: exit 1",
+	Service: "web",
+}
+
+func TestNotifier_SignsDeliveries(t *testing.T) {
+	n, rc := newNotifier(t, config.Webhook{Secret: "s3cret"})
+	n.Notify([]Event{taskFailed})
+	n.Wait()
+
+	if len(rc.bodies) != 1 {
+		t.Fatalf("deliveries = %d, want 1", len(rc.bodies))
+	}
+	h := rc.headers[0]
+	if got, want := h.Get(HeaderSignature), Sign("s3cret", h.Get(HeaderTimestamp), []byte(rc.bodies[0])); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if h.Get(HeaderEvent) != config.EventTaskFailed || h.Get(HeaderDelivery) == "" || h.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v", h)
+	}
+	want := `{"type":"task_failed","key":"t1","time":"2026-01-02T03:04:05Z","message":"Task web.1 failed: exit 1","service":"web"}`
+	if rc.bodies[0] != want {
+		t.Errorf("body = %s, want %s", rc.bodies[0], want)
+	}
+}
+
+func TestNotifier_Retries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int
+	}{
+		{"server errors are retried", []int{500, 503}, 3},
+		{"attempts are capped", []int{500, 500, 500, 500}, 3},
+		{"client errors are not retried", []int{400}, 1},
+		{"too many requests is retried", []int{429}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, rc := newNotifier(t, config.Webhook{}, tt.statuses...)
+			n.Notify([]Event{taskFailed})
+			n.Wait()
+			if len(rc.bodies) != tt.want {
+				t.Errorf("attempts = %d, want %d", len(rc.bodies), tt.want)
+			}
+			for _, h := range rc.headers {
+				if h.Get(HeaderDelivery) != rc.headers[0].Get(HeaderDelivery) {
+					t.Errorf("retries have delivery IDs %q and %q, want the same", h.Get(HeaderDelivery), rc.headers[0].Get(HeaderDelivery))
+				}
+			}
+		})
+	}
+}
+
+func TestNotifier_Dedupes(t *testing.T) {
+	n, rc := newNotifier(t, config.Webhook{DedupeWindow: time.Hour})
+	other := taskFailed
+	other.Key = "t2"
+	n.Notify([]Event{taskFailed})
+	n.Notify([]Event{taskFailed, other})
+	n.Wait()
+	if len(rc.bodies) != 2 {
+		t.Errorf("deliveries = %d, want 2: t1 once and t2", len(rc.bodies))
+	}
+
+	n, rc = newNotifier(t, config.Webhook{})
+	n.Notify([]Event{taskFailed})
+	n.Notify([]Event{taskFailed})
+	n.Wait()
+	if len(rc.bodies) != 2 {
+		t.Errorf("deliveries without a window = %d, want 2", len(rc.bodies))
+	}
+}
+
+func TestNotifier_Filters(t *testing.T) {
+	n, rc := newNotifier(t, config.Webhook{
+		Events:             []string{config.EventServiceUnderReplicated},
+		UnderReplicatedFor: time.Minute,
+	})
+	under := Event{Type: config.EventServiceUnderReplicated, Key: "s1", Time: taskFailed.Time}
+	under.Since = under.Time.Add(-30 * time.Second)
+	n.Notify([]Event{taskFailed, under})
+	n.Wait()
+	if len(rc.bodies) != 0 {
+		t.Fatalf("deliveries = %v, want none before a minute under", rc.bodies)
+	}
+
+	under.Since = under.Time.Add(-time.Minute)
+	n.Notify([]Event{under})
+	n.Wait()
+	if len(rc.bodies) != 1 {
+		t.Errorf("deliveries = %d, want 1 after a minute under", len(rc.bodies))
+	}
+}
+
+func TestNotifier_Template(t *testing.T) {
+	tmpl := template.Must(template.New("slack").Funcs(config.WebhookTemplateFuncs).Parse(`{"text": {{json .Message}}}`))
+	n, rc := newNotifier(t, config.Webhook{Template: tmpl})
+	e := taskFailed
+	e.Message = `Task "web.1" failed`
+	n.Notify([]Event{e})
+	n.Wait()
+	if want := `{"text": "Task \"web.1\" failed"}`; len(rc.bodies) != 1 || rc.bodies[0] != want {
+		t.Errorf("bodies = %v, want [%s]", rc.bodies, want)
+	}
+}
+
+func TestNotifier_CancelAbandonsRetries(t *testing.T) {
+	n, rc := newNotifier(t, config.Webhook{MaxAttempts: 5}, 500, 500, 500, 500)
+	ctx, cancel := context.WithCancel(context.Background())
+	n.ctx = ctx
+	n.backoff = time.Hour
+	n.Notify([]Event{taskFailed})
+
+	delivered := func() bool {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		return len(rc.bodies) > 0
+	}
+	for deadline := time.Now().Add(2 * time.Second); !delivered(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("first attempt not made")
+		}
+	}
+	cancel()
+
+	waited := make(chan struct{})
+	go func() {
+		n.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait did not return after the context was cancelled")
+	}
+	if len(rc.bodies) != 1 {
+		t.Errorf("attempts = %d, want 1", len(rc.bodies))
+	}
+}
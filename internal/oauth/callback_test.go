@@ -11,6 +11,8 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"golang.org/x/oauth2"
 )
 
@@ -83,7 +85,7 @@ func TestHandleCallback_NonceValidation(t *testing.T) {
 		return s
 	}
 
-	cfg := &config.Config{ContextRoot: "/", OAuthConfig: config.OAuthConfig{ClientID: client, Issuer: issuer}}
+	cfg := &config.Config{ContextRoot: "/", OAuthConfig: config.OAuthConfig{ClientID: client}}
 
 	doCallback := func(t *testing.T, tokenNonce, cookieNonce string) *httptest.ResponseRecorder {
 		idToken := signIDToken(tokenNonce)
@@ -94,8 +96,9 @@ func TestHandleCallback_NonceValidation(t *testing.T) {
 		defer tokenSrv.Close()
 
 		a := &Authenticator{
-			cfg:  cfg,
-			keys: keys,
+			cfg:    cfg,
+			keys:   keys,
+			issuer: issuer,
 			oauthConfig: &oauth2.Config{
 				ClientID: client,
 				Endpoint: oauth2.Endpoint{TokenURL: tokenSrv.URL, AuthURL: "https://issuer.example.com/auth"},
@@ -119,7 +122,12 @@ func TestHandleCallback_NonceValidation(t *testing.T) {
 		return ""
 	}
 
+	logins := func(result string) float64 {
+		return testutil.ToFloat64(metrics.OIDCLogins.WithLabelValues(result))
+	}
+
 	t.Run("matching nonce establishes the session", func(t *testing.T) {
+		before := logins("success")
 		rr := doCallback(t, "nonce-abc", "nonce-abc")
 		if rr.Code != http.StatusTemporaryRedirect {
 			t.Fatalf("status = %d, want redirect; body=%s", rr.Code, rr.Body.String())
@@ -127,9 +135,13 @@ func TestHandleCallback_NonceValidation(t *testing.T) {
 		if idTokenCookie(rr) == "" {
 			t.Fatal("expected id_token cookie to be set")
 		}
+		if got := logins("success") - before; got != 1 {
+			t.Errorf("successful logins moved by %v, want 1", got)
+		}
 	})
 
 	t.Run("mismatched nonce is rejected", func(t *testing.T) {
+		before := logins("failure")
 		rr := doCallback(t, "nonce-abc", "nonce-different")
 		if rr.Code != http.StatusBadRequest {
 			t.Fatalf("status = %d, want %d", rr.Code, http.StatusBadRequest)
@@ -137,6 +149,9 @@ func TestHandleCallback_NonceValidation(t *testing.T) {
 		if v := idTokenCookie(rr); v != "" {
 			t.Fatalf("id_token must not be set on nonce mismatch, got %q", v)
 		}
+		if got := logins("failure") - before; got != 1 {
+			t.Errorf("failed logins moved by %v, want 1", got)
+		}
 	})
 }
 
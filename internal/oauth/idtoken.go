@@ -31,6 +31,8 @@ func (a *Authenticator) ValidateToken(r *http.Request) (jwt.MapClaims, error) {
 // returns its claims. It is shared by the WebSocket request path and the OAuth
 // callback (which additionally checks the nonce).
 func (a *Authenticator) validateRawToken(rawIDToken string) (jwt.MapClaims, error) {
+	_, keys, expectedIssuer := a.provider()
+
 	token, err := jwt.Parse(rawIDToken, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -41,10 +43,10 @@ func (a *Authenticator) validateRawToken(rawIDToken string) (jwt.MapClaims, erro
 			return nil, fmt.Errorf("missing kid in token header")
 		}
 
-		if a.keys == nil {
+		if keys == nil {
 			return nil, fmt.Errorf("signing keys not initialized")
 		}
-		rsaPublicKey, ok := a.keys.key(kid)
+		rsaPublicKey, ok := keys.key(kid)
 		if !ok {
 			return nil, fmt.Errorf("unknown kid: %s", kid)
 		}
@@ -60,12 +62,12 @@ func (a *Authenticator) validateRawToken(rawIDToken string) (jwt.MapClaims, erro
 		return nil, fmt.Errorf("unauthorized")
 	}
 
-	if a.cfg.OAuthConfig.Issuer != "" {
+	if expectedIssuer != "" {
 		issuer, err := claims.GetIssuer()
 		if err != nil {
 			return nil, fmt.Errorf("failed to read issuer claim: %v", err)
 		}
-		if issuer != a.cfg.OAuthConfig.Issuer {
+		if issuer != expectedIssuer {
 			return nil, fmt.Errorf("ID token from unexpected issuer: %s", issuer)
 		}
 	}
@@ -80,19 +80,20 @@ func TestValidateToken(t *testing.T) {
 	wrongIssClaims := validClaims()
 	wrongIssClaims["iss"] = "https://evil.example.com"
 
-	defaultCfg := &config.Config{OAuthConfig: config.OAuthConfig{ClientID: client, Issuer: issuer}}
+	cfg := &config.Config{OAuthConfig: config.OAuthConfig{ClientID: client}}
 
 	tests := []struct {
 		name    string
 		token   string
 		bearer  bool
 		noToken bool
-		cfg     *config.Config
-		wantErr bool
+		// noIssuer simulates discovery that provided no issuer.
+		noIssuer bool
+		wantErr  bool
 	}{
 		{name: "valid token via cookie", token: signRS256(validClaims(), key, kid)},
 		{name: "valid token via bearer header", token: signRS256(validClaims(), key, kid), bearer: true},
-		{name: "issuer not configured skips check", token: signRS256(wrongIssClaims, key, kid), cfg: &config.Config{OAuthConfig: config.OAuthConfig{ClientID: client}}},
+		{name: "issuer not configured skips check", token: signRS256(wrongIssClaims, key, kid), noIssuer: true},
 		{name: "expired token", token: signRS256(expiredClaims, key, kid), wantErr: true},
 		{name: "wrong audience", token: signRS256(wrongAudClaims, key, kid), wantErr: true},
 		{name: "wrong issuer", token: signRS256(wrongIssClaims, key, kid), wantErr: true},
@@ -105,11 +106,6 @@ func TestValidateToken(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			cfg := tc.cfg
-			if cfg == nil {
-				cfg = defaultCfg
-			}
-
 			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
 			if !tc.noToken {
 				if tc.bearer {
@@ -119,7 +115,10 @@ func TestValidateToken(t *testing.T) {
 				}
 			}
 
-			a := &Authenticator{cfg: cfg, keys: keys}
+			a := &Authenticator{cfg: cfg, keys: keys, issuer: issuer}
+			if tc.noIssuer {
+				a.issuer = ""
+			}
 			claims, err := a.ValidateToken(req)
 			if tc.wantErr {
 				if err == nil {
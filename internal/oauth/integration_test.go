@@ -5,8 +5,10 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/ratelimit"
 	"golang.org/x/oauth2"
 )
 
@@ -19,7 +21,9 @@ func newTestAuthenticator() *Authenticator {
 			ClientID: "client-1",
 			Endpoint: oauth2.Endpoint{AuthURL: "https://idp.example.com/auth"},
 		},
-		limiters: make(map[string]*ipLimiter),
+		limiter: ratelimit.New(map[string]config.RateLimit{
+			config.RateLimitLogin: {Requests: 5, Period: time.Minute, Burst: 5},
+		}, nil),
 	}
 }
 
@@ -1,15 +1,20 @@
 package oauth
 
 import (
+	"context"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+	"github.com/jtgasper3/swarm-visualizer/internal/tracing"
 )
 
 const (
@@ -25,10 +30,11 @@ const (
 // keyStore holds the identity provider's RSA signing keys and keeps them
 // current. It is safe for concurrent use.
 type keyStore struct {
-	jwksURI    string
 	httpClient *http.Client
 
-	mu          sync.RWMutex
+	mu sync.RWMutex
+	// jwksURI can change when discovery is refreshed.
+	jwksURI     string
 	keys        map[string]*rsa.PublicKey
 	lastRefresh time.Time
 }
@@ -74,19 +80,35 @@ func (ks *keyStore) refreshIfStale() bool {
 		return false
 	}
 	if err := ks.refresh(); err != nil {
-		log.Printf("JWKS on-demand refresh failed: %v", err)
+		slog.Warn("JWKS on-demand refresh failed", logging.Err(err))
 	}
 	return true
 }
 
 // refresh fetches the JWKS and atomically replaces the in-memory key set.
 func (ks *keyStore) refresh() error {
+	ks.mu.RLock()
+	jwksURI := ks.jwksURI
+	ks.mu.RUnlock()
+	return ks.refreshFrom(jwksURI)
+}
+
+// refreshFrom fetches the JWKS from jwksURI, which may be a new endpoint from
+// refreshed discovery, and replaces the key set and endpoint together, so a
+// failed fetch leaves the store on its previous, verified endpoint.
+func (ks *keyStore) refreshFrom(jwksURI string) (err error) {
+	_, span := tracer.Start(context.Background(), "oidc.jwks_refresh")
+	defer func() {
+		metrics.JWKSRefreshes.WithLabelValues(metrics.Result(err)).Inc()
+		tracing.End(span, err)
+	}()
+
 	// Record the attempt up front so failures are throttled too.
 	ks.mu.Lock()
 	ks.lastRefresh = time.Now()
 	ks.mu.Unlock()
 
-	resp, err := ks.httpClient.Get(ks.jwksURI)
+	resp, err := ks.httpClient.Get(jwksURI)
 	if err != nil {
 		return fmt.Errorf("failed to fetch JWKS: %v", err)
 	}
@@ -105,22 +127,22 @@ func (ks *keyStore) refresh() error {
 	newKeys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
 	for _, key := range jwks.Keys {
 		if len(key.X5c) == 0 {
-			log.Printf("Skipping JWKS key %s: no x5c certificate data", key.Kid)
+			slog.Warn("Skipping JWKS key with no x5c certificate data", "kid", key.Kid)
 			continue
 		}
 		certData, err := base64.StdEncoding.DecodeString(key.X5c[0])
 		if err != nil {
-			log.Printf("Skipping JWKS key %s: failed to decode certificate: %v", key.Kid, err)
+			slog.Warn("Skipping JWKS key whose certificate cannot be decoded", "kid", key.Kid, logging.Err(err))
 			continue
 		}
 		cert, err := x509.ParseCertificate(certData)
 		if err != nil {
-			log.Printf("Skipping JWKS key %s: failed to parse certificate: %v", key.Kid, err)
+			slog.Warn("Skipping JWKS key whose certificate cannot be parsed", "kid", key.Kid, logging.Err(err))
 			continue
 		}
 		rsaPub, ok := cert.PublicKey.(*rsa.PublicKey)
 		if !ok {
-			log.Printf("Skipping JWKS key %s: not an RSA public key", key.Kid)
+			slog.Warn("Skipping JWKS key that is not an RSA public key", "kid", key.Kid)
 			continue
 		}
 		newKeys[key.Kid] = rsaPub
@@ -132,6 +154,7 @@ func (ks *keyStore) refresh() error {
 
 	ks.mu.Lock()
 	ks.keys = newKeys
+	ks.jwksURI = jwksURI
 	ks.mu.Unlock()
 	return nil
 }
@@ -142,7 +165,7 @@ func (ks *keyStore) refreshLoop() {
 	defer ticker.Stop()
 	for range ticker.C {
 		if err := ks.refresh(); err != nil {
-			log.Printf("JWKS periodic refresh failed: %v", err)
+			slog.Warn("JWKS periodic refresh failed", logging.Err(err))
 		}
 	}
 }
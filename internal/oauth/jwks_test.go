@@ -191,6 +191,31 @@ func TestKeyStore_RetainsKeysOnFailedRefresh(t *testing.T) {
 	}
 }
 
+func TestKeyStore_KeepsURIOnFailedRefreshFrom(t *testing.T) {
+	_, rsaCert := rsaX5c(t)
+	h := &jwksHandler{body: jwksBody(t, testJWK{kid: "rsa1", x5c: []string{rsaCert}})}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	bad := httptest.NewServer(&jwksHandler{body: "not valid json"})
+	defer bad.Close()
+
+	ks := newKeyStore(srv.URL, srv.Client())
+	if err := ks.refresh(); err != nil {
+		t.Fatalf("initial refresh: %v", err)
+	}
+	if err := ks.refreshFrom(bad.URL); err == nil {
+		t.Fatal("expected decode error from the new endpoint")
+	}
+
+	// Later refreshes must keep using the verified endpoint.
+	if err := ks.refresh(); err != nil {
+		t.Fatalf("refresh after failed switch: %v", err)
+	}
+	if h.count() != 2 {
+		t.Fatalf("expected 2 fetches from the original endpoint, got %d", h.count())
+	}
+}
+
 func TestKeyStore_RefreshesOnUnknownKid(t *testing.T) {
 	_, cert1 := rsaX5c(t)
 	pub2, cert2 := rsaX5c(t)
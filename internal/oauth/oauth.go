@@ -6,139 +6,108 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net"
+	"log/slog"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/logging"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+	"github.com/jtgasper3/swarm-visualizer/internal/ratelimit"
+	"github.com/jtgasper3/swarm-visualizer/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
 	"golang.org/x/oauth2"
-	"golang.org/x/time/rate"
 )
 
-// Authenticator holds the OIDC configuration, JWKS signing keys, and per-IP
-// rate limiters for the auth endpoints. One instance backs the running server;
+// tracer traces the login callback and the refreshes of the signing keys.
+var tracer = otel.Tracer("github.com/jtgasper3/swarm-visualizer/internal/oauth")
+
+// Authenticator holds the OIDC configuration and JWKS signing keys, and
+// serves the auth endpoints. One instance backs the running server;
 // tests construct their own.
+//
+// The identity provider's endpoints, issuer, and keys are discovered in the
+// background (see run), so the Authenticator starts in a degraded state: until
+// discovery first succeeds, login and callback serve a "temporarily
+// unavailable" page and token validation fails closed.
 type Authenticator struct {
-	cfg         *config.Config
+	cfg        *config.Config
+	httpClient *http.Client
+
+	// mu guards the discovered provider state below, which is replaced
+	// wholesale whenever discovery succeeds.
+	mu          sync.RWMutex
 	oauthConfig *oauth2.Config
 	keys        *keyStore
+	issuer      string
+	// lastErr is the most recent discovery or JWKS failure, cleared on success.
+	lastErr error
 
-	limitersMu sync.Mutex
-	limiters   map[string]*ipLimiter
-}
-
-type ipLimiter struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
+	// limiter rate limits the auth endpoints per client IP. nil disables
+	// limiting.
+	limiter *ratelimit.Limiter
 }
 
-// NewAuthenticator discovers the OIDC endpoints and JWKS, then builds the
-// oauth2 config. It performs network I/O.
-func NewAuthenticator(cfg *config.Config) (*Authenticator, error) {
-	a := &Authenticator{cfg: cfg, limiters: make(map[string]*ipLimiter)}
-	if err := a.fetchWellKnownOIDCConfig(); err != nil {
-		return nil, err
+// NewAuthenticator creates an Authenticator in the degraded state. It performs
+// no network I/O; call run to discover the identity provider. limiter may be
+// nil to disable rate limiting.
+func NewAuthenticator(cfg *config.Config, limiter *ratelimit.Limiter) *Authenticator {
+	return &Authenticator{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		limiter:    limiter,
 	}
-	a.oauthConfig = setupOAuthConfig(&cfg.OAuthConfig)
-	return a, nil
 }
 
-// clientIP returns the real client IP. If the direct connection is from a
-// trusted proxy, X-Real-IP and X-Forwarded-For headers are consulted instead.
-func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		host = r.RemoteAddr
-	}
-
-	if len(trustedProxies) > 0 {
-		if remoteIP := net.ParseIP(host); remoteIP != nil {
-			for _, cidr := range trustedProxies {
-				if cidr.Contains(remoteIP) {
-					if ip := r.Header.Get("X-Real-IP"); ip != "" {
-						return strings.TrimSpace(ip)
-					}
-					if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
-						return strings.TrimSpace(strings.SplitN(fwd, ",", 2)[0])
-					}
-					break
-				}
-			}
-		}
-	}
-
-	return host
+// provider returns the discovered oauth2 config, key store, and issuer. The
+// oauth2 config is nil until discovery first succeeds.
+func (a *Authenticator) provider() (*oauth2.Config, *keyStore, string) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.oauthConfig, a.keys, a.issuer
 }
 
-// authLimiter returns a rate limiter for the given IP, creating one if needed.
-// Allows 5 requests per minute with a burst of 5.
-func (a *Authenticator) authLimiter(ip string) *rate.Limiter {
-	a.limitersMu.Lock()
-	defer a.limitersMu.Unlock()
-
-	l, ok := a.limiters[ip]
-	if !ok {
-		l = &ipLimiter{limiter: rate.NewLimiter(rate.Every(time.Minute/5), 5)}
-		a.limiters[ip] = l
+// Status reports whether the identity provider has been discovered and its
+// signing keys loaded. It returns nil when login and token validation are
+// available, and the reason they are not otherwise.
+func (a *Authenticator) Status() error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.oauthConfig != nil && a.keys != nil {
+		return nil
 	}
-	l.lastSeen = time.Now()
-	return l.limiter
-}
-
-// cleanupLimiters removes entries not seen in the last 10 minutes.
-func (a *Authenticator) cleanupLimiters() {
-	for {
-		time.Sleep(5 * time.Minute)
-		a.limitersMu.Lock()
-		for ip, l := range a.limiters {
-			if time.Since(l.lastSeen) > 10*time.Minute {
-				delete(a.limiters, ip)
-			}
-		}
-		a.limitersMu.Unlock()
+	if a.lastErr != nil {
+		return a.lastErr
 	}
+	return errors.New("identity provider discovery pending")
 }
 
 // RegisterOAuthHandlers builds the Authenticator and wires its endpoints onto
 // mux when authentication is enabled. It returns the Authenticator (whose
 // ValidateToken the WebSocket handler uses), or nil when auth is disabled.
-func RegisterOAuthHandlers(mux *http.ServeMux, cfg *config.Config) *Authenticator {
+// Discovery runs in the background, so an unreachable identity provider
+// degrades login rather than preventing the server from starting.
+func RegisterOAuthHandlers(mux *http.ServeMux, cfg *config.Config, limiter *ratelimit.Limiter) *Authenticator {
 	if !cfg.AuthEnabled {
 		return nil
 	}
 
-	auth, err := NewAuthenticator(cfg)
-	if err != nil {
-		log.Fatalf("Failed to initialize authentication: %v", err)
-	}
-	go auth.cleanupLimiters()
+	auth := NewAuthenticator(cfg, limiter)
+	go auth.run()
 	auth.register(mux)
 	return auth
 }
 
-// register wires the auth endpoints onto mux.
+// register wires the auth endpoints onto mux, each rate limited under its own
+// group.
 func (a *Authenticator) register(mux *http.ServeMux) {
-	mux.HandleFunc(a.cfg.ContextRoot+"login", func(w http.ResponseWriter, r *http.Request) {
-		if !a.authLimiter(clientIP(r, a.cfg.TrustedProxies)).Allow() {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return
-		}
-		a.handleLogin(w, r)
-	})
-	mux.HandleFunc(a.cfg.ContextRoot+"callback", func(w http.ResponseWriter, r *http.Request) {
-		if !a.authLimiter(clientIP(r, a.cfg.TrustedProxies)).Allow() {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return
-		}
-		a.handleCallback(w, r)
-	})
-	mux.HandleFunc(a.cfg.ContextRoot+"logout", func(w http.ResponseWriter, r *http.Request) {
-		a.handleLogout(w, r)
-	})
+	mux.Handle(a.cfg.ContextRoot+"login", a.limiter.Wrap(config.RateLimitLogin, http.HandlerFunc(a.handleLogin)))
+	mux.Handle(a.cfg.ContextRoot+"callback", a.limiter.Wrap(config.RateLimitCallback, http.HandlerFunc(a.handleCallback)))
+	mux.Handle(a.cfg.ContextRoot+"logout", a.limiter.Wrap(config.RateLimitLogout, http.HandlerFunc(a.handleLogout)))
 }
 
 func setupOAuthConfig(cfg *config.OAuthConfig) *oauth2.Config {
@@ -155,6 +124,12 @@ func setupOAuthConfig(cfg *config.OAuthConfig) *oauth2.Config {
 }
 
 func (a *Authenticator) handleLogin(w http.ResponseWriter, r *http.Request) {
+	oauthConfig, _, _ := a.provider()
+	if oauthConfig == nil {
+		serveLoginUnavailable(w)
+		return
+	}
+
 	state, err := generateSecureRandomString(32)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to generate state: %v", err), http.StatusInternalServerError)
@@ -169,13 +144,32 @@ func (a *Authenticator) handleLogin(w http.ResponseWriter, r *http.Request) {
 	// state binds the callback to this browser (CSRF); nonce binds the issued
 	// ID token to this login flow (replay protection) and is validated against
 	// the token's nonce claim in the callback.
-	url := a.oauthConfig.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce))
+	url := oauthConfig.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce))
 	setFlowCookie(a.cfg, w, "state", state)
 	setFlowCookie(a.cfg, w, "nonce", nonce)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
 func (a *Authenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "oidc.callback")
+	loggedIn := false
+	defer func() {
+		result := "failure"
+		if loggedIn {
+			result = "success"
+		} else {
+			span.SetStatus(codes.Error, "login failed")
+		}
+		metrics.OIDCLogins.WithLabelValues(result).Inc()
+		span.End()
+	}()
+
+	oauthConfig, _, _ := a.provider()
+	if oauthConfig == nil {
+		serveLoginUnavailable(w)
+		return
+	}
+
 	stateCookie, err := r.Cookie("state")
 	if err != nil || subtle.ConstantTimeCompare([]byte(stateCookie.Value), []byte(r.URL.Query().Get("state"))) != 1 {
 		clearFlowCookies(a.cfg, w)
@@ -185,11 +179,13 @@ func (a *Authenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
 
 	// Bound the token exchange so a hung or slow IdP token endpoint cannot tie
 	// up the request indefinitely.
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	code := r.URL.Query().Get("code")
-	token, err := a.oauthConfig.Exchange(ctx, code)
+	_, exchangeSpan := tracer.Start(ctx, "oidc.token_exchange")
+	token, err := oauthConfig.Exchange(ctx, code)
+	tracing.End(exchangeSpan, err)
 	if err != nil {
 		clearFlowCookies(a.cfg, w)
 		http.Error(w, fmt.Sprintf("Failed to exchange token: %v", err), http.StatusInternalServerError)
@@ -208,7 +204,7 @@ func (a *Authenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
 	claims, err := a.validateRawToken(rawIDToken)
 	if err != nil {
 		clearFlowCookies(a.cfg, w)
-		log.Printf("Callback ID token validation failed: %s %v", r.RemoteAddr, err)
+		slog.Warn("Login callback ID token validation failed", logging.ClientIP(ratelimit.ClientIP(r, a.cfg.TrustedProxies)), logging.Err(err))
 		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
 		return
 	}
@@ -221,7 +217,7 @@ func (a *Authenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
 	tokenNonce, _ := claims["nonce"].(string)
 	if tokenNonce == "" || subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonceCookie.Value)) != 1 {
 		clearFlowCookies(a.cfg, w)
-		log.Printf("Callback nonce mismatch: %s", r.RemoteAddr)
+		slog.Warn("Login callback nonce mismatch", logging.ClientIP(ratelimit.ClientIP(r, a.cfg.TrustedProxies)))
 		http.Error(w, "Invalid nonce", http.StatusBadRequest)
 		return
 	}
@@ -237,58 +233,168 @@ func (a *Authenticator) handleCallback(w http.ResponseWriter, r *http.Request) {
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
 	})
+	loggedIn = true
+	user, _ := claims[a.cfg.OAuthConfig.UsernameClaim].(string)
+	slog.Info("Login succeeded", logging.ClientIP(ratelimit.ClientIP(r, a.cfg.TrustedProxies)), logging.User(user))
 	http.Redirect(w, r, a.cfg.ContextRoot, http.StatusTemporaryRedirect)
 }
 
-func (a *Authenticator) fetchWellKnownOIDCConfig() error {
-	cfg := a.cfg
-	httpClient := &http.Client{Timeout: 10 * time.Second}
+// discovery is the subset of the OIDC discovery document the app uses.
+type discovery struct {
+	Issuer   string `json:"issuer"`
+	TokenUrl string `json:"token_endpoint"`
+	AuthUrl  string `json:"authorization_endpoint"`
+	JWKSURI  string `json:"jwks_uri"`
+}
 
-	wellKnownURL := cfg.OAuthConfig.OIDCWellKnownURL
+// fetchDiscovery retrieves and decodes the OIDC discovery document.
+func fetchDiscovery(httpClient *http.Client, wellKnownURL string) (*discovery, error) {
 	resp, err := httpClient.Get(wellKnownURL)
 	if err != nil {
-		return fmt.Errorf("failed to fetch well-known configuration: %v", err)
+		return nil, fmt.Errorf("failed to fetch well-known configuration: %v", err)
 	}
 	defer resp.Body.Close()
 
-	var discovery struct {
-		Issuer   string `json:"issuer"`
-		TokenUrl string `json:"token_endpoint"`
-		AuthUrl  string `json:"authorization_endpoint"`
-		JWKSURI  string `json:"jwks_uri"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
-		return fmt.Errorf("failed to decode well-known configuration: %v", err)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch well-known configuration: unexpected status %d", resp.StatusCode)
 	}
 
-	if discovery.Issuer != "" {
-		cfg.OAuthConfig.Issuer = discovery.Issuer
-	} else {
-		log.Printf("Warning: well-known configuration provided no issuer; ID token issuer validation is disabled")
+	var d discovery
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("failed to decode well-known configuration: %v", err)
 	}
+	if d.JWKSURI == "" {
+		return nil, fmt.Errorf("well-known configuration provided no jwks_uri")
+	}
+	return &d, nil
+}
 
-	if cfg.OAuthConfig.AuthURL == "" {
-		log.Printf("Using Authorization Endpoint from well-known config %s", discovery.AuthUrl)
-		cfg.OAuthConfig.AuthURL = discovery.AuthUrl
+// CheckDiscovery fetches the identity provider's discovery document and
+// signing keys once, reporting whether authentication could start. It installs
+// nothing and is meant for validating a deployment before it runs.
+func CheckDiscovery(cfg *config.Config) error {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	d, err := fetchDiscovery(httpClient, cfg.OAuthConfig.OIDCWellKnownURL)
+	if err != nil {
+		return err
 	}
-	if cfg.OAuthConfig.TokenURL == "" {
-		log.Printf("Using Token Endpoint from well-known config %s", discovery.TokenUrl)
-		cfg.OAuthConfig.TokenURL = discovery.TokenUrl
+	return newKeyStore(d.JWKSURI, httpClient).refresh()
+}
+
+const (
+	// discoveryRetryMin and discoveryRetryMax bound the exponential backoff
+	// between failed discovery attempts.
+	discoveryRetryMin = 2 * time.Second
+	discoveryRetryMax = 2 * time.Minute
+	// discoveryRefreshInterval is how often discovery is re-run after it has
+	// succeeded, so endpoint or issuer changes are picked up without a restart.
+	discoveryRefreshInterval = time.Hour
+)
+
+// run discovers the identity provider and keeps it current for the life of
+// the process. Failed attempts are retried with exponential backoff; once
+// discovery succeeds it is refreshed every discoveryRefreshInterval. A failed
+// refresh keeps the previously discovered provider in service.
+func (a *Authenticator) run() {
+	backoff := discoveryRetryMin
+	for {
+		wait := discoveryRefreshInterval
+		if err := a.discover(); err != nil {
+			if a.Status() != nil {
+				slog.Error("Authentication unavailable", "retry_in", backoff, logging.Err(err))
+			} else {
+				slog.Warn("OIDC discovery refresh failed, keeping the previous configuration", "retry_in", backoff, logging.Err(err))
+			}
+			wait = backoff
+			backoff = min(backoff*2, discoveryRetryMax)
+		} else {
+			backoff = discoveryRetryMin
+		}
+		time.Sleep(wait)
 	}
+}
+
+// discover performs one discovery attempt: it fetches the well-known
+// configuration, loads the signing keys, and on success atomically installs
+// the new provider state. On failure the previous state, if any, is kept.
+func (a *Authenticator) discover() error {
+	err := a.installDiscovery()
+	a.mu.Lock()
+	a.lastErr = err
+	a.mu.Unlock()
+	return err
+}
 
-	if discovery.JWKSURI == "" {
-		return fmt.Errorf("well-known configuration provided no jwks_uri")
+// installDiscovery does the work of discover and returns its failure, if any.
+func (a *Authenticator) installDiscovery() error {
+	d, err := fetchDiscovery(a.httpClient, a.cfg.OAuthConfig.OIDCWellKnownURL)
+	if err != nil {
+		return err
 	}
 
-	a.keys = newKeyStore(discovery.JWKSURI, httpClient)
-	if err := a.keys.refresh(); err != nil {
+	_, keys, prevIssuer := a.provider()
+	newStore := keys == nil
+	if newStore {
+		keys = newKeyStore(d.JWKSURI, a.httpClient)
+	}
+	if err := keys.refreshFrom(d.JWKSURI); err != nil {
 		return err
 	}
-	go a.keys.refreshLoop()
 
+	if d.Issuer == "" {
+		slog.Warn("Well-known configuration provided no issuer; ID token issuer validation is disabled")
+	} else if prevIssuer != "" && d.Issuer != prevIssuer {
+		slog.Info("OIDC issuer changed", "from", prevIssuer, "to", d.Issuer)
+	}
+
+	// Explicitly configured endpoints override the discovered ones.
+	oauthCfg := a.cfg.OAuthConfig
+	if oauthCfg.AuthURL == "" {
+		oauthCfg.AuthURL = d.AuthUrl
+	}
+	if oauthCfg.TokenURL == "" {
+		oauthCfg.TokenURL = d.TokenUrl
+	}
+	oauthConfig := setupOAuthConfig(&oauthCfg)
+
+	a.mu.Lock()
+	prev := a.oauthConfig
+	a.oauthConfig = oauthConfig
+	a.keys = keys
+	a.issuer = d.Issuer
+	a.mu.Unlock()
+
+	if prev == nil {
+		slog.Info("Authentication available", "auth_url", oauthConfig.Endpoint.AuthURL, "token_url", oauthConfig.Endpoint.TokenURL)
+	} else if prev.Endpoint != oauthConfig.Endpoint {
+		slog.Info("OIDC endpoints changed", "auth_url", oauthConfig.Endpoint.AuthURL, "token_url", oauthConfig.Endpoint.TokenURL)
+	}
+	if newStore {
+		go keys.refreshLoop()
+	}
 	return nil
 }
 
+// loginUnavailablePage is served by the login and callback endpoints while the
+// identity provider has not yet been discovered.
+const loginUnavailablePage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta http-equiv="refresh" content="30"><title>Login temporarily unavailable</title></head>
+<body>
+<h1>Login temporarily unavailable</h1>
+<p>The identity provider cannot be reached right now. This page will retry automatically.</p>
+</body>
+</html>
+`
+
+// serveLoginUnavailable responds with a 503 and the login unavailable page.
+func serveLoginUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Retry-After", "30")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.Write([]byte(loginUnavailablePage))
+}
+
 // handleLogout clears the session cookie and returns the user to the app,
 // which will then prompt for login again. This is a local logout; it does not
 // terminate the session at the identity provider.
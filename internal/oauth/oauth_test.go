@@ -1,116 +1,16 @@
 package oauth
 
 import (
-	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jtgasper3/swarm-visualizer/internal/config"
 )
 
-func mustParseCIDR(s string) *net.IPNet {
-	_, cidr, err := net.ParseCIDR(s)
-	if err != nil {
-		panic(err)
-	}
-	return cidr
-}
-
-func TestClientIP(t *testing.T) {
-	trustedProxy := mustParseCIDR("10.0.0.0/8")
-
-	tests := []struct {
-		name           string
-		remoteAddr     string
-		xRealIP        string
-		xForwardedFor  string
-		trustedProxies []*net.IPNet
-		want           string
-	}{
-		{
-			name:       "no trusted proxies uses RemoteAddr",
-			remoteAddr: "1.2.3.4:5678",
-			xRealIP:    "9.9.9.9",
-			want:       "1.2.3.4",
-		},
-		{
-			name:           "trusted proxy with X-Real-IP",
-			remoteAddr:     "10.1.2.3:5678",
-			xRealIP:        "203.0.113.5",
-			trustedProxies: []*net.IPNet{trustedProxy},
-			want:           "203.0.113.5",
-		},
-		{
-			name:           "trusted proxy with X-Forwarded-For single entry",
-			remoteAddr:     "10.1.2.3:5678",
-			xForwardedFor:  "203.0.113.10",
-			trustedProxies: []*net.IPNet{trustedProxy},
-			want:           "203.0.113.10",
-		},
-		{
-			name:           "trusted proxy with X-Forwarded-For multiple entries returns first",
-			remoteAddr:     "10.1.2.3:5678",
-			xForwardedFor:  "203.0.113.10, 10.5.6.7",
-			trustedProxies: []*net.IPNet{trustedProxy},
-			want:           "203.0.113.10",
-		},
-		{
-			name:           "X-Real-IP preferred over X-Forwarded-For",
-			remoteAddr:     "10.1.2.3:5678",
-			xRealIP:        "203.0.113.5",
-			xForwardedFor:  "203.0.113.10",
-			trustedProxies: []*net.IPNet{trustedProxy},
-			want:           "203.0.113.5",
-		},
-		{
-			name:           "untrusted remote ignores headers",
-			remoteAddr:     "5.5.5.5:1234",
-			xRealIP:        "9.9.9.9",
-			xForwardedFor:  "8.8.8.8",
-			trustedProxies: []*net.IPNet{trustedProxy},
-			want:           "5.5.5.5",
-		},
-		{
-			name:           "trusted proxy with no headers returns RemoteAddr host",
-			remoteAddr:     "10.1.2.3:5678",
-			trustedProxies: []*net.IPNet{trustedProxy},
-			want:           "10.1.2.3",
-		},
-		{
-			name:       "RemoteAddr without port",
-			remoteAddr: "1.2.3.4",
-			want:       "1.2.3.4",
-		},
-		{
-			name:           "X-Real-IP header with whitespace is trimmed",
-			remoteAddr:     "10.1.2.3:5678",
-			xRealIP:        "  203.0.113.5  ",
-			trustedProxies: []*net.IPNet{trustedProxy},
-			want:           "203.0.113.5",
-		},
-	}
-
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/", nil)
-			req.RemoteAddr = tc.remoteAddr
-			if tc.xRealIP != "" {
-				req.Header.Set("X-Real-IP", tc.xRealIP)
-			}
-			if tc.xForwardedFor != "" {
-				req.Header.Set("X-Forwarded-For", tc.xForwardedFor)
-			}
-
-			got := clientIP(req, tc.trustedProxies)
-			if got != tc.want {
-				t.Errorf("clientIP() = %q, want %q", got, tc.want)
-			}
-		})
-	}
-}
-
 func TestRegisterOAuthHandlersUsesDiscoveredEndpoints(t *testing.T) {
 	_, cert := rsaX5c(t)
 	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -141,16 +41,29 @@ func TestRegisterOAuthHandlersUsesDiscoveredEndpoints(t *testing.T) {
 	}
 
 	mux := http.NewServeMux()
-	auth := RegisterOAuthHandlers(mux, cfg)
+	auth := RegisterOAuthHandlers(mux, cfg, nil)
 	if auth == nil {
 		t.Fatal("expected an Authenticator when auth is enabled")
 	}
 
-	if auth.oauthConfig.Endpoint.AuthURL != "https://issuer.example.com/auth" {
-		t.Fatalf("AuthURL = %q, want discovered endpoint", auth.oauthConfig.Endpoint.AuthURL)
+	// Discovery runs in the background; wait for it to complete.
+	deadline := time.Now().Add(2 * time.Second)
+	for auth.Status() != nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := auth.Status(); err != nil {
+		t.Fatalf("discovery did not complete: %v", err)
+	}
+
+	oauthConfig, _, issuer := auth.provider()
+	if oauthConfig.Endpoint.AuthURL != "https://issuer.example.com/auth" {
+		t.Fatalf("AuthURL = %q, want discovered endpoint", oauthConfig.Endpoint.AuthURL)
+	}
+	if oauthConfig.Endpoint.TokenURL != "https://issuer.example.com/token" {
+		t.Fatalf("TokenURL = %q, want discovered endpoint", oauthConfig.Endpoint.TokenURL)
 	}
-	if auth.oauthConfig.Endpoint.TokenURL != "https://issuer.example.com/token" {
-		t.Fatalf("TokenURL = %q, want discovered endpoint", auth.oauthConfig.Endpoint.TokenURL)
+	if issuer != "https://issuer.example.com" {
+		t.Fatalf("issuer = %q, want discovered issuer", issuer)
 	}
 
 	req := httptest.NewRequest(http.MethodGet, "/login", nil)
@@ -162,3 +75,114 @@ func TestRegisterOAuthHandlersUsesDiscoveredEndpoints(t *testing.T) {
 		t.Fatalf("login redirect Location = %q, want discovered authorization endpoint", loc)
 	}
 }
+
+// TestDegradedLoginUnavailable verifies that before discovery succeeds the
+// login and callback endpoints serve the temporarily unavailable page rather
+// than failing.
+func TestDegradedLoginUnavailable(t *testing.T) {
+	a := NewAuthenticator(&config.Config{ContextRoot: "/"}, nil)
+	mux := http.NewServeMux()
+	a.register(mux)
+
+	for _, path := range []string{"/login", "/callback?code=abc&state=xyz"} {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.7:1234"
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s status = %d, want %d", path, rr.Code, http.StatusServiceUnavailable)
+		}
+		if rr.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s: expected a Retry-After header", path)
+		}
+		if !strings.Contains(rr.Body.String(), "Login temporarily unavailable") {
+			t.Fatalf("%s body = %q, want the unavailable page", path, rr.Body.String())
+		}
+	}
+
+	if a.Status() == nil {
+		t.Fatal("expected a non-nil status before discovery")
+	}
+}
+
+// TestDiscover_RecoversAndRefreshes verifies that a failed discovery leaves the
+// Authenticator degraded, a later attempt brings it up, and a refresh picks up
+// changed endpoints while a failed refresh keeps the previous configuration.
+func TestDiscover_RecoversAndRefreshes(t *testing.T) {
+	_, cert := rsaX5c(t)
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(jwksBody(t, testJWK{kid: "rsa1", x5c: []string{cert}})))
+	}))
+	defer jwks.Close()
+
+	var (
+		mu        sync.Mutex
+		available bool
+		authURL   = "https://issuer.example.com/auth"
+	)
+	discovery := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !available {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{
+			"issuer":"https://issuer.example.com",
+			"authorization_endpoint":"` + authURL + `",
+			"token_endpoint":"https://issuer.example.com/token",
+			"jwks_uri":"` + jwks.URL + `"
+		}`))
+	}))
+	defer discovery.Close()
+
+	setState := func(up bool, auth string) {
+		mu.Lock()
+		available, authURL = up, auth
+		mu.Unlock()
+	}
+	authEndpoint := func(a *Authenticator) string {
+		oauthConfig, _, _ := a.provider()
+		return oauthConfig.Endpoint.AuthURL
+	}
+
+	a := NewAuthenticator(&config.Config{ContextRoot: "/", OAuthConfig: config.OAuthConfig{OIDCWellKnownURL: discovery.URL}}, nil)
+
+	if err := a.discover(); err == nil {
+		t.Fatal("expected discovery to fail while the IdP is down")
+	}
+	if err := a.Status(); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("Status() = %v, want the discovery failure", err)
+	}
+
+	setState(true, "https://issuer.example.com/auth")
+	if err := a.discover(); err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	if err := a.Status(); err != nil {
+		t.Fatalf("Status() = %v, want nil after discovery", err)
+	}
+	if got := authEndpoint(a); got != "https://issuer.example.com/auth" {
+		t.Fatalf("AuthURL = %q", got)
+	}
+
+	setState(true, "https://issuer.example.com/v2/auth")
+	if err := a.discover(); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if got := authEndpoint(a); got != "https://issuer.example.com/v2/auth" {
+		t.Fatalf("AuthURL = %q, want the refreshed endpoint", got)
+	}
+
+	setState(false, "")
+	if err := a.discover(); err == nil {
+		t.Fatal("expected the refresh to fail while the IdP is down")
+	}
+	if err := a.Status(); err != nil {
+		t.Fatalf("Status() = %v, want nil: a failed refresh keeps the previous provider", err)
+	}
+	if got := authEndpoint(a); got != "https://issuer.example.com/v2/auth" {
+		t.Fatalf("AuthURL = %q, want the previous endpoint retained", got)
+	}
+}
@@ -0,0 +1,423 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Plan is a set of Paths compiled against one root type. Struct fields are
+// resolved to indexes and patterns are matched against field names when the
+// plan is built, so applying it only walks the values the paths select; map
+// keys and slice indexes vary with the data and are still matched on every
+// Apply. A Plan is immutable and safe for concurrent use.
+type Plan struct {
+	root  reflect.Type
+	steps []planStep
+}
+
+type planStep struct {
+	path *Path
+	node *planNode
+}
+
+// NewPlan compiles paths against root. A path that cannot be resolved
+// against root is left out of the plan and reported in the returned error;
+// the plan is usable either way.
+func NewPlan(root reflect.Type, paths []*Path) (*Plan, error) {
+	plan := &Plan{root: root}
+	var errs []error
+	for _, p := range paths {
+		node, err := compilePlan(root, p.segments)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+			continue
+		}
+		if node != nil {
+			plan.steps = append(plan.steps, planStep{path: p, node: node})
+		}
+	}
+	return plan, errors.Join(errs...)
+}
+
+// Apply redacts the values in obj, a pointer to a value of the plan's root
+// type, selected by each path with that path's action. salt keys the hash
+// action. Every path is applied; the error reports literal slice indexes that
+// are out of range for this value.
+//
+// The slices, maps, and pointers a path writes through are replaced with
+// copies first, so values obj shares with others, e.g. a cache it was built
+// from, are left as they were and applying the plan again to the original
+// does not redact what is already redacted.
+func (pl *Plan) Apply(obj any, salt []byte) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Pointer || v.Type().Elem() != pl.root {
+		return fmt.Errorf("plan for %s applied to %T", pl.root, obj)
+	}
+	v = v.Elem()
+
+	var errs []error
+	owned := make(map[uintptr]struct{})
+	for _, step := range pl.steps {
+		r := redaction{action: step.path.action, salt: salt, owned: owned}
+		if err := step.node.apply(v, r); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", step.path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type planOp int
+
+const (
+	// opRedact applies the action to the value.
+	opRedact planOp = iota
+	// opOpaque does nothing: the value is an interface, whose contents
+	// cannot be known until it is inspected.
+	opOpaque
+	opPointer
+	opFields
+	opMap
+	opSlice
+	opRecursive
+)
+
+// planNode applies the remainder of a path to values of a single type.
+type planNode struct {
+	op planOp
+	// seg selects map keys or slice elements.
+	seg segment
+	// key is the map key named by a literal segment, when the key type is a
+	// string kind.
+	key reflect.Value
+	// index is the slice index named by a literal segment.
+	index int
+	// fields are the selected struct fields.
+	fields []planField
+	// next continues the path below a pointer, map entry, or slice element.
+	next *planNode
+	// here applies the path after "**" at this value, and below descends
+	// into the values nested within it.
+	here, below *planNode
+	// keyValue marks an opRedact node selecting an environment list or
+	// entry.
+	keyValue bool
+}
+
+type planField struct {
+	index []int
+	node  *planNode
+}
+
+// compilePlan compiles segments against values of type t. A nil node with a
+// nil error means the path can never select anything within t, which is only
+// possible with "**".
+func compilePlan(t reflect.Type, segments []segment) (*planNode, error) {
+	if len(segments) == 0 {
+		return &planNode{op: opRedact}, nil
+	}
+
+	if t.Kind() == reflect.Pointer {
+		next, err := compilePlan(t.Elem(), segments)
+		if err != nil || next == nil {
+			return nil, err
+		}
+		return &planNode{op: opPointer, next: next}, nil
+	}
+
+	seg := segments[0]
+	rest := segments[1:]
+
+	if seg.kind == segmentRecursive {
+		return compileDescendants(t, rest, make(map[reflect.Type]*planNode)), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if seg.kind == segmentLiteral {
+			f, ok := findJSONField(t, seg.literal)
+			if !ok {
+				return nil, fmt.Errorf("struct field or json tag %q not found in %s", seg.literal, t)
+			}
+			node, err := compilePlan(f.typ, rest)
+			if err != nil || node == nil {
+				return nil, err
+			}
+			markKeyValue(f, node)
+			return &planNode{op: opFields, fields: []planField{{index: f.index, node: node}}}, nil
+		}
+
+		// A pattern keeps every matching field the rest of the path fits,
+		// and is an error only if it fits none of them.
+		var fields []planField
+		var firstErr error
+		matched := false
+		for _, f := range jsonFields(t) {
+			if !seg.matches(f.name) {
+				continue
+			}
+			matched = true
+			node, err := compilePlan(f.typ, rest)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			if node != nil {
+				markKeyValue(f, node)
+				fields = append(fields, planField{index: f.index, node: node})
+			}
+		}
+		switch {
+		case len(fields) > 0:
+			return &planNode{op: opFields, fields: fields}, nil
+		case firstErr != nil:
+			return nil, firstErr
+		case !matched:
+			return nil, fmt.Errorf("no field of %s matches %q", t, seg.literal)
+		}
+		return nil, nil
+
+	case reflect.Map:
+		next, err := compilePlan(t.Elem(), rest)
+		if err != nil || next == nil {
+			return nil, err
+		}
+		node := &planNode{op: opMap, seg: seg, next: next}
+		if seg.kind == segmentLiteral && t.Key().Kind() == reflect.String {
+			node.key = reflect.ValueOf(seg.literal).Convert(t.Key())
+		}
+		return node, nil
+
+	case reflect.Slice, reflect.Array:
+		node := &planNode{op: opSlice, seg: seg}
+		if seg.kind == segmentLiteral {
+			idx, err := strconv.Atoi(seg.literal)
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("invalid index %q", seg.literal)
+			}
+			node.index = idx
+		}
+		next, err := compilePlan(t.Elem(), rest)
+		if err != nil || next == nil {
+			return nil, err
+		}
+		node.next = next
+		return node, nil
+
+	case reflect.Interface:
+		return &planNode{op: opOpaque}, nil
+
+	default:
+		return nil, fmt.Errorf("cannot navigate into %s at %q", t.Kind(), seg.literal)
+	}
+}
+
+// markKeyValue marks node, compiled for field f, as selecting an
+// environment list or entry if f is one.
+func markKeyValue(f jsonField, node *planNode) {
+	if !isKeyValueList(f) {
+		return
+	}
+	switch {
+	case node.op == opRedact:
+		node.keyValue = true
+	case node.op == opSlice && node.next.op == opRedact:
+		node.next.keyValue = true
+	}
+}
+
+// compileDescendants implements "**": it compiles rest at t and at every type
+// nested within it, pruning branches where rest can never apply. memo holds
+// the nodes already built for this "**", which also terminates recursive
+// types.
+func compileDescendants(t reflect.Type, rest []segment, memo map[reflect.Type]*planNode) *planNode {
+	if t.Kind() == reflect.Pointer {
+		next := compileDescendants(t.Elem(), rest, memo)
+		if next == nil {
+			return nil
+		}
+		return &planNode{op: opPointer, next: next}
+	}
+	if node, ok := memo[t]; ok {
+		return node
+	}
+
+	node := &planNode{op: opRecursive}
+	memo[t] = node
+
+	// Most types will not have what rest names, so errors just mean there
+	// is nothing to apply here.
+	node.here, _ = compilePlan(t, rest)
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var fields []planField
+		for _, f := range jsonFields(t) {
+			if child := compileDescendants(f.typ, rest, memo); child != nil {
+				fields = append(fields, planField{index: f.index, node: child})
+			}
+		}
+		if len(fields) > 0 {
+			node.below = &planNode{op: opFields, fields: fields}
+		}
+	case reflect.Map:
+		if canNest(t.Elem()) {
+			if child := compileDescendants(t.Elem(), rest, memo); child != nil {
+				node.below = &planNode{op: opMap, seg: segment{kind: segmentWildcard}, next: child}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if canNest(t.Elem()) {
+			if child := compileDescendants(t.Elem(), rest, memo); child != nil {
+				node.below = &planNode{op: opSlice, seg: segment{kind: segmentWildcard}, next: child}
+			}
+		}
+	}
+
+	if node.here == nil && node.below == nil {
+		memo[t] = nil
+		return nil
+	}
+	return node
+}
+
+// apply runs n against v, which must be settable. The error reports literal
+// slice indexes out of range for v; the first one found is returned, but the
+// rest of the plan is still applied.
+func (n *planNode) apply(v reflect.Value, r redaction) error {
+	switch n.op {
+	case opRedact:
+		r.redact(v, n.keyValue)
+
+	case opPointer:
+		if v.IsNil() {
+			return nil
+		}
+		r.own(v)
+		return n.next.apply(v.Elem(), r)
+
+	case opFields:
+		var firstErr error
+		for _, f := range n.fields {
+			if err := f.node.apply(v.FieldByIndex(f.index), r); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+
+	case opMap:
+		if v.Len() == 0 {
+			return nil
+		}
+		// Map elements are not addressable, so each entry is modified in a
+		// copy that is stored back.
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if n.key.IsValid() {
+			val := v.MapIndex(n.key)
+			if !val.IsValid() {
+				return nil
+			}
+			r.own(v)
+			elem.Set(val)
+			err := n.next.apply(elem, r)
+			v.SetMapIndex(n.key, elem)
+			return err
+		}
+		r.own(v)
+		var firstErr error
+		key := reflect.New(v.Type().Key()).Elem()
+		iter := v.MapRange()
+		for iter.Next() {
+			key.SetIterKey(iter)
+			if n.seg.kind != segmentWildcard && !n.seg.matches(mapKeyString(key)) {
+				continue
+			}
+			elem.SetIterValue(iter)
+			if err := n.next.apply(elem, r); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			v.SetMapIndex(key, elem)
+		}
+		return firstErr
+
+	case opSlice:
+		if n.seg.kind == segmentLiteral {
+			if n.index >= v.Len() {
+				return fmt.Errorf("invalid index %q", n.seg.literal)
+			}
+			r.own(v)
+			return n.next.apply(v.Index(n.index), r)
+		}
+		r.own(v)
+		var firstErr error
+		for i := 0; i < v.Len(); i++ {
+			if n.seg.kind != segmentWildcard && !n.seg.matches(strconv.Itoa(i)) {
+				continue
+			}
+			if err := n.next.apply(v.Index(i), r); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+
+	case opRecursive:
+		// As with compiling, "**" ignores values the rest of the path does
+		// not fit.
+		if n.here != nil {
+			n.here.apply(v, r)
+		}
+		if n.below != nil {
+			n.below.apply(v, r)
+		}
+	}
+	return nil
+}
+
+// own replaces v, a slice, map, or non-nil pointer, with a shallow copy, so
+// what is written through it is not seen by values sharing the original. A
+// copy made earlier in the same application is already owned and is not
+// copied again. Arrays are values, so are left alone.
+func (r redaction) own(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+	default:
+		return
+	}
+	if v.IsNil() {
+		return
+	}
+	if _, ok := r.owned[v.Pointer()]; ok {
+		return
+	}
+	var c reflect.Value
+	switch v.Kind() {
+	case reflect.Pointer:
+		c = reflect.New(v.Type().Elem())
+		c.Elem().Set(v.Elem())
+	case reflect.Map:
+		c = reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), iter.Value())
+		}
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return
+		}
+		c = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+	}
+	v.Set(c)
+	r.owned[c.Pointer()] = struct{}{}
+}
+
+// mapKeyString formats a map key for matching against a segment.
+func mapKeyString(key reflect.Value) string {
+	if key.Kind() == reflect.String {
+		return key.String()
+	}
+	return fmt.Sprint(key.Interface())
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustCompilePaths(tb testing.TB, specs ...string) []*Path {
+	tb.Helper()
+	paths := make([]*Path, len(specs))
+	for i, spec := range specs {
+		p, err := CompilePath(spec)
+		if err != nil {
+			tb.Fatalf("CompilePath(%q): %v", spec, err)
+		}
+		paths[i] = p
+	}
+	return paths
+}
+
+func TestNewPlan(t *testing.T) {
+	paths := mustCompilePaths(t, "inner.secret", "nope", "**.keep=mask", "name.deeper")
+	plan, err := NewPlan(reflect.TypeOf(clrOuter{}), paths)
+	if err == nil || !strings.Contains(err.Error(), "nope") || !strings.Contains(err.Error(), "name.deeper") {
+		t.Fatalf("NewPlan error = %v, want the unresolvable paths reported", err)
+	}
+
+	o := newClrOuter()
+	if err := plan.Apply(o, nil); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if o.Inner.Secret != "" || o.Name != "name" {
+		t.Errorf("Inner.Secret = %q, Name = %q, want only the valid literal path applied", o.Inner.Secret, o.Name)
+	}
+	if o.Inner.Keep != Sanitized || o.Items[1].Keep != Sanitized || o.Labels["keep"] != Sanitized {
+		t.Errorf("**.keep not masked everywhere: %+v", o)
+	}
+}
+
+func TestPlan_Apply(t *testing.T) {
+	plan, err := NewPlan(reflect.TypeOf(clrOuter{}), mustCompilePaths(t, "items.1.secret", "labels.secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := newClrOuter()
+	o.Items = o.Items[:1]
+	err = plan.Apply(o, nil)
+	if err == nil || !strings.Contains(err.Error(), "items.1.secret") {
+		t.Fatalf("Apply error = %v, want the out-of-range index reported", err)
+	}
+	if o.Labels["secret"] != "" {
+		t.Errorf("Labels[secret] = %q, want later paths applied despite the error", o.Labels["secret"])
+	}
+
+	if err := plan.Apply(&clrInner{}, nil); err == nil {
+		t.Error("expected an error applying the plan to the wrong type")
+	}
+}
+
+// TestPlan_ApplyLeavesSharedValues verifies that applying a plan to a copy
+// of a value leaves the slices and maps it shares with the original alone, so
+// applying it again to a fresh copy gives the same result.
+func TestPlan_ApplyLeavesSharedValues(t *testing.T) {
+	plan, err := NewPlan(reflect.TypeOf(clrOuter{}), mustCompilePaths(t, "items.*.secret", "m.*.secret", "labels.secret=mask", "**.keep=hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := newClrOuter()
+	first, second := *original, *original
+	if err := plan.Apply(&first, []byte("salt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Apply(&second, []byte("salt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(*original, *newClrOuter()) {
+		t.Errorf("original = %+v, want it unchanged", *original)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("applied again = %+v, want %+v", second, first)
+	}
+	if first.Items[0].Secret != "" || first.M["x"].Secret != "" || first.Labels["secret"] != Sanitized || first.Inner.Keep == "keep" {
+		t.Errorf("applied = %+v, want the paths applied", first)
+	}
+}
+
+// benchmarkOuter builds a clrOuter with n items and n map entries.
+func benchmarkOuter(n int) *clrOuter {
+	o := newClrOuter()
+	for i := range n {
+		o.Items = append(o.Items, clrInner{Secret: "s", Keep: "k"})
+		o.M[fmt.Sprint(i)] = clrInner{Secret: "s", Keep: "k"}
+		o.Labels[fmt.Sprint("l", i)] = "v"
+	}
+	return o
+}
+
+// BenchmarkApply compares applying paths through a Plan built once with
+// compiling each path against the value on every Apply.
+func BenchmarkApply(b *testing.B) {
+	paths := mustCompilePaths(b, "items.*.secret", "m.*.secret", "labels.'l1*'", "**.keep")
+	o := benchmarkOuter(1000)
+
+	b.Run("plan", func(b *testing.B) {
+		plan, err := NewPlan(reflect.TypeOf(clrOuter{}), paths)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		for b.Loop() {
+			plan.Apply(o, nil)
+		}
+	})
+
+	b.Run("path", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			for _, p := range paths {
+				p.Apply(o, nil)
+			}
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Project copies into dst the values of src selected by paths, leaving the
+// rest of dst untouched, so starting from a zero dst yields an allow-listed
+// copy of src. dst and src must be pointers to the same type. Containers on
+// the way to a selected value are allocated as needed; slices keep src's
+// length so elements stay aligned. Path actions are ignored, values inside
+// interfaces are never copied, and "**" is not supported.
+func Project(dst, src any, paths []*Path) {
+	d, s := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	for _, p := range paths {
+		project(d, s, p.segments)
+	}
+}
+
+func project(dst, src reflect.Value, segments []segment) {
+	if len(segments) == 0 {
+		dst.Set(src)
+		return
+	}
+
+	seg := segments[0]
+	rest := segments[1:]
+
+	// Dereference pointers, allocating in dst
+	if src.Kind() == reflect.Pointer {
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(src.Type().Elem()))
+		}
+		dst, src = dst.Elem(), src.Elem()
+	}
+
+	switch src.Kind() {
+	case reflect.Struct:
+		for _, f := range jsonFields(src.Type()) {
+			if seg.matches(f.name) || (seg.kind == segmentLiteral && f.goName == seg.literal) {
+				project(dst.FieldByIndex(f.index), src.FieldByIndex(f.index), rest)
+			}
+		}
+
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+		}
+		for _, key := range src.MapKeys() {
+			if !seg.matches(fmt.Sprint(key.Interface())) {
+				continue
+			}
+			elem := reflect.New(src.Type().Elem()).Elem()
+			if existing := dst.MapIndex(key); existing.IsValid() {
+				elem.Set(existing)
+			}
+			project(elem, src.MapIndex(key), rest)
+			dst.SetMapIndex(key, elem)
+		}
+
+	case reflect.Slice, reflect.Array:
+		if src.Kind() == reflect.Slice {
+			if src.IsNil() {
+				return
+			}
+			if dst.IsNil() {
+				dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Len()))
+			}
+		}
+		for i := 0; i < src.Len(); i++ {
+			if seg.matches(strconv.Itoa(i)) {
+				project(dst.Index(i), src.Index(i), rest)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+type projInner struct {
+	Keep string `json:"keep"`
+	Drop string `json:"drop"`
+}
+
+type projOuter struct {
+	ID     string
+	Inner  *projInner           `json:"inner"`
+	Items  []projInner          `json:"items"`
+	Labels map[string]string    `json:"labels"`
+	M      map[string]projInner `json:"m"`
+	Drop   int                  `json:"drop"`
+}
+
+func TestProject(t *testing.T) {
+	src := &projOuter{
+		ID:     "id",
+		Inner:  &projInner{Keep: "k", Drop: "d"},
+		Items:  []projInner{{Keep: "k0", Drop: "d0"}, {Keep: "k1", Drop: "d1"}},
+		Labels: map[string]string{"com.example.a": "a", "com.example.b": "b", "other": "o"},
+		M:      map[string]projInner{"x": {Keep: "kx", Drop: "dx"}},
+		Drop:   7,
+	}
+
+	var paths []*Path
+	for _, p := range []string{"ID", "inner.keep", "items.*.keep", "labels.'com.example.*'", "m.x.keep", "missing.path"} {
+		compiled, err := CompilePath(p)
+		if err != nil {
+			t.Fatalf("CompilePath(%q): %v", p, err)
+		}
+		paths = append(paths, compiled)
+	}
+
+	var dst projOuter
+	Project(&dst, src, paths)
+
+	want := projOuter{
+		ID:     "id",
+		Inner:  &projInner{Keep: "k"},
+		Items:  []projInner{{Keep: "k0"}, {Keep: "k1"}},
+		Labels: map[string]string{"com.example.a": "a", "com.example.b": "b"},
+		M:      map[string]projInner{"x": {Keep: "kx"}},
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("Project =\n%+v\nwant\n%+v", dst, want)
+	}
+	if src.Inner.Drop != "d" || src.Items[0].Drop != "d0" {
+		t.Error("Project modified src")
+	}
+}
+
+func TestProject_NilSourcesStayNil(t *testing.T) {
+	p, err := CompilePath("inner.keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst projOuter
+	Project(&dst, &projOuter{}, []*Path{p})
+	if dst.Inner != nil {
+		t.Errorf("Inner = %+v, want nil when the source is nil", dst.Inner)
+	}
+}
@@ -0,0 +1,150 @@
+// Package ratelimit applies per-client-IP token-bucket limits to groups of
+// HTTP endpoints and resolves the client IP behind trusted reverse proxies.
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+)
+
+// Limiter holds a token bucket per (group, client IP) pair, each governed by
+// the group's policy. A nil *Limiter allows everything. It is safe for
+// concurrent use.
+type Limiter struct {
+	policies       map[string]config.RateLimit
+	trustedProxies []*net.IPNet
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+}
+
+type bucketKey struct {
+	group string
+	ip    string
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// New creates a Limiter enforcing policies, keyed by group name. Groups with
+// no policy, or a policy with zero Requests, are not limited.
+func New(policies map[string]config.RateLimit, trustedProxies []*net.IPNet) *Limiter {
+	return &Limiter{
+		policies:       policies,
+		trustedProxies: trustedProxies,
+		buckets:        make(map[bucketKey]*bucket),
+	}
+}
+
+// Allow consumes a token from ip's bucket for group. When the bucket is empty
+// it returns false and how long the client should wait before retrying.
+func (l *Limiter) Allow(group, ip string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	p, ok := l.policies[group]
+	if !ok || p.Requests <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	key := bucketKey{group: group, ip: ip}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Every(p.Period/time.Duration(p.Requests)), max(p.Burst, 1))}
+		l.buckets[key] = b
+	}
+	now := time.Now()
+	b.lastSeen = now
+	l.mu.Unlock()
+
+	res := b.limiter.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		metrics.RateLimitRejections.WithLabelValues(group).Inc()
+		return false, delay
+	}
+	return true, 0
+}
+
+// Wrap rate limits next under group's policy, responding 429 with a
+// Retry-After header when the client's bucket is empty.
+func (l *Limiter) Wrap(group string, next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := l.Allow(group, ClientIP(r, l.trustedProxies)); !ok {
+			SetRetryAfter(w, retryAfter)
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Cleanup periodically removes buckets not seen in the last 10 minutes. It
+// runs for the life of the process.
+func (l *Limiter) Cleanup() {
+	for {
+		time.Sleep(5 * time.Minute)
+		l.prune(time.Now().Add(-10 * time.Minute))
+	}
+}
+
+// prune removes buckets last seen before cutoff.
+func (l *Limiter) prune(cutoff time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if b.lastSeen.Before(cutoff) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// SetRetryAfter sets the Retry-After header to d rounded up to whole seconds,
+// and at least one second.
+func SetRetryAfter(w http.ResponseWriter, d time.Duration) {
+	secs := max(int(math.Ceil(d.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
+
+// ClientIP returns the real client IP. If the direct connection is from a
+// trusted proxy, X-Real-IP and X-Forwarded-For headers are consulted instead.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if len(trustedProxies) > 0 {
+		if remoteIP := net.ParseIP(host); remoteIP != nil {
+			for _, cidr := range trustedProxies {
+				if cidr.Contains(remoteIP) {
+					if ip := r.Header.Get("X-Real-IP"); ip != "" {
+						return strings.TrimSpace(ip)
+					}
+					if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+						return strings.TrimSpace(strings.SplitN(fwd, ",", 2)[0])
+					}
+					break
+				}
+			}
+		}
+	}
+
+	return host
+}
@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jtgasper3/swarm-visualizer/internal/config"
+	"github.com/jtgasper3/swarm-visualizer/internal/metrics"
+)
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, cidr, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return cidr
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxy := mustParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		xRealIP        string
+		xForwardedFor  string
+		trustedProxies []*net.IPNet
+		want           string
+	}{
+		{
+			name:       "no trusted proxies uses RemoteAddr",
+			remoteAddr: "1.2.3.4:5678",
+			xRealIP:    "9.9.9.9",
+			want:       "1.2.3.4",
+		},
+		{
+			name:           "trusted proxy with X-Real-IP",
+			remoteAddr:     "10.1.2.3:5678",
+			xRealIP:        "203.0.113.5",
+			trustedProxies: []*net.IPNet{trustedProxy},
+			want:           "203.0.113.5",
+		},
+		{
+			name:           "trusted proxy with X-Forwarded-For single entry",
+			remoteAddr:     "10.1.2.3:5678",
+			xForwardedFor:  "203.0.113.10",
+			trustedProxies: []*net.IPNet{trustedProxy},
+			want:           "203.0.113.10",
+		},
+		{
+			name:           "trusted proxy with X-Forwarded-For multiple entries returns first",
+			remoteAddr:     "10.1.2.3:5678",
+			xForwardedFor:  "203.0.113.10, 10.5.6.7",
+			trustedProxies: []*net.IPNet{trustedProxy},
+			want:           "203.0.113.10",
+		},
+		{
+			name:           "X-Real-IP preferred over X-Forwarded-For",
+			remoteAddr:     "10.1.2.3:5678",
+			xRealIP:        "203.0.113.5",
+			xForwardedFor:  "203.0.113.10",
+			trustedProxies: []*net.IPNet{trustedProxy},
+			want:           "203.0.113.5",
+		},
+		{
+			name:           "untrusted remote ignores headers",
+			remoteAddr:     "5.5.5.5:1234",
+			xRealIP:        "9.9.9.9",
+			xForwardedFor:  "8.8.8.8",
+			trustedProxies: []*net.IPNet{trustedProxy},
+			want:           "5.5.5.5",
+		},
+		{
+			name:           "trusted proxy with no headers returns RemoteAddr host",
+			remoteAddr:     "10.1.2.3:5678",
+			trustedProxies: []*net.IPNet{trustedProxy},
+			want:           "10.1.2.3",
+		},
+		{
+			name:       "RemoteAddr without port",
+			remoteAddr: "1.2.3.4",
+			want:       "1.2.3.4",
+		},
+		{
+			name:           "X-Real-IP header with whitespace is trimmed",
+			remoteAddr:     "10.1.2.3:5678",
+			xRealIP:        "  203.0.113.5  ",
+			trustedProxies: []*net.IPNet{trustedProxy},
+			want:           "203.0.113.5",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.xRealIP != "" {
+				req.Header.Set("X-Real-IP", tc.xRealIP)
+			}
+			if tc.xForwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.xForwardedFor)
+			}
+
+			got := ClientIP(req, tc.trustedProxies)
+			if got != tc.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestWrap_RejectsWithRetryAfter verifies that once a client's burst is spent
+// it receives 429 with a Retry-After header, the rejection is counted, and
+// other clients and groups are unaffected.
+func TestWrap_RejectsWithRetryAfter(t *testing.T) {
+	l := New(map[string]config.RateLimit{
+		config.RateLimitWS: {Requests: 1, Period: time.Minute, Burst: 2},
+	}, nil)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	ws := l.Wrap(config.RateLimitWS, ok)
+	api := l.Wrap(config.RateLimitAPI, ok) // no policy: unlimited
+	rejected := testutil.ToFloat64(metrics.RateLimitRejections.WithLabelValues(config.RateLimitWS))
+
+	do := func(h http.Handler, remote string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := do(ws, "1.1.1.1:1"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d within burst: status = %d", i, rr.Code)
+		}
+	}
+	rr := do(ws, "1.1.1.1:1")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	secs, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	if err != nil || secs < 1 || secs > 60 {
+		t.Fatalf("Retry-After = %q, want 1..60 seconds", rr.Header().Get("Retry-After"))
+	}
+	if got := testutil.ToFloat64(metrics.RateLimitRejections.WithLabelValues(config.RateLimitWS)) - rejected; got != 1 {
+		t.Fatalf("rejections = %v, want 1", got)
+	}
+
+	if rr := do(ws, "2.2.2.2:1"); rr.Code != http.StatusOK {
+		t.Fatalf("other client status = %d, want 200", rr.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if rr := do(api, "1.1.1.1:1"); rr.Code != http.StatusOK {
+			t.Fatalf("unlimited group status = %d, want 200", rr.Code)
+		}
+	}
+}
+
+func TestNilLimiterAllows(t *testing.T) {
+	var l *Limiter
+	if ok, _ := l.Allow(config.RateLimitLogin, "1.1.1.1"); !ok {
+		t.Fatal("nil limiter should allow")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l := New(map[string]config.RateLimit{config.RateLimitLogin: {Requests: 1, Period: time.Minute, Burst: 1}}, nil)
+	l.Allow(config.RateLimitLogin, "1.1.1.1")
+	l.prune(time.Now().Add(time.Second))
+	if len(l.buckets) != 0 {
+		t.Fatalf("expected stale buckets to be pruned, have %d", len(l.buckets))
+	}
+}
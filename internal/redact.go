@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Sanitized replaces redacted values in the published data, so the UI can
+// tell a hidden value from an empty one.
+const Sanitized = "(sanitized)"
+
+// hashedPrefix starts the replacement written by ActionHash.
+const hashedPrefix = "(sanitized:"
+
+// Action is what a sanitization path does to the values it selects.
+type Action int
+
+const (
+	// ActionClear zeroes the value. It is the default.
+	ActionClear Action = iota
+	// ActionMask replaces each string with Sanitized.
+	ActionMask
+	// ActionKeys keeps the key of KEY=value strings, replacing the value
+	// with Sanitized as SanitizeEnv does. It applies to environment lists;
+	// elsewhere, where a string is not known to be KEY=value, it masks.
+	ActionKeys
+	// ActionHash replaces each string with a salted hash, so equal values
+	// can be spotted without being revealed.
+	ActionHash
+)
+
+var actionNames = map[string]Action{
+	"clear": ActionClear,
+	"mask":  ActionMask,
+	"keys":  ActionKeys,
+	"hash":  ActionHash,
+}
+
+// ParseAction parses an action name: clear, mask, keys, or hash.
+func ParseAction(name string) (Action, error) {
+	a, ok := actionNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown action %q, expected clear, mask, keys, or hash", name)
+	}
+	return a, nil
+}
+
+// String returns the action's name.
+func (a Action) String() string {
+	for name, action := range actionNames {
+		if action == a {
+			return name
+		}
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// replace returns the redacted form of s. keyValue reports whether s is an
+// environment entry, whose key ActionKeys keeps. Every action is idempotent:
+// redacting an already redacted string leaves it unchanged, so data can be
+// sanitized more than once.
+func (a Action) replace(s string, salt []byte, keyValue bool) string {
+	switch a {
+	case ActionKeys:
+		// Selected by a path, an entry without "=" may itself be a value.
+		if !keyValue || !strings.Contains(s, "=") {
+			return Sanitized
+		}
+		return SanitizeEnv(s)
+	case ActionHash:
+		if strings.HasPrefix(s, hashedPrefix) {
+			return s
+		}
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(s))
+		return hashedPrefix + hex.EncodeToString(mac.Sum(nil))[:16] + ")"
+	default:
+		return Sanitized
+	}
+}
+
+// SanitizeEnv redacts the value of a KEY=value environment entry, returning
+// KEY=(sanitized). A bare KEY, which passes a variable through from the
+// client's environment, holds no value, so it becomes KEY=(sanitized) too.
+func SanitizeEnv(env string) string {
+	key, _, _ := strings.Cut(env, "=")
+	return key + "=" + Sanitized
+}
+
+// splitAction separates a trailing "=action" from a path spec. The "=" must
+// be outside single quotes.
+func splitAction(spec string) (string, Action, error) {
+	inQuotes := false
+	eq := -1
+	for i := 0; i < len(spec); i++ {
+		switch spec[i] {
+		case '\'':
+			inQuotes = !inQuotes
+		case '=':
+			if !inQuotes {
+				eq = i
+			}
+		}
+	}
+	if eq < 0 {
+		return spec, ActionClear, nil
+	}
+	action, err := ParseAction(strings.TrimSpace(spec[eq+1:]))
+	return strings.TrimSpace(spec[:eq]), action, err
+}
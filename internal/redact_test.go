@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPathApplyActions(t *testing.T) {
+	type env struct {
+		Env    []string          `json:"env"`
+		Args   []string          `json:"args"`
+		Labels map[string]string `json:"labels"`
+		Count  int               `json:"count"`
+	}
+	newEnv := func() *env {
+		return &env{
+			Env:    []string{"USER=admin", "PASSWORD=hunter2", "BARE"},
+			Args:   []string{"--token=hunter2", "hunter2"},
+			Labels: map[string]string{"a": "same", "b": "same", "c": "other", "d": "hunter2=x"},
+			Count:  3,
+		}
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		check func(t *testing.T, e *env)
+	}{
+		{
+			name: "clear is the default",
+			path: "env",
+			check: func(t *testing.T, e *env) {
+				if e.Env != nil {
+					t.Errorf("Env = %v, want nil", e.Env)
+				}
+			},
+		},
+		{
+			name: "explicit clear",
+			path: "env.1=clear",
+			check: func(t *testing.T, e *env) {
+				if e.Env[1] != "" || e.Env[0] != "USER=admin" {
+					t.Errorf("Env = %v, want only index 1 cleared", e.Env)
+				}
+			},
+		},
+		{
+			name: "mask replaces every string",
+			path: "env=mask",
+			check: func(t *testing.T, e *env) {
+				for i, v := range e.Env {
+					if v != Sanitized {
+						t.Errorf("Env[%d] = %q, want %q", i, v, Sanitized)
+					}
+				}
+			},
+		},
+		{
+			name: "mask zeroes non-strings",
+			path: "count=mask",
+			check: func(t *testing.T, e *env) {
+				if e.Count != 0 {
+					t.Errorf("Count = %d, want 0", e.Count)
+				}
+			},
+		},
+		{
+			name: "keys keeps variable names",
+			path: "env=keys",
+			check: func(t *testing.T, e *env) {
+				want := []string{"USER=(sanitized)", "PASSWORD=(sanitized)", "(sanitized)"}
+				for i := range want {
+					if e.Env[i] != want[i] {
+						t.Errorf("Env[%d] = %q, want %q", i, e.Env[i], want[i])
+					}
+				}
+			},
+		},
+		{
+			name: "keys applies to an environment entry",
+			path: "env.*=keys",
+			check: func(t *testing.T, e *env) {
+				if e.Env[1] != "PASSWORD=(sanitized)" {
+					t.Errorf("Env[1] = %q, want %q", e.Env[1], "PASSWORD=(sanitized)")
+				}
+			},
+		},
+		{
+			name: "keys masks lists that are not environments",
+			path: "args=keys",
+			check: func(t *testing.T, e *env) {
+				for i, v := range e.Args {
+					if v != Sanitized {
+						t.Errorf("Args[%d] = %q, want %q", i, v, Sanitized)
+					}
+				}
+			},
+		},
+		{
+			name: "keys masks labels",
+			path: "labels.*=keys",
+			check: func(t *testing.T, e *env) {
+				for k, v := range e.Labels {
+					if v != Sanitized {
+						t.Errorf("Labels[%s] = %q, want %q", k, v, Sanitized)
+					}
+				}
+			},
+		},
+		{
+			name: "keys on every field keeps only environment keys",
+			path: "*=keys",
+			check: func(t *testing.T, e *env) {
+				if e.Env[0] != "USER=(sanitized)" || e.Args[0] != Sanitized || e.Labels["d"] != Sanitized {
+					t.Errorf("Env = %v, Args = %v, Labels = %v, want keys kept only in Env", e.Env, e.Args, e.Labels)
+				}
+			},
+		},
+		{
+			name: "hash is stable and hides the value",
+			path: "labels.*=hash",
+			check: func(t *testing.T, e *env) {
+				if e.Labels["a"] != e.Labels["b"] {
+					t.Errorf("equal values hashed differently: %q, %q", e.Labels["a"], e.Labels["b"])
+				}
+				if e.Labels["a"] == e.Labels["c"] {
+					t.Errorf("different values hashed equally: %q", e.Labels["a"])
+				}
+				if !strings.HasPrefix(e.Labels["a"], "(sanitized:") || strings.Contains(e.Labels["a"], "same") {
+					t.Errorf("Labels[a] = %q, want a sanitized hash", e.Labels["a"])
+				}
+			},
+		},
+		{
+			name: "quoted equals sign is part of the key",
+			path: "labels.'a=b'=mask",
+			check: func(t *testing.T, e *env) {
+				if e.Labels["a"] != "same" {
+					t.Errorf("Labels[a] = %q, want unchanged", e.Labels["a"])
+				}
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := CompilePath(tc.path)
+			if err != nil {
+				t.Fatalf("CompilePath(%q): %v", tc.path, err)
+			}
+			e := newEnv()
+			if err := p.Apply(e, []byte("salt")); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			tc.check(t, e)
+
+			// Actions are idempotent, so applying again changes nothing.
+			before := *e
+			before.Env = append([]string(nil), e.Env...)
+			before.Args = append([]string(nil), e.Args...)
+			before.Labels = make(map[string]string)
+			for k, v := range e.Labels {
+				before.Labels[k] = v
+			}
+			if err := p.Apply(e, []byte("salt")); err != nil {
+				t.Fatalf("second Apply: %v", err)
+			}
+			for i := range e.Env {
+				if e.Env[i] != before.Env[i] {
+					t.Errorf("second Apply changed Env[%d] from %q to %q", i, before.Env[i], e.Env[i])
+				}
+			}
+			for i := range e.Args {
+				if e.Args[i] != before.Args[i] {
+					t.Errorf("second Apply changed Args[%d] from %q to %q", i, before.Args[i], e.Args[i])
+				}
+			}
+			for k := range e.Labels {
+				if e.Labels[k] != before.Labels[k] {
+					t.Errorf("second Apply changed Labels[%s] from %q to %q", k, before.Labels[k], e.Labels[k])
+				}
+			}
+		})
+	}
+}
+
+func TestPathApplyKeysWithinStruct(t *testing.T) {
+	type container struct {
+		Env     []string          `json:",omitempty"`
+		Command []string          `json:",omitempty"`
+		Labels  map[string]string `json:",omitempty"`
+	}
+	v := struct {
+		Spec container `json:"spec"`
+	}{Spec: container{
+		Env:     []string{"PASSWORD=hunter2"},
+		Command: []string{"run", "--token=hunter2"},
+		Labels:  map[string]string{"hunter2": "a=b"},
+	}}
+	p, err := CompilePath("spec=keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Apply(&v, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v.Spec.Env[0] != "PASSWORD=(sanitized)" {
+		t.Errorf("Env = %v, want the key kept", v.Spec.Env)
+	}
+	if v.Spec.Command[1] != Sanitized || v.Spec.Labels["hunter2"] != Sanitized {
+		t.Errorf("Command = %v, Labels = %v, want masked", v.Spec.Command, v.Spec.Labels)
+	}
+}
+
+func TestSanitizeEnv(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "PASSWORD=hunter2", want: "PASSWORD=(sanitized)"},
+		{in: "EMPTY=", want: "EMPTY=(sanitized)"},
+		{in: "URL=a=b", want: "URL=(sanitized)"},
+		{in: "FOO", want: "FOO=(sanitized)"},
+		{in: "FOO=(sanitized)", want: "FOO=(sanitized)"},
+	}
+	for _, tc := range tests {
+		if got := SanitizeEnv(tc.in); got != tc.want {
+			t.Errorf("SanitizeEnv(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestPathApplyHashSalt(t *testing.T) {
+	p, err := CompilePath("labels.a=hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := func(salt string) string {
+		v := struct {
+			Labels map[string]string `json:"labels"`
+		}{Labels: map[string]string{"a": "value"}}
+		if err := p.Apply(&v, []byte(salt)); err != nil {
+			t.Fatal(err)
+		}
+		return v.Labels["a"]
+	}
+	if hash("one") != hash("one") {
+		t.Error("hash differs for the same salt")
+	}
+	if hash("one") == hash("two") {
+		t.Error("hash is the same for different salts")
+	}
+}
+
+func TestCompilePathUnknownAction(t *testing.T) {
+	if _, err := CompilePath("labels.a=scramble"); err == nil || !strings.Contains(err.Error(), "unknown action") {
+		t.Fatalf("CompilePath error = %v, want an unknown action error", err)
+	}
+}
@@ -3,143 +3,323 @@ package internal
 import (
 	"fmt"
 	"reflect"
-	"strconv"
+	"regexp"
 	"strings"
+	"sync"
 )
 
-func ClearByPath(obj any, path string) error {
-	parts := splitPath(path)
+// Path is a compiled sanitization path: a sequence of segments, each naming a
+// struct field (by JSON tag or Go name), map key, or slice index. Besides
+// literal names a segment may be
+//
+//   - "*", matching every field, key, or element at that level;
+//   - "**", matching zero or more levels of any fields, keys, or elements;
+//   - a glob containing "*" (any run of characters) or "?" (one character),
+//     e.g. 'com.example.secret.*';
+//   - a regular expression between slashes, e.g. '/^com\.example\./', matched
+//     unanchored.
+//
+// Patterns are matched against map keys, JSON field names, and slice indexes.
+//
+// A path may end with "=action" (outside quotes) to choose what happens to
+// the values it selects; see Action. A Path is immutable and safe for
+// concurrent use.
+type Path struct {
+	raw      string
+	segments []segment
+	action   Action
+}
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentWildcard
+	segmentRecursive
+	segmentPattern
+)
+
+type segment struct {
+	kind segmentKind
+	// literal is the segment as written, used for literal matching and in
+	// error messages.
+	literal string
+	re      *regexp.Regexp
+}
 
-	return clearRecursive(reflect.ValueOf(obj), parts)
+// matches reports whether a field name, map key, or slice index matches s.
+func (s segment) matches(name string) bool {
+	switch s.kind {
+	case segmentWildcard:
+		return true
+	case segmentPattern:
+		return s.re.MatchString(name)
+	default:
+		return name == s.literal
+	}
 }
 
-func clearRecursive(v reflect.Value, parts []string) error {
+// CompilePath parses spec, a path with an optional "=action" suffix, into a
+// Path, compiling its globs and regular expressions once so the path can be
+// applied repeatedly.
+func CompilePath(spec string) (*Path, error) {
+	path, action, err := splitAction(spec)
+	if err != nil {
+		return nil, err
+	}
+	parts := SplitPath(path)
 	if len(parts) == 0 {
-		return nil
+		return nil, fmt.Errorf("empty path")
 	}
 
-	part := parts[0]
-	last := len(parts) == 1
+	p := &Path{raw: spec, segments: make([]segment, len(parts)), action: action}
+	for i, part := range parts {
+		switch {
+		case part == "":
+			return nil, fmt.Errorf("empty segment")
+		case part == "*":
+			p.segments[i] = segment{kind: segmentWildcard, literal: part}
+		case part == "**":
+			if i == len(parts)-1 {
+				return nil, fmt.Errorf("** cannot be the last segment")
+			}
+			p.segments[i] = segment{kind: segmentRecursive, literal: part}
+		case len(part) >= 2 && strings.HasPrefix(part, "/") && strings.HasSuffix(part, "/"):
+			re, err := regexp.Compile(part[1 : len(part)-1])
+			if err != nil {
+				return nil, fmt.Errorf("segment %q: %v", part, err)
+			}
+			p.segments[i] = segment{kind: segmentPattern, literal: part, re: re}
+		case strings.ContainsAny(part, "*?"):
+			p.segments[i] = segment{kind: segmentPattern, literal: part, re: globToRegexp(part)}
+		default:
+			p.segments[i] = segment{kind: segmentLiteral, literal: part}
+		}
+	}
+	return p, nil
+}
 
-	// Dereference pointers
-	if v.Kind() == reflect.Pointer {
-		if v.IsNil() {
-			return nil
+// globToRegexp converts a glob, in which "*" matches any run of characters and
+// "?" any single character, into an anchored regular expression.
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
 		}
-		v = v.Elem()
 	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
 
-	switch v.Kind() {
+// String returns the path as written, including any action.
+func (p *Path) String() string {
+	return p.raw
+}
 
-	case reflect.Struct:
-		if part == "*" {
-			// Apply to all fields
-			for i := 0; i < v.NumField(); i++ {
-				field := v.Field(i)
-				if last {
-					field.Set(reflect.Zero(field.Type()))
-				} else {
-					clearRecursive(field, parts[1:])
-				}
-			}
-			return nil
-		}
+// Action returns the path's action.
+func (p *Path) Action() Action {
+	return p.action
+}
 
-		field := findStructFieldByJSONTag(v, part)
-		if !field.IsValid() {
-			return fmt.Errorf("struct field or json tag %q not found", part)
+// Recursive reports whether the path contains a "**" segment.
+func (p *Path) Recursive() bool {
+	for _, seg := range p.segments {
+		if seg.kind == segmentRecursive {
+			return true
 		}
+	}
+	return false
+}
 
-		if last {
-			field.Set(reflect.Zero(field.Type()))
-			return nil
-		}
-		return clearRecursive(field, parts[1:])
+// Apply redacts every value in obj matched by p with p's action. obj must be
+// a pointer. salt keys the hash action. The path is compiled against obj's
+// type on every call; callers applying paths repeatedly should build a Plan.
+// A literal segment that names a missing struct field or an invalid slice
+// index is an error; patterns that match no map key or slice element are not.
+func (p *Path) Apply(obj any, salt []byte) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		// What obj points to is redacted in place; only what it shares is
+		// copied.
+		v = v.Elem()
+	}
+	node, err := compilePlan(v.Type(), p.segments)
+	if err != nil || node == nil {
+		return err
+	}
+	return node.apply(v, redaction{action: p.action, salt: salt, owned: make(map[uintptr]struct{})})
+}
 
-	case reflect.Map:
-		if part == "*" {
-			for _, key := range v.MapKeys() {
-				elem := v.MapIndex(key)
-				if last {
-					v.SetMapIndex(key, reflect.Zero(elem.Type()))
-				} else {
-					clearRecursive(elem, parts[1:])
-				}
-			}
-			return nil
-		}
+// ClearByPath compiles path and applies it to obj. Callers applying the same
+// path repeatedly should use CompilePath and NewPlan instead.
+func ClearByPath(obj any, path string) error {
+	p, err := CompilePath(path)
+	if err != nil {
+		return err
+	}
+	return p.Apply(obj, nil)
+}
 
-		key := reflect.ValueOf(part)
-		elem := v.MapIndex(key)
-		if !elem.IsValid() {
-			return nil
-		}
+// redaction is an action being applied along a path.
+type redaction struct {
+	action Action
+	salt   []byte
+	// owned are the slices, maps, and pointers copied by this application,
+	// by address, which need not be copied again.
+	owned map[uintptr]struct{}
+}
 
-		if last {
-			v.SetMapIndex(key, reflect.Zero(elem.Type()))
-			return nil
-		}
-		return clearRecursive(elem, parts[1:])
+// redact applies the action to the selected value v. Clearing zeroes it; the
+// other actions rewrite every string within it and zero any other scalar,
+// which cannot hold a marker. keyValue reports whether v is an environment
+// list or entry, whose keys the keys action keeps.
+func (r redaction) redact(v reflect.Value, keyValue bool) {
+	if r.action == ActionClear {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
 
-	case reflect.Slice, reflect.Array:
-		if part == "*" {
-			for i := 0; i < v.Len(); i++ {
-				elem := v.Index(i)
-				if last {
-					elem.Set(reflect.Zero(elem.Type()))
-				} else {
-					clearRecursive(elem, parts[1:])
-				}
-			}
-			return nil
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(r.action.replace(v.String(), r.salt, keyValue))
+	case reflect.Pointer:
+		if !v.IsNil() {
+			r.own(v)
+			r.redact(v.Elem(), keyValue)
 		}
-
-		idx, err := strconv.Atoi(part)
-		if err != nil || idx < 0 || idx >= v.Len() {
-			return fmt.Errorf("invalid index %q", part)
+	case reflect.Struct:
+		for _, f := range jsonFields(v.Type()) {
+			r.redact(v.FieldByIndex(f.index), isKeyValueList(f))
 		}
-
-		elem := v.Index(idx)
-		if last {
-			elem.Set(reflect.Zero(elem.Type()))
-			return nil
+	case reflect.Map:
+		r.own(v)
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			r.redact(elem, false)
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Slice, reflect.Array:
+		r.own(v)
+		for i := 0; i < v.Len(); i++ {
+			r.redact(v.Index(i), keyValue)
 		}
-		return clearRecursive(elem, parts[1:])
-
 	default:
-		return fmt.Errorf("cannot navigate into %s at %q", v.Kind(), part)
+		v.Set(reflect.Zero(v.Type()))
+	}
+}
+
+// canNest reports whether values of type t can contain further fields, keys,
+// or elements.
+func canNest(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		return true
 	}
+	return false
+}
+
+// isPromoted reports whether sf is an embedded struct whose fields
+// encoding/json promotes into the enclosing object.
+func isPromoted(sf reflect.StructField) bool {
+	return sf.Anonymous && sf.Type.Kind() == reflect.Struct && sf.Tag.Get("json") == ""
 }
 
-func findStructFieldByJSONTag(v reflect.Value, name string) reflect.Value {
-	t := v.Type()
+// isKeyValueList reports whether f is an environment list of KEY=value
+// entries, such as a container's Env.
+func isKeyValueList(f jsonField) bool {
+	return f.goName == "Env" && f.typ == reflect.TypeFor[[]string]()
+}
+
+// jsonField is a struct field as it appears in the JSON output.
+type jsonField struct {
+	name   string
+	goName string
+	index  []int
+	typ    reflect.Type
+}
+
+// structInfo is the JSON view of a struct type, computed once per type.
+type structInfo struct {
+	fields []jsonField
+	// byName resolves literal segments: JSON names and Go names of direct
+	// fields first, then those of promoted fields.
+	byName map[string]jsonField
+}
+
+var structInfos sync.Map // reflect.Type -> *structInfo
 
+// jsonFields lists the fields of struct type t under their JSON names,
+// including those promoted from embedded structs and excluding unexported
+// and "-" fields. The result is cached and must not be modified.
+func jsonFields(t reflect.Type) []jsonField {
+	return cachedStructInfo(t).fields
+}
+
+// findJSONField finds the field of struct type t named by a literal segment,
+// matching JSON names and Go names, including those of promoted fields.
+func findJSONField(t reflect.Type, name string) (jsonField, bool) {
+	f, ok := cachedStructInfo(t).byName[name]
+	return f, ok
+}
+
+func cachedStructInfo(t reflect.Type) *structInfo {
+	if info, ok := structInfos.Load(t); ok {
+		return info.(*structInfo)
+	}
+	info := &structInfo{fields: collectJSONFields(t), byName: make(map[string]jsonField)}
+	for _, promoted := range []bool{false, true} {
+		for _, f := range info.fields {
+			if (len(f.index) > 1) != promoted {
+				continue
+			}
+			for _, name := range []string{f.name, f.goName} {
+				if _, dup := info.byName[name]; !dup {
+					info.byName[name] = f
+				}
+			}
+		}
+	}
+	actual, _ := structInfos.LoadOrStore(t, info)
+	return actual.(*structInfo)
+}
+
+func collectJSONFields(t reflect.Type) []jsonField {
+	var fields []jsonField
 	for i := 0; i < t.NumField(); i++ {
 		sf := t.Field(i)
-
-		// Skip unexported fields
+		if isPromoted(sf) {
+			for _, f := range collectJSONFields(sf.Type) {
+				f.index = append([]int{i}, f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		}
 		if sf.PkgPath != "" {
 			continue
 		}
-
-		tag := sf.Tag.Get("json")
-		if tag != "" {
-			tagName := strings.Split(tag, ",")[0]
-			if tagName == name {
-				return v.Field(i)
-			}
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
 		}
-
-		// Fallback to Go field name
-		if sf.Name == name {
-			return v.Field(i)
+		if name == "" {
+			name = sf.Name
 		}
+		fields = append(fields, jsonField{name: name, goName: sf.Name, index: []int{i}, typ: sf.Type})
 	}
-
-	return reflect.Value{}
+	return fields
 }
 
-func splitPath(path string) []string {
+// SplitPath splits a sanitization path into its segments on unquoted periods.
+// Single quotes delimit segments containing periods.
+func SplitPath(path string) []string {
 	var parts []string
 	var buf strings.Builder
 	inQuotes := false
@@ -170,3 +350,29 @@ func splitPath(path string) []string {
 
 	return parts
 }
+
+// ValidatePath compiles path and reports whether it can be resolved against
+// values of type t; see Path.Validate.
+func ValidatePath(t reflect.Type, path string) error {
+	p, err := CompilePath(path)
+	if err != nil {
+		return err
+	}
+	return p.Validate(t)
+}
+
+// Validate reports whether p can be resolved against values of type t as
+// Apply would resolve it, without needing a value. Literal struct fields must
+// exist, patterns must match at least one struct field, slice indexes must be
+// integers, and any map key is accepted. Paths that descend into an interface
+// cannot be checked past that point.
+func (p *Path) Validate(t reflect.Type) error {
+	node, err := compilePlan(t, p.segments)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return fmt.Errorf("nothing within %s matches %q", t, p.raw)
+	}
+	return nil
+}
@@ -1,13 +1,23 @@
 package internal
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 type clrInner struct {
 	Secret string `json:"secret"`
 	Keep   string `json:"keep"`
 }
 
+type clrEmbedded struct {
+	Tags map[string]string `json:"tags"`
+	// Name is shadowed by clrOuter.Name, as encoding/json shadows it.
+	Name string `json:"name"`
+}
+
 type clrOuter struct {
+	clrEmbedded
 	Name   string              `json:"name"`
 	Inner  clrInner            `json:"inner"`
 	Items  []clrInner          `json:"items"`
@@ -18,12 +28,13 @@ type clrOuter struct {
 
 func newClrOuter() *clrOuter {
 	return &clrOuter{
-		Name:   "name",
-		Inner:  clrInner{Secret: "s", Keep: "keep"},
-		Items:  []clrInner{{Secret: "s0", Keep: "k0"}, {Secret: "s1", Keep: "k1"}},
-		M:      map[string]clrInner{"x": {Secret: "sx", Keep: "kx"}},
-		Labels: map[string]string{"secret": "v", "keep": "v"},
-		Dotted: map[string]string{"a.b.c": "v"},
+		clrEmbedded: clrEmbedded{Tags: map[string]string{"secret": "v"}, Name: "embedded"},
+		Name:        "name",
+		Inner:       clrInner{Secret: "s", Keep: "keep"},
+		Items:       []clrInner{{Secret: "s0", Keep: "k0"}, {Secret: "s1", Keep: "k1"}},
+		M:           map[string]clrInner{"x": {Secret: "sx", Keep: "kx"}},
+		Labels:      map[string]string{"secret": "v", "keep": "v"},
+		Dotted:      map[string]string{"a.b.c": "v"},
 	}
 }
 
@@ -44,6 +55,18 @@ func TestClearByPath(t *testing.T) {
 				if o.Inner.Secret != "s" {
 					t.Errorf("Inner.Secret = %q, want unchanged", o.Inner.Secret)
 				}
+				if o.clrEmbedded.Name != "embedded" {
+					t.Errorf("embedded Name = %q, want the outer field cleared instead", o.clrEmbedded.Name)
+				}
+			},
+		},
+		{
+			name: "field promoted from embedded struct",
+			path: "tags.secret",
+			check: func(t *testing.T, o *clrOuter) {
+				if _, ok := o.Tags["secret"]; !ok || o.Tags["secret"] != "" {
+					t.Errorf("Tags[secret] = %q, want present and empty", o.Tags["secret"])
+				}
 			},
 		},
 		{
@@ -133,6 +156,76 @@ func TestClearByPath(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "map entry of struct by key",
+			path: "m.x.secret",
+			check: func(t *testing.T, o *clrOuter) {
+				if v := o.M["x"]; v.Secret != "" || v.Keep != "kx" {
+					t.Errorf("M[x] = %+v, want only Secret cleared", v)
+				}
+			},
+		},
+		{
+			name: "glob matches map keys",
+			path: "labels.'s*t'",
+			check: func(t *testing.T, o *clrOuter) {
+				if o.Labels["secret"] != "" || o.Labels["keep"] != "v" {
+					t.Errorf("Labels = %v, want only secret cleared", o.Labels)
+				}
+			},
+		},
+		{
+			name: "glob with embedded dots",
+			path: "dotted.'a.?.*'",
+			check: func(t *testing.T, o *clrOuter) {
+				if o.Dotted["a.b.c"] != "" {
+					t.Errorf("Dotted[a.b.c] = %q, want empty", o.Dotted["a.b.c"])
+				}
+			},
+		},
+		{
+			name: "regex matches json field names",
+			path: "inner./^sec/",
+			check: func(t *testing.T, o *clrOuter) {
+				if o.Inner.Secret != "" || o.Inner.Keep != "keep" {
+					t.Errorf("Inner = %+v, want only Secret cleared", o.Inner)
+				}
+			},
+		},
+		{
+			name: "regex matching nothing is a no-op",
+			path: "labels./^zzz/",
+			check: func(t *testing.T, o *clrOuter) {
+				if o.Labels["secret"] != "v" || o.Labels["keep"] != "v" {
+					t.Errorf("Labels mutated unexpectedly: %v", o.Labels)
+				}
+			},
+		},
+		{
+			name: "recursive descent",
+			path: "**.secret",
+			check: func(t *testing.T, o *clrOuter) {
+				if o.Inner.Secret != "" || o.Items[0].Secret != "" || o.Items[1].Secret != "" || o.M["x"].Secret != "" {
+					t.Errorf("struct secrets not all cleared: %+v", o)
+				}
+				if o.Labels["secret"] != "" || o.Tags["secret"] != "" {
+					t.Errorf("map secrets not all cleared: %v %v", o.Labels, o.Tags)
+				}
+				if o.Inner.Keep != "keep" || o.Items[0].Keep != "k0" || o.M["x"].Keep != "kx" || o.Labels["keep"] != "v" {
+					t.Errorf("unrelated values cleared: %+v", o)
+				}
+			},
+		},
+		{
+			name:    "invalid regex errors",
+			path:    "labels./(/",
+			wantErr: true,
+		},
+		{
+			name:    "trailing recursive descent errors",
+			path:    "inner.**",
+			wantErr: true,
+		},
 		{
 			name:    "unknown field errors",
 			path:    "nope",
@@ -169,3 +262,45 @@ func TestClearByPath(t *testing.T) {
 		})
 	}
 }
+
+func TestValidatePath(t *testing.T) {
+	typ := reflect.TypeOf(clrOuter{})
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{path: "name"},
+		{path: "inner.secret"},
+		{path: "Inner.Secret"},
+		{path: "items.*.secret"},
+		{path: "items.1.keep"},
+		{path: "m.x.secret"},
+		{path: "labels.anything"},
+		{path: "dotted.'a.b.c'"},
+		{path: "*.secret"},
+		{path: "tags.secret"},
+		{path: "labels.'com.example.*'"},
+		{path: "items.*./^s/"},
+		{path: "**.keep"},
+		{path: "inner.'k*'"},
+		{path: "inner.'z*'", wantErr: true},
+		{path: "**.keep.deeper", wantErr: true},
+		{path: "labels./[/", wantErr: true},
+		{path: "nmae", wantErr: true},
+		{path: "inner.secert", wantErr: true},
+		{path: "items.first.secret", wantErr: true},
+		{path: "m.x.missing", wantErr: true},
+		{path: "name.deeper", wantErr: true},
+		{path: "*.x.missing", wantErr: true},
+		{path: "", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			err := ValidatePath(typ, tc.path)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ValidatePath(%q) error = %v, wantErr %v", tc.path, err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -1,5 +1,5 @@
 import Details from './details.js';
-import { formatBytes } from './utils.js';
+import { formatBytes, statusColor, statusTitle } from './utils.js';
 
 export default {
   name: 'Task',
@@ -15,7 +15,7 @@ export default {
           <v-badge :color="taskStatus(task.Status.State)" dot inline floating :title="task.Status.State" :aria-label="'Task status: ' + task.Status.State"></v-badge>
           <span :title="task.service.Spec.Name">{{ task.service.Spec.Name }}</span>
         </v-card-title>
-        <v-card-subtitle class="pa-0"><v-chip color="primary" class="ml-1 pa-1" label size="x-medium" density="compact" slim>{{ mode }}</v-chip></v-card-subtitle>
+        <v-card-subtitle class="pa-0"><v-chip color="primary" class="ml-1 pa-1" label size="x-medium" density="compact" slim>{{ mode }}</v-chip><v-chip v-if="service?.status && service.status.status !== 'healthy'" :color="statusColor(service.status.status)" class="ml-1 pa-1" label size="x-medium" density="compact" slim :title="statusTitle(service.status)">{{ service.status.status }}</v-chip></v-card-subtitle>
       </v-card-item>
 
       <v-card-text v-if="task.service" class="mt-n2 pa-1 pb-0">
@@ -95,6 +95,8 @@ export default {
       open: false,
       opened: ['networks'],
       formatBytes: formatBytes, // make the utility function available in the template
+      statusColor: statusColor,
+      statusTitle: statusTitle,
     }
   },
   props: {
@@ -104,6 +106,7 @@ export default {
   computed: {
     mode() {
       if (!this.service) return 'unknown';
+      if (this.service.status) return this.service.status.mode;
       if (this.service.Spec.Mode.Replicated) {
         return 'replicated';
       } else if (this.service.Spec.Mode.Global) {
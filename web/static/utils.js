@@ -8,4 +8,23 @@ export function formatBytes(bytes = 0) {
   }
 
   return `${bytes.toFixed(2)} ${units[unitIndex]}`;
-}
\ No newline at end of file
+}
+
+// statusColor is the color of a service or stack status computed by the
+// server.
+export function statusColor(status) {
+  switch (status) {
+    case 'healthy': return 'success';
+    case 'completed': return 'secondary';
+    case 'updating': return 'info';
+    case 'degraded':
+    case 'paused': return 'warning';
+    default: return 'error';
+  }
+}
+
+// statusTitle describes a service status and its reasons, for a tooltip.
+export function statusTitle(status) {
+  if (!status) return '';
+  return [status.status, ...(status.reasons || []).map(reason => reason.message)].join('\n');
+}
@@ -7,6 +7,9 @@ export default {
       reconnectAttempts: 0,
       maxReconnectInterval: 30000, // 30 seconds
       connected: false,
+      // replayEnded is set once a historical replay has been sent in full,
+      // so the connection closing is not retried.
+      replayEnded: false,
     }
   },
   computed: {
@@ -21,14 +24,16 @@ export default {
       this.$emit('state-change', newState);
     }
   },
-  emits: ['update', 'not-authorized', 'state-change'],
+  emits: ['update', 'changes', 'replay', 'not-authorized', 'state-change'],
   mounted() {
     this.connectWebSocket();
   },
   methods: {
     connectWebSocket() {
       const proto = window.location.protocol === 'https:' ? 'wss' : 'ws'
-      this.ws = new WebSocket(proto + '://' + window.location.host + window.location.pathname + 'ws');
+      // The page's query, e.g. ?mode=historical&from=..., selects a replay
+      // of the history instead of the live swarm.
+      this.ws = new WebSocket(proto + '://' + window.location.host + window.location.pathname + 'ws' + window.location.search);
 
       this.ws.onopen = () => {
         console.log('WebSocket connection established');
@@ -45,7 +50,15 @@ export default {
 
         try {
           const json = JSON.parse(data);
-          this.$emit('update', json);
+          // Snapshots carry no type; other messages say what they are.
+          if (json && json.type === 'changes') {
+            this.$emit('changes', json.changes);
+          } else if (json && (json.type === 'replay' || json.type === 'replay_end')) {
+            this.replayEnded = json.type === 'replay_end';
+            this.$emit('replay', json);
+          } else {
+            this.$emit('update', json);
+          }
         } catch (e) {
           console.error('Failed to parse WebSocket message:', e);
         }
@@ -54,7 +67,9 @@ export default {
       this.ws.onclose = () => {
         console.log('WebSocket connection closed');
         this.connected = false;
-        this.reconnectWebSocket();
+        if (!this.replayEnded) {
+          this.reconnectWebSocket();
+        }
       };
 
       this.ws.onerror = (error) => {